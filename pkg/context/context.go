@@ -20,7 +20,9 @@ package context
 
 import (
 	"bytes"
+	stdcontext "context"
 	"runtime/debug"
+	"time"
 
 	"github.com/megaease/easegress/v2/pkg/logger"
 	"github.com/megaease/easegress/v2/pkg/protocols"
@@ -44,11 +46,14 @@ type MuxMapper interface {
 type requestRef struct {
 	req     protocols.Request
 	counter int
+	// origin is the reference in the parent context if the request is
+	// borrowed by a forked context, which never closes the request.
+	origin *requestRef
 }
 
 func (rr *requestRef) release() {
 	rr.counter--
-	if rr.counter == 0 {
+	if rr.counter == 0 && rr.origin == nil {
 		rr.req.Close()
 	}
 }
@@ -56,11 +61,14 @@ func (rr *requestRef) release() {
 type responseRef struct {
 	resp    protocols.Response
 	counter int
+	// origin is the reference in the parent context if the response is
+	// borrowed by a forked context, which never closes the response.
+	origin *responseRef
 }
 
 func (rr *responseRef) release() {
 	rr.counter--
-	if rr.counter == 0 {
+	if rr.counter == 0 && rr.origin == nil {
 		rr.resp.Close()
 	}
 }
@@ -79,6 +87,17 @@ type Context struct {
 
 	data        map[string]interface{}
 	finishFuncs []func()
+
+	// dirtyData records the keys of data set by a forked context.
+	dirtyData map[string]struct{}
+
+	// deadline is the deadline of the processing, zero means no deadline.
+	deadline time.Time
+
+	// canceled is done when the context is canceled, it is nil if the
+	// context is not forked, as only forked contexts can be canceled.
+	canceled stdcontext.Context
+	cancel   stdcontext.CancelFunc
 }
 
 // New creates a new Context.
@@ -213,7 +232,7 @@ func (ctx *Context) SetRequest(ns string, req protocols.Request) {
 		}
 		prev.release()
 	}
-	ctx.requests[ns] = &requestRef{req, 1}
+	ctx.requests[ns] = &requestRef{req: req, counter: 1}
 }
 
 // GetInputRequest returns the request of the input namespace.
@@ -316,7 +335,7 @@ func (ctx *Context) SetResponse(ns string, resp protocols.Response) {
 		}
 		prev.release()
 	}
-	ctx.responses[ns] = &responseRef{resp, 1}
+	ctx.responses[ns] = &responseRef{resp: resp, counter: 1}
 }
 
 // GetInputResponse returns the response of the input namespace.
@@ -347,6 +366,9 @@ func (ctx *Context) Data() map[string]interface{} {
 // SetData sets the data of key to val.
 func (ctx *Context) SetData(key string, val interface{}) {
 	ctx.data[key] = val
+	if ctx.dirtyData != nil {
+		ctx.dirtyData[key] = struct{}{}
+	}
 }
 
 // GetData returns the data of key.
@@ -397,3 +419,88 @@ func (ctx *Context) Finish() {
 		}()
 	}
 }
+
+// Fork creates a child context to handle the traffic concurrently with
+// other child contexts, the child context shares the span and route of
+// ctx, and has a copy of the data of ctx.
+//
+// The requests and responses of ctx are borrowed by the child context,
+// they could be read but should not be modified by the child context.
+// The child context must be merged back to ctx by Join, or be finished
+// by Finish if its result is discarded, and ctx must not be modified
+// before all its child contexts are created. The child context can be
+// canceled by Cancel.
+func (ctx *Context) Fork() *Context {
+	child := &Context{
+		span:      ctx.span,
+		activeNs:  ctx.activeNs,
+		route:     ctx.route,
+		deadline:  ctx.deadline,
+		requests:  make(map[string]*requestRef, len(ctx.requests)),
+		responses: make(map[string]*responseRef, len(ctx.responses)),
+		data:      make(map[string]interface{}, len(ctx.data)),
+		dirtyData: map[string]struct{}{},
+	}
+
+	for k, v := range ctx.requests {
+		child.requests[k] = &requestRef{req: v.req, counter: 1, origin: v}
+	}
+	for k, v := range ctx.responses {
+		child.responses[k] = &responseRef{resp: v.resp, counter: 1, origin: v}
+	}
+	for k, v := range ctx.data {
+		child.data[k] = v
+	}
+
+	// the child context is canceled when ctx is canceled.
+	parent := ctx.canceled
+	if parent == nil {
+		parent = stdcontext.Background()
+	}
+	child.canceled, child.cancel = stdcontext.WithCancel(parent)
+	child.OnFinish(child.cancel)
+
+	return child
+}
+
+// Join merges the child context created by Fork back to ctx, the requests,
+// responses and data set by the child context overwrite the ones of ctx
+// in the same namespace or of the same key, and its tags and finish
+// functions are added to ctx. The child context must not be used after
+// Join.
+func (ctx *Context) Join(child *Context) {
+	for ns, rr := range child.requests {
+		if rr.origin != nil {
+			if ctx.requests[ns] == rr.origin {
+				continue
+			}
+			rr = rr.origin
+			rr.counter++
+		}
+		if prev := ctx.requests[ns]; prev != nil {
+			prev.release()
+		}
+		ctx.requests[ns] = rr
+	}
+
+	for ns, rr := range child.responses {
+		if rr.origin != nil {
+			if ctx.responses[ns] == rr.origin {
+				continue
+			}
+			rr = rr.origin
+			rr.counter++
+		}
+		if prev := ctx.responses[ns]; prev != nil {
+			prev.release()
+		}
+		ctx.responses[ns] = rr
+	}
+
+	for k := range child.dirtyData {
+		ctx.SetData(k, child.data[k])
+	}
+
+	ctx.lazyTags = append(ctx.lazyTags, child.lazyTags...)
+	ctx.finishFuncs = append(ctx.finishFuncs, child.finishFuncs...)
+}
@@ -0,0 +1,103 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package context
+
+import (
+	stdcontext "context"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/util/fasttime"
+)
+
+// SetDeadline sets the deadline of the context, the deadline can only be
+// shortened, a deadline later than the current one is ignored.
+func (ctx *Context) SetDeadline(deadline time.Time) {
+	if deadline.IsZero() {
+		return
+	}
+	if ctx.deadline.IsZero() || deadline.Before(ctx.deadline) {
+		ctx.deadline = deadline
+	}
+}
+
+// ResetDeadline sets the deadline of the context even if it is later than
+// the current one, a zero deadline removes it. It is used to restore the
+// deadline after a sub-flow with its own timeout finishes.
+func (ctx *Context) ResetDeadline(deadline time.Time) {
+	ctx.deadline = deadline
+}
+
+// SetTimeout sets the deadline of the context to timeout from now, it
+// follows the rule of SetDeadline.
+func (ctx *Context) SetTimeout(timeout time.Duration) {
+	if timeout > 0 {
+		ctx.SetDeadline(fasttime.Now().Add(timeout))
+	}
+}
+
+// Deadline returns the deadline of the context, ok is false if no
+// deadline is set.
+func (ctx *Context) Deadline() (deadline time.Time, ok bool) {
+	return ctx.deadline, !ctx.deadline.IsZero()
+}
+
+// DeadlineExceeded returns whether the deadline of the context has been
+// exceeded.
+func (ctx *Context) DeadlineExceeded() bool {
+	return !ctx.deadline.IsZero() && !fasttime.Now().Before(ctx.deadline)
+}
+
+// Cancel cancels a context created by Fork and the contexts forked from
+// it, e.g. a branch whose result is no longer needed, so that it stops
+// running the filters and sending requests. It is safe to call Cancel
+// concurrently with the filters using ctx.
+func (ctx *Context) Cancel() {
+	if ctx.cancel != nil {
+		ctx.cancel()
+	}
+}
+
+// Canceled returns whether the context has been canceled.
+func (ctx *Context) Canceled() bool {
+	return ctx.canceled != nil && ctx.canceled.Err() != nil
+}
+
+// WithDeadline returns a Go context derived from parent with the deadline
+// of ctx, which is also canceled when ctx is canceled. parent is returned
+// as is if ctx has no deadline and can't be canceled. The returned
+// context is canceled when ctx finishes, so it can be used by streaming
+// responses which outlive the filter creating them.
+func (ctx *Context) WithDeadline(parent stdcontext.Context) stdcontext.Context {
+	if ctx.deadline.IsZero() && ctx.canceled == nil {
+		return parent
+	}
+
+	var c stdcontext.Context
+	var cancel stdcontext.CancelFunc
+	if ctx.deadline.IsZero() {
+		c, cancel = stdcontext.WithCancel(parent)
+	} else {
+		c, cancel = stdcontext.WithDeadline(parent, ctx.deadline)
+	}
+	if ctx.canceled != nil {
+		stop := stdcontext.AfterFunc(ctx.canceled, cancel)
+		ctx.OnFinish(func() { stop() })
+	}
+	ctx.OnFinish(cancel)
+	return c
+}
@@ -0,0 +1,73 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package builder
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+
+	sprig "github.com/go-task/slim-sprig"
+	"github.com/megaease/easegress/v2/pkg/context"
+)
+
+// Condition is a template expression which evaluates to a boolean, it
+// has the same functions and data as the templates of builder filters,
+// and additionally the tags of the context as `.tags`.
+type Condition struct {
+	template *template.Template
+}
+
+// NewCondition parses expr into a Condition. The expr is the pipeline of
+// a template action like `eq .req.Method "GET"`, or a template like
+// `{{eq .req.Method "GET"}}` which renders to "true" or "false".
+func NewCondition(expr string) (*Condition, error) {
+	if !strings.Contains(expr, "{{") {
+		expr = "{{" + expr + "}}"
+	}
+
+	t := template.New("").Funcs(sprig.TxtFuncMap()).Funcs(extraFuncs)
+	t, err := t.Parse(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &Condition{template: t}, nil
+}
+
+// Eval evaluates the condition with the data of ctx.
+func (c *Condition) Eval(ctx *context.Context) (bool, error) {
+	data, err := prepareBuilderData(ctx)
+	if err != nil {
+		return false, err
+	}
+	data["tags"] = ctx.Tags()
+
+	var result bytes.Buffer
+	if err = c.template.Execute(&result, data); err != nil {
+		return false, err
+	}
+
+	s := strings.TrimSpace(result.String())
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, fmt.Errorf("condition result %q is not a boolean", s)
+	}
+	return b, nil
+}
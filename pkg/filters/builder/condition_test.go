@@ -0,0 +1,78 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package builder
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCondition(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := NewCondition(`eq .req.Method`)
+	assert.NoError(err)
+	_, err = NewCondition(`eq (.req.Method "GET"`)
+	assert.Error(err)
+	_, err = NewCondition(`noSuchFunc .req.Method`)
+	assert.Error(err)
+
+	ctx := context.New(nil)
+	stdReq, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1/api/v1", nil)
+	stdReq.Header.Set("X-Canary", "true")
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "alice"})
+	signed, _ := token.SignedString([]byte("secret"))
+	stdReq.Header.Set("Authorization", "Bearer "+signed)
+	setRequest(t, ctx, context.DefaultNamespace, stdReq)
+	ctx.SetData("foo", "bar")
+	ctx.AddTag("cached")
+
+	cases := []struct {
+		expr   string
+		result bool
+	}{
+		{`eq .req.Method "GET"`, true},
+		{`hasPrefix "/api/" .req.URL.Path`, true},
+		{`eq (header .req.Header "X-Canary") "true"`, true},
+		{`eq (header .req.Header "X-Canary") "false"`, false},
+		{`eq (jwtClaims (header .req.Header "Authorization")).sub "alice"`, true},
+		{`eq .data.foo "bar"`, true},
+		{`contains "cached" .tags`, true},
+		{`{{if eq .req.Method "POST"}}true{{else}}false{{end}}`, false},
+	}
+	for _, c := range cases {
+		cond, err := NewCondition(c.expr)
+		assert.NoError(err, c.expr)
+		result, err := cond.Eval(ctx)
+		assert.NoError(err, c.expr)
+		assert.Equal(c.result, result, c.expr)
+	}
+
+	// not a boolean
+	cond, _ := NewCondition(`.req.Method`)
+	_, err = cond.Eval(ctx)
+	assert.Error(err)
+
+	// invalid token
+	claims := extraFuncs["jwtClaims"].(func(string) map[string]interface{})("abc")
+	assert.Empty(claims)
+}
@@ -27,6 +27,8 @@ import (
 	"strings"
 	"text/template"
 
+	"github.com/golang-jwt/jwt/v4"
+
 	"github.com/megaease/easegress/v2/pkg/logger"
 )
 
@@ -178,4 +180,18 @@ var extraFuncs = template.FuncMap{
 	"urlQueryEscape": func(s string) string {
 		return url.QueryEscape(s)
 	},
+
+	// jwtClaims returns the claims of a JWT token, the token could be
+	// prefixed with "Bearer ". The signature is NOT verified, so the
+	// token should be verified by a Validator filter before.
+	"jwtClaims": func(token string) map[string]interface{} {
+		if len(token) > 7 && strings.EqualFold(token[:7], "bearer ") {
+			token = token[7:]
+		}
+		claims := jwt.MapClaims{}
+		if _, _, err := jwt.NewParser().ParseUnverified(token, claims); err != nil {
+			return map[string]interface{}{}
+		}
+		return claims
+	},
 }
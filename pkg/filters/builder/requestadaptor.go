@@ -22,6 +22,7 @@ import (
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/megaease/easegress/v2/pkg/context"
@@ -45,6 +46,9 @@ const (
 
 	keyContentLength   = "Content-Length"
 	keyContentEncoding = "Content-Encoding"
+
+	// decompressAuto decompresses the body in any supported encoding.
+	decompressAuto = "auto"
 )
 
 var requestAdaptorKind = &filters.Kind{
@@ -174,11 +178,11 @@ type (
 
 // Validate verifies that at least one of the validations is defined.
 func (spec *RequestAdaptorSpec) Validate() error {
-	if spec.Decompress != "" && spec.Decompress != "gzip" {
-		return fmt.Errorf("RequestAdaptor only support decompress type of gzip")
+	if spec.Decompress != "" && spec.Decompress != decompressAuto && !readers.IsSupportedEncoding(spec.Decompress) {
+		return fmt.Errorf("RequestAdaptor only support decompress type of gzip, br, zstd or auto")
 	}
-	if spec.Compress != "" && spec.Compress != "gzip" {
-		return fmt.Errorf("RequestAdaptor only support decompress type of gzip")
+	if spec.Compress != "" && !readers.IsSupportedEncoding(spec.Compress) {
+		return fmt.Errorf("RequestAdaptor only support compress type of gzip, br or zstd")
 	}
 	if spec.Compress != "" && spec.Decompress != "" {
 		return fmt.Errorf("RequestAdaptor can only do compress or decompress for given request body, not both")
@@ -349,7 +353,13 @@ func (ra *RequestAdaptor) processCompress(req *httpprot.Request) string {
 		return ""
 	}
 
-	zr := readers.NewGZipCompressReader(req.GetPayload())
+	encoding = compressEncoding(ra.spec.Compress)
+	zr, err := readers.NewCompressReader(req.GetPayload(), encoding, 0)
+	if err != nil {
+		logger.Errorf("create %s compress reader failed: %v", encoding, err)
+		return resultCompressFailed
+	}
+
 	if req.IsStream() {
 		req.SetPayload(zr)
 		req.ContentLength = -1
@@ -366,17 +376,17 @@ func (ra *RequestAdaptor) processCompress(req *httpprot.Request) string {
 		req.HTTPHeader().Set(keyContentLength, strconv.Itoa(len(data)))
 	}
 
-	req.HTTPHeader().Set(keyContentEncoding, "gzip")
+	req.HTTPHeader().Set(keyContentEncoding, encoding)
 	return ""
 }
 
 func (ra *RequestAdaptor) processDecompress(req *httpprot.Request) string {
-	encoding := req.HTTPHeader().Get(keyContentEncoding)
-	if ra.spec.Decompress != "gzip" || encoding != "gzip" {
+	encoding := contentEncoding(req.HTTPHeader())
+	if !shouldDecompress(ra.spec.Decompress, encoding) {
 		return ""
 	}
 
-	zr, err := readers.NewGZipDecompressReader(req.GetPayload())
+	zr, err := readers.NewDecompressReader(req.GetPayload(), encoding)
 	if err != nil {
 		return resultDecompressFailed
 	}
@@ -401,6 +411,32 @@ func (ra *RequestAdaptor) processDecompress(req *httpprot.Request) string {
 	return ""
 }
 
+// compressEncoding returns the encoding to compress the body with, gzip
+// is used if the option is not specified.
+func compressEncoding(option string) string {
+	if option == "" {
+		return readers.EncodingGzip
+	}
+	return option
+}
+
+// contentEncoding returns the normalized Content-Encoding of header.
+func contentEncoding(h http.Header) string {
+	return strings.ToLower(strings.TrimSpace(h.Get(keyContentEncoding)))
+}
+
+// shouldDecompress returns whether a body in encoding should be
+// decompressed according to the decompress option.
+func shouldDecompress(option, encoding string) bool {
+	if encoding == "" {
+		return false
+	}
+	if option == decompressAuto {
+		return readers.IsSupportedEncoding(encoding)
+	}
+	return option == encoding
+}
+
 func (ra *RequestAdaptor) signRequest(req *httpprot.Request) string {
 	sCtx := ra.signer.NewSigningContext(time.Now(), ra.spec.Sign.Scopes...)
 	if req.IsStream() {
@@ -404,3 +404,68 @@ func TestRequestAdaptorProcessCompressDecompress(t *testing.T) {
 
 	assert.Empty(ra.processDecompress(req))
 }
+
+func TestRequestAdaptorBrotliZstd(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NotNil(defaultFilterSpec(&RequestAdaptorSpec{Compress: "br"}))
+	assert.NotNil(defaultFilterSpec(&RequestAdaptorSpec{Decompress: "zstd"}))
+	assert.NotNil(defaultFilterSpec(&RequestAdaptorSpec{Decompress: "auto"}))
+	assert.Nil(defaultFilterSpec(&RequestAdaptorSpec{Compress: "auto"}))
+
+	for _, encoding := range []string{"br", "zstd"} {
+		// compress
+		spec := defaultFilterSpec(&RequestAdaptorSpec{Compress: encoding})
+		ra := requestAdaptorKind.CreateInstance(spec)
+		ra.Init()
+
+		req, err := http.NewRequest(http.MethodPost, "127.0.0.1", bytes.NewReader([]byte("hello")))
+		assert.Nil(err)
+		ctx := context.New(nil)
+		setRequest(t, ctx, "DEFAULT", req)
+		assert.Empty(ra.Handle(ctx))
+		assert.Equal(encoding, ctx.GetInputRequest().Header().Get("Content-Encoding"))
+		data := ctx.GetInputRequest().RawPayload()
+
+		// decompress with the specified encoding
+		for _, option := range []string{encoding, "auto"} {
+			spec = defaultFilterSpec(&RequestAdaptorSpec{Decompress: option})
+			ra = requestAdaptorKind.CreateInstance(spec)
+			ra.Init()
+
+			req, err = http.NewRequest(http.MethodPost, "127.0.0.1", bytes.NewReader(data))
+			assert.Nil(err)
+			req.Header.Set("Content-Encoding", encoding)
+			ctx = context.New(nil)
+			setRequest(t, ctx, "DEFAULT", req)
+			assert.Empty(ra.Handle(ctx))
+			assert.Empty(ctx.GetInputRequest().Header().Get("Content-Encoding"))
+			assert.Equal("hello", string(ctx.GetInputRequest().RawPayload()))
+		}
+
+		// the body is kept if the encoding does not match
+		spec = defaultFilterSpec(&RequestAdaptorSpec{Decompress: "gzip"})
+		ra = requestAdaptorKind.CreateInstance(spec)
+		ra.Init()
+		req, err = http.NewRequest(http.MethodPost, "127.0.0.1", bytes.NewReader(data))
+		assert.Nil(err)
+		req.Header.Set("Content-Encoding", encoding)
+		ctx = context.New(nil)
+		setRequest(t, ctx, "DEFAULT", req)
+		assert.Empty(ra.Handle(ctx))
+		assert.Equal(encoding, ctx.GetInputRequest().Header().Get("Content-Encoding"))
+		assert.Equal(data, ctx.GetInputRequest().RawPayload())
+	}
+
+	// unsupported encodings are kept as is by auto
+	spec := defaultFilterSpec(&RequestAdaptorSpec{Decompress: "auto"})
+	ra := requestAdaptorKind.CreateInstance(spec)
+	ra.Init()
+	req, err := http.NewRequest(http.MethodPost, "127.0.0.1", bytes.NewReader([]byte("hello")))
+	assert.Nil(err)
+	req.Header.Set("Content-Encoding", "deflate")
+	ctx := context.New(nil)
+	setRequest(t, ctx, "DEFAULT", req)
+	assert.Empty(ra.Handle(ctx))
+	assert.Equal("deflate", ctx.GetInputRequest().Header().Get("Content-Encoding"))
+}
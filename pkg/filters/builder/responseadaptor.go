@@ -20,7 +20,6 @@ package builder
 import (
 	"io"
 	"strconv"
-	"strings"
 
 	"github.com/megaease/easegress/v2/pkg/context"
 	"github.com/megaease/easegress/v2/pkg/filters"
@@ -98,11 +97,11 @@ func (ra *ResponseAdaptor) Spec() filters.Spec {
 
 // Init initializes ResponseAdaptor.
 func (ra *ResponseAdaptor) Init() {
-	if ra.spec.Decompress != "" && ra.spec.Decompress != "gzip" {
-		panic("ResponseAdaptor only support decompress type of gzip")
+	if ra.spec.Decompress != "" && ra.spec.Decompress != decompressAuto && !readers.IsSupportedEncoding(ra.spec.Decompress) {
+		panic("ResponseAdaptor only support decompress type of gzip, br, zstd or auto")
 	}
-	if ra.spec.Compress != "" && ra.spec.Compress != "gzip" {
-		panic("ResponseAdaptor only support decompress type of gzip")
+	if ra.spec.Compress != "" && !readers.IsSupportedEncoding(ra.spec.Compress) {
+		panic("ResponseAdaptor only support compress type of gzip, br or zstd")
 	}
 	if ra.spec.Compress != "" && ra.spec.Decompress != "" {
 		panic("ResponseAdaptor can only do compress or decompress for given request body, not both")
@@ -180,13 +179,17 @@ func (ra *ResponseAdaptor) Handle(ctx *context.Context) string {
 }
 
 func (ra *ResponseAdaptor) compress(resp *httpprot.Response) string {
-	for _, ce := range resp.HTTPHeader().Values(keyContentEncoding) {
-		if strings.Contains(ce, "gzip") {
-			return ""
-		}
+	if encoding := contentEncoding(resp.HTTPHeader()); encoding != "" && encoding != "identity" {
+		return ""
+	}
+
+	encoding := compressEncoding(ra.spec.Compress)
+	zr, err := readers.NewCompressReader(resp.GetPayload(), encoding, 0)
+	if err != nil {
+		logger.Errorf("create %s compress reader failed, %v", encoding, err)
+		return resultCompressFailed
 	}
 
-	zr := readers.NewGZipCompressReader(resp.GetPayload())
 	if resp.IsStream() {
 		resp.SetPayload(zr)
 		resp.ContentLength = -1
@@ -203,21 +206,17 @@ func (ra *ResponseAdaptor) compress(resp *httpprot.Response) string {
 		resp.HTTPHeader().Set(keyContentLength, strconv.Itoa(len(data)))
 	}
 
-	resp.HTTPHeader().Set(keyContentEncoding, "gzip")
+	resp.HTTPHeader().Set(keyContentEncoding, encoding)
 	return ""
 }
 
 func (ra *ResponseAdaptor) decompress(resp *httpprot.Response) string {
-	if ra.spec.Decompress != "gzip" {
-		return ""
-	}
-
-	encoding := resp.HTTPHeader().Get(keyContentEncoding)
-	if encoding != "gzip" {
+	encoding := contentEncoding(resp.HTTPHeader())
+	if !shouldDecompress(ra.spec.Decompress, encoding) {
 		return ""
 	}
 
-	zr, err := readers.NewGZipDecompressReader(resp.GetPayload())
+	zr, err := readers.NewDecompressReader(resp.GetPayload(), encoding)
 	if err != nil {
 		return resultDecompressFailed
 	}
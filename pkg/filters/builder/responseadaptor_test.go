@@ -285,3 +285,37 @@ func TestResponseAdaptorCompressDecompress(t *testing.T) {
 
 	assert.Empty(ra.decompress(resp))
 }
+
+func TestResponseAdaptorBrotliZstd(t *testing.T) {
+	assert := assert.New(t)
+
+	ra := &ResponseAdaptor{spec: &ResponseAdaptorSpec{Compress: "auto"}}
+	assert.Panics(func() { ra.Init() })
+
+	for _, encoding := range []string{"br", "zstd"} {
+		ra = &ResponseAdaptor{spec: &ResponseAdaptorSpec{Compress: encoding}}
+		ra.Init()
+
+		resp, _ := httpprot.NewResponse(nil)
+		resp.SetPayload([]byte("hello"))
+		assert.Empty(ra.compress(resp))
+		assert.Equal(encoding, resp.HTTPHeader().Get(keyContentEncoding))
+
+		// already encoded
+		data := resp.RawPayload()
+		assert.Empty(ra.compress(resp))
+		assert.Equal(data, resp.RawPayload())
+
+		zr, err := readers.NewDecompressReader(bytes.NewReader(data), encoding)
+		assert.Nil(err)
+		plain, err := io.ReadAll(zr)
+		assert.Nil(err)
+		assert.Equal("hello", string(plain))
+
+		ra = &ResponseAdaptor{spec: &ResponseAdaptorSpec{Decompress: "auto"}}
+		ra.Init()
+		assert.Empty(ra.decompress(resp))
+		assert.Empty(resp.HTTPHeader().Get(keyContentEncoding))
+		assert.Equal("hello", string(resp.RawPayload()))
+	}
+}
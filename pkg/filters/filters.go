@@ -87,6 +87,12 @@ type (
 		InjectResiliencePolicy(policies map[string]resilience.Policy)
 	}
 
+	// Resulter is the interface of filter specs which define results in
+	// addition to the Results of their kind.
+	Resulter interface {
+		Results() []string
+	}
+
 	// Spec is the common interface of filter specs
 	Spec interface {
 		// Super returns supervisor
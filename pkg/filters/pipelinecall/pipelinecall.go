@@ -0,0 +1,216 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package pipelinecall implements the PipelineCall filter, which calls
+// another pipeline as a sub-flow.
+package pipelinecall
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/object/pipeline"
+	"github.com/megaease/easegress/v2/pkg/util/fasttime"
+)
+
+const (
+	// Kind is the kind of PipelineCall.
+	Kind = "PipelineCall"
+
+	resultPipelineNotFound = "pipelineNotFound"
+	resultCallCycle        = "callCycle"
+	resultFailed           = "failed"
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "PipelineCall calls another pipeline as a sub-flow.",
+	Results:     []string{resultPipelineNotFound, resultCallCycle, resultFailed},
+	DefaultSpec: func() filters.Spec {
+		return &Spec{}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &PipelineCall{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+// fnGetPipeline gets a running pipeline, it is a variable so that it can
+// be replaced in testing.
+var fnGetPipeline = pipeline.GetPipeline
+
+type (
+	// PipelineCall is filter PipelineCall.
+	PipelineCall struct {
+		spec *Spec
+
+		mutex   sync.Mutex
+		calls   uint64
+		results map[string]uint64
+		total   time.Duration
+		max     time.Duration
+	}
+
+	// Spec describes the PipelineCall.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		// Target is the name of the called pipeline.
+		Target string `json:"pipeline" jsonschema:"required,format=urlname"`
+		// ResultMapping maps the results of the called pipeline to the
+		// results of the filter, the other non-empty results are mapped
+		// to failed.
+		ResultMapping map[string]string `json:"resultMapping,omitempty"`
+	}
+
+	// Status is the status of PipelineCall.
+	Status struct {
+		Calls        uint64            `json:"calls"`
+		Results      map[string]uint64 `json:"results,omitempty"`
+		MeanDuration string            `json:"meanDuration"`
+		MaxDuration  string            `json:"maxDuration"`
+	}
+)
+
+// Validate validates the spec.
+func (spec *Spec) Validate() error {
+	for from, to := range spec.ResultMapping {
+		if from == "" {
+			return fmt.Errorf("resultMapping: the normal result can't be mapped")
+		}
+		if to == resultPipelineNotFound || to == resultCallCycle {
+			return fmt.Errorf("resultMapping: %s is a reserved result", to)
+		}
+	}
+	return nil
+}
+
+// CalledPipeline returns the name of the called pipeline, it implements
+// pipeline.CallerSpec.
+func (spec *Spec) CalledPipeline() string {
+	return spec.Target
+}
+
+// Results returns the mapped results, it implements filters.Resulter.
+func (spec *Spec) Results() []string {
+	results := make([]string, 0, len(spec.ResultMapping))
+	for _, to := range spec.ResultMapping {
+		results = append(results, to)
+	}
+	return results
+}
+
+// Name returns the name of the PipelineCall filter instance.
+func (pc *PipelineCall) Name() string {
+	return pc.spec.Name()
+}
+
+// Kind returns the kind of PipelineCall.
+func (pc *PipelineCall) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the PipelineCall
+func (pc *PipelineCall) Spec() filters.Spec {
+	return pc.spec
+}
+
+// Init initializes PipelineCall.
+func (pc *PipelineCall) Init() {
+	pc.results = map[string]uint64{}
+}
+
+// Inherit inherits previous generation of PipelineCall.
+func (pc *PipelineCall) Inherit(previousGeneration filters.Filter) {
+	pc.Init()
+}
+
+// Handle calls the pipeline with ctx.
+func (pc *PipelineCall) Handle(ctx *context.Context) string {
+	start := fasttime.Now()
+	result := pc.call(ctx)
+	pc.record(result, fasttime.Since(start))
+	return result
+}
+
+func (pc *PipelineCall) call(ctx *context.Context) string {
+	p, ok := fnGetPipeline(pc.spec.Super(), pipeline.CallerNamespace(ctx), pc.spec.Target)
+	if !ok {
+		logger.Errorf("%s: pipeline %s not found", pc.Name(), pc.spec.Target)
+		return resultPipelineNotFound
+	}
+
+	result, err := p.HandleCall(ctx, pc.spec.Pipeline())
+	if err != nil {
+		logger.Errorf("%s: %v", pc.Name(), err)
+		return resultCallCycle
+	}
+
+	if to, ok := pc.spec.ResultMapping[result]; ok {
+		return to
+	}
+	if result != "" {
+		return resultFailed
+	}
+	return ""
+}
+
+func (pc *PipelineCall) record(result string, d time.Duration) {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+
+	pc.calls++
+	if result != "" {
+		pc.results[result]++
+	}
+	pc.total += d
+	if d > pc.max {
+		pc.max = d
+	}
+}
+
+// Status returns Status.
+func (pc *PipelineCall) Status() interface{} {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+
+	s := &Status{
+		Calls:       pc.calls,
+		Results:     make(map[string]uint64, len(pc.results)),
+		MaxDuration: pc.max.String(),
+	}
+	for k, v := range pc.results {
+		s.Results[k] = v
+	}
+	if pc.calls > 0 {
+		s.MeanDuration = (pc.total / time.Duration(pc.calls)).String()
+	} else {
+		s.MeanDuration = time.Duration(0).String()
+	}
+	return s
+}
+
+// Close closes PipelineCall.
+func (pc *PipelineCall) Close() {
+}
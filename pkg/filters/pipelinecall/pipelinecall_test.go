@@ -0,0 +1,185 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pipelinecall
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	_ "github.com/megaease/easegress/v2/pkg/filters/builder"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/object/pipeline"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/supervisor"
+	"github.com/megaease/easegress/v2/pkg/tracing"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newPipeline(t *testing.T, yamlConfig string) *pipeline.Pipeline {
+	superSpec, err := supervisor.NewSpec(yamlConfig)
+	assert.NoError(t, err)
+	p := &pipeline.Pipeline{}
+	p.Init(superSpec, nil)
+	return p
+}
+
+func newPipelineCall(t *testing.T, yamlConfig string) *PipelineCall {
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(yamlConfig), &rawSpec)
+	spec, err := filters.NewSpec(nil, "parent", rawSpec)
+	assert.NoError(t, err)
+	pc := kind.CreateInstance(spec).(*PipelineCall)
+	pc.Init()
+	return pc
+}
+
+func newContext() *context.Context {
+	stdReq, _ := http.NewRequest(http.MethodGet, "http://localhost:9095", nil)
+	req, _ := httpprot.NewRequest(stdReq)
+	ctx := context.New(tracing.NoopSpan)
+	ctx.SetRequest(context.DefaultNamespace, req)
+	return ctx
+}
+
+func TestSpec(t *testing.T) {
+	assert := assert.New(t)
+
+	rawSpec := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(`
+name: call
+kind: PipelineCall
+pipeline: auth
+resultMapping:
+  "": denied
+`), &rawSpec)
+	_, err := filters.NewSpec(nil, "parent", rawSpec)
+	assert.Error(err)
+
+	rawSpec = map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(`
+name: call
+kind: PipelineCall
+pipeline: auth
+resultMapping:
+  denied: callCycle
+`), &rawSpec)
+	_, err = filters.NewSpec(nil, "parent", rawSpec)
+	assert.Error(err)
+
+	pc := newPipelineCall(t, `
+name: call
+kind: PipelineCall
+pipeline: auth
+resultMapping:
+  result0: denied
+`)
+	assert.Equal("auth", pc.spec.CalledPipeline())
+	assert.ElementsMatch(
+		[]string{resultPipelineNotFound, resultCallCycle, resultFailed, "denied"},
+		filters.GetResults(pc.spec),
+	)
+	assert.Equal(Kind, pc.Kind().Name)
+	assert.Equal("call", pc.Name())
+	pc.Inherit(pc)
+	pc.Close()
+}
+
+func TestPipelineCall(t *testing.T) {
+	assert := assert.New(t)
+
+	auth := newPipeline(t, `
+name: auth
+kind: Pipeline
+flow:
+  - filter: adaptor
+  - filter: result
+filters:
+  - name: adaptor
+    kind: RequestAdaptor
+    header:
+      set:
+        X-Sub: called
+  - name: result
+    kind: ResultBuilder
+    template: |
+      {{if eq (.req.Header.Get "X-Token") "ok"}}{{else if eq (.req.Header.Get "X-Token") "bad"}}result1{{else}}result0{{end}}
+`)
+	defer auth.Close()
+
+	old := fnGetPipeline
+	fnGetPipeline = func(super *supervisor.Supervisor, namespace, name string) (*pipeline.Pipeline, bool) {
+		if namespace == "default" && name == "auth" {
+			return auth, true
+		}
+		return nil, false
+	}
+	defer func() { fnGetPipeline = old }()
+
+	pc := newPipelineCall(t, `
+name: call
+kind: PipelineCall
+pipeline: auth
+resultMapping:
+  result0: denied
+`)
+
+	ctx := newContext()
+	assert.Equal("denied", pc.Handle(ctx))
+	req := ctx.GetInputRequest().(*httpprot.Request)
+	assert.Equal("called", req.HTTPHeader().Get("X-Sub"))
+
+	ctx = newContext()
+	req = ctx.GetInputRequest().(*httpprot.Request)
+	req.HTTPHeader().Set("X-Token", "bad")
+	assert.Equal(resultFailed, pc.Handle(ctx))
+
+	ctx = newContext()
+	req = ctx.GetInputRequest().(*httpprot.Request)
+	req.HTTPHeader().Set("X-Token", "ok")
+	assert.Equal("", pc.Handle(ctx))
+
+	// the caller is in the call stack
+	ctx = newContext()
+	ctx.SetData("PIPELINE_CALL_STACK", []string{"auth"})
+	assert.Equal(resultCallCycle, pc.Handle(ctx))
+
+	// the pipeline is looked up in the namespace of the caller
+	ctx = newContext()
+	ctx.SetData("PIPELINE_CALL_NAMESPACE", "other")
+	assert.Equal(resultPipelineNotFound, pc.Handle(ctx))
+
+	status := pc.Status().(*Status)
+	assert.Equal(uint64(5), status.Calls)
+	assert.Equal(map[string]uint64{"denied": 1, resultFailed: 1, resultCallCycle: 1, resultPipelineNotFound: 1}, status.Results)
+
+	pc = newPipelineCall(t, `
+name: call
+kind: PipelineCall
+pipeline: unknown
+`)
+	assert.Equal(resultPipelineNotFound, pc.Handle(newContext()))
+	status = pc.Status().(*Status)
+	assert.Equal(uint64(1), status.Calls)
+}
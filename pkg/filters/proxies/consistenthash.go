@@ -0,0 +1,422 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxies
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/megaease/easegress/v2/pkg/protocols"
+	"github.com/megaease/easegress/v2/pkg/protocols/grpcprot"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/spaolacci/murmur3"
+)
+
+const (
+	// ConsistentHashAlgorithmKetama is the ketama ring algorithm.
+	ConsistentHashAlgorithmKetama = "ketama"
+	// ConsistentHashAlgorithmMaglev is the maglev lookup table algorithm.
+	ConsistentHashAlgorithmMaglev = "maglev"
+
+	// ConsistentHashOnIP uses the real IP of the request as the hash key.
+	ConsistentHashOnIP = "ip"
+	// ConsistentHashOnHeader uses a header of the request as the hash key.
+	ConsistentHashOnHeader = "header"
+	// ConsistentHashOnCookie uses a cookie of the request as the hash key.
+	ConsistentHashOnCookie = "cookie"
+	// ConsistentHashOnQuery uses a query parameter of the request as the hash key.
+	ConsistentHashOnQuery = "query"
+	// ConsistentHashOnPath uses the path of the request as the hash key.
+	ConsistentHashOnPath = "path"
+
+	defaultKetamaVirtualNodes = 160
+	defaultMaglevTableSize    = 65537
+)
+
+// ConsistentHashSpec is the spec of the consistent hash load balance policy.
+type ConsistentHashSpec struct {
+	// Algorithm is the algorithm to build the hash ring, default is ketama.
+	Algorithm string `json:"algorithm,omitempty" jsonschema:"enum=,enum=ketama,enum=maglev"`
+	// HashOn is where the hash key is taken from, default is ip.
+	HashOn string `json:"hashOn,omitempty" jsonschema:"enum=,enum=ip,enum=header,enum=cookie,enum=query,enum=path"`
+	// Key is the name of the header, cookie or query parameter.
+	Key string `json:"key,omitempty"`
+	// VirtualNodes is the number of virtual nodes of a server with weight
+	// 1 in ketama, default is 160.
+	VirtualNodes int `json:"virtualNodes,omitempty" jsonschema:"minimum=1"`
+	// TableSize is the size of the lookup table in maglev, it should be a
+	// prime number much larger than the number of servers, default is 65537.
+	TableSize int `json:"tableSize,omitempty" jsonschema:"minimum=1"`
+	// BoundedLoad is the load factor of consistent hashing with bounded
+	// loads, a server is skipped if its in-flight requests exceed the
+	// average multiplied by this factor. It should be greater than 1, and
+	// the default value 0 disables the feature.
+	BoundedLoad float64 `json:"boundedLoad,omitempty" jsonschema:"minimum=0"`
+}
+
+// Validate validates the ConsistentHashSpec.
+func (spec *ConsistentHashSpec) Validate() error {
+	switch spec.HashOn {
+	case ConsistentHashOnHeader, ConsistentHashOnCookie, ConsistentHashOnQuery:
+		if spec.Key == "" {
+			return fmt.Errorf("key is required when hash on %s", spec.HashOn)
+		}
+	}
+
+	if spec.TableSize != 0 && !isPrime(spec.TableSize) {
+		return fmt.Errorf("tableSize must be a prime number")
+	}
+
+	if spec.BoundedLoad != 0 && spec.BoundedLoad <= 1 {
+		return fmt.Errorf("boundedLoad must be greater than 1")
+	}
+
+	return nil
+}
+
+func isPrime(n int) bool {
+	return big.NewInt(int64(n)).ProbablyPrime(0)
+}
+
+// hashRing is the interface of a consistent hash ring.
+type hashRing interface {
+	// walk calls fn on servers in the order of the ring, starting from
+	// the position of hash, until fn returns true or all positions are
+	// visited.
+	walk(hash uint64, fn func(svr *Server) bool)
+}
+
+// ketamaRing is a hash ring with virtual nodes.
+type ketamaRing struct {
+	hashes  []uint64
+	servers []*Server
+}
+
+type ketamaNode struct {
+	hash   uint64
+	server *Server
+}
+
+func newKetamaRing(servers []*Server, virtualNodes int) *ketamaRing {
+	nodes := make([]ketamaNode, 0, len(servers)*virtualNodes)
+	for _, svr := range servers {
+		count := virtualNodes * serverWeight(svr)
+		for i := 0; i < count; i++ {
+			h := murmur3.Sum64([]byte(svr.ID() + "#" + strconv.Itoa(i)))
+			nodes = append(nodes, ketamaNode{hash: h, server: svr})
+		}
+	}
+
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].hash < nodes[j].hash
+	})
+
+	r := &ketamaRing{
+		hashes:  make([]uint64, len(nodes)),
+		servers: make([]*Server, len(nodes)),
+	}
+	for i, n := range nodes {
+		r.hashes[i], r.servers[i] = n.hash, n.server
+	}
+	return r
+}
+
+func (r *ketamaRing) walk(hash uint64, fn func(svr *Server) bool) {
+	n := len(r.hashes)
+	start := sort.Search(n, func(i int) bool {
+		return r.hashes[i] >= hash
+	})
+	for i := 0; i < n; i++ {
+		if fn(r.servers[(start+i)%n]) {
+			return
+		}
+	}
+}
+
+// maglevRing is a lookup table built by the maglev algorithm.
+type maglevRing struct {
+	table []*Server
+}
+
+// newMaglevRing builds a maglev ring, it returns nil if there's no server,
+// as the table can't be filled.
+func newMaglevRing(servers []*Server, tableSize int) *maglevRing {
+	if len(servers) == 0 {
+		return nil
+	}
+
+	type entry struct {
+		server *Server
+		offset uint64
+		skip   uint64
+		next   uint64
+		weight int
+		target int
+	}
+
+	size := uint64(tableSize)
+	maxWeight := 0
+	entries := make([]*entry, len(servers))
+	for i, svr := range servers {
+		id := []byte(svr.ID())
+		entries[i] = &entry{
+			server: svr,
+			offset: murmur3.Sum64WithSeed(id, 0) % size,
+			skip:   murmur3.Sum64WithSeed(id, 1)%(size-1) + 1,
+			weight: serverWeight(svr),
+		}
+		if entries[i].weight > maxWeight {
+			maxWeight = entries[i].weight
+		}
+	}
+
+	table := make([]*Server, size)
+	filled := uint64(0)
+	for iteration := 1; filled < size; iteration++ {
+		for _, e := range entries {
+			if filled == size {
+				break
+			}
+
+			// servers with lower weight skip some iterations, so that they
+			// fill fewer slots of the table.
+			if iteration*e.weight < e.target {
+				continue
+			}
+			e.target += maxWeight
+
+			c := (e.offset + e.next*e.skip) % size
+			for table[c] != nil {
+				e.next++
+				c = (e.offset + e.next*e.skip) % size
+			}
+			table[c] = e.server
+			e.next++
+			filled++
+		}
+	}
+
+	return &maglevRing{table: table}
+}
+
+func (r *maglevRing) walk(hash uint64, fn func(svr *Server) bool) {
+	n := uint64(len(r.table))
+	start := hash % n
+	for i := uint64(0); i < n; i++ {
+		if fn(r.table[(start+i)%n]) {
+			return
+		}
+	}
+}
+
+// hashRingCache caches the hash rings built for a server group and its
+// priority levels, the rings are dropped when the server group changes.
+type hashRingCache struct {
+	root  *ServerGroup
+	rings map[*ServerGroup]hashRing
+}
+
+// ConsistentHashLoadBalancePolicy is a load balance policy that chooses a
+// server by consistent hashing, so that only the keys of the changed
+// servers are remapped when servers become healthy or unhealthy.
+type ConsistentHashLoadBalancePolicy struct {
+	spec  *ConsistentHashSpec
+	lock  sync.Mutex
+	cache atomic.Pointer[hashRingCache]
+}
+
+// NewConsistentHashLoadBalancePolicy creates a new ConsistentHashLoadBalancePolicy.
+func NewConsistentHashLoadBalancePolicy(spec *ConsistentHashSpec) *ConsistentHashLoadBalancePolicy {
+	if spec == nil {
+		spec = &ConsistentHashSpec{}
+	}
+	return &ConsistentHashLoadBalancePolicy{spec: spec}
+}
+
+// getRing returns the hash ring of the server group, it returns nil if
+// the server group is empty.
+func (lbp *ConsistentHashLoadBalancePolicy) getRing(sg *ServerGroup) hashRing {
+	if len(sg.Servers) == 0 {
+		return nil
+	}
+
+	root := sg
+	if sg.parent != nil {
+		root = sg.parent
+	}
+
+	c := lbp.cache.Load()
+	if c != nil && c.root == root {
+		if ring := c.rings[sg]; ring != nil {
+			return ring
+		}
+	}
+
+	lbp.lock.Lock()
+	defer lbp.lock.Unlock()
+
+	// double check, another goroutine may have built the ring.
+	c = lbp.cache.Load()
+	if c != nil && c.root == root {
+		if ring := c.rings[sg]; ring != nil {
+			return ring
+		}
+	}
+
+	var ring hashRing
+	if lbp.spec.Algorithm == ConsistentHashAlgorithmMaglev {
+		size := lbp.spec.TableSize
+		if !isPrime(size) {
+			size = defaultMaglevTableSize
+		}
+		ring = newMaglevRing(sg.Servers, size)
+	} else {
+		vnodes := lbp.spec.VirtualNodes
+		if vnodes <= 0 {
+			vnodes = defaultKetamaVirtualNodes
+		}
+		ring = newKetamaRing(sg.Servers, vnodes)
+	}
+
+	// the cache is copied on write, so that readers don't need the lock.
+	rings := map[*ServerGroup]hashRing{sg: ring}
+	if c != nil && c.root == root {
+		for k, v := range c.rings {
+			rings[k] = v
+		}
+	}
+	lbp.cache.Store(&hashRingCache{root: root, rings: rings})
+	return ring
+}
+
+func (lbp *ConsistentHashLoadBalancePolicy) hashKey(req protocols.Request) string {
+	if req == nil {
+		return ""
+	}
+
+	key := lbp.spec.Key
+	switch lbp.spec.HashOn {
+	case ConsistentHashOnHeader:
+		switch v := req.Header().Get(key).(type) {
+		case string:
+			return v
+		case []string:
+			if len(v) > 0 {
+				return v[0]
+			}
+		}
+	case ConsistentHashOnCookie:
+		if r, ok := req.(*httpprot.Request); ok {
+			if c, err := r.Cookie(key); err == nil {
+				return c.Value
+			}
+		}
+	case ConsistentHashOnQuery:
+		if r, ok := req.(*httpprot.Request); ok {
+			return r.URL().Query().Get(key)
+		}
+	case ConsistentHashOnPath:
+		switch r := req.(type) {
+		case *httpprot.Request:
+			return r.Path()
+		case *grpcprot.Request:
+			return r.FullMethod()
+		}
+	default:
+		return req.RealIP()
+	}
+
+	return ""
+}
+
+// ChooseServer chooses a server by consistent hashing.
+func (lbp *ConsistentHashLoadBalancePolicy) ChooseServer(req protocols.Request, sg *ServerGroup) *Server {
+	if len(sg.Servers) == 0 {
+		return nil
+	}
+
+	key := lbp.hashKey(req)
+
+	// fallback to random if there's no hash key.
+	if key == "" {
+		return sg.Servers[rand.Intn(len(sg.Servers))]
+	}
+
+	hash := murmur3.Sum64([]byte(key))
+	ring := lbp.getRing(sg)
+	if ring == nil {
+		return nil
+	}
+
+	var chosen *Server
+	if lbp.spec.BoundedLoad <= 1 {
+		ring.walk(hash, func(svr *Server) bool {
+			chosen = svr
+			return true
+		})
+		return chosen
+	}
+
+	// consistent hashing with bounded loads, a server is skipped if its
+	// load exceeds the capacity, which is proportional to its weight.
+	total := 0
+	for _, svr := range sg.Servers {
+		total += int(svr.load.inflight.Load())
+	}
+	totalWeight := 0
+	for _, svr := range sg.Servers {
+		totalWeight += serverWeight(svr)
+	}
+	avg := float64(total+1) / float64(totalWeight)
+
+	ring.walk(hash, func(svr *Server) bool {
+		if chosen == nil {
+			chosen = svr
+		}
+		capacity := math.Ceil(avg * float64(serverWeight(svr)) * lbp.spec.BoundedLoad)
+		if float64(svr.load.inflight.Load()) < capacity {
+			chosen = svr
+			return true
+		}
+		return false
+	})
+
+	return chosen
+}
+
+// OnChoose increases the in-flight requests of the server if bounded load
+// is enabled.
+func (lbp *ConsistentHashLoadBalancePolicy) OnChoose(svr *Server, req protocols.Request) {
+	if lbp.spec.BoundedLoad > 1 {
+		svr.load.inflight.Add(1)
+	}
+}
+
+// OnReturn decreases the in-flight requests of the server if bounded load
+// is enabled.
+func (lbp *ConsistentHashLoadBalancePolicy) OnReturn(svr *Server, req protocols.Request, resp protocols.Response) {
+	if lbp.spec.BoundedLoad > 1 {
+		svr.load.inflight.Add(-1)
+	}
+}
@@ -0,0 +1,205 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxies
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/megaease/easegress/v2/pkg/protocols"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsistentHashSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &ConsistentHashSpec{HashOn: ConsistentHashOnHeader}
+	assert.Error(spec.Validate())
+	spec.Key = "X-User"
+	assert.NoError(spec.Validate())
+
+	spec.TableSize = 100
+	assert.Error(spec.Validate())
+	spec.TableSize = 101
+	assert.NoError(spec.Validate())
+
+	spec.BoundedLoad = 0.5
+	assert.Error(spec.Validate())
+	spec.BoundedLoad = 1.25
+	assert.NoError(spec.Validate())
+
+	lbSpec := &LoadBalanceSpec{ConsistentHash: &ConsistentHashSpec{HashOn: ConsistentHashOnQuery}}
+	assert.Error(lbSpec.Validate())
+}
+
+// testConsistentHashRemap checks that removing a server only remaps its
+// own keys, maglev may remap a few more keys, which is limited by maxMoved.
+func testConsistentHashRemap(t *testing.T, algorithm string, maxMoved int) {
+	assert := assert.New(t)
+
+	servers := prepareServers(10)
+	for _, s := range servers {
+		s.Weight = 0
+	}
+	spec := &LoadBalanceSpec{
+		Policy: LoadBalancePolicyConsistentHash,
+		ConsistentHash: &ConsistentHashSpec{
+			Algorithm: algorithm,
+			HashOn:    ConsistentHashOnHeader,
+			Key:       "X-User",
+		},
+	}
+	lb := NewGeneralLoadBalancer(spec, servers)
+	lb.Init(nil, nil, nil)
+
+	reqs := make([]protocols.Request, 1000)
+	before := make([]*Server, len(reqs))
+	counter := map[*Server]int{}
+	for i := range reqs {
+		stdr := &http.Request{Header: http.Header{}}
+		stdr.Header.Set("X-User", fmt.Sprintf("user-%d", i))
+		reqs[i], _ = httpprot.NewRequest(stdr)
+		before[i] = lb.ChooseServer(reqs[i])
+		counter[before[i]]++
+
+		// same key, same server
+		assert.Same(before[i], lb.ChooseServer(reqs[i]))
+	}
+	for _, s := range servers {
+		assert.Greater(counter[s], 20)
+	}
+
+	// remove a server, only its keys should be remapped.
+	removed := servers[3]
+	healthy := append(append([]*Server{}, servers[:3]...), servers[4:]...)
+	lb.healthyServers.Store(newServerGroup(healthy))
+	moved := 0
+	for i, req := range reqs {
+		svr := lb.ChooseServer(req)
+		assert.NotSame(removed, svr)
+		if before[i] != removed && before[i] != svr {
+			moved++
+		}
+	}
+	assert.LessOrEqual(moved, maxMoved)
+}
+
+func TestConsistentHashKetama(t *testing.T) {
+	testConsistentHashRemap(t, ConsistentHashAlgorithmKetama, 0)
+}
+
+func TestConsistentHashMaglev(t *testing.T) {
+	testConsistentHashRemap(t, ConsistentHashAlgorithmMaglev, 50)
+}
+
+func TestConsistentHashEmpty(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(newMaglevRing(nil, defaultMaglevTableSize))
+
+	stdr, _ := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+	stdr.Header.Set("X-User", "alice")
+	req, _ := httpprot.NewRequest(stdr)
+	for _, algorithm := range []string{ConsistentHashAlgorithmKetama, ConsistentHashAlgorithmMaglev} {
+		lbp := NewConsistentHashLoadBalancePolicy(&ConsistentHashSpec{
+			Algorithm: algorithm,
+			HashOn:    ConsistentHashOnHeader,
+			Key:       "X-User",
+		})
+		assert.Nil(lbp.ChooseServer(req, newServerGroup(nil)), algorithm)
+	}
+}
+
+func TestConsistentHashKeys(t *testing.T) {
+	assert := assert.New(t)
+
+	stdr, _ := http.NewRequest(http.MethodGet, "http://example.com/users/1?tenant=abc", nil)
+	stdr.Header.Set("X-Real-Ip", "10.0.0.1")
+	stdr.AddCookie(&http.Cookie{Name: "session", Value: "xyz"})
+	req, _ := httpprot.NewRequest(stdr)
+
+	cases := map[string]string{
+		"":                     "10.0.0.1",
+		ConsistentHashOnIP:     "10.0.0.1",
+		ConsistentHashOnCookie: "xyz",
+		ConsistentHashOnQuery:  "abc",
+		ConsistentHashOnPath:   "/users/1",
+		ConsistentHashOnHeader: "10.0.0.1",
+	}
+	keys := map[string]string{
+		ConsistentHashOnCookie: "session",
+		ConsistentHashOnQuery:  "tenant",
+		ConsistentHashOnHeader: "X-Real-Ip",
+	}
+	for hashOn, expected := range cases {
+		lbp := NewConsistentHashLoadBalancePolicy(&ConsistentHashSpec{HashOn: hashOn, Key: keys[hashOn]})
+		assert.Equal(expected, lbp.hashKey(req), hashOn)
+	}
+
+	// fallback to random without hash key
+	lbp := NewConsistentHashLoadBalancePolicy(&ConsistentHashSpec{HashOn: ConsistentHashOnHeader, Key: "X-None"})
+	assert.NotNil(lbp.ChooseServer(req, newServerGroup(prepareServers(3))))
+}
+
+func TestConsistentHashWeightAndBoundedLoad(t *testing.T) {
+	assert := assert.New(t)
+
+	// weighted
+	for _, algorithm := range []string{ConsistentHashAlgorithmKetama, ConsistentHashAlgorithmMaglev} {
+		servers := prepareServers(2)
+		servers[1].Weight = 4
+		lbp := NewConsistentHashLoadBalancePolicy(&ConsistentHashSpec{Algorithm: algorithm, HashOn: ConsistentHashOnPath})
+		sg := newServerGroup(servers)
+		counter := map[*Server]int{}
+		for i := 0; i < 5000; i++ {
+			stdr, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("http://example.com/%d", i), nil)
+			req, _ := httpprot.NewRequest(stdr)
+			counter[lbp.ChooseServer(req, sg)]++
+		}
+		assert.Greater(counter[servers[1]], counter[servers[0]]*2, algorithm)
+	}
+
+	// bounded load, requests of the same key spill over to other servers
+	// when the first server is overloaded.
+	servers := prepareServers(4)
+	for _, s := range servers {
+		s.Weight = 0
+	}
+	spec := &LoadBalanceSpec{
+		Policy: LoadBalancePolicyConsistentHash,
+		ConsistentHash: &ConsistentHashSpec{
+			HashOn:      ConsistentHashOnPath,
+			BoundedLoad: 1.25,
+		},
+	}
+	lb := NewGeneralLoadBalancer(spec, servers)
+	lb.Init(nil, nil, nil)
+
+	stdr, _ := http.NewRequest(http.MethodGet, "http://example.com/hot", nil)
+	req, _ := httpprot.NewRequest(stdr)
+	counter := map[*Server]int{}
+	for i := 0; i < 100; i++ {
+		counter[lb.ChooseServer(req)]++
+	}
+	assert.Len(counter, 4)
+	for _, s := range servers {
+		assert.LessOrEqual(counter[s], 32)
+	}
+}
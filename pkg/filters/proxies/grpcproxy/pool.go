@@ -22,11 +22,13 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"net/http"
 	"net/url"
 	"sync"
 
 	"github.com/megaease/easegress/v2/pkg/context"
 	"github.com/megaease/easegress/v2/pkg/filters/proxies"
+	"github.com/megaease/easegress/v2/pkg/protocols"
 	"github.com/megaease/easegress/v2/pkg/protocols/grpcprot"
 	"github.com/megaease/easegress/v2/pkg/util/objectpool"
 	"google.golang.org/grpc"
@@ -126,17 +128,24 @@ type ServerPool struct {
 	proxy *Proxy
 	spec  *ServerPoolSpec
 
-	filter                RequestMatcher
-	circuitBreakerWrapper resilience.Wrapper
+	filter                     RequestMatcher
+	circuitBreakerWrapper      resilience.Wrapper
+	adaptiveConcurrencyWrapper resilience.Wrapper
+	bulkheadWrapper            resilience.Wrapper
+	healthChecker              proxies.HealthChecker
 }
 
 // ServerPoolSpec is the spec for a server pool.
 type ServerPoolSpec struct {
 	BaseServerPoolSpec `json:",inline"`
 
-	SpanName             string              `json:"spanName,omitempty"`
-	Filter               *RequestMatcherSpec `json:"filter,omitempty"`
-	CircuitBreakerPolicy string              `json:"circuitBreakerPolicy,omitempty"`
+	SpanName             string                `json:"spanName,omitempty"`
+	Filter               *RequestMatcherSpec   `json:"filter,omitempty"`
+	CircuitBreakerPolicy string                `json:"circuitBreakerPolicy,omitempty"`
+	HealthCheck          *ProxyHealthCheckSpec `json:"healthCheck,omitempty"`
+
+	AdaptiveConcurrencyPolicy string `json:"adaptiveConcurrencyPolicy,omitempty"`
+	BulkheadPolicy            string `json:"bulkheadPolicy,omitempty"`
 }
 
 // Validate validates ServerPoolSpec.
@@ -155,9 +164,36 @@ func (sps *ServerPoolSpec) Validate() error {
 		msgFmt := "not all servers have weight(%d/%d)"
 		return fmt.Errorf(msgFmt, serversGotWeight, len(sps.Servers))
 	}
+
+	if sps.ServiceName != "" && sps.HealthCheck != nil {
+		return fmt.Errorf("serviceName and healthCheck can't be set at the same time")
+	}
+	if sps.HealthCheck != nil {
+		if err := sps.HealthCheck.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if sps.LoadBalance != nil {
+		return sps.LoadBalance.Validate()
+	}
 	return nil
 }
 
+// ProxyHealthCheckSpec is the spec of gRPC proxy health check.
+type ProxyHealthCheckSpec struct {
+	proxies.HealthCheckSpec  `json:",inline"`
+	proxies.HealthProbesSpec `json:",inline"`
+}
+
+// Validate validates ProxyHealthCheckSpec.
+func (spec *ProxyHealthCheckSpec) Validate() error {
+	if spec.IsEmpty() {
+		return fmt.Errorf("empty health check spec")
+	}
+	return spec.HealthProbesSpec.Validate()
+}
+
 // NewServerPool creates a new server pool according to spec.
 func NewServerPool(proxy *Proxy, spec *ServerPoolSpec, name string) *ServerPool {
 	sp := &ServerPool{
@@ -165,6 +201,10 @@ func NewServerPool(proxy *Proxy, spec *ServerPoolSpec, name string) *ServerPool
 		spec:  spec,
 	}
 
+	if spec.HealthCheck != nil {
+		sp.healthChecker = spec.HealthCheck.NewHealthChecker(spec.HealthCheck.HealthCheckSpec, nil)
+	}
+
 	if spec.Filter != nil {
 		sp.filter = NewRequestMatcher(spec.Filter)
 	}
@@ -181,7 +221,8 @@ func (sp *ServerPool) CreateLoadBalancer(spec *LoadBalanceSpec, servers []*Serve
 	}
 
 	lb := proxies.NewGeneralLoadBalancer(spec, servers)
-	lb.Init(nil, nil, nil)
+	lb.SetLocalLabels(sp.LocalLabels())
+	lb.Init(nil, sp.healthChecker, nil)
 	return lb
 }
 
@@ -199,6 +240,32 @@ func (sp *ServerPool) InjectResiliencePolicy(policies map[string]resilience.Poli
 		}
 		sp.circuitBreakerWrapper = policy.CreateWrapper()
 	}
+
+	name = sp.spec.AdaptiveConcurrencyPolicy
+	if name != "" {
+		p := policies[name]
+		if p == nil {
+			panic(fmt.Errorf("adaptiveConcurrency policy %s not found", name))
+		}
+		policy, ok := p.(*resilience.AdaptiveConcurrencyPolicy)
+		if !ok {
+			panic(fmt.Errorf("policy %s is not an adaptiveConcurrency policy", name))
+		}
+		sp.adaptiveConcurrencyWrapper = policy.CreateWrapper()
+	}
+
+	name = sp.spec.BulkheadPolicy
+	if name != "" {
+		p := policies[name]
+		if p == nil {
+			panic(fmt.Errorf("bulkhead policy %s not found", name))
+		}
+		policy, ok := p.(*resilience.BulkheadPolicy)
+		if !ok {
+			panic(fmt.Errorf("policy %s is not a bulkhead policy", name))
+		}
+		sp.bulkheadWrapper = policy.CreateWrapper()
+	}
 }
 
 func (sp *ServerPool) handle(ctx *context.Context) string {
@@ -236,16 +303,37 @@ func (sp *ServerPool) handle(ctx *context.Context) string {
 	if sp.circuitBreakerWrapper != nil {
 		handler = sp.circuitBreakerWrapper.Wrap(handler)
 	}
+	if sp.adaptiveConcurrencyWrapper != nil {
+		handler = sp.adaptiveConcurrencyWrapper.Wrap(handler)
+	}
+	if sp.bulkheadWrapper != nil {
+		handler = sp.bulkheadWrapper.Wrap(handler)
+	}
 
-	// call the handler.
-	err := handler(spCtx.req.Context())
+	// call the handler, the deadline of the pipeline is sent to the
+	// server in grpc-timeout.
+	err := handler(ctx.WithDeadline(spCtx.req.Context()))
 	if err == nil {
 		spCtx.Context.SetOutputResponse(spCtx.resp)
 		return ""
 	}
 
-	// CircuitBreaker is the most outside resiliencer, if the error
-	// is ErrShortCircuited, we are sure the response is nil.
+	// Bulkhead and AdaptiveConcurrency are the most outside
+	// resiliencers, if the request is rejected, we are sure the
+	// response is nil.
+	if re, ok := err.(*resilience.RejectedError); ok {
+		logger.Debugf("%s: rejected by policy %s", sp.Name, re.Policy)
+		spCtx.AddTag("rejected")
+		code := codes.Unavailable
+		if re.StatusCode == http.StatusTooManyRequests {
+			code = codes.ResourceExhausted
+		}
+		sp.buildOutputResponse(spCtx, status.Newf(code, "rejected by policy %s", re.Policy))
+		return resultRejected
+	}
+
+	// CircuitBreaker is outside of the handler, if the error is
+	// ErrShortCircuited, we are sure the response is nil.
 	if err == resilience.ErrShortCircuited {
 		logger.Debugf("%s: short circuited by circuit break policy", sp.Name)
 		spCtx.AddTag("short circuited")
@@ -284,8 +372,14 @@ func (sp *ServerPool) doHandle(ctx stdcontext.Context, spCtx *serverPoolContext)
 		logger.Debugf("%s: no available server", sp.Name)
 		return serverPoolError{status.New(codes.InvalidArgument, "no available server"), resultClientError}
 	}
+	// the server is returned after the stream is finished, resp is nil
+	// if the stream to the server could not be created.
+	var resp protocols.Response
+	defer func() {
+		lb.ReturnServer(svr, spCtx.req, resp)
+	}()
+
 	target := sp.getTarget(svr.URL)
-	lb.ReturnServer(svr, spCtx.req, spCtx.resp)
 	if target == "" {
 		logger.Debugf("request %v from %v context target address %s invalid", spCtx.req.FullMethod(), spCtx.req.RealIP(), target)
 		return serverPoolError{status.New(codes.Internal, "server url invalid"), resultInternalError}
@@ -332,6 +426,10 @@ func (sp *ServerPool) doHandle(ctx stdcontext.Context, spCtx *serverPoolContext)
 	}
 
 	result := sp.biTransport(spCtx, proxyAsClientStream)
+	if spe, ok := result.(serverPoolError); ok {
+		spCtx.resp.SetStatus(spe.status)
+	}
+	resp = spCtx.resp
 	if result != nil && result != io.EOF {
 		logger.Infof("create new stream fail %s for source addr %s, target addr %s, path %s",
 			result.Error(), spCtx.req.SourceHost(), target, fullMethodName)
@@ -191,6 +191,14 @@ func TestServerPoolSpecValidate(t *testing.T) {
 	}
 	assert.NoError(t, sps.Validate())
 
+	sps.HealthCheck = &ProxyHealthCheckSpec{}
+	assert.Error(t, sps.Validate())
+	sps.HealthCheck.GRPC = &proxies.GRPCHealthCheckSpec{Service: "easegress"}
+	assert.NoError(t, sps.Validate())
+	sps.HealthCheck.Mode = "xor"
+	assert.Error(t, sps.Validate())
+	sps.HealthCheck.Mode = proxies.HealthCheckModeAnd
+
 	sps.Servers[0].Weight = 1
 	assert.Error(t, sps.Validate())
 }
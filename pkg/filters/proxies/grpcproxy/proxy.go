@@ -44,6 +44,7 @@ const (
 
 	// result for resilience
 	resultShortCircuited = "shortCircuited"
+	resultRejected       = "rejected"
 )
 
 var (
@@ -55,6 +56,7 @@ var (
 			resultClientError,
 			resultServerError,
 			resultShortCircuited,
+			resultRejected,
 		},
 		DefaultSpec: func() filters.Spec {
 			return &Spec{
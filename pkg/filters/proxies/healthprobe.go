@@ -0,0 +1,457 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxies
+
+import (
+	"bytes"
+	stdcontext "context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/megaease/easegress/v2/pkg/logger"
+)
+
+const (
+	// HealthCheckModeAnd means a server is healthy only if all probes pass.
+	HealthCheckModeAnd = "and"
+	// HealthCheckModeOr means a server is healthy if any probe passes.
+	HealthCheckModeOr = "or"
+
+	// maxTCPHealthCheckResponseSize is the max size of data read from the
+	// server to match the expected response of a TCP health check.
+	maxTCPHealthCheckResponseSize = 64 * 1024
+)
+
+type (
+	// HealthProbesSpec is the spec of protocol independent health check
+	// probes, it can be embedded into the health check spec of any pool.
+	HealthProbesSpec struct {
+		TCP    *TCPHealthCheckSpec    `json:"tcp,omitempty"`
+		GRPC   *GRPCHealthCheckSpec   `json:"grpc,omitempty"`
+		Script *ScriptHealthCheckSpec `json:"script,omitempty"`
+		// Mode is how the probes are combined, "and" or "or", default
+		// is "and".
+		Mode string `json:"mode,omitempty" jsonschema:"enum=,enum=and,enum=or"`
+	}
+
+	// TCPHealthCheckSpec is the spec of TCP health check, the check passes
+	// if the connection is established, Send is sent, and the response
+	// contains Expect.
+	TCPHealthCheckSpec struct {
+		Port   int    `json:"port,omitempty" jsonschema:"minimum=0,maximum=65535"`
+		Send   string `json:"send,omitempty"`
+		Expect string `json:"expect,omitempty"`
+	}
+
+	// GRPCHealthCheckSpec is the spec of gRPC health check, which calls
+	// grpc.health.v1.Health/Check of the server.
+	GRPCHealthCheckSpec struct {
+		Port int `json:"port,omitempty" jsonschema:"minimum=0,maximum=65535"`
+		// Service is the service name in the health check request, empty
+		// for the overall health of the server.
+		Service string `json:"service,omitempty"`
+		// Authority overrides the :authority pseudo header.
+		Authority string `json:"authority,omitempty"`
+	}
+
+	// ScriptHealthCheckSpec is the spec of script health check, which runs
+	// a command and passes if the command exits with 0. The command is run
+	// without a shell, and the server is passed by environment variables
+	// EG_SERVER_URL, EG_SERVER_HOST and EG_SERVER_PORT.
+	ScriptHealthCheckSpec struct {
+		Command []string `json:"command" jsonschema:"required,minItems=1"`
+	}
+
+	tcpHealthChecker struct {
+		base HealthCheckSpec
+		spec *TCPHealthCheckSpec
+	}
+
+	grpcHealthChecker struct {
+		base      HealthCheckSpec
+		spec      *GRPCHealthCheckSpec
+		tlsConfig *tls.Config
+
+		// conns are the connections to servers, keyed by server URL, they
+		// are reused by checks and closed when the checker is closed.
+		lock  sync.Mutex
+		conns map[string]*grpc.ClientConn
+	}
+
+	scriptHealthChecker struct {
+		base HealthCheckSpec
+		spec *ScriptHealthCheckSpec
+	}
+
+	compositeHealthChecker struct {
+		base     HealthCheckSpec
+		mode     string
+		checkers []HealthChecker
+	}
+)
+
+// IsEmpty returns whether no probe is configured.
+func (spec *HealthProbesSpec) IsEmpty() bool {
+	return spec.TCP == nil && spec.GRPC == nil && spec.Script == nil
+}
+
+// Validate validates HealthProbesSpec.
+func (spec *HealthProbesSpec) Validate() error {
+	switch spec.Mode {
+	case "", HealthCheckModeAnd, HealthCheckModeOr:
+	default:
+		return fmt.Errorf("invalid health check mode: %s", spec.Mode)
+	}
+	if spec.TCP != nil {
+		if err := spec.TCP.Validate(); err != nil {
+			return err
+		}
+	}
+	if spec.GRPC != nil {
+		if err := spec.GRPC.Validate(); err != nil {
+			return err
+		}
+	}
+	if spec.Script != nil {
+		if err := spec.Script.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate validates TCPHealthCheckSpec.
+func (spec *TCPHealthCheckSpec) Validate() error {
+	if spec.Port < 0 || spec.Port > 65535 {
+		return fmt.Errorf("invalid port: %d", spec.Port)
+	}
+	if len(spec.Expect) > maxTCPHealthCheckResponseSize {
+		return fmt.Errorf("expect is too long")
+	}
+	return nil
+}
+
+// Validate validates GRPCHealthCheckSpec.
+func (spec *GRPCHealthCheckSpec) Validate() error {
+	if spec.Port < 0 || spec.Port > 65535 {
+		return fmt.Errorf("invalid port: %d", spec.Port)
+	}
+	return nil
+}
+
+// Validate validates ScriptHealthCheckSpec.
+func (spec *ScriptHealthCheckSpec) Validate() error {
+	if len(spec.Command) == 0 || spec.Command[0] == "" {
+		return fmt.Errorf("command of script health check is empty")
+	}
+	return nil
+}
+
+// NewHealthChecker creates a health checker of the probes, checkers are
+// additional protocol specific checkers to combine with the probes, nil
+// checkers are ignored. It returns nil if there's no checker at all.
+func (spec *HealthProbesSpec) NewHealthChecker(base HealthCheckSpec, tlsConfig *tls.Config, checkers ...HealthChecker) HealthChecker {
+	var all []HealthChecker
+	for _, c := range checkers {
+		if c != nil {
+			all = append(all, c)
+		}
+	}
+	if spec.TCP != nil {
+		all = append(all, NewTCPHealthChecker(base, spec.TCP))
+	}
+	if spec.GRPC != nil {
+		all = append(all, NewGRPCHealthChecker(base, spec.GRPC, tlsConfig))
+	}
+	if spec.Script != nil {
+		all = append(all, NewScriptHealthChecker(base, spec.Script))
+	}
+
+	switch len(all) {
+	case 0:
+		return nil
+	case 1:
+		return all[0]
+	default:
+		return NewCompositeHealthChecker(base, spec.Mode, all...)
+	}
+}
+
+// healthCheckAddr returns the address to check of the server, port
+// overrides the port in the server URL if it is not zero.
+func healthCheckAddr(svr *Server, port int) (string, error) {
+	u, err := url.Parse(svr.URL)
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("invalid server url: %s", svr.URL)
+	}
+
+	if port != 0 {
+		return net.JoinHostPort(u.Hostname(), strconv.Itoa(port)), nil
+	}
+	if u.Port() != "" {
+		return u.Host, nil
+	}
+	switch u.Scheme {
+	case "https", "wss":
+		return net.JoinHostPort(u.Hostname(), "443"), nil
+	default:
+		return net.JoinHostPort(u.Hostname(), "80"), nil
+	}
+}
+
+// NewTCPHealthChecker creates a new TCP health checker.
+func NewTCPHealthChecker(base HealthCheckSpec, spec *TCPHealthCheckSpec) HealthChecker {
+	return &tcpHealthChecker{base: base, spec: spec}
+}
+
+// BaseSpec returns the base spec.
+func (hc *tcpHealthChecker) BaseSpec() HealthCheckSpec {
+	return hc.base
+}
+
+// Check checks the health of the server.
+func (hc *tcpHealthChecker) Check(svr *Server) bool {
+	addr, err := healthCheckAddr(svr, hc.spec.Port)
+	if err != nil {
+		logger.Errorf("tcp health check %s failed: %v", svr.URL, err)
+		return false
+	}
+
+	timeout := hc.base.GetTimeout()
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		logger.Warnf("tcp health check %s failed: %v", addr, err)
+		return false
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if hc.spec.Send != "" {
+		if _, err = conn.Write([]byte(hc.spec.Send)); err != nil {
+			logger.Warnf("tcp health check %s failed: %v", addr, err)
+			return false
+		}
+	}
+
+	if hc.spec.Expect == "" {
+		return true
+	}
+
+	expect := []byte(hc.spec.Expect)
+	buf := make([]byte, 4096)
+	var received []byte
+	for len(received) < maxTCPHealthCheckResponseSize {
+		n, err := conn.Read(buf)
+		received = append(received, buf[:n]...)
+		if bytes.Contains(received, expect) {
+			return true
+		}
+		if err != nil {
+			logger.Warnf("tcp health check %s failed: %v", addr, err)
+			return false
+		}
+	}
+
+	logger.Warnf("tcp health check %s failed: unexpected response", addr)
+	return false
+}
+
+// Close closes the health checker.
+func (hc *tcpHealthChecker) Close() {}
+
+// NewGRPCHealthChecker creates a new gRPC health checker, tlsConfig is
+// used for servers with https scheme.
+func NewGRPCHealthChecker(base HealthCheckSpec, spec *GRPCHealthCheckSpec, tlsConfig *tls.Config) HealthChecker {
+	return &grpcHealthChecker{
+		base:      base,
+		spec:      spec,
+		tlsConfig: tlsConfig,
+		conns:     map[string]*grpc.ClientConn{},
+	}
+}
+
+// BaseSpec returns the base spec.
+func (hc *grpcHealthChecker) BaseSpec() HealthCheckSpec {
+	return hc.base
+}
+
+// getConn returns the connection to the server, the connection is created
+// on first use, and reconnects by itself if it is broken.
+func (hc *grpcHealthChecker) getConn(svr *Server, addr string) (*grpc.ClientConn, error) {
+	hc.lock.Lock()
+	defer hc.lock.Unlock()
+
+	if conn := hc.conns[svr.URL]; conn != nil {
+		return conn, nil
+	}
+
+	creds := insecure.NewCredentials()
+	if u, _ := url.Parse(svr.URL); u.Scheme == "https" {
+		tlsConfig := hc.tlsConfig
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	if hc.spec.Authority != "" {
+		opts = append(opts, grpc.WithAuthority(hc.spec.Authority))
+	}
+
+	conn, err := grpc.NewClient(addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	hc.conns[svr.URL] = conn
+	return conn, nil
+}
+
+// Check checks the health of the server.
+func (hc *grpcHealthChecker) Check(svr *Server) bool {
+	addr, err := healthCheckAddr(svr, hc.spec.Port)
+	if err != nil {
+		logger.Errorf("grpc health check %s failed: %v", svr.URL, err)
+		return false
+	}
+
+	conn, err := hc.getConn(svr, addr)
+	if err != nil {
+		logger.Warnf("grpc health check %s failed: %v", addr, err)
+		return false
+	}
+
+	ctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), hc.base.GetTimeout())
+	defer cancel()
+
+	req := &grpc_health_v1.HealthCheckRequest{Service: hc.spec.Service}
+	resp, err := grpc_health_v1.NewHealthClient(conn).Check(ctx, req)
+	if err != nil {
+		logger.Warnf("grpc health check %s failed: %v", addr, err)
+		return false
+	}
+	if resp.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+		logger.Warnf("grpc health check %s failed: status %s", addr, resp.Status)
+		return false
+	}
+	return true
+}
+
+// Close closes the health checker and its connections.
+func (hc *grpcHealthChecker) Close() {
+	hc.lock.Lock()
+	defer hc.lock.Unlock()
+
+	for _, conn := range hc.conns {
+		conn.Close()
+	}
+	hc.conns = map[string]*grpc.ClientConn{}
+}
+
+// NewScriptHealthChecker creates a new script health checker.
+func NewScriptHealthChecker(base HealthCheckSpec, spec *ScriptHealthCheckSpec) HealthChecker {
+	return &scriptHealthChecker{base: base, spec: spec}
+}
+
+// BaseSpec returns the base spec.
+func (hc *scriptHealthChecker) BaseSpec() HealthCheckSpec {
+	return hc.base
+}
+
+// Check checks the health of the server, the command is killed if it
+// doesn't exit before timeout.
+func (hc *scriptHealthChecker) Check(svr *Server) bool {
+	addr, err := healthCheckAddr(svr, 0)
+	if err != nil {
+		logger.Errorf("script health check %s failed: %v", svr.URL, err)
+		return false
+	}
+	host, port, _ := net.SplitHostPort(addr)
+
+	ctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), hc.base.GetTimeout())
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, hc.spec.Command[0], hc.spec.Command[1:]...)
+	cmd.Env = append(os.Environ(),
+		"EG_SERVER_URL="+svr.URL,
+		"EG_SERVER_HOST="+host,
+		"EG_SERVER_PORT="+port,
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		logger.Warnf("script health check %s failed: %v, output: %s", svr.URL, err, out)
+		return false
+	}
+	return true
+}
+
+// Close closes the health checker.
+func (hc *scriptHealthChecker) Close() {}
+
+// NewCompositeHealthChecker creates a health checker which combines the
+// checkers by mode.
+func NewCompositeHealthChecker(base HealthCheckSpec, mode string, checkers ...HealthChecker) HealthChecker {
+	if mode == "" {
+		mode = HealthCheckModeAnd
+	}
+	return &compositeHealthChecker{base: base, mode: mode, checkers: checkers}
+}
+
+// BaseSpec returns the base spec.
+func (hc *compositeHealthChecker) BaseSpec() HealthCheckSpec {
+	return hc.base
+}
+
+// Check checks the health of the server.
+func (hc *compositeHealthChecker) Check(svr *Server) bool {
+	if hc.mode == HealthCheckModeOr {
+		for _, c := range hc.checkers {
+			if c.Check(svr) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, c := range hc.checkers {
+		if !c.Check(svr) {
+			return false
+		}
+	}
+	return true
+}
+
+// Close closes the health checker.
+func (hc *compositeHealthChecker) Close() {
+	for _, c := range hc.checkers {
+		c.Close()
+	}
+}
@@ -0,0 +1,225 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxies
+
+import (
+	"bufio"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestHealthProbesSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &HealthProbesSpec{}
+	assert.True(spec.IsEmpty())
+	assert.NoError(spec.Validate())
+
+	spec.Mode = "xor"
+	assert.Error(spec.Validate())
+	spec.Mode = HealthCheckModeOr
+	assert.NoError(spec.Validate())
+
+	spec.TCP = &TCPHealthCheckSpec{Port: -1}
+	assert.False(spec.IsEmpty())
+	assert.Error(spec.Validate())
+	spec.TCP.Port = 8080
+	assert.NoError(spec.Validate())
+
+	spec.GRPC = &GRPCHealthCheckSpec{Port: 65536}
+	assert.Error(spec.Validate())
+	spec.GRPC.Port = 0
+	assert.NoError(spec.Validate())
+
+	spec.Script = &ScriptHealthCheckSpec{}
+	assert.Error(spec.Validate())
+	spec.Script.Command = []string{"true"}
+	assert.NoError(spec.Validate())
+}
+
+func TestHealthCheckAddr(t *testing.T) {
+	assert := assert.New(t)
+
+	addr, err := healthCheckAddr(&Server{URL: "http://127.0.0.1:8080"}, 0)
+	assert.NoError(err)
+	assert.Equal("127.0.0.1:8080", addr)
+
+	addr, err = healthCheckAddr(&Server{URL: "http://127.0.0.1:8080"}, 9090)
+	assert.NoError(err)
+	assert.Equal("127.0.0.1:9090", addr)
+
+	addr, err = healthCheckAddr(&Server{URL: "https://[::1]"}, 0)
+	assert.NoError(err)
+	assert.Equal("[::1]:443", addr)
+
+	addr, err = healthCheckAddr(&Server{URL: "http://www.megaease.com"}, 0)
+	assert.NoError(err)
+	assert.Equal("www.megaease.com:80", addr)
+
+	_, err = healthCheckAddr(&Server{URL: "127.0.0.1"}, 0)
+	assert.Error(err)
+}
+
+func TestTCPHealthChecker(t *testing.T) {
+	assert := assert.New(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				line, _ := bufio.NewReader(conn).ReadString('\n')
+				if line == "PING\n" {
+					conn.Write([]byte("+PONG\r\n"))
+				} else {
+					conn.Write([]byte("-ERR\r\n"))
+				}
+			}()
+		}
+	}()
+
+	svr := &Server{URL: "http://" + ln.Addr().String()}
+	base := HealthCheckSpec{Timeout: "1s"}
+
+	hc := NewTCPHealthChecker(base, &TCPHealthCheckSpec{})
+	assert.Equal(base, hc.BaseSpec())
+	assert.True(hc.Check(svr))
+
+	hc = NewTCPHealthChecker(base, &TCPHealthCheckSpec{Send: "PING\n", Expect: "PONG"})
+	assert.True(hc.Check(svr))
+
+	hc = NewTCPHealthChecker(base, &TCPHealthCheckSpec{Send: "HELLO\n", Expect: "PONG"})
+	assert.False(hc.Check(svr))
+
+	// nothing listens on the port.
+	ln2, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(err)
+	ln2.Close()
+	hc = NewTCPHealthChecker(base, &TCPHealthCheckSpec{})
+	assert.False(hc.Check(&Server{URL: "http://" + ln2.Addr().String()}))
+	hc.Close()
+}
+
+func TestGRPCHealthChecker(t *testing.T) {
+	assert := assert.New(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(err)
+	s := grpc.NewServer()
+	hs := health.NewServer()
+	hs.SetServingStatus("easegress", grpc_health_v1.HealthCheckResponse_SERVING)
+	hs.SetServingStatus("megaease", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	grpc_health_v1.RegisterHealthServer(s, hs)
+	go s.Serve(ln)
+	defer s.Stop()
+
+	svr := &Server{URL: "http://" + ln.Addr().String()}
+	base := HealthCheckSpec{Timeout: "1s"}
+
+	hc := NewGRPCHealthChecker(base, &GRPCHealthCheckSpec{}, nil)
+	assert.Equal(base, hc.BaseSpec())
+	assert.True(hc.Check(svr))
+
+	// the connection is reused by checks.
+	conn := hc.(*grpcHealthChecker).conns[svr.URL]
+	assert.NotNil(conn)
+	assert.True(hc.Check(svr))
+	assert.Len(hc.(*grpcHealthChecker).conns, 1)
+	assert.Same(conn, hc.(*grpcHealthChecker).conns[svr.URL])
+	hc.Close()
+	assert.Empty(hc.(*grpcHealthChecker).conns)
+
+	hc = NewGRPCHealthChecker(base, &GRPCHealthCheckSpec{Service: "easegress"}, nil)
+	assert.True(hc.Check(svr))
+
+	hc = NewGRPCHealthChecker(base, &GRPCHealthCheckSpec{Service: "megaease"}, nil)
+	assert.False(hc.Check(svr))
+
+	hc = NewGRPCHealthChecker(base, &GRPCHealthCheckSpec{Service: "unknown"}, nil)
+	assert.False(hc.Check(svr))
+	hc.Close()
+}
+
+func TestScriptHealthChecker(t *testing.T) {
+	assert := assert.New(t)
+
+	svr := &Server{URL: "http://127.0.0.1:8080"}
+	base := HealthCheckSpec{Timeout: "1s"}
+
+	hc := NewScriptHealthChecker(base, &ScriptHealthCheckSpec{
+		Command: []string{"sh", "-c", `test "$EG_SERVER_HOST:$EG_SERVER_PORT" = 127.0.0.1:8080`},
+	})
+	assert.Equal(base, hc.BaseSpec())
+	assert.True(hc.Check(svr))
+	assert.False(hc.Check(&Server{URL: "http://127.0.0.1:9090"}))
+
+	// the command is killed on timeout.
+	hc = NewScriptHealthChecker(HealthCheckSpec{Timeout: "100ms"}, &ScriptHealthCheckSpec{
+		Command: []string{"sleep", "10"},
+	})
+	assert.False(hc.Check(svr))
+
+	hc = NewScriptHealthChecker(base, &ScriptHealthCheckSpec{Command: []string{"/not/exist"}})
+	assert.False(hc.Check(svr))
+	hc.Close()
+}
+
+func TestCompositeHealthChecker(t *testing.T) {
+	assert := assert.New(t)
+
+	pass := &MockHealthChecker{Result: true}
+	fail := &MockHealthChecker{Result: false}
+	base := HealthCheckSpec{Interval: "10s"}
+
+	hc := NewCompositeHealthChecker(base, "", pass, fail)
+	assert.Equal(base, hc.BaseSpec())
+	assert.False(hc.Check(&Server{}))
+	assert.True(NewCompositeHealthChecker(base, HealthCheckModeAnd, pass, pass).Check(&Server{}))
+
+	hc = NewCompositeHealthChecker(base, HealthCheckModeOr, fail, pass)
+	assert.True(hc.Check(&Server{}))
+	assert.False(NewCompositeHealthChecker(base, HealthCheckModeOr, fail, fail).Check(&Server{}))
+	hc.Close()
+
+	// probes are combined with other checkers.
+	spec := &HealthProbesSpec{}
+	assert.Nil(spec.NewHealthChecker(base, nil))
+	assert.Nil(spec.NewHealthChecker(base, nil, nil))
+	assert.Equal(pass, spec.NewHealthChecker(base, nil, pass))
+
+	spec.TCP = &TCPHealthCheckSpec{}
+	_, ok := spec.NewHealthChecker(base, nil).(*tcpHealthChecker)
+	assert.True(ok)
+
+	spec.Mode = HealthCheckModeOr
+	c, ok := spec.NewHealthChecker(base, nil, pass).(*compositeHealthChecker)
+	assert.True(ok)
+	assert.Equal(HealthCheckModeOr, c.mode)
+	assert.Len(c.checkers, 2)
+}
@@ -0,0 +1,251 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpproxy
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+)
+
+type (
+	// CoalescingSpec describes the request coalescing of a server pool.
+	CoalescingSpec struct {
+		// Headers are the request headers which are part of the key to
+		// identify identical requests, in addition to method and URL.
+		Headers []string `json:"headers,omitempty" jsonschema:"uniqueItems=true"`
+	}
+
+	// CoalescingStatus is the status of request coalescing.
+	CoalescingStatus struct {
+		InFlight  int    `json:"inFlight"`
+		Coalesced uint64 `json:"coalesced"`
+	}
+
+	// coalescer collapses concurrent identical requests into one request
+	// to the server, the first request is the leader which sends the
+	// request, and others wait for and share its response.
+	coalescer struct {
+		headers   []string
+		coalesced atomic.Uint64
+
+		mu    sync.Mutex
+		calls map[string]*coalescedCall
+	}
+
+	// coalescedCall is an in-flight request shared by identical requests.
+	coalescedCall struct {
+		done chan struct{}
+
+		// fields below are set by the leader before done is closed.
+		shared     bool
+		result     string
+		statusCode int
+		header     http.Header
+		body       []byte
+	}
+)
+
+// Validate validates the CoalescingSpec.
+func (spec *CoalescingSpec) Validate() error {
+	for _, h := range spec.Headers {
+		if strings.TrimSpace(h) == "" {
+			return fmt.Errorf("empty header name in coalescing")
+		}
+	}
+	return nil
+}
+
+// newCoalescer creates a coalescer, Accept-Encoding is always a part of
+// the key if the response may be compressed.
+func newCoalescer(spec *CoalescingSpec, compression bool) *coalescer {
+	c := &coalescer{calls: map[string]*coalescedCall{}}
+	for _, h := range spec.Headers {
+		c.headers = append(c.headers, http.CanonicalHeaderKey(strings.TrimSpace(h)))
+	}
+	if compression {
+		found := false
+		for _, h := range c.headers {
+			found = found || h == keyAcceptEncoding
+		}
+		if !found {
+			c.headers = append(c.headers, keyAcceptEncoding)
+		}
+	}
+	return c
+}
+
+// hasHeader returns whether h is a part of the key.
+func (c *coalescer) hasHeader(h string) bool {
+	for _, kh := range c.headers {
+		if kh == h {
+			return true
+		}
+	}
+	return false
+}
+
+// key returns the key of the request, it returns false if the request
+// can't be coalesced, that's, it is not a GET or HEAD request, it has
+// a body, or it carries credentials which are not a part of the key.
+func (c *coalescer) key(req *httpprot.Request) (string, bool) {
+	method := req.Method()
+	if method != http.MethodGet && method != http.MethodHead {
+		return "", false
+	}
+	if req.IsStream() || len(req.RawPayload()) > 0 {
+		return "", false
+	}
+	for _, h := range []string{"Authorization", "Cookie"} {
+		if req.HTTPHeader().Get(h) != "" && !c.hasHeader(h) {
+			return "", false
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString(method)
+	sb.WriteByte(' ')
+	sb.WriteString(httpCacheKey(req))
+	for _, h := range c.headers {
+		sb.WriteByte('\n')
+		sb.WriteString(h)
+		sb.WriteByte(':')
+		sb.WriteString(strings.Join(req.HTTPHeader().Values(h), ","))
+	}
+	return sb.String(), true
+}
+
+// join joins the in-flight call of key, or starts a new one if there's
+// no such call, it returns true if the caller is the leader.
+func (c *coalescer) join(key string) (*coalescedCall, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if call := c.calls[key]; call != nil {
+		c.coalesced.Add(1)
+		return call, false
+	}
+
+	call := &coalescedCall{done: make(chan struct{})}
+	c.calls[key] = call
+	return call, true
+}
+
+// shareable returns whether the response can be shared by the waiters,
+// stream responses and responses for a single user are not shareable.
+func shareable(resp *httpprot.Response) bool {
+	if resp == nil || resp.IsStream() {
+		return false
+	}
+	header := resp.HTTPHeader()
+	if header.Get("Set-Cookie") != "" {
+		return false
+	}
+	cc := parseCacheControl(header)
+	return !cc.has("private") && !cc.has("no-store")
+}
+
+// finish finishes the call of key with the response of the leader, the
+// response is shared only if it is shareable, otherwise the waiters send
+// their own requests.
+func (c *coalescer) finish(key string, call *coalescedCall, resp *httpprot.Response, result string) {
+	c.mu.Lock()
+	delete(c.calls, key)
+	c.mu.Unlock()
+
+	if shareable(resp) {
+		call.shared = true
+		call.result = result
+		call.statusCode = resp.StatusCode()
+		call.header = resp.HTTPHeader().Clone()
+		call.body = bytes.Clone(resp.RawPayload())
+	}
+	close(call.done)
+}
+
+func (c *coalescer) status() *CoalescingStatus {
+	c.mu.Lock()
+	inFlight := len(c.calls)
+	c.mu.Unlock()
+
+	return &CoalescingStatus{
+		InFlight:  inFlight,
+		Coalesced: c.coalesced.Load(),
+	}
+}
+
+// handleCoalesced handles the request with request coalescing, if the
+// response of the leader can't be shared, the waiters send their own
+// requests.
+func (sp *ServerPool) handleCoalesced(spCtx *serverPoolContext) string {
+	c := sp.coalescer
+	key, ok := c.key(spCtx.req)
+	if !ok {
+		return sp.handleRequest(spCtx)
+	}
+
+	call, leader := c.join(key)
+	if leader {
+		result := ""
+		// finish the call even if the handler panics, so that the
+		// waiters are not blocked forever.
+		defer func() {
+			c.finish(key, call, spCtx.resp, result)
+		}()
+		result = sp.handleRequest(spCtx)
+		return result
+	}
+
+	select {
+	case <-call.done:
+	case <-spCtx.WithDeadline(spCtx.req.Context()).Done():
+		if spCtx.DeadlineExceeded() {
+			spCtx.AddTag("deadline exceeded while coalescing")
+			sp.buildFailureResponse(spCtx, http.StatusGatewayTimeout)
+			return resultTimeout
+		}
+		spCtx.AddTag("client canceled while coalescing")
+		sp.buildFailureResponse(spCtx, 499)
+		return resultClientError
+	}
+
+	if !call.shared {
+		return sp.handleRequest(spCtx)
+	}
+
+	resp, _ := spCtx.GetOutputResponse().(*httpprot.Response)
+	if resp == nil {
+		resp, _ = httpprot.NewResponse(nil)
+	}
+	header := resp.HTTPHeader()
+	for k, v := range call.header {
+		header[k] = v
+	}
+	resp.SetStatusCode(call.statusCode)
+	resp.SetPayload(call.body)
+
+	spCtx.AddTag("coalesced")
+	spCtx.resp = resp
+	spCtx.SetOutputResponse(resp)
+	return call.result
+}
@@ -0,0 +1,229 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpproxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCoalescingSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError((&CoalescingSpec{}).Validate())
+	assert.NoError((&CoalescingSpec{Headers: []string{"Authorization"}}).Validate())
+	assert.Error((&CoalescingSpec{Headers: []string{" "}}).Validate())
+}
+
+func TestCoalescerKey(t *testing.T) {
+	assert := assert.New(t)
+
+	c := newCoalescer(&CoalescingSpec{Headers: []string{"x-user"}}, false)
+	assert.Equal([]string{"X-User"}, c.headers)
+
+	stdr, _ := http.NewRequest(http.MethodPost, "http://example.com/a?b=1", nil)
+	req, _ := httpprot.NewRequest(stdr)
+	_, ok := c.key(req)
+	assert.False(ok)
+
+	stdr, _ = http.NewRequest(http.MethodGet, "http://example.com/a?b=1", strings.NewReader("body"))
+	req, _ = httpprot.NewRequest(stdr)
+	req.FetchPayload(0)
+	_, ok = c.key(req)
+	assert.False(ok)
+
+	stdr, _ = http.NewRequest(http.MethodGet, "http://example.com/a?b=1", nil)
+	stdr.Header.Set("X-User", "alice")
+	req, _ = httpprot.NewRequest(stdr)
+	key1, ok := c.key(req)
+	assert.True(ok)
+	stdr.Header.Set("X-User", "bob")
+	key2, _ := c.key(req)
+	assert.NotEqual(key1, key2)
+	stdr.Header.Set("Accept-Encoding", "gzip")
+	key3, _ := c.key(req)
+	assert.Equal(key2, key3)
+
+	// credentials which are not a part of the key.
+	stdr.Header.Set("Authorization", "Basic xxx")
+	_, ok = c.key(req)
+	assert.False(ok)
+	stdr.Header.Del("Authorization")
+	stdr.Header.Set("Cookie", "a=b")
+	_, ok = c.key(req)
+	assert.False(ok)
+
+	c = newCoalescer(&CoalescingSpec{Headers: []string{"Cookie"}}, false)
+	key1, ok = c.key(req)
+	assert.True(ok)
+	stdr.Header.Set("Cookie", "a=c")
+	key2, _ = c.key(req)
+	assert.NotEqual(key1, key2)
+
+	c = newCoalescer(&CoalescingSpec{}, true)
+	assert.Equal([]string{"Accept-Encoding"}, c.headers)
+	c = newCoalescer(&CoalescingSpec{Headers: []string{"accept-encoding"}}, true)
+	assert.Equal([]string{"Accept-Encoding"}, c.headers)
+}
+
+func TestCoalescer(t *testing.T) {
+	assert := assert.New(t)
+
+	c := newCoalescer(&CoalescingSpec{}, false)
+	call, leader := c.join("k")
+	assert.True(leader)
+	call2, leader := c.join("k")
+	assert.False(leader)
+	assert.Same(call, call2)
+	assert.Equal(&CoalescingStatus{InFlight: 1, Coalesced: 1}, c.status())
+
+	// stream responses are not shared.
+	resp, _ := httpprot.NewResponse(nil)
+	resp.SetPayload(strings.NewReader("stream"))
+	c.finish("k", call, resp, "")
+	<-call.done
+	assert.False(call.shared)
+	assert.Equal(0, c.status().InFlight)
+
+	call, _ = c.join("k")
+	resp, _ = httpprot.NewResponse(nil)
+	resp.SetStatusCode(http.StatusNotFound)
+	resp.SetPayload([]byte("not found"))
+	c.finish("k", call, resp, resultFailureCode)
+	assert.True(call.shared)
+	assert.Equal(http.StatusNotFound, call.statusCode)
+	assert.Equal("not found", string(call.body))
+	assert.Equal(resultFailureCode, call.result)
+
+	call, _ = c.join("k")
+	c.finish("k", call, nil, "")
+	assert.False(call.shared)
+
+	// responses for a single user are not shared.
+	for _, h := range []http.Header{
+		{"Set-Cookie": {"a=b"}},
+		{"Cache-Control": {"private"}},
+		{"Cache-Control": {"no-store"}},
+	} {
+		call, _ = c.join("k")
+		resp, _ = httpprot.NewResponse(nil)
+		for k, v := range h {
+			resp.HTTPHeader()[k] = v
+		}
+		resp.SetPayload([]byte("user data"))
+		c.finish("k", call, resp, "")
+		assert.False(call.shared)
+	}
+}
+
+func TestCoalescingProxy(t *testing.T) {
+	assert := assert.New(t)
+
+	var hits atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+		w.Header().Set("X-Path", r.URL.Path)
+		fmt.Fprintf(w, "hello %s", r.URL.Path)
+	}))
+	defer srv.Close()
+
+	old := fnSendRequest
+	defer func() { fnSendRequest = old }()
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		return client.Do(r)
+	}
+
+	yamlConfig := fmt.Sprintf(`
+name: proxy
+kind: Proxy
+pools:
+- servers:
+  - url: %s
+  coalescing:
+    headers: [X-User]
+`, srv.URL)
+	proxy := newTestProxy(yamlConfig, assert)
+	defer proxy.Close()
+
+	do := func(method, path, user string) (string, *httpprot.Response, string) {
+		stdr, _ := http.NewRequest(method, "http://www.megaease.com"+path, nil)
+		stdr.Header.Set("X-User", user)
+		ctx := getCtx(stdr)
+		result := proxy.Handle(ctx)
+		resp := ctx.GetResponse(context.DefaultNamespace).(*httpprot.Response)
+		return result, resp, ctx.Tags()
+	}
+
+	const n = 10
+	var wg sync.WaitGroup
+	var coalesced atomic.Int32
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, resp, tags := do(http.MethodGet, "/slow", "alice")
+			assert.Equal("", result)
+			assert.Equal(http.StatusOK, resp.StatusCode())
+			assert.Equal("/slow", resp.HTTPHeader().Get("X-Path"))
+			assert.Equal("hello /slow", string(resp.RawPayload()))
+			if strings.Contains(tags, "coalesced") {
+				coalesced.Add(1)
+			}
+		}()
+	}
+
+	status := func() *CoalescingStatus {
+		return proxy.mainPool.status().Coalescing
+	}
+	assert.Eventually(func() bool {
+		return status().Coalesced == n-1
+	}, 3*time.Second, 10*time.Millisecond)
+	assert.Equal(1, status().InFlight)
+
+	// requests with a different key are not coalesced.
+	go do(http.MethodGet, "/slow", "bob")
+	assert.Eventually(func() bool {
+		return status().InFlight == 2
+	}, 3*time.Second, 10*time.Millisecond)
+
+	// requests can't be coalesced are sent directly.
+	result, resp, _ := do(http.MethodPost, "/fast", "alice")
+	assert.Equal("", result)
+	assert.Equal("hello /fast", string(resp.RawPayload()))
+
+	close(release)
+	wg.Wait()
+	assert.Equal(int32(n-1), coalesced.Load())
+	assert.Eventually(func() bool {
+		return hits.Load() == 3 && status().InFlight == 0
+	}, 3*time.Second, 10*time.Millisecond)
+}
@@ -18,23 +18,35 @@
 package httpproxy
 
 import (
+	"fmt"
 	"net/http"
+	"path"
+	"strconv"
 	"strings"
 
+	"github.com/megaease/easegress/v2/pkg/logger"
 	"github.com/megaease/easegress/v2/pkg/util/readers"
 )
 
-// TODO: Expose more options: compression level, mime types.
-
 type (
 	// compression is filter compression.
 	compression struct {
-		spec *CompressionSpec
+		spec      *CompressionSpec
+		encodings []string
 	}
 
 	// CompressionSpec describes the compression.
 	CompressionSpec struct {
 		MinLength uint32 `json:"minLength"`
+		// Encodings is the list of supported encodings in the order of
+		// server preference, it is gzip only if empty.
+		Encodings []string `json:"encodings,omitempty" jsonschema:"uniqueItems=true,enum=gzip,enum=br,enum=zstd"`
+		// Level is the compression level, zero means the default level
+		// of each encoding.
+		Level int `json:"level,omitempty" jsonschema:"minimum=0"`
+		// ContentTypes is the allowlist of response media types, items
+		// like 'text/*' are supported, all types are allowed if empty.
+		ContentTypes []string `json:"contentTypes,omitempty"`
 	}
 )
 
@@ -42,21 +54,54 @@ const (
 	keyAcceptEncoding  = "Accept-Encoding"
 	keyContentEncoding = "Content-Encoding"
 	keyContentLength   = "Content-Length"
+	keyContentType     = "Content-Type"
 	keyVary            = "Vary"
 )
 
+// Validate validates CompressionSpec.
+func (spec *CompressionSpec) Validate() error {
+	encodings := spec.Encodings
+	if len(encodings) == 0 {
+		encodings = []string{readers.EncodingGzip}
+	}
+
+	for _, encoding := range encodings {
+		if !readers.IsSupportedEncoding(encoding) {
+			return fmt.Errorf("unsupported encoding %q", encoding)
+		}
+		if err := readers.ValidateLevel(encoding, spec.Level); err != nil {
+			return err
+		}
+	}
+
+	for _, ct := range spec.ContentTypes {
+		if _, err := path.Match(ct, ""); err != nil {
+			return fmt.Errorf("invalid content type %q: %v", ct, err)
+		}
+	}
+
+	return nil
+}
+
 func newCompression(spec *CompressionSpec) *compression {
+	encodings := spec.Encodings
+	if len(encodings) == 0 {
+		encodings = []string{readers.EncodingGzip}
+	}
+
 	return &compression{
-		spec: spec,
+		spec:      spec,
+		encodings: encodings,
 	}
 }
 
 func (c *compression) compress(req *http.Request, resp *http.Response) bool {
-	if !c.acceptGzip(req) {
+	encoding := c.negotiate(req)
+	if encoding == "" {
 		return false
 	}
 
-	if c.alreadyGziped(resp) {
+	if c.alreadyEncoded(resp) {
 		return false
 	}
 
@@ -64,18 +109,29 @@ func (c *compression) compress(req *http.Request, resp *http.Response) bool {
 		return false
 	}
 
+	if !c.allowContentType(resp) {
+		return false
+	}
+
+	zr, err := readers.NewCompressReader(resp.Body, encoding, c.spec.Level)
+	if err != nil {
+		logger.Errorf("create %s compress reader failed: %v", encoding, err)
+		return false
+	}
+
 	resp.ContentLength = -1
 	resp.Header.Del(keyContentLength)
-	resp.Header.Set(keyContentEncoding, "gzip")
-	resp.Header.Add(keyVary, keyContentEncoding)
+	resp.Header.Set(keyContentEncoding, encoding)
+	resp.Header.Add(keyVary, keyAcceptEncoding)
 
-	resp.Body = readers.NewGZipCompressReader(resp.Body)
+	resp.Body = zr
 	return true
 }
 
-func (c *compression) alreadyGziped(resp *http.Response) bool {
+func (c *compression) alreadyEncoded(resp *http.Response) bool {
 	for _, ce := range resp.Header.Values(keyContentEncoding) {
-		if strings.Contains(ce, "gzip") {
+		ce = strings.TrimSpace(ce)
+		if ce != "" && !strings.EqualFold(ce, "identity") {
 			return true
 		}
 	}
@@ -83,20 +139,91 @@ func (c *compression) alreadyGziped(resp *http.Response) bool {
 	return false
 }
 
-func (c *compression) acceptGzip(req *http.Request) bool {
+func (c *compression) allowContentType(resp *http.Response) bool {
+	if len(c.spec.ContentTypes) == 0 {
+		return true
+	}
+
+	mediaType, _, _ := strings.Cut(resp.Header.Get(keyContentType), ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if mediaType == "" {
+		return false
+	}
+
+	for _, ct := range c.spec.ContentTypes {
+		if ok, _ := path.Match(strings.ToLower(ct), mediaType); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// negotiate selects the encoding of the response according to the
+// Accept-Encoding header of the request and the quality values in it,
+// ties are broken by the server preference. It returns an empty string
+// if none of the supported encodings is acceptable.
+//
+// Reference: https://www.rfc-editor.org/rfc/rfc9110#section-12.5.3
+func (c *compression) negotiate(req *http.Request) string {
 	acceptEncodings := req.Header.Values(keyAcceptEncoding)
 
-	// NOTE: Easegress does not support parsing qvalue for performance.
-	// Reference: https://tools.ietf.org/html/rfc2616#section-14.3
-	if len(acceptEncodings) > 0 {
-		for _, ae := range acceptEncodings {
-			if strings.Contains(ae, "*/*") ||
-				strings.Contains(ae, "gzip") {
-				return true
+	// Keep the behavior of previous versions, compress the response with
+	// the most preferred encoding if there's no Accept-Encoding.
+	if len(acceptEncodings) == 0 {
+		return c.encodings[0]
+	}
+
+	qvalues := map[string]float64{}
+	for _, ae := range acceptEncodings {
+		for _, item := range strings.Split(ae, ",") {
+			coding, q, ok := parseQValue(item)
+			if !ok {
+				continue
 			}
+			// '*/*' is not a valid content coding, but it was accepted
+			// by previous versions, so treat it as '*'.
+			if coding == "*/*" {
+				coding = "*"
+			}
+			qvalues[coding] = q
 		}
-		return false
 	}
 
-	return true
+	best, bestQ := "", 0.0
+	for _, encoding := range c.encodings {
+		q, ok := qvalues[encoding]
+		if !ok {
+			q, ok = qvalues["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+
+	return best
+}
+
+// parseQValue parses an item of Accept-Encoding like 'gzip;q=0.8'.
+func parseQValue(item string) (coding string, q float64, ok bool) {
+	coding, params, _ := strings.Cut(item, ";")
+	coding = strings.ToLower(strings.TrimSpace(coding))
+	if coding == "" {
+		return "", 0, false
+	}
+
+	q = 1
+	for _, param := range strings.Split(params, ";") {
+		k, v, found := strings.Cut(param, "=")
+		if !found || strings.ToLower(strings.TrimSpace(k)) != "q" {
+			continue
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil || f < 0 || f > 1 {
+			return "", 0, false
+		}
+		q = f
+	}
+
+	return coding, q, true
 }
@@ -22,54 +22,119 @@ import (
 	"net/http"
 	"strings"
 	"testing"
+
+	"github.com/megaease/easegress/v2/pkg/util/readers"
+	"github.com/stretchr/testify/assert"
 )
 
-func TestAcceptGzip(t *testing.T) {
+func TestCompressionSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &CompressionSpec{}
+	assert.NoError(spec.Validate())
+
+	spec = &CompressionSpec{Encodings: []string{"br", "zstd", "gzip"}, Level: 5}
+	assert.NoError(spec.Validate())
+
+	spec = &CompressionSpec{Encodings: []string{"deflate"}}
+	assert.Error(spec.Validate())
+
+	spec = &CompressionSpec{Encodings: []string{"br"}, Level: 10}
+	assert.NoError(spec.Validate())
+	spec.Encodings = append(spec.Encodings, "gzip")
+	assert.Error(spec.Validate())
+
+	spec = &CompressionSpec{Level: 10}
+	assert.Error(spec.Validate())
+
+	spec = &CompressionSpec{ContentTypes: []string{"text/*", "application/json"}}
+	assert.NoError(spec.Validate())
+	spec.ContentTypes = append(spec.ContentTypes, "text/[")
+	assert.Error(spec.Validate())
+}
+
+func TestNegotiate(t *testing.T) {
+	assert := assert.New(t)
+
 	c := newCompression(&CompressionSpec{MinLength: 100})
 
 	req, _ := http.NewRequest(http.MethodGet, "https://megaease.com", nil)
-	if !c.acceptGzip(req) {
-		t.Error("accept gzip should be true")
-	}
+	assert.Equal("gzip", c.negotiate(req))
 
-	req.Header.Add(keyAcceptEncoding, "text/text")
-	if c.acceptGzip(req) {
-		t.Error("accept gzip should be false")
-	}
+	req.Header.Set(keyAcceptEncoding, "text/text")
+	assert.Equal("", c.negotiate(req))
 
 	req.Header.Add(keyAcceptEncoding, "*/*")
-	if !c.acceptGzip(req) {
-		t.Error("accept gzip should be true")
-	}
+	assert.Equal("gzip", c.negotiate(req))
 
-	req.Header.Del(keyAcceptEncoding)
-	req.Header.Add(keyAcceptEncoding, "gzip")
-	if !c.acceptGzip(req) {
-		t.Error("accept gzip should be true")
-	}
+	req.Header.Set(keyAcceptEncoding, "gzip")
+	assert.Equal("gzip", c.negotiate(req))
+
+	req.Header.Set(keyAcceptEncoding, "gzip;q=0, *")
+	assert.Equal("", c.negotiate(req))
+
+	c = newCompression(&CompressionSpec{Encodings: []string{"zstd", "br", "gzip"}})
+
+	req.Header.Set(keyAcceptEncoding, "gzip, deflate, br")
+	assert.Equal("br", c.negotiate(req))
+
+	req.Header.Set(keyAcceptEncoding, "gzip;q=1.0, br;q=0.5, zstd;q=0.8")
+	assert.Equal("gzip", c.negotiate(req))
+
+	req.Header.Set(keyAcceptEncoding, "GZIP ; q=0.2, *;q=0.5")
+	assert.Equal("zstd", c.negotiate(req))
+
+	req.Header.Set(keyAcceptEncoding, "zstd;q=0, br;q=0")
+	assert.Equal("", c.negotiate(req))
+
+	req.Header.Set(keyAcceptEncoding, "zstd;q=2, br;q=abc, gzip;q=0.1")
+	assert.Equal("gzip", c.negotiate(req))
+
+	req.Header.Set(keyAcceptEncoding, "identity")
+	assert.Equal("", c.negotiate(req))
 }
 
-func TestAlreadyGziped(t *testing.T) {
+func TestAlreadyEncoded(t *testing.T) {
+	assert := assert.New(t)
+
 	c := newCompression(&CompressionSpec{MinLength: 100})
 
 	resp := &http.Response{Header: http.Header{}}
+	assert.False(c.alreadyEncoded(resp))
 
-	if c.alreadyGziped(resp) {
-		t.Error("already gziped should be false")
-	}
+	resp.Header.Set(keyContentEncoding, "identity")
+	assert.False(c.alreadyEncoded(resp))
 
-	resp.Header.Add(keyContentEncoding, "text")
-	if c.alreadyGziped(resp) {
-		t.Error("already gziped should be false")
-	}
+	resp.Header.Set(keyContentEncoding, "br")
+	assert.True(c.alreadyEncoded(resp))
 
-	resp.Header.Add(keyContentEncoding, "gzip")
-	if !c.alreadyGziped(resp) {
-		t.Error("already gziped should be true")
-	}
+	resp.Header.Set(keyContentEncoding, "gzip")
+	assert.True(c.alreadyEncoded(resp))
+}
+
+func TestAllowContentType(t *testing.T) {
+	assert := assert.New(t)
+
+	c := newCompression(&CompressionSpec{})
+	resp := &http.Response{Header: http.Header{}}
+	assert.True(c.allowContentType(resp))
+
+	c = newCompression(&CompressionSpec{ContentTypes: []string{"text/*", "application/json"}})
+	assert.False(c.allowContentType(resp))
+
+	resp.Header.Set(keyContentType, "text/html; charset=utf-8")
+	assert.True(c.allowContentType(resp))
+
+	resp.Header.Set(keyContentType, "Application/JSON")
+	assert.True(c.allowContentType(resp))
+
+	resp.Header.Set(keyContentType, "image/png")
+	assert.False(c.allowContentType(resp))
 }
 
 func TestCompress(t *testing.T) {
+	assert := assert.New(t)
+
 	c := newCompression(&CompressionSpec{MinLength: 100})
 
 	req, _ := http.NewRequest(http.MethodGet, "https://megaease.com", nil)
@@ -79,21 +144,42 @@ func TestCompress(t *testing.T) {
 	resp.Body = io.NopCloser(strings.NewReader(rawBody))
 
 	resp.ContentLength = 20
-	c.compress(req, resp)
-	if resp.Header.Get(keyContentEncoding) == "gzip" {
-		t.Error("body should not be gziped")
-	}
+	assert.False(c.compress(req, resp))
+	assert.Empty(resp.Header.Get(keyContentEncoding))
 
 	resp.Body = http.NoBody
 
 	resp.ContentLength = 120
-	c.compress(req, resp)
-	if resp.Header.Get(keyContentEncoding) != "gzip" {
-		t.Error("body should be gziped")
-	}
+	assert.True(c.compress(req, resp))
+	assert.Equal("gzip", resp.Header.Get(keyContentEncoding))
+	assert.Equal(keyAcceptEncoding, resp.Header.Get(keyVary))
 
 	data, _ := io.ReadAll(resp.Body)
-	if len(data) == 0 {
-		t.Error("data length should not be zero")
+	assert.NotZero(len(data))
+
+	c = newCompression(&CompressionSpec{
+		Encodings:    []string{"zstd", "br"},
+		ContentTypes: []string{"text/*"},
+	})
+
+	for _, encoding := range []string{"br", "zstd"} {
+		req.Header.Set(keyAcceptEncoding, "gzip, "+encoding)
+		resp = &http.Response{Header: http.Header{}, ContentLength: -1}
+		resp.Header.Set(keyContentType, "text/plain")
+		resp.Body = io.NopCloser(strings.NewReader(rawBody))
+
+		assert.True(c.compress(req, resp))
+		assert.Equal(encoding, resp.Header.Get(keyContentEncoding))
+
+		zr, err := readers.NewDecompressReader(resp.Body, encoding)
+		assert.NoError(err)
+		data, err = io.ReadAll(zr)
+		assert.NoError(err)
+		assert.Equal(rawBody, string(data))
 	}
+
+	resp = &http.Response{Header: http.Header{}, ContentLength: -1}
+	resp.Header.Set(keyContentType, "image/png")
+	resp.Body = io.NopCloser(strings.NewReader(rawBody))
+	assert.False(c.compress(req, resp))
 }
@@ -39,9 +39,13 @@ const (
 )
 
 // ProxyHealthCheckSpec is the spec of http proxy health check.
+//
+// The HTTP probe is always used if no other probe is configured, otherwise,
+// it is used only when uri (or the deprecated path) is set.
 type ProxyHealthCheckSpec struct {
-	proxies.HealthCheckSpec `json:",inline"`
-	HTTPHealthCheckSpec     `json:",inline"`
+	proxies.HealthCheckSpec  `json:",inline"`
+	HTTPHealthCheckSpec      `json:",inline"`
+	proxies.HealthProbesSpec `json:",inline"`
 }
 
 // HTTPHealthCheckSpec is the spec of HTTP health check.
@@ -200,7 +204,10 @@ func (spec *HTTPHealthCheckSpec) Validate() error {
 
 // Validate validates HealthCheckSpec.
 func (spec *ProxyHealthCheckSpec) Validate() error {
-	return spec.HTTPHealthCheckSpec.Validate()
+	if err := spec.HTTPHealthCheckSpec.Validate(); err != nil {
+		return err
+	}
+	return spec.HealthProbesSpec.Validate()
 }
 
 type httpHealthChecker struct {
@@ -254,11 +261,21 @@ func (hc *httpHealthChecker) Check(server *proxies.Server) bool {
 // Close closes the health checker.
 func (hc *httpHealthChecker) Close() {}
 
-// NewHTTPHealthChecker creates a new HTTP health checker.
+// NewHTTPHealthChecker creates a new HTTP health checker, which is combined
+// with the other probes in spec.
 func NewHTTPHealthChecker(tlsConfig *tls.Config, spec *ProxyHealthCheckSpec) proxies.HealthChecker {
 	if spec == nil {
 		return nil
 	}
+
+	var hc proxies.HealthChecker
+	if spec.IsEmpty() || spec.URI != "" || spec.Path != "" {
+		hc = newHTTPHealthChecker(tlsConfig, spec)
+	}
+	return spec.NewHealthChecker(spec.HealthCheckSpec, tlsConfig, hc)
+}
+
+func newHTTPHealthChecker(tlsConfig *tls.Config, spec *ProxyHealthCheckSpec) *httpHealthChecker {
 	if spec.Method == "" {
 		spec.Method = http.MethodGet
 	}
@@ -306,9 +323,10 @@ func NewHTTPHealthChecker(tlsConfig *tls.Config, spec *ProxyHealthCheckSpec) pro
 
 // WSProxyHealthCheckSpec is the spec of ws proxy health check.
 type WSProxyHealthCheckSpec struct {
-	proxies.HealthCheckSpec `json:",inline"`
-	HTTP                    *HTTPHealthCheckSpec `json:"http,omitempty"`
-	WS                      *WSHealthCheckSpec   `json:"ws,omitempty"`
+	proxies.HealthCheckSpec  `json:",inline"`
+	proxies.HealthProbesSpec `json:",inline"`
+	HTTP                     *HTTPHealthCheckSpec `json:"http,omitempty"`
+	WS                       *WSHealthCheckSpec   `json:"ws,omitempty"`
 }
 
 type WSHealthCheckSpec struct {
@@ -319,9 +337,12 @@ type WSHealthCheckSpec struct {
 }
 
 func (spec *WSProxyHealthCheckSpec) Validate() error {
-	if spec.HTTP == nil && spec.WS == nil {
+	if spec.HTTP == nil && spec.WS == nil && spec.IsEmpty() {
 		return fmt.Errorf("empty health check spec")
 	}
+	if err := spec.HealthProbesSpec.Validate(); err != nil {
+		return err
+	}
 	if spec.HTTP != nil {
 		err := spec.HTTP.Validate()
 		if err != nil {
@@ -403,6 +424,14 @@ func NewWebSocketHealthChecker(spec *WSProxyHealthCheckSpec) proxies.HealthCheck
 		return nil
 	}
 
+	var hc proxies.HealthChecker
+	if spec.HTTP != nil || spec.WS != nil {
+		hc = newWebSocketHealthChecker(spec)
+	}
+	return spec.NewHealthChecker(spec.HealthCheckSpec, nil, hc)
+}
+
+func newWebSocketHealthChecker(spec *WSProxyHealthCheckSpec) *wsHealthChecker {
 	res := &wsHealthChecker{spec: spec}
 	if spec.HTTP != nil {
 		httpSpec := &ProxyHealthCheckSpec{
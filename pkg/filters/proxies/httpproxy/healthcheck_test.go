@@ -628,3 +628,44 @@ func TestWebSocketHealthCheck(t *testing.T) {
 		assert.False(hc.Check(s))
 	}
 }
+
+func TestHealthCheckWithProbes(t *testing.T) {
+	assert := assert.New(t)
+
+	// the HTTP probe is used when no other probe is configured.
+	spec := &ProxyHealthCheckSpec{}
+	_, ok := NewHTTPHealthChecker(nil, spec).(*httpHealthChecker)
+	assert.True(ok)
+
+	// the HTTP probe is not used when uri is not set.
+	spec = &ProxyHealthCheckSpec{
+		HealthProbesSpec: proxies.HealthProbesSpec{
+			TCP:  &proxies.TCPHealthCheckSpec{},
+			Mode: "xor",
+		},
+	}
+	assert.Error(spec.Validate())
+	spec.Mode = proxies.HealthCheckModeOr
+	assert.NoError(spec.Validate())
+	hc := NewHTTPHealthChecker(nil, spec)
+	_, ok = hc.(*httpHealthChecker)
+	assert.False(ok)
+
+	spec.URI = "/healthz"
+	hc = NewHTTPHealthChecker(nil, spec)
+	assert.NotNil(hc)
+	_, ok = hc.(*httpHealthChecker)
+	assert.False(ok)
+
+	// websocket
+	wsSpec := &WSProxyHealthCheckSpec{}
+	assert.Error(wsSpec.Validate())
+	wsSpec.TCP = &proxies.TCPHealthCheckSpec{}
+	assert.NoError(wsSpec.Validate())
+	assert.NotNil(NewWebSocketHealthChecker(wsSpec))
+	_, ok = NewWebSocketHealthChecker(wsSpec).(*wsHealthChecker)
+	assert.False(ok)
+
+	wsSpec.WS = &WSHealthCheckSpec{}
+	assert.NotNil(NewWebSocketHealthChecker(wsSpec))
+}
@@ -0,0 +1,325 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpproxy
+
+import (
+	stdctx "context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+type (
+	// HTTP2Spec is the spec of HTTP/2 connections to servers.
+	HTTP2Spec struct {
+		// H2C sends requests with HTTP/2 over cleartext TCP connections
+		// (prior knowledge), otherwise HTTP/2 over TLS is used.
+		H2C bool `json:"h2c,omitempty"`
+		// MaxConcurrentStreams is the maximum number of concurrent streams
+		// of a connection, zero means it is only limited by the server.
+		MaxConcurrentStreams uint32 `json:"maxConcurrentStreams,omitempty"`
+		// ShareConnection multiplexes all requests to a server on a single
+		// connection, requests wait for available streams instead of
+		// opening new connections.
+		ShareConnection bool `json:"shareConnection,omitempty"`
+	}
+
+	// http2Transport is an http.RoundTripper which sends requests with
+	// HTTP/2 and manages the connections by itself, so that the number
+	// of concurrent streams of a connection can be limited.
+	http2Transport struct {
+		spec *HTTP2Spec
+		t    *http2.Transport
+		dial func(ctx stdctx.Context, addr string) (net.Conn, error)
+
+		mu      sync.Mutex
+		conns   map[string][]*http2Conn
+		dialing map[string]bool
+		// notify is closed and replaced when a stream finishes or a new
+		// connection is ready, to wake up requests waiting for streams.
+		notify chan struct{}
+	}
+
+	http2Conn struct {
+		cc      *http2.ClientConn
+		streams uint32
+	}
+
+	// http2Body releases the stream when the response body is
+	// consumed or closed.
+	http2Body struct {
+		io.ReadCloser
+		once    sync.Once
+		release func()
+	}
+)
+
+// Validate validates HTTP2Spec.
+func (spec *HTTP2Spec) Validate(servers []*Server) error {
+	scheme := "https"
+	if spec.H2C {
+		scheme = "http"
+	}
+
+	for _, s := range servers {
+		u, err := url.Parse(s.URL)
+		if err != nil {
+			return err
+		}
+		if u.Scheme != scheme {
+			return fmt.Errorf("server %s: scheme must be %s when h2c is %v", s.URL, scheme, spec.H2C)
+		}
+	}
+
+	return nil
+}
+
+func newHTTP2Transport(tlsCfg *tls.Config, spec *HTTP2Spec, dialFunc func(ctx stdctx.Context, network, addr string) (net.Conn, error)) *http2Transport {
+	t := &http2Transport{
+		spec: spec,
+		t: &http2.Transport{
+			AllowHTTP:                  spec.H2C,
+			StrictMaxConcurrentStreams: spec.ShareConnection,
+			IdleConnTimeout:            90 * time.Second,
+			ReadIdleTimeout:            30 * time.Second,
+		},
+		conns:   map[string][]*http2Conn{},
+		dialing: map[string]bool{},
+		notify:  make(chan struct{}),
+	}
+
+	if spec.H2C {
+		t.dial = func(ctx stdctx.Context, addr string) (net.Conn, error) {
+			return dialFunc(ctx, "tcp", addr)
+		}
+		return t
+	}
+
+	if tlsCfg == nil {
+		tlsCfg = &tls.Config{}
+	}
+	t.dial = func(ctx stdctx.Context, addr string) (net.Conn, error) {
+		conn, err := dialFunc(ctx, "tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg := tlsCfg.Clone()
+		cfg.NextProtos = []string{http2.NextProtoTLS}
+		if cfg.ServerName == "" {
+			cfg.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+
+		tlsConn := tls.Client(conn, cfg)
+		ctx, cancel := stdctx.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		if err = tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		if p := tlsConn.ConnectionState().NegotiatedProtocol; p != http2.NextProtoTLS {
+			tlsConn.Close()
+			return nil, fmt.Errorf("server %s does not support HTTP/2, negotiated protocol: %q", addr, p)
+		}
+		return tlsConn, nil
+	}
+
+	return t
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *http2Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	addr := http2Addr(req)
+
+	conn, err := t.getConn(req.Context(), addr)
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+
+	release := func() { t.releaseStream(conn) }
+	resp, err := conn.cc.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	resp.Body = &http2Body{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// getConn returns a connection which can take a new stream, it dials a
+// new connection when necessary, or waits for available streams if the
+// connection is shared.
+func (t *http2Transport) getConn(ctx stdctx.Context, addr string) (*http2Conn, error) {
+	for {
+		t.mu.Lock()
+		conns := t.pruneLocked(addr)
+		for _, c := range conns {
+			if t.availableLocked(c) {
+				c.streams++
+				t.mu.Unlock()
+				return c, nil
+			}
+		}
+
+		if !t.dialing[addr] && (len(conns) == 0 || !t.spec.ShareConnection) {
+			t.dialing[addr] = true
+			t.mu.Unlock()
+
+			c, err := t.dialConn(addr)
+
+			t.mu.Lock()
+			delete(t.dialing, addr)
+			if err != nil {
+				t.notifyLocked()
+				t.mu.Unlock()
+				return nil, err
+			}
+
+			c.streams++
+			t.conns[addr] = append(t.conns[addr], c)
+			t.notifyLocked()
+			t.mu.Unlock()
+			return c, nil
+		}
+
+		notify := t.notify
+		t.mu.Unlock()
+
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (t *http2Transport) dialConn(addr string) (*http2Conn, error) {
+	// NOTE: the connection is shared by requests, so it should not be
+	// canceled by the context of the request which triggers the dial.
+	conn, err := t.dial(stdctx.Background(), addr)
+	if err != nil {
+		return nil, err
+	}
+
+	cc, err := t.t.NewClientConn(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &http2Conn{cc: cc}, nil
+}
+
+// pruneLocked removes closed connections of addr and returns the rest.
+func (t *http2Transport) pruneLocked(addr string) []*http2Conn {
+	conns := t.conns[addr]
+	alive := conns[:0]
+	for _, c := range conns {
+		st := c.cc.State()
+		if st.Closed || (st.Closing && c.streams == 0) {
+			continue
+		}
+		alive = append(alive, c)
+	}
+
+	for i := len(alive); i < len(conns); i++ {
+		conns[i] = nil
+	}
+
+	if len(alive) == 0 {
+		delete(t.conns, addr)
+		return nil
+	}
+	t.conns[addr] = alive
+	return alive
+}
+
+func (t *http2Transport) availableLocked(c *http2Conn) bool {
+	if max := t.spec.MaxConcurrentStreams; max > 0 && c.streams >= max {
+		return false
+	}
+	return c.cc.CanTakeNewRequest()
+}
+
+func (t *http2Transport) releaseStream(c *http2Conn) {
+	t.mu.Lock()
+	c.streams--
+	t.notifyLocked()
+	t.mu.Unlock()
+}
+
+func (t *http2Transport) notifyLocked() {
+	close(t.notify)
+	t.notify = make(chan struct{})
+}
+
+// CloseIdleConnections closes connections without active streams.
+func (t *http2Transport) CloseIdleConnections() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for addr, conns := range t.conns {
+		for _, c := range conns {
+			if c.streams == 0 {
+				c.cc.Close()
+			}
+		}
+		t.pruneLocked(addr)
+	}
+}
+
+// http2Addr returns the address to dial for req, with the default port
+// of the scheme if it has no port.
+func http2Addr(req *http.Request) string {
+	host, port := req.URL.Hostname(), req.URL.Port()
+	if port == "" {
+		port = "443"
+		if req.URL.Scheme == "http" {
+			port = "80"
+		}
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// Read implements io.Reader.
+func (b *http2Body) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		b.once.Do(b.release)
+	}
+	return n, err
+}
+
+// Close implements io.Closer.
+func (b *http2Body) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
@@ -0,0 +1,215 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpproxy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/stretchr/testify/assert"
+)
+
+func newH2CServer(handler http.Handler) *httptest.Server {
+	srv := httptest.NewUnstartedServer(handler)
+	srv.Config.Protocols = &http.Protocols{}
+	srv.Config.Protocols.SetUnencryptedHTTP2(true)
+	srv.Start()
+	return srv
+}
+
+func TestHTTP2SpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &ServerPoolSpec{HTTP2: &HTTP2Spec{H2C: true}}
+	spec.Servers = []*Server{{URL: "http://127.0.0.1:8080"}}
+	assert.NoError(spec.Validate())
+
+	spec.Servers = append(spec.Servers, &Server{URL: "https://127.0.0.1:8443"})
+	assert.Error(spec.Validate())
+
+	spec.HTTP2.H2C = false
+	assert.Error(spec.Validate())
+
+	spec.Servers = spec.Servers[1:]
+	assert.NoError(spec.Validate())
+
+	spec.ProxyProtocol = "v1"
+	assert.Error(spec.Validate())
+}
+
+func TestHTTP2Proxy(t *testing.T) {
+	assert := assert.New(t)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	})
+
+	h2c := newH2CServer(handler)
+	defer h2c.Close()
+
+	h2 := httptest.NewUnstartedServer(handler)
+	h2.EnableHTTP2 = true
+	h2.StartTLS()
+	defer h2.Close()
+
+	old := fnSendRequest
+	defer func() { fnSendRequest = old }()
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		return client.Do(r)
+	}
+
+	for _, c := range []struct {
+		url string
+		h2c bool
+	}{{h2c.URL, true}, {h2.URL, false}} {
+		yamlConfig := fmt.Sprintf(`
+name: proxy
+kind: Proxy
+pools:
+- servers:
+  - url: %s
+  http2:
+    h2c: %v
+    maxConcurrentStreams: 10
+`, c.url, c.h2c)
+		proxy := newTestProxy(yamlConfig, assert)
+		assert.NotNil(proxy.mainPool.client)
+
+		stdr, _ := http.NewRequest(http.MethodGet, "http://www.megaease.com/", nil)
+		ctx := getCtx(stdr)
+		assert.Equal("", proxy.Handle(ctx))
+
+		resp := ctx.GetResponse(context.DefaultNamespace).(*httpprot.Response)
+		assert.Equal("HTTP/2.0", string(resp.RawPayload()))
+		proxy.Close()
+	}
+
+	// HTTP/2 over TLS fails if the server does not support it.
+	h1 := httptest.NewTLSServer(handler)
+	defer h1.Close()
+
+	client := HTTPClient(&tls.Config{InsecureSkipVerify: true}, &HTTPClientSpec{HTTP2: &HTTP2Spec{}}, 0)
+	_, err := client.Get(h1.URL)
+	assert.Error(err)
+}
+
+func TestHTTP2TransportStreams(t *testing.T) {
+	assert := assert.New(t)
+
+	var conns sync.Map
+	var active, maxActive int32
+	release := make(chan struct{})
+
+	srv := newH2CServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conns.Store(r.RemoteAddr, struct{}{})
+		n := atomic.AddInt32(&active, 1)
+		for {
+			m := atomic.LoadInt32(&maxActive)
+			if n <= m || atomic.CompareAndSwapInt32(&maxActive, m, n) {
+				break
+			}
+		}
+		<-release
+		atomic.AddInt32(&active, -1)
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	countConns := func() int {
+		n := 0
+		conns.Range(func(_, _ any) bool { n++; return true })
+		return n
+	}
+
+	send := func(client *http.Client, n int) *sync.WaitGroup {
+		wg := &sync.WaitGroup{}
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp, err := client.Get(srv.URL)
+				assert.NoError(err)
+				body, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				assert.Equal("ok", string(body))
+			}()
+		}
+		return wg
+	}
+
+	// a new connection is opened when the streams of a connection are
+	// exhausted.
+	client := HTTPClient(nil, &HTTPClientSpec{
+		HTTP2: &HTTP2Spec{H2C: true, MaxConcurrentStreams: 2},
+	}, 0)
+	wg := send(client, 4)
+	assert.Eventually(func() bool {
+		return atomic.LoadInt32(&active) == 4
+	}, 3*time.Second, 10*time.Millisecond)
+	assert.Equal(2, countConns())
+	close(release)
+	wg.Wait()
+	client.CloseIdleConnections()
+
+	// requests wait for available streams of the shared connection.
+	conns = sync.Map{}
+	atomic.StoreInt32(&maxActive, 0)
+	release = make(chan struct{})
+	client = HTTPClient(nil, &HTTPClientSpec{
+		HTTP2: &HTTP2Spec{H2C: true, MaxConcurrentStreams: 2, ShareConnection: true},
+	}, 0)
+	wg = send(client, 4)
+	assert.Eventually(func() bool {
+		return atomic.LoadInt32(&active) == 2
+	}, 3*time.Second, 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	assert.EqualValues(2, atomic.LoadInt32(&active))
+	close(release)
+	wg.Wait()
+	assert.Equal(1, countConns())
+	assert.EqualValues(2, atomic.LoadInt32(&maxActive))
+
+	tr := client.Transport.(*http2Transport)
+	client.CloseIdleConnections()
+	tr.mu.Lock()
+	assert.Empty(tr.conns)
+	tr.mu.Unlock()
+}
+
+func TestHTTP2Addr(t *testing.T) {
+	assert := assert.New(t)
+
+	req, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1/", nil)
+	assert.Equal("127.0.0.1:80", http2Addr(req))
+
+	req, _ = http.NewRequest(http.MethodGet, "https://[::1]/", nil)
+	assert.Equal(net.JoinHostPort("::1", "443"), http2Addr(req))
+
+	req, _ = http.NewRequest(http.MethodGet, "https://megaease.com:8443/", nil)
+	assert.Equal("megaease.com:8443", http2Addr(req))
+}
@@ -0,0 +1,194 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpproxy
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+
+	"github.com/megaease/easegress/v2/pkg/logger"
+)
+
+const defaultHTTP3FallbackPeriod = time.Minute
+
+type (
+	// HTTP3Spec is the spec of HTTP/3 connections to servers.
+	HTTP3Spec struct {
+		// MaxIdleTimeout is the max idle time of QUIC connections.
+		MaxIdleTimeout string `json:"maxIdleTimeout,omitempty" jsonschema:"format=duration"`
+		// HandshakeIdleTimeout is the idle timeout before the handshake
+		// of QUIC connections completes.
+		HandshakeIdleTimeout string `json:"handshakeIdleTimeout,omitempty" jsonschema:"format=duration"`
+		// DisableFallback disables falling back to TCP when QUIC fails.
+		DisableFallback bool `json:"disableFallback,omitempty"`
+		// FallbackPeriod is how long requests to a server are sent over
+		// TCP after QUIC to it fails, default is 1m.
+		FallbackPeriod string `json:"fallbackPeriod,omitempty" jsonschema:"format=duration"`
+	}
+
+	// http3Transport is an http.RoundTripper which sends requests with
+	// HTTP/3, and falls back to TCP for a period when QUIC connections
+	// to a server fail.
+	http3Transport struct {
+		h3             *http3.RoundTripper
+		tcp            http.RoundTripper
+		fallbackPeriod time.Duration
+
+		mu        sync.Mutex
+		fallbacks map[string]time.Time
+	}
+)
+
+// Validate validates HTTP3Spec.
+func (spec *HTTP3Spec) Validate(servers []*Server) error {
+	durations := map[string]string{
+		"maxIdleTimeout":       spec.MaxIdleTimeout,
+		"handshakeIdleTimeout": spec.HandshakeIdleTimeout,
+		"fallbackPeriod":       spec.FallbackPeriod,
+	}
+	for name, d := range durations {
+		if d == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(d); err != nil {
+			return fmt.Errorf("http3: invalid %s %q: %v", name, d, err)
+		}
+	}
+	for _, s := range servers {
+		u, err := url.Parse(s.URL)
+		if err != nil {
+			return err
+		}
+		if u.Scheme != "https" {
+			return fmt.Errorf("server %s: scheme must be https when http3 is set", s.URL)
+		}
+	}
+	return nil
+}
+
+func newHTTP3Transport(tlsCfg *tls.Config, spec *HTTP3Spec, tcp http.RoundTripper) *http3Transport {
+	if tlsCfg == nil {
+		tlsCfg = &tls.Config{}
+	}
+
+	quicCfg := &quic.Config{}
+	if spec.MaxIdleTimeout != "" {
+		quicCfg.MaxIdleTimeout, _ = time.ParseDuration(spec.MaxIdleTimeout)
+	}
+	if spec.HandshakeIdleTimeout != "" {
+		quicCfg.HandshakeIdleTimeout, _ = time.ParseDuration(spec.HandshakeIdleTimeout)
+	}
+
+	t := &http3Transport{
+		h3: &http3.RoundTripper{
+			TLSClientConfig: tlsCfg.Clone(),
+			QuicConfig:      quicCfg,
+		},
+		fallbackPeriod: defaultHTTP3FallbackPeriod,
+		fallbacks:      map[string]time.Time{},
+	}
+	if spec.FallbackPeriod != "" {
+		t.fallbackPeriod, _ = time.ParseDuration(spec.FallbackPeriod)
+	}
+	if !spec.DisableFallback {
+		t.tcp = tcp
+	}
+	return t
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *http3Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	if t.tcp != nil && t.inFallback(host) {
+		return t.tcp.RoundTrip(req)
+	}
+
+	resp, err := t.h3.RoundTrip(req)
+	if err == nil || t.tcp == nil || req.Context().Err() != nil || !isQUICConnError(err) {
+		return resp, err
+	}
+
+	t.mu.Lock()
+	t.fallbacks[host] = time.Now().Add(t.fallbackPeriod)
+	t.mu.Unlock()
+
+	// the body may have been consumed, it could only be sent again if
+	// it can be recreated.
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return nil, err
+		}
+		body, e := req.GetBody()
+		if e != nil {
+			return nil, err
+		}
+		req = req.Clone(req.Context())
+		req.Body = body
+	}
+
+	logger.Warnf("HTTP/3 to %s failed, fall back to TCP for %s: %v", host, t.fallbackPeriod, err)
+	return t.tcp.RoundTrip(req)
+}
+
+func (t *http3Transport) inFallback(host string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	until, ok := t.fallbacks[host]
+	if !ok {
+		return false
+	}
+	if time.Now().Before(until) {
+		return true
+	}
+	delete(t.fallbacks, host)
+	return false
+}
+
+// isQUICConnError returns whether the error is a failure of the QUIC
+// connection, rather than an error of the request.
+func isQUICConnError(err error) bool {
+	var (
+		handshakeTimeout *quic.HandshakeTimeoutError
+		idleTimeout      *quic.IdleTimeoutError
+		transportErr     *quic.TransportError
+		versionErr       *quic.VersionNegotiationError
+		resetErr         *quic.StatelessResetError
+		opErr            *net.OpError
+	)
+	return errors.As(err, &handshakeTimeout) || errors.As(err, &idleTimeout) ||
+		errors.As(err, &transportErr) || errors.As(err, &versionErr) ||
+		errors.As(err, &resetErr) || errors.As(err, &opErr)
+}
+
+// CloseIdleConnections closes the idle connections of both transports.
+func (t *http3Transport) CloseIdleConnections() {
+	t.h3.CloseIdleConnections()
+	if ci, ok := t.tcp.(interface{ CloseIdleConnections() }); ok {
+		ci.CloseIdleConnections()
+	}
+}
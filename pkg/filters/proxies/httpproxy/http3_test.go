@@ -0,0 +1,112 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpproxy
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/quic-go/quic-go/http3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTP3SpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &ServerPoolSpec{HTTP3: &HTTP3Spec{}}
+	spec.Servers = []*Server{{URL: "https://127.0.0.1:8443"}}
+	assert.NoError(spec.Validate())
+
+	spec.Servers = append(spec.Servers, &Server{URL: "http://127.0.0.1:8080"})
+	assert.Error(spec.Validate())
+	spec.Servers = spec.Servers[:1]
+
+	spec.HTTP3.FallbackPeriod = "abc"
+	assert.Error(spec.Validate())
+	spec.HTTP3.FallbackPeriod = "10s"
+	assert.NoError(spec.Validate())
+
+	spec.HTTP2 = &HTTP2Spec{}
+	assert.Error(spec.Validate())
+	spec.HTTP2 = nil
+
+	spec.ProxyProtocol = "v2"
+	assert.Error(spec.Validate())
+}
+
+func TestHTTP3Transport(t *testing.T) {
+	assert := assert.New(t)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write([]byte(r.Proto + " " + string(body)))
+	})
+
+	tcpSrv := httptest.NewTLSServer(handler)
+	defer tcpSrv.Close()
+
+	// serve HTTP/3 on the UDP port with the same number.
+	addr := tcpSrv.Listener.Addr().(*net.TCPAddr)
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: addr.IP, Port: addr.Port})
+	if err != nil {
+		t.Skipf("failed to listen on udp: %v", err)
+	}
+	h3Srv := &http3.Server{
+		Handler: handler,
+		TLSConfig: http3.ConfigureTLSConfig(&tls.Config{
+			Certificates: tcpSrv.TLS.Certificates,
+		}),
+	}
+	go h3Srv.Serve(udpConn)
+
+	clientTLS := &tls.Config{InsecureSkipVerify: true}
+	spec := &HTTP3Spec{HandshakeIdleTimeout: "500ms"}
+	client := HTTPClient(clientTLS, &HTTPClientSpec{HTTP3: spec}, 0)
+
+	resp, err := client.Post(tcpSrv.URL, "text/plain", strings.NewReader("hello"))
+	assert.NoError(err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal("HTTP/3.0 hello", string(body))
+
+	// QUIC fails after the HTTP/3 server is closed, requests fall
+	// back to TCP.
+	h3Srv.Close()
+	udpConn.Close()
+	client.CloseIdleConnections()
+
+	resp, err = client.Post(tcpSrv.URL, "text/plain", strings.NewReader("hello"))
+	assert.NoError(err)
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal("HTTP/1.1 hello", string(body))
+
+	tr := client.Transport.(*http3Transport)
+	assert.True(tr.inFallback(addr.String()))
+
+	// requests fail without fallback.
+	spec.DisableFallback = true
+	client = HTTPClient(clientTLS, &HTTPClientSpec{HTTP3: spec}, 0)
+	_, err = client.Get(tcpSrv.URL)
+	assert.Error(err)
+}
@@ -0,0 +1,753 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpproxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+)
+
+const (
+	defaultHTTPCacheMaxEntryBytes = 1 << 20
+	defaultHTTPCacheTagHeader     = "Cache-Tag"
+	defaultHTTPCacheStaleTTL      = 10 * time.Minute
+	defaultRevalidationTimeout    = 30 * time.Second
+
+	// the interval to retry creating the storage if it failed.
+	httpCacheStorageRetryInterval = 5 * time.Second
+)
+
+// httpCaches is the registry of HTTP caches for purging, keyed by name.
+var httpCaches sync.Map
+
+type (
+	// HTTPCache is a shared HTTP cache following RFC 9111, responses are
+	// stored in a pluggable storage.
+	HTTPCache struct {
+		spec          *HTTPCacheSpec
+		maxEntryBytes int
+		defaultTTL    time.Duration
+		staleTTL      time.Duration
+		tagHeader     string
+
+		storageLock    sync.Mutex
+		storage        httpCacheStorage
+		lastCreateTime time.Time
+
+		revalidating sync.Map
+	}
+
+	// HTTPCacheSpec describes the HTTPCache.
+	HTTPCacheSpec struct {
+		Name          string                `json:"name" jsonschema:"required"`
+		Storage       string                `json:"storage" jsonschema:"required,enum=disk,enum=resp"`
+		Disk          *DiskCacheStorageSpec `json:"disk,omitempty"`
+		RESP          *RESPCacheStorageSpec `json:"resp,omitempty"`
+		MaxEntryBytes uint32                `json:"maxEntryBytes,omitempty"`
+		DefaultTTL    string                `json:"defaultTTL,omitempty" jsonschema:"format=duration"`
+		StaleTTL      string                `json:"staleTTL,omitempty" jsonschema:"format=duration"`
+		TagHeader     string                `json:"tagHeader,omitempty"`
+		Tags          []string              `json:"tags,omitempty" jsonschema:"uniqueItems=true"`
+	}
+
+	// httpCacheEntry is a stored response.
+	httpCacheEntry struct {
+		StatusCode   int         `json:"statusCode"`
+		Header       http.Header `json:"header"`
+		Body         []byte      `json:"body,omitempty"`
+		RequestTime  time.Time   `json:"requestTime"`
+		ResponseTime time.Time   `json:"responseTime"`
+	}
+
+	// httpCacheIndex is stored with the primary key of a request, it
+	// records the header names to select the stored responses.
+	httpCacheIndex struct {
+		Vary []string `json:"vary,omitempty"`
+	}
+
+	// httpCacheLookup is the result of looking up the cache for a request.
+	httpCacheLookup struct {
+		key       string
+		method    string
+		reqHeader http.Header
+		reqCC     cacheControl
+		noStore   bool
+
+		// fields below are only valid if entry is not nil.
+		entry      *httpCacheEntry
+		storageKey string
+		age        time.Duration
+		fresh      bool
+		// staleWhileRevalidate means the entry is stale, but can be
+		// served while it is being revalidated in background.
+		staleWhileRevalidate bool
+		// staleIfError means the entry is stale, but can be served if
+		// the server fails.
+		staleIfError bool
+	}
+
+	// cacheControl is the parsed directives of Cache-Control headers.
+	cacheControl map[string]string
+)
+
+// Validate validates the HTTPCacheSpec.
+func (spec *HTTPCacheSpec) Validate() error {
+	if spec.Name == "" {
+		return fmt.Errorf("name of httpCache is empty")
+	}
+
+	switch spec.Storage {
+	case httpCacheStorageDisk:
+		if spec.Disk == nil {
+			return fmt.Errorf("disk must be set for disk storage")
+		}
+		if err := spec.Disk.Validate(); err != nil {
+			return err
+		}
+	case httpCacheStorageRESP:
+		if spec.RESP == nil {
+			return fmt.Errorf("resp must be set for resp storage")
+		}
+		if err := spec.RESP.Validate(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown storage %q", spec.Storage)
+	}
+
+	for _, d := range []string{spec.DefaultTTL, spec.StaleTTL} {
+		if d == "" {
+			continue
+		}
+		if v, err := time.ParseDuration(d); err != nil {
+			return err
+		} else if v < 0 {
+			return fmt.Errorf("duration %s is negative", d)
+		}
+	}
+	return nil
+}
+
+// NewHTTPCache creates an HTTPCache and registers it for purging.
+func NewHTTPCache(spec *HTTPCacheSpec) *HTTPCache {
+	c := &HTTPCache{
+		spec:          spec,
+		maxEntryBytes: int(spec.MaxEntryBytes),
+		staleTTL:      defaultHTTPCacheStaleTTL,
+		tagHeader:     spec.TagHeader,
+	}
+	if c.maxEntryBytes == 0 {
+		c.maxEntryBytes = defaultHTTPCacheMaxEntryBytes
+	}
+	if c.tagHeader == "" {
+		c.tagHeader = defaultHTTPCacheTagHeader
+	}
+	if spec.DefaultTTL != "" {
+		c.defaultTTL, _ = time.ParseDuration(spec.DefaultTTL)
+	}
+	if spec.StaleTTL != "" {
+		c.staleTTL, _ = time.ParseDuration(spec.StaleTTL)
+	}
+
+	c.getStorage()
+	httpCaches.Store(spec.Name, c)
+	registerHTTPCacheAPIs()
+	return c
+}
+
+// GetHTTPCache returns the HTTPCache of name, it returns nil if not found.
+func GetHTTPCache(name string) *HTTPCache {
+	if v, ok := httpCaches.Load(name); ok {
+		return v.(*HTTPCache)
+	}
+	return nil
+}
+
+// ListHTTPCaches returns the names of all HTTP caches.
+func ListHTTPCaches() []string {
+	names := []string{}
+	httpCaches.Range(func(key, value any) bool {
+		names = append(names, key.(string))
+		return true
+	})
+	sort.Strings(names)
+	return names
+}
+
+// Close unregisters the cache and closes its storage.
+func (c *HTTPCache) Close() {
+	httpCaches.CompareAndDelete(c.spec.Name, c)
+
+	c.storageLock.Lock()
+	defer c.storageLock.Unlock()
+	if c.storage != nil {
+		c.storage.Close()
+	}
+}
+
+// getStorage returns the storage, it creates the storage if it is not
+// created yet, for example, the RESP store was unavailable at startup.
+func (c *HTTPCache) getStorage() httpCacheStorage {
+	c.storageLock.Lock()
+	defer c.storageLock.Unlock()
+
+	if c.storage != nil {
+		return c.storage
+	}
+
+	now := time.Now()
+	if now.Sub(c.lastCreateTime) < httpCacheStorageRetryInterval {
+		return nil
+	}
+	c.lastCreateTime = now
+
+	var err error
+	switch c.spec.Storage {
+	case httpCacheStorageDisk:
+		c.storage, err = newDiskCacheStorage(c.spec.Disk)
+	case httpCacheStorageRESP:
+		c.storage, err = newRESPCacheStorage(c.spec.RESP, c.spec.Name)
+	}
+	if err != nil {
+		logger.Errorf("httpCache %s: failed to create storage: %v", c.spec.Name, err)
+		c.storage = nil
+	}
+	return c.storage
+}
+
+// PurgeKey purges all stored responses of the URL.
+func (c *HTTPCache) PurgeKey(url string) (int, error) {
+	return c.purge(httpCacheKeyTag(url))
+}
+
+// PurgeTag purges all stored responses with the tag.
+func (c *HTTPCache) PurgeTag(tag string) (int, error) {
+	return c.purge("tag:" + tag)
+}
+
+func (c *HTTPCache) purge(tag string) (int, error) {
+	storage := c.getStorage()
+	if storage == nil {
+		return 0, fmt.Errorf("storage of httpCache %s is unavailable", c.spec.Name)
+	}
+	return storage.PurgeTag(tag)
+}
+
+// httpCacheKey returns the primary cache key of the request, which is
+// its effective URL.
+func httpCacheKey(req *httpprot.Request) string {
+	return req.Scheme() + "://" + req.Host() + req.Std().URL.RequestURI()
+}
+
+// httpCacheKeyTag returns the implicit tag of all stored data of a
+// primary key.
+func httpCacheKeyTag(key string) string {
+	return "key:" + key
+}
+
+func httpCacheIndexKey(key string) string {
+	return "i:" + key
+}
+
+// httpCacheVariantKey returns the storage key of the response selected
+// by the header values of the request, according to the Vary header.
+func httpCacheVariantKey(key string, vary []string, header http.Header) string {
+	if len(vary) == 0 {
+		return "v:" + key
+	}
+
+	h := sha256.New()
+	for _, name := range vary {
+		h.Write([]byte(name))
+		h.Write([]byte{':'})
+		h.Write([]byte(strings.Join(header.Values(name), ",")))
+		h.Write([]byte{'\n'})
+	}
+	return "v:" + key + "#" + hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// parseVary returns the normalized header names of Vary headers.
+func parseVary(header http.Header) []string {
+	var names []string
+	for _, v := range header.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseCacheControl parses the Cache-Control headers, the Pragma header
+// is used if there is no Cache-Control header.
+func parseCacheControl(header http.Header) cacheControl {
+	cc := cacheControl{}
+	values := header.Values(keyCacheControl)
+	if len(values) == 0 {
+		for _, v := range header.Values("Pragma") {
+			if strings.Contains(strings.ToLower(v), "no-cache") {
+				cc["no-cache"] = ""
+			}
+		}
+		return cc
+	}
+
+	for _, v := range values {
+		for _, d := range strings.Split(v, ",") {
+			d = strings.TrimSpace(d)
+			if d == "" {
+				continue
+			}
+			name, value, _ := strings.Cut(d, "=")
+			name = strings.ToLower(strings.TrimSpace(name))
+			value = strings.Trim(strings.TrimSpace(value), `"`)
+			if _, ok := cc[name]; !ok {
+				cc[name] = value
+			}
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+// seconds returns the value of a delta-seconds directive.
+func (cc cacheControl) seconds(name string) (time.Duration, bool) {
+	v, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// heuristicallyCacheable reports whether the response of status code can
+// be cached with a heuristic freshness lifetime.
+func heuristicallyCacheable(code int) bool {
+	switch code {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent,
+		http.StatusMultipleChoices, http.StatusMovedPermanently,
+		http.StatusPermanentRedirect, http.StatusNotFound,
+		http.StatusMethodNotAllowed, http.StatusGone,
+		http.StatusRequestURITooLong, http.StatusNotImplemented:
+		return true
+	}
+	return false
+}
+
+// isUnsafeMethod reports whether the method may change the state of the
+// server, responses of these methods invalidate the stored responses.
+func isUnsafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	}
+	return true
+}
+
+// freshnessLifetime returns the freshness lifetime of the entry, it
+// returns false if there's no explicit expiration time.
+func (e *httpCacheEntry) freshnessLifetime(cc cacheControl) (time.Duration, bool) {
+	if d, ok := cc.seconds("s-maxage"); ok {
+		return d, true
+	}
+	if d, ok := cc.seconds("max-age"); ok {
+		return d, true
+	}
+	if v := e.Header.Get("Expires"); v != "" {
+		expires, err := http.ParseTime(v)
+		if err != nil {
+			// invalid Expires means already expired.
+			return 0, true
+		}
+		date := e.ResponseTime
+		if t, err := http.ParseTime(e.Header.Get("Date")); err == nil {
+			date = t
+		}
+		if d := expires.Sub(date); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// initialAge returns the corrected initial age of the entry.
+func (e *httpCacheEntry) initialAge() time.Duration {
+	var apparentAge time.Duration
+	if date, err := http.ParseTime(e.Header.Get("Date")); err == nil {
+		if d := e.ResponseTime.Sub(date); d > 0 {
+			apparentAge = d
+		}
+	}
+
+	correctedAge := e.ResponseTime.Sub(e.RequestTime)
+	if n, err := strconv.ParseInt(e.Header.Get("Age"), 10, 64); err == nil && n > 0 {
+		correctedAge += time.Duration(n) * time.Second
+	}
+
+	if apparentAge > correctedAge {
+		return apparentAge
+	}
+	return correctedAge
+}
+
+// currentAge returns the current age of the entry.
+func (e *httpCacheEntry) currentAge(now time.Time) time.Duration {
+	return e.initialAge() + now.Sub(e.ResponseTime)
+}
+
+func (e *httpCacheEntry) hasValidators() bool {
+	return e.Header.Get("ETag") != "" || e.Header.Get("Last-Modified") != ""
+}
+
+// matchConditional reports whether the conditional request headers match
+// the entry, which means a 304 response can be sent.
+func (e *httpCacheEntry) matchConditional(header http.Header) bool {
+	if inm := header.Get("If-None-Match"); inm != "" {
+		etag := strings.TrimPrefix(e.Header.Get("ETag"), "W/")
+		if etag == "" {
+			return false
+		}
+		for _, v := range strings.Split(inm, ",") {
+			v = strings.TrimSpace(v)
+			if v == "*" || strings.TrimPrefix(v, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ims := header.Get("If-Modified-Since"); ims != "" {
+		t, err := http.ParseTime(ims)
+		if err != nil {
+			return false
+		}
+		lm, err := http.ParseTime(e.Header.Get("Last-Modified"))
+		return err == nil && !lm.After(t)
+	}
+
+	return false
+}
+
+// lookup looks up the cache for the request, it returns nil if the
+// method of the request is not cacheable.
+func (c *HTTPCache) lookup(req *httpprot.Request, now time.Time) *httpCacheLookup {
+	method := req.Method()
+	if method != http.MethodGet && method != http.MethodHead {
+		return nil
+	}
+
+	// range requests bypass the cache, as partial responses are neither
+	// stored nor built from stored responses.
+	if req.HTTPHeader().Get("Range") != "" {
+		return nil
+	}
+
+	l := &httpCacheLookup{
+		key:       httpCacheKey(req),
+		method:    method,
+		reqHeader: req.HTTPHeader(),
+		reqCC:     parseCacheControl(req.HTTPHeader()),
+	}
+	if l.reqCC.has("no-store") {
+		l.noStore = true
+		return l
+	}
+
+	storage := c.getStorage()
+	if storage == nil {
+		return l
+	}
+
+	index := &httpCacheIndex{}
+	if !c.load(storage, httpCacheIndexKey(l.key), index) {
+		return l
+	}
+
+	storageKey := httpCacheVariantKey(l.key, index.Vary, l.reqHeader)
+	entry := &httpCacheEntry{}
+	if !c.load(storage, storageKey, entry) {
+		return l
+	}
+
+	l.entry = entry
+	l.storageKey = storageKey
+	l.age = entry.currentAge(now)
+
+	respCC := parseCacheControl(entry.Header)
+	lifetime, ok := entry.freshnessLifetime(respCC)
+	if !ok {
+		lifetime = c.defaultTTL
+	}
+	if respCC.has("no-cache") || l.reqCC.has("no-cache") {
+		lifetime = 0
+	}
+	if d, ok := l.reqCC.seconds("max-age"); ok && d < lifetime {
+		lifetime = d
+	}
+
+	freshness := lifetime
+	if d, ok := l.reqCC.seconds("min-fresh"); ok {
+		freshness -= d
+	}
+	l.fresh = l.age < freshness
+	if l.fresh {
+		return l
+	}
+
+	if respCC.has("must-revalidate") || respCC.has("proxy-revalidate") ||
+		(respCC.has("s-maxage") && respCC.has("no-cache")) {
+		return l
+	}
+
+	staleness := l.age - lifetime
+	if d, ok := respCC.seconds("stale-while-revalidate"); ok && staleness <= d {
+		l.staleWhileRevalidate = !l.reqCC.has("no-cache") && method == http.MethodGet
+	}
+	if d, ok := l.reqCC.seconds("stale-if-error"); ok && staleness <= d {
+		l.staleIfError = true
+	} else if d, ok := respCC.seconds("stale-if-error"); ok && staleness <= d {
+		l.staleIfError = true
+	}
+	return l
+}
+
+func (c *HTTPCache) load(storage httpCacheStorage, key string, v any) bool {
+	data, err := storage.Get(key)
+	if err != nil {
+		logger.Warnf("httpCache %s: failed to load %s: %v", c.spec.Name, key, err)
+		return false
+	}
+	if data == nil {
+		return false
+	}
+	if err = codectool.UnmarshalJSON(data, v); err != nil {
+		logger.Warnf("httpCache %s: failed to unmarshal %s: %v", c.spec.Name, key, err)
+		return false
+	}
+	return true
+}
+
+// addConditionals adds the validators of the stored response to the
+// header of the request to the server, it returns false if nothing is
+// added, or the request is already a conditional one.
+func (l *httpCacheLookup) addConditionals(header http.Header) bool {
+	if l.entry == nil || l.noStore {
+		return false
+	}
+	for _, name := range []string{"If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since", "If-Range"} {
+		if header.Get(name) != "" {
+			return false
+		}
+	}
+
+	added := false
+	if etag := l.entry.Header.Get("ETag"); etag != "" {
+		header.Set("If-None-Match", etag)
+		added = true
+	}
+	if lm := l.entry.Header.Get("Last-Modified"); lm != "" {
+		header.Set("If-Modified-Since", lm)
+		added = true
+	}
+	return added
+}
+
+// store stores the response of the request if it is cacheable.
+func (c *HTTPCache) store(l *httpCacheLookup, resp *httpprot.Response, requestTime, responseTime time.Time) {
+	// only responses to GET requests are stored, a response to HEAD has
+	// no body, and must not be served to GET requests.
+	if l == nil || l.noStore || l.method != http.MethodGet || resp.IsStream() {
+		return
+	}
+	if resp.StatusCode() == http.StatusPartialContent {
+		return
+	}
+
+	header := resp.HTTPHeader()
+	if len(resp.RawPayload()) > c.maxEntryBytes || header.Get("Set-Cookie") != "" {
+		return
+	}
+
+	respCC := parseCacheControl(header)
+	if respCC.has("no-store") || respCC.has("private") {
+		return
+	}
+	if l.reqHeader.Get("Authorization") != "" && !respCC.has("public") &&
+		!respCC.has("s-maxage") && !respCC.has("must-revalidate") {
+		return
+	}
+
+	entry := &httpCacheEntry{
+		StatusCode:   resp.StatusCode(),
+		Header:       header.Clone(),
+		Body:         resp.RawPayload(),
+		RequestTime:  requestTime,
+		ResponseTime: responseTime,
+	}
+
+	lifetime, explicit := entry.freshnessLifetime(respCC)
+	if !explicit {
+		if !heuristicallyCacheable(entry.StatusCode) {
+			return
+		}
+		lifetime = c.defaultTTL
+	}
+	if respCC.has("no-cache") {
+		lifetime = 0
+	}
+
+	c.save(l, entry, lifetime, respCC)
+}
+
+// save saves the entry, and the index of the primary key.
+func (c *HTTPCache) save(l *httpCacheLookup, entry *httpCacheEntry, lifetime time.Duration, respCC cacheControl) {
+	vary := parseVary(entry.Header)
+	for _, name := range vary {
+		if name == "*" {
+			return
+		}
+	}
+
+	// keep the entry for a while after it becomes stale, so that it can
+	// be served stale, or be revalidated.
+	var keep time.Duration
+	if d, ok := respCC.seconds("stale-while-revalidate"); ok && d > keep {
+		keep = d
+	}
+	if d, ok := respCC.seconds("stale-if-error"); ok && d > keep {
+		keep = d
+	}
+	if entry.hasValidators() && c.staleTTL > keep {
+		keep = c.staleTTL
+	}
+
+	ttl := lifetime - entry.initialAge() + keep
+	if lifetime < entry.initialAge() {
+		ttl = keep
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	storage := c.getStorage()
+	if storage == nil {
+		return
+	}
+
+	tags := []string{httpCacheKeyTag(l.key)}
+	for _, tag := range c.spec.Tags {
+		tags = append(tags, "tag:"+tag)
+	}
+	for _, v := range entry.Header.Values(c.tagHeader) {
+		for _, tag := range strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ' ' }) {
+			tags = append(tags, "tag:"+tag)
+		}
+	}
+
+	index, _ := codectool.MarshalJSON(&httpCacheIndex{Vary: vary})
+	if err := storage.Set(httpCacheIndexKey(l.key), index, ttl, tags[:1]); err != nil {
+		logger.Warnf("httpCache %s: failed to store index of %s: %v", c.spec.Name, l.key, err)
+		return
+	}
+
+	data, _ := codectool.MarshalJSON(entry)
+	storageKey := httpCacheVariantKey(l.key, vary, l.reqHeader)
+	if err := storage.Set(storageKey, data, ttl, tags); err != nil {
+		logger.Warnf("httpCache %s: failed to store %s: %v", c.spec.Name, storageKey, err)
+	}
+}
+
+// freshen updates the stored response with the header of a 304 response,
+// and returns the updated entry.
+func (c *HTTPCache) freshen(l *httpCacheLookup, header http.Header, requestTime, responseTime time.Time) *httpCacheEntry {
+	entry := &httpCacheEntry{
+		StatusCode:   l.entry.StatusCode,
+		Header:       l.entry.Header.Clone(),
+		Body:         l.entry.Body,
+		RequestTime:  requestTime,
+		ResponseTime: responseTime,
+	}
+	for k, v := range header {
+		switch k {
+		case "Content-Length", "Content-Encoding", "Transfer-Encoding":
+			continue
+		}
+		entry.Header[k] = v
+	}
+	entry.Header.Del("Age")
+
+	respCC := parseCacheControl(entry.Header)
+	lifetime, explicit := entry.freshnessLifetime(respCC)
+	if !explicit {
+		lifetime = c.defaultTTL
+	}
+	if respCC.has("no-cache") {
+		lifetime = 0
+	}
+	if !respCC.has("no-store") && !respCC.has("private") {
+		c.save(l, entry, lifetime, respCC)
+	}
+	return entry
+}
+
+// invalidate invalidates the stored responses of the request if it is an
+// unsafe request and the response is not an error.
+func (c *HTTPCache) invalidate(req *httpprot.Request, statusCode int) {
+	if !isUnsafeMethod(req.Method()) || statusCode < 200 || statusCode >= 400 {
+		return
+	}
+
+	key := httpCacheKey(req)
+	if _, err := c.PurgeKey(key); err != nil {
+		logger.Warnf("httpCache %s: failed to invalidate %s: %v", c.spec.Name, key, err)
+	}
+}
+
+// startRevalidation marks the key is being revalidated, it returns false
+// if the key is already being revalidated.
+func (c *HTTPCache) startRevalidation(key string) bool {
+	_, loaded := c.revalidating.LoadOrStore(key, struct{}{})
+	return !loaded
+}
+
+// finishRevalidation marks the revalidation of the key is finished.
+func (c *HTTPCache) finishRevalidation(key string) {
+	c.revalidating.Delete(key)
+}
@@ -0,0 +1,567 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpproxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestHTTPCache(t *testing.T, name string) *HTTPCache {
+	spec := &HTTPCacheSpec{
+		Name:       name,
+		Storage:    httpCacheStorageDisk,
+		Disk:       &DiskCacheStorageSpec{Dir: t.TempDir()},
+		DefaultTTL: "1m",
+		Tags:       []string{"all"},
+	}
+	if err := spec.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return NewHTTPCache(spec)
+}
+
+func newHTTPCacheRequest(method, url string, header http.Header) *httpprot.Request {
+	stdr, _ := http.NewRequest(method, url, nil)
+	if header != nil {
+		stdr.Header = header
+	}
+	req, _ := httpprot.NewRequest(stdr)
+	return req
+}
+
+func newHTTPCacheResponse(code int, header http.Header, body string) *httpprot.Response {
+	resp, _ := httpprot.NewResponse(nil)
+	resp.SetStatusCode(code)
+	for k, v := range header {
+		resp.HTTPHeader()[k] = v
+	}
+	resp.SetPayload([]byte(body))
+	return resp
+}
+
+func TestHTTPCacheSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &HTTPCacheSpec{}
+	assert.Error(spec.Validate())
+
+	spec.Name = "cache"
+	assert.Error(spec.Validate())
+
+	spec.Storage = httpCacheStorageDisk
+	assert.Error(spec.Validate())
+	spec.Disk = &DiskCacheStorageSpec{}
+	assert.Error(spec.Validate())
+	spec.Disk.Dir = "/tmp"
+	assert.NoError(spec.Validate())
+
+	spec.Storage = httpCacheStorageRESP
+	assert.Error(spec.Validate())
+	spec.RESP = &RESPCacheStorageSpec{Address: "127.0.0.1:6379"}
+	assert.NoError(spec.Validate())
+
+	spec.DefaultTTL = "-1s"
+	assert.Error(spec.Validate())
+	spec.DefaultTTL = "1s"
+	spec.StaleTTL = "abc"
+	assert.Error(spec.Validate())
+	spec.StaleTTL = ""
+	assert.NoError(spec.Validate())
+
+	pool := &ServerPoolSpec{
+		BaseServerPoolSpec: BaseServerPoolSpec{
+			Servers: []*Server{{URL: "http://127.0.0.1:9095"}},
+		},
+		MemoryCache: &MemoryCacheSpec{},
+		HTTPCache:   spec,
+	}
+	assert.Error(pool.Validate())
+	pool.MemoryCache = nil
+	assert.NoError(pool.Validate())
+}
+
+func TestParseCacheControl(t *testing.T) {
+	assert := assert.New(t)
+
+	h := http.Header{}
+	h.Add("Cache-Control", `Max-Age=60, no-cache="Set-Cookie"`)
+	h.Add("Cache-Control", "s-maxage=abc,, public")
+	cc := parseCacheControl(h)
+	d, ok := cc.seconds("max-age")
+	assert.True(ok)
+	assert.Equal(time.Minute, d)
+	assert.Equal("Set-Cookie", cc["no-cache"])
+	assert.True(cc.has("public"))
+	_, ok = cc.seconds("s-maxage")
+	assert.False(ok)
+	_, ok = cc.seconds("min-fresh")
+	assert.False(ok)
+
+	h = http.Header{}
+	h.Set("Pragma", "no-cache")
+	assert.True(parseCacheControl(h).has("no-cache"))
+	h.Set("Cache-Control", "max-age=1")
+	assert.False(parseCacheControl(h).has("no-cache"))
+
+	h = http.Header{}
+	h.Add("Vary", "accept-encoding, Accept-Language")
+	h.Add("Vary", "Origin")
+	assert.Equal([]string{"Accept-Encoding", "Accept-Language", "Origin"}, parseVary(h))
+}
+
+func TestHTTPCacheEntry(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Now().Truncate(time.Second)
+	e := &httpCacheEntry{
+		Header:       http.Header{},
+		RequestTime:  now.Add(-2 * time.Second),
+		ResponseTime: now,
+	}
+
+	// freshness lifetime
+	_, ok := e.freshnessLifetime(cacheControl{})
+	assert.False(ok)
+	e.Header.Set("Date", now.Add(-5*time.Second).Format(http.TimeFormat))
+	e.Header.Set("Expires", now.Add(time.Minute).Format(http.TimeFormat))
+	d, ok := e.freshnessLifetime(cacheControl{})
+	assert.True(ok)
+	assert.Equal(65*time.Second, d)
+	d, _ = e.freshnessLifetime(cacheControl{"max-age": "10"})
+	assert.Equal(10*time.Second, d)
+	d, _ = e.freshnessLifetime(cacheControl{"max-age": "10", "s-maxage": "20"})
+	assert.Equal(20*time.Second, d)
+	e.Header.Set("Expires", "0")
+	d, ok = e.freshnessLifetime(cacheControl{})
+	assert.True(ok)
+	assert.Equal(time.Duration(0), d)
+
+	// age
+	assert.Equal(5*time.Second, e.initialAge())
+	e.Header.Set("Age", "10")
+	assert.Equal(12*time.Second, e.initialAge())
+	assert.Equal(15*time.Second, e.currentAge(now.Add(3*time.Second)))
+
+	// conditional requests
+	assert.False(e.hasValidators())
+	assert.False(e.matchConditional(http.Header{"If-None-Match": {`"v1"`}}))
+	e.Header.Set("ETag", `W/"v1"`)
+	e.Header.Set("Last-Modified", now.Add(-time.Hour).Format(http.TimeFormat))
+	assert.True(e.hasValidators())
+	assert.True(e.matchConditional(http.Header{"If-None-Match": {`"v0", "v1"`}}))
+	assert.True(e.matchConditional(http.Header{"If-None-Match": {"*"}}))
+	assert.False(e.matchConditional(http.Header{"If-None-Match": {`"v2"`}}))
+	assert.True(e.matchConditional(http.Header{"If-Modified-Since": {now.Format(http.TimeFormat)}}))
+	assert.False(e.matchConditional(http.Header{"If-Modified-Since": {now.Add(-2 * time.Hour).Format(http.TimeFormat)}}))
+	assert.False(e.matchConditional(http.Header{}))
+}
+
+func TestHTTPCacheStore(t *testing.T) {
+	assert := assert.New(t)
+
+	c := newTestHTTPCache(t, "test-store")
+	defer c.Close()
+	now := time.Now()
+
+	assert.Nil(c.lookup(newHTTPCacheRequest(http.MethodPost, "http://example.com/a", nil), now))
+
+	cases := []struct {
+		reqHeader  http.Header
+		code       int
+		respHeader http.Header
+		stored     bool
+	}{
+		{nil, 200, nil, true},
+		{nil, 200, http.Header{"Cache-Control": {"max-age=60"}}, true},
+		{nil, 500, nil, false},
+		{nil, 500, http.Header{"Cache-Control": {"max-age=60"}}, true},
+		{nil, 200, http.Header{"Cache-Control": {"no-store"}}, false},
+		{nil, 200, http.Header{"Cache-Control": {"private, max-age=60"}}, false},
+		{nil, 200, http.Header{"Set-Cookie": {"a=b"}}, false},
+		{nil, 200, http.Header{"Vary": {"*"}}, false},
+		{http.Header{"Cache-Control": {"no-store"}}, 200, nil, false},
+		{http.Header{"Authorization": {"Basic xxx"}}, 200, http.Header{"Cache-Control": {"max-age=60"}}, false},
+		{http.Header{"Authorization": {"Basic xxx"}}, 200, http.Header{"Cache-Control": {"s-maxage=60"}}, true},
+	}
+
+	for i, tc := range cases {
+		url := fmt.Sprintf("http://example.com/store/%d", i)
+		l := c.lookup(newHTTPCacheRequest(http.MethodGet, url, tc.reqHeader), now)
+		assert.NotNil(l)
+		assert.Nil(l.entry)
+		c.store(l, newHTTPCacheResponse(tc.code, tc.respHeader, "body"), now, now)
+
+		l = c.lookup(newHTTPCacheRequest(http.MethodGet, url, tc.reqHeader), now)
+		if tc.stored {
+			assert.NotNil(l.entry, "case %d", i)
+			assert.True(l.fresh, "case %d", i)
+			assert.Equal("body", string(l.entry.Body))
+		} else {
+			assert.Nil(l.entry, "case %d", i)
+		}
+	}
+
+	// too large
+	l := c.lookup(newHTTPCacheRequest(http.MethodGet, "http://example.com/large", nil), now)
+	c.store(l, newHTTPCacheResponse(200, nil, strings.Repeat("x", defaultHTTPCacheMaxEntryBytes+1)), now, now)
+	l = c.lookup(newHTTPCacheRequest(http.MethodGet, "http://example.com/large", nil), now)
+	assert.Nil(l.entry)
+
+	// responses to HEAD are not stored, but HEAD can be served from a
+	// response to GET.
+	url := "http://example.com/head"
+	l = c.lookup(newHTTPCacheRequest(http.MethodHead, url, nil), now)
+	c.store(l, newHTTPCacheResponse(200, http.Header{"Cache-Control": {"max-age=60"}}, ""), now, now)
+	assert.Nil(c.lookup(newHTTPCacheRequest(http.MethodGet, url, nil), now).entry)
+	l = c.lookup(newHTTPCacheRequest(http.MethodGet, url, nil), now)
+	c.store(l, newHTTPCacheResponse(200, http.Header{"Cache-Control": {"max-age=60"}}, "body"), now, now)
+	assert.Equal("body", string(c.lookup(newHTTPCacheRequest(http.MethodHead, url, nil), now).entry.Body))
+
+	// range requests bypass the cache, and partial responses are not stored.
+	rangeHeader := http.Header{"Range": {"bytes=0-1"}}
+	assert.Nil(c.lookup(newHTTPCacheRequest(http.MethodGet, url, rangeHeader), now))
+	url = "http://example.com/partial"
+	l = c.lookup(newHTTPCacheRequest(http.MethodGet, url, nil), now)
+	c.store(l, newHTTPCacheResponse(206, http.Header{"Cache-Control": {"max-age=60"}}, "bo"), now, now)
+	assert.Nil(c.lookup(newHTTPCacheRequest(http.MethodGet, url, nil), now).entry)
+}
+
+func TestHTTPCacheLookup(t *testing.T) {
+	assert := assert.New(t)
+
+	c := newTestHTTPCache(t, "test-lookup")
+	defer c.Close()
+	now := time.Now()
+
+	url := "http://example.com/lookup"
+	store := func(respHeader http.Header) {
+		l := c.lookup(newHTTPCacheRequest(http.MethodGet, url, nil), now)
+		c.store(l, newHTTPCacheResponse(200, respHeader, "body"), now, now)
+	}
+	lookup := func(reqHeader http.Header, after time.Duration) *httpCacheLookup {
+		return c.lookup(newHTTPCacheRequest(http.MethodGet, url, reqHeader), now.Add(after))
+	}
+
+	store(http.Header{"Cache-Control": {"max-age=60, stale-while-revalidate=30, stale-if-error=60"}})
+	l := lookup(nil, 10*time.Second)
+	assert.True(l.fresh)
+	assert.Equal(10*time.Second, l.age)
+	l = lookup(http.Header{"Cache-Control": {"max-age=5"}}, 10*time.Second)
+	assert.False(l.fresh)
+	l = lookup(http.Header{"Cache-Control": {"min-fresh=55"}}, 10*time.Second)
+	assert.False(l.fresh)
+	l = lookup(http.Header{"Cache-Control": {"no-cache"}}, 0)
+	assert.False(l.fresh)
+	assert.False(l.staleWhileRevalidate)
+	assert.True(l.staleIfError)
+
+	l = lookup(nil, 80*time.Second)
+	assert.False(l.fresh)
+	assert.True(l.staleWhileRevalidate)
+	assert.True(l.staleIfError)
+	l = lookup(nil, 100*time.Second)
+	assert.False(l.staleWhileRevalidate)
+	assert.True(l.staleIfError)
+	l = lookup(nil, 200*time.Second)
+	assert.False(l.staleIfError)
+	l = lookup(http.Header{"Cache-Control": {"stale-if-error=300"}}, 200*time.Second)
+	assert.True(l.staleIfError)
+
+	// must-revalidate disables serving stale responses.
+	store(http.Header{"Cache-Control": {"max-age=60, must-revalidate, stale-if-error=60"}})
+	l = lookup(nil, 80*time.Second)
+	assert.NotNil(l.entry)
+	assert.False(l.staleIfError)
+
+	// conditionals
+	store(http.Header{"Cache-Control": {"no-cache"}, "Etag": {`"v1"`}})
+	l = lookup(nil, 0)
+	assert.NotNil(l.entry)
+	assert.False(l.fresh)
+	h := http.Header{}
+	assert.True(l.addConditionals(h))
+	assert.Equal(`"v1"`, h.Get("If-None-Match"))
+	h = http.Header{"If-None-Match": {`"v0"`}}
+	assert.False(l.addConditionals(h))
+	assert.Equal(`"v0"`, h.Get("If-None-Match"))
+
+	// vary
+	store(http.Header{"Vary": {"Accept-Language"}})
+	assert.NotNil(lookup(nil, 0).entry)
+	assert.Nil(lookup(http.Header{"Accept-Language": {"en"}}, 0).entry)
+	l = c.lookup(newHTTPCacheRequest(http.MethodGet, url, http.Header{"Accept-Language": {"en"}}), now)
+	c.store(l, newHTTPCacheResponse(200, http.Header{"Vary": {"Accept-Language"}}, "en"), now, now)
+	l = c.lookup(newHTTPCacheRequest(http.MethodGet, url, http.Header{"Accept-Language": {"zh"}}), now)
+	c.store(l, newHTTPCacheResponse(200, http.Header{"Vary": {"Accept-Language"}}, "zh"), now, now)
+	l = c.lookup(newHTTPCacheRequest(http.MethodHead, url, http.Header{"Accept-Language": {"en"}}), now)
+	assert.Equal("en", string(l.entry.Body))
+	l = c.lookup(newHTTPCacheRequest(http.MethodGet, url, http.Header{"Accept-Language": {"zh"}}), now)
+	assert.Equal("zh", string(l.entry.Body))
+
+	// freshen
+	store(http.Header{"Cache-Control": {"max-age=0"}, "Etag": {`"v1"`}, "X-Version": {"1"}})
+	l = lookup(nil, 0)
+	assert.False(l.fresh)
+	e := c.freshen(l, http.Header{"Cache-Control": {"max-age=60"}, "X-Version": {"2"}, "Content-Length": {"0"}}, now, now)
+	assert.Equal("body", string(e.Body))
+	assert.Equal("2", e.Header.Get("X-Version"))
+	l = lookup(nil, 0)
+	assert.True(l.fresh)
+	assert.Equal("2", l.entry.Header.Get("X-Version"))
+
+	// purge and invalidate
+	n, err := c.PurgeKey(url)
+	assert.NoError(err)
+	assert.Equal(5, n)
+	assert.Nil(lookup(nil, 0).entry)
+
+	store(http.Header{"Cache-Tag": {"t1, t2"}})
+	n, err = c.PurgeTag("t2")
+	assert.NoError(err)
+	assert.Equal(1, n)
+	assert.Nil(lookup(nil, 0).entry)
+
+	store(nil)
+	n, _ = c.PurgeTag("all")
+	assert.Equal(1, n)
+
+	store(nil)
+	c.invalidate(newHTTPCacheRequest(http.MethodPost, url, nil), 500)
+	assert.NotNil(lookup(nil, 0).entry)
+	c.invalidate(newHTTPCacheRequest(http.MethodGet, url, nil), 200)
+	assert.NotNil(lookup(nil, 0).entry)
+	c.invalidate(newHTTPCacheRequest(http.MethodPost, url, nil), 200)
+	assert.Nil(lookup(nil, 0).entry)
+}
+
+func TestHTTPCacheRegistry(t *testing.T) {
+	assert := assert.New(t)
+
+	c1 := newTestHTTPCache(t, "test-registry")
+	assert.Same(c1, GetHTTPCache("test-registry"))
+	assert.Contains(ListHTTPCaches(), "test-registry")
+
+	// the new cache replaces the old one, and closing the old one
+	// doesn't unregister the new one.
+	c2 := newTestHTTPCache(t, "test-registry")
+	assert.Same(c2, GetHTTPCache("test-registry"))
+	c1.Close()
+	assert.Same(c2, GetHTTPCache("test-registry"))
+	c2.Close()
+	assert.Nil(GetHTTPCache("test-registry"))
+
+	// the storage is created later if it failed.
+	c := NewHTTPCache(&HTTPCacheSpec{
+		Name:    "test-registry",
+		Storage: httpCacheStorageRESP,
+		RESP:    &RESPCacheStorageSpec{Address: "127.0.0.1:1"},
+	})
+	defer c.Close()
+	assert.Nil(c.getStorage())
+	_, err := c.PurgeTag("t1")
+	assert.Error(err)
+	l := c.lookup(newHTTPCacheRequest(http.MethodGet, "http://example.com/", nil), time.Now())
+	assert.Nil(l.entry)
+}
+
+func TestHTTPCacheAPI(t *testing.T) {
+	assert := assert.New(t)
+
+	c := newTestHTTPCache(t, "test-api")
+	defer c.Close()
+	now := time.Now()
+
+	for _, url := range []string{"http://example.com/a", "http://example.com/b"} {
+		l := c.lookup(newHTTPCacheRequest(http.MethodGet, url, nil), now)
+		c.store(l, newHTTPCacheResponse(200, http.Header{"Cache-Tag": {"t1"}}, "body"), now, now)
+	}
+
+	router := chi.NewRouter()
+	router.Get(HTTPCacheAPIPrefix, listHTTPCaches)
+	router.Post(HTTPCacheAPIPrefix+"/{name}/purge", purgeHTTPCache)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, HTTPCacheAPIPrefix, nil))
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Contains(w.Body.String(), "test-api")
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, HTTPCacheAPIPrefix+"/none/purge", strings.NewReader(`{}`)))
+	assert.Equal(http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, HTTPCacheAPIPrefix+"/test-api/purge", strings.NewReader(`{`)))
+	assert.Equal(http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	body := `{"keys": ["http://example.com/a"]}`
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, HTTPCacheAPIPrefix+"/test-api/purge", strings.NewReader(body)))
+	assert.Equal(http.StatusOK, w.Code)
+	assert.JSONEq(`{"purged": 2}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	body = `{"tags": ["t1"]}`
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, HTTPCacheAPIPrefix+"/test-api/purge", strings.NewReader(body)))
+	assert.Equal(http.StatusOK, w.Code)
+	assert.JSONEq(`{"purged": 1}`, w.Body.String())
+}
+
+func TestHTTPCacheProxy(t *testing.T) {
+	assert := assert.New(t)
+
+	var hits, failing atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		h := w.Header()
+		switch r.URL.Path {
+		case "/fresh":
+			h.Set("Cache-Control", "max-age=60")
+			h.Set("ETag", `"v1"`)
+		case "/revalidate":
+			h.Set("Cache-Control", "no-cache")
+			h.Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/swr":
+			h.Set("Cache-Control", "max-age=0, stale-while-revalidate=60")
+		case "/sie":
+			if failing.Load() == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			h.Set("Cache-Control", "max-age=0, stale-if-error=60")
+		case "/private":
+			h.Set("Cache-Control", "private, max-age=60")
+		}
+		fmt.Fprintf(w, "hello %s", r.URL.Path)
+	}))
+	defer srv.Close()
+
+	old := fnSendRequest
+	defer func() { fnSendRequest = old }()
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		return client.Do(r)
+	}
+
+	yamlConfig := fmt.Sprintf(`
+name: proxy
+kind: Proxy
+pools:
+- servers:
+  - url: %s
+  httpCache:
+    name: test-proxy
+    storage: disk
+    disk:
+      dir: %s
+`, srv.URL, t.TempDir())
+	proxy := newTestProxy(yamlConfig, assert)
+	defer proxy.Close()
+
+	type result struct {
+		code int
+		body string
+		tags string
+		resp *httpprot.Response
+	}
+	do := func(method, path string, header http.Header) result {
+		stdr, _ := http.NewRequest(method, "http://www.megaease.com"+path, nil)
+		for k, v := range header {
+			stdr.Header[k] = v
+		}
+		ctx := getCtx(stdr)
+		defer ctx.Finish()
+		proxy.Handle(ctx)
+		resp := ctx.GetResponse(context.DefaultNamespace).(*httpprot.Response)
+		return result{resp.StatusCode(), string(resp.RawPayload()), ctx.Tags(), resp}
+	}
+
+	// fresh responses are served from the cache.
+	r := do(http.MethodGet, "/fresh", nil)
+	assert.Equal(200, r.code)
+	assert.Equal("hello /fresh", r.body)
+	r = do(http.MethodGet, "/fresh", nil)
+	assert.Equal("hello /fresh", r.body)
+	assert.Contains(r.tags, "cache hit")
+	assert.Equal(int32(1), hits.Load())
+	r = do(http.MethodHead, "/fresh", nil)
+	assert.Equal(200, r.code)
+	assert.Empty(r.body)
+	r = do(http.MethodGet, "/fresh", http.Header{"If-None-Match": {`"v1"`}})
+	assert.Equal(http.StatusNotModified, r.code)
+	assert.Equal(int32(1), hits.Load())
+
+	// unsafe requests invalidate the stored responses.
+	do(http.MethodPost, "/fresh", nil)
+	assert.Equal(int32(2), hits.Load())
+	do(http.MethodGet, "/fresh", nil)
+	assert.Equal(int32(3), hits.Load())
+
+	// stored responses are revalidated.
+	hits.Store(0)
+	do(http.MethodGet, "/revalidate", nil)
+	r = do(http.MethodGet, "/revalidate", nil)
+	assert.Equal(200, r.code)
+	assert.Equal("hello /revalidate", r.body)
+	assert.Contains(r.tags, "cache revalidated")
+	assert.Equal(int32(2), hits.Load())
+
+	// stale responses are served while revalidating in background.
+	hits.Store(0)
+	do(http.MethodGet, "/swr", nil)
+	r = do(http.MethodGet, "/swr", nil)
+	assert.Equal("hello /swr", r.body)
+	assert.Contains(r.tags, "cache stale")
+	assert.Eventually(func() bool { return hits.Load() == 2 }, time.Second, 10*time.Millisecond)
+
+	// stale responses are served if the server fails.
+	hits.Store(0)
+	do(http.MethodGet, "/sie", nil)
+	failing.Store(1)
+	r = do(http.MethodGet, "/sie", nil)
+	assert.Equal(200, r.code)
+	assert.Equal("hello /sie", r.body)
+	assert.Contains(r.tags, "cache stale-if-error")
+	assert.Equal(int32(2), hits.Load())
+
+	// private responses are not stored.
+	hits.Store(0)
+	do(http.MethodGet, "/private", nil)
+	r = do(http.MethodGet, "/private", nil)
+	assert.NotContains(r.tags, "cache")
+	assert.Equal(int32(2), hits.Load())
+
+	// only-if-cached
+	r = do(http.MethodGet, "/none", http.Header{"Cache-Control": {"only-if-cached"}})
+	assert.Equal(http.StatusGatewayTimeout, r.code)
+	assert.Equal(int32(2), hits.Load())
+}
@@ -0,0 +1,114 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpproxy
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/megaease/easegress/v2/pkg/api"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+)
+
+const (
+	// HTTPCacheAPIGroupName is the name of the HTTP cache API group.
+	HTTPCacheAPIGroupName = "httpcache"
+	// HTTPCacheAPIPrefix is the prefix of HTTP cache API endpoints.
+	HTTPCacheAPIPrefix = "/httpcaches"
+)
+
+var registerHTTPCacheAPIsOnce sync.Once
+
+type (
+	// HTTPCachePurgeRequest is the request to purge an HTTP cache, a key
+	// is the URL of a request, like https://example.com/path?query.
+	HTTPCachePurgeRequest struct {
+		Keys []string `json:"keys"`
+		Tags []string `json:"tags"`
+	}
+
+	// HTTPCachePurgeResponse is the response of purging an HTTP cache.
+	HTTPCachePurgeResponse struct {
+		Purged int `json:"purged"`
+	}
+)
+
+// registerHTTPCacheAPIs registers the APIs of HTTP caches, it is called
+// when the first HTTP cache is created.
+func registerHTTPCacheAPIs() {
+	registerHTTPCacheAPIsOnce.Do(func() {
+		group := &api.Group{
+			Group: HTTPCacheAPIGroupName,
+			Entries: []*api.Entry{
+				{
+					Path:    HTTPCacheAPIPrefix,
+					Method:  http.MethodGet,
+					Handler: listHTTPCaches,
+				},
+				{
+					Path:    HTTPCacheAPIPrefix + "/{name}/purge",
+					Method:  http.MethodPost,
+					Handler: purgeHTTPCache,
+				},
+			},
+		}
+		api.RegisterAPIs(group)
+	})
+}
+
+func listHTTPCaches(w http.ResponseWriter, r *http.Request) {
+	api.WriteBody(w, r, ListHTTPCaches())
+}
+
+func purgeHTTPCache(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	c := GetHTTPCache(name)
+	if c == nil {
+		api.HandleAPIError(w, r, http.StatusNotFound, fmt.Errorf("httpCache %s not found", name))
+		return
+	}
+
+	req := &HTTPCachePurgeRequest{}
+	if err := codectool.DecodeJSON(r.Body, req); err != nil {
+		api.HandleAPIError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	resp := &HTTPCachePurgeResponse{}
+	for _, key := range req.Keys {
+		n, err := c.PurgeKey(key)
+		if err != nil {
+			api.HandleAPIError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		resp.Purged += n
+	}
+	for _, tag := range req.Tags {
+		n, err := c.PurgeTag(tag)
+		if err != nil {
+			api.HandleAPIError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		resp.Purged += n
+	}
+
+	api.WriteBody(w, r, resp)
+}
@@ -0,0 +1,465 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpproxy
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	stdcontext "context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/rueidis"
+
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+)
+
+const (
+	httpCacheStorageDisk = "disk"
+	httpCacheStorageRESP = "resp"
+
+	defaultDiskCacheMaxBytes = 1 << 30
+	respCacheTimeout         = time.Second
+)
+
+type (
+	// httpCacheStorage is the storage of HTTPCache, values are stored
+	// with a time to live and a list of tags, and can be purged by tags.
+	httpCacheStorage interface {
+		// Get returns the value of key, a nil value means not found.
+		Get(key string) ([]byte, error)
+		// Set stores the value of key.
+		Set(key string, value []byte, ttl time.Duration, tags []string) error
+		// Delete deletes keys and returns the number of deleted keys.
+		Delete(keys ...string) (int, error)
+		// PurgeTag deletes all keys with the tag and returns the number
+		// of deleted keys.
+		PurgeTag(tag string) (int, error)
+		// Close closes the storage.
+		Close()
+	}
+
+	// DiskCacheStorageSpec describes the disk storage of HTTPCache.
+	DiskCacheStorageSpec struct {
+		Dir      string `json:"dir" jsonschema:"required"`
+		MaxBytes int64  `json:"maxBytes,omitempty" jsonschema:"minimum=0"`
+	}
+
+	// RESPCacheStorageSpec describes the storage of HTTPCache on a
+	// store speaking the RESP protocol, like Redis, Valkey or KeyDB.
+	RESPCacheStorageSpec struct {
+		Address   string `json:"address" jsonschema:"required"`
+		Username  string `json:"username,omitempty"`
+		Password  string `json:"password,omitempty"`
+		DB        int    `json:"db,omitempty" jsonschema:"minimum=0"`
+		KeyPrefix string `json:"keyPrefix,omitempty"`
+	}
+
+	// diskCacheStorage is a LRU storage on local disk, every value is
+	// stored in a file, and the index of values is kept in memory.
+	diskCacheStorage struct {
+		dir      string
+		maxBytes int64
+
+		mu    sync.Mutex
+		size  int64
+		lru   *list.List
+		items map[string]*list.Element
+		tags  map[string]map[string]struct{}
+	}
+
+	// diskCacheItem is the meta data of a value of diskCacheStorage, it is
+	// stored as the first line of the file.
+	diskCacheItem struct {
+		Key      string    `json:"key"`
+		Tags     []string  `json:"tags,omitempty"`
+		ExpireAt time.Time `json:"expireAt"`
+
+		size int64
+	}
+
+	// respCacheStorage is the storage on a RESP store, the keys of a tag
+	// are kept in a set.
+	respCacheStorage struct {
+		client rueidis.Client
+		prefix string
+	}
+)
+
+// Validate validates the DiskCacheStorageSpec.
+func (spec *DiskCacheStorageSpec) Validate() error {
+	if spec.Dir == "" {
+		return fmt.Errorf("dir of disk storage is empty")
+	}
+	return nil
+}
+
+// Validate validates the RESPCacheStorageSpec.
+func (spec *RESPCacheStorageSpec) Validate() error {
+	if spec.Address == "" {
+		return fmt.Errorf("address of resp storage is empty")
+	}
+	return nil
+}
+
+func newDiskCacheStorage(spec *DiskCacheStorageSpec) (*diskCacheStorage, error) {
+	if err := os.MkdirAll(spec.Dir, 0o750); err != nil {
+		return nil, err
+	}
+
+	s := &diskCacheStorage{
+		dir:      spec.Dir,
+		maxBytes: spec.MaxBytes,
+		lru:      list.New(),
+		items:    map[string]*list.Element{},
+		tags:     map[string]map[string]struct{}{},
+	}
+	if s.maxBytes <= 0 {
+		s.maxBytes = defaultDiskCacheMaxBytes
+	}
+
+	entries, err := os.ReadDir(spec.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(spec.Dir, e.Name())
+		item, err := s.readItem(path)
+		if err != nil || !now.Before(item.ExpireAt) || s.fileName(item.Key) != path {
+			os.Remove(path)
+			continue
+		}
+		s.add(item)
+	}
+	s.evict()
+
+	return s, nil
+}
+
+func (s *diskCacheStorage) fileName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:]))
+}
+
+func (s *diskCacheStorage) readItem(path string) (*diskCacheItem, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	item := &diskCacheItem{}
+	if err = codectool.UnmarshalJSON(line, item); err != nil {
+		return nil, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	item.size = fi.Size()
+	return item, nil
+}
+
+// add adds an item to the index, the caller must hold the lock.
+func (s *diskCacheStorage) add(item *diskCacheItem) {
+	if e := s.items[item.Key]; e != nil {
+		s.remove(e)
+	}
+	s.items[item.Key] = s.lru.PushFront(item)
+	s.size += item.size
+	for _, tag := range item.Tags {
+		keys := s.tags[tag]
+		if keys == nil {
+			keys = map[string]struct{}{}
+			s.tags[tag] = keys
+		}
+		keys[item.Key] = struct{}{}
+	}
+}
+
+// remove removes an item from the index, the caller must hold the lock.
+func (s *diskCacheStorage) remove(e *list.Element) {
+	item := e.Value.(*diskCacheItem)
+	s.lru.Remove(e)
+	delete(s.items, item.Key)
+	s.size -= item.size
+	for _, tag := range item.Tags {
+		keys := s.tags[tag]
+		delete(keys, item.Key)
+		if len(keys) == 0 {
+			delete(s.tags, tag)
+		}
+	}
+}
+
+// evict evicts the least recently used items until the total size is
+// no larger than maxBytes, the caller must hold the lock.
+func (s *diskCacheStorage) evict() {
+	for s.size > s.maxBytes {
+		e := s.lru.Back()
+		if e == nil {
+			return
+		}
+		s.remove(e)
+		os.Remove(s.fileName(e.Value.(*diskCacheItem).Key))
+	}
+}
+
+// Get implements httpCacheStorage.
+func (s *diskCacheStorage) Get(key string) ([]byte, error) {
+	s.mu.Lock()
+	e := s.items[key]
+	if e == nil {
+		s.mu.Unlock()
+		return nil, nil
+	}
+	if !time.Now().Before(e.Value.(*diskCacheItem).ExpireAt) {
+		s.remove(e)
+		s.mu.Unlock()
+		os.Remove(s.fileName(key))
+		return nil, nil
+	}
+	s.lru.MoveToFront(e)
+	s.mu.Unlock()
+
+	data, err := os.ReadFile(s.fileName(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	idx := bytes.IndexByte(data, '\n')
+	if idx < 0 {
+		return nil, fmt.Errorf("invalid cache file of key %s", key)
+	}
+	return data[idx+1:], nil
+}
+
+// Set implements httpCacheStorage.
+func (s *diskCacheStorage) Set(key string, value []byte, ttl time.Duration, tags []string) error {
+	item := &diskCacheItem{
+		Key:      key,
+		Tags:     tags,
+		ExpireAt: time.Now().Add(ttl),
+	}
+	meta, err := codectool.MarshalJSON(item)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(s.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(append(meta, '\n'), value...))
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), s.fileName(key))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	item.size = int64(len(meta) + 1 + len(value))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.add(item)
+	s.evict()
+	return nil
+}
+
+// Delete implements httpCacheStorage.
+func (s *diskCacheStorage) Delete(keys ...string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for _, key := range keys {
+		if e := s.items[key]; e != nil {
+			s.remove(e)
+			os.Remove(s.fileName(key))
+			count++
+		}
+	}
+	return count, nil
+}
+
+// PurgeTag implements httpCacheStorage.
+func (s *diskCacheStorage) PurgeTag(tag string) (int, error) {
+	s.mu.Lock()
+	keys := make([]string, 0, len(s.tags[tag]))
+	for key := range s.tags[tag] {
+		keys = append(keys, key)
+	}
+	s.mu.Unlock()
+
+	return s.Delete(keys...)
+}
+
+// Close implements httpCacheStorage.
+func (s *diskCacheStorage) Close() {
+}
+
+func newRESPCacheStorage(spec *RESPCacheStorageSpec, name string) (*respCacheStorage, error) {
+	client, err := rueidis.NewClient(rueidis.ClientOption{
+		InitAddress:  []string{spec.Address},
+		Username:     spec.Username,
+		Password:     spec.Password,
+		SelectDB:     spec.DB,
+		DisableCache: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the default prefix is a hash tag, so that all keys are in the same
+	// slot of a cluster, and can be deleted by a single command.
+	prefix := spec.KeyPrefix
+	if prefix == "" {
+		prefix = "{easegress:httpcache:" + name + "}:"
+	}
+	return &respCacheStorage{client: client, prefix: prefix}, nil
+}
+
+func (s *respCacheStorage) entryKey(key string) string {
+	return s.prefix + "e:" + key
+}
+
+func (s *respCacheStorage) tagKey(tag string) string {
+	return s.prefix + "t:" + tag
+}
+
+// Get implements httpCacheStorage.
+func (s *respCacheStorage) Get(key string) ([]byte, error) {
+	ctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), respCacheTimeout)
+	defer cancel()
+
+	cmd := s.client.B().Get().Key(s.entryKey(key)).Build()
+	data, err := s.client.Do(ctx, cmd).AsBytes()
+	if rueidis.IsRedisNil(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// Set implements httpCacheStorage.
+func (s *respCacheStorage) Set(key string, value []byte, ttl time.Duration, tags []string) error {
+	ctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), respCacheTimeout)
+	defer cancel()
+
+	ms := ttl.Milliseconds()
+	if ms <= 0 {
+		return nil
+	}
+
+	b := s.client.B()
+	entryKey := s.entryKey(key)
+	cmds := rueidis.Commands{
+		b.Set().Key(entryKey).Value(rueidis.BinaryString(value)).PxMilliseconds(ms).Build(),
+	}
+	// the set of a tag lives as long as the longest living key in it.
+	for _, tag := range tags {
+		tagKey := s.tagKey(tag)
+		cmds = append(cmds,
+			b.Sadd().Key(tagKey).Member(entryKey).Build(),
+			b.Pexpire().Key(tagKey).Milliseconds(ms).Nx().Build(),
+			b.Pexpire().Key(tagKey).Milliseconds(ms).Gt().Build(),
+		)
+	}
+
+	for _, resp := range s.client.DoMulti(ctx, cmds...) {
+		if err := resp.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete implements httpCacheStorage.
+func (s *respCacheStorage) Delete(keys ...string) (int, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	entryKeys := make([]string, len(keys))
+	for i, key := range keys {
+		entryKeys[i] = s.entryKey(key)
+	}
+	return s.del(entryKeys)
+}
+
+func (s *respCacheStorage) del(keys []string) (int, error) {
+	ctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), respCacheTimeout)
+	defer cancel()
+
+	cmd := s.client.B().Del().Key(keys...).Build()
+	n, err := s.client.Do(ctx, cmd).AsInt64()
+	return int(n), err
+}
+
+// PurgeTag implements httpCacheStorage.
+func (s *respCacheStorage) PurgeTag(tag string) (int, error) {
+	ctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), respCacheTimeout)
+	defer cancel()
+
+	tagKey := s.tagKey(tag)
+	cmd := s.client.B().Smembers().Key(tagKey).Build()
+	keys, err := s.client.Do(ctx, cmd).AsStrSlice()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	if len(keys) > 0 {
+		if count, err = s.del(keys); err != nil {
+			return 0, err
+		}
+	}
+	if _, err = s.del([]string{tagKey}); err != nil {
+		logger.Warnf("failed to delete key %s: %v", tagKey, err)
+	}
+	return count, nil
+}
+
+// Close implements httpCacheStorage.
+func (s *respCacheStorage) Close() {
+	s.client.Close()
+}
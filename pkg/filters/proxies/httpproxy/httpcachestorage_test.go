@@ -0,0 +1,277 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpproxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRESPServer is a minimal in memory RESP2 server for testing.
+type fakeRESPServer struct {
+	ln   net.Listener
+	mu   sync.Mutex
+	kv   map[string]string
+	sets map[string]map[string]struct{}
+}
+
+func newFakeRESPServer(t *testing.T) *fakeRESPServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeRESPServer{
+		ln:   ln,
+		kv:   map[string]string{},
+		sets: map[string]map[string]struct{}{},
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeRESPServer) addr() string {
+	return s.ln.Addr().String()
+}
+
+func (s *fakeRESPServer) close() {
+	s.ln.Close()
+}
+
+func (s *fakeRESPServer) readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, n)
+	for i := range args {
+		line, err = r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		l, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		buf := make([]byte, l+2)
+		if _, err = io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:l])
+	}
+	return args, nil
+}
+
+func (s *fakeRESPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := s.readCommand(r)
+		if err != nil {
+			return
+		}
+		conn.Write([]byte(s.exec(args)))
+	}
+}
+
+func (s *fakeRESPServer) exec(args []string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch strings.ToUpper(args[0]) {
+	case "HELLO":
+		return "-ERR unknown command 'HELLO'\r\n"
+	case "GET":
+		v, ok := s.kv[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+	case "SET":
+		s.kv[args[1]] = args[2]
+		return "+OK\r\n"
+	case "DEL":
+		n := 0
+		for _, k := range args[1:] {
+			if _, ok := s.kv[k]; ok {
+				delete(s.kv, k)
+				n++
+			} else if _, ok := s.sets[k]; ok {
+				delete(s.sets, k)
+				n++
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", n)
+	case "SADD":
+		set := s.sets[args[1]]
+		if set == nil {
+			set = map[string]struct{}{}
+			s.sets[args[1]] = set
+		}
+		for _, m := range args[2:] {
+			set[m] = struct{}{}
+		}
+		return ":1\r\n"
+	case "SMEMBERS":
+		set := s.sets[args[1]]
+		resp := fmt.Sprintf("*%d\r\n", len(set))
+		for m := range set {
+			resp += fmt.Sprintf("$%d\r\n%s\r\n", len(m), m)
+		}
+		return resp
+	case "PEXPIRE":
+		return ":1\r\n"
+	case "CLUSTER":
+		return "-ERR This instance has cluster support disabled\r\n"
+	case "PING":
+		return "+PONG\r\n"
+	}
+	return "+OK\r\n"
+}
+
+func TestHTTPCacheStorageSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Error((&DiskCacheStorageSpec{}).Validate())
+	assert.NoError((&DiskCacheStorageSpec{Dir: "/tmp"}).Validate())
+	assert.Error((&RESPCacheStorageSpec{}).Validate())
+	assert.NoError((&RESPCacheStorageSpec{Address: "127.0.0.1:6379"}).Validate())
+}
+
+func TestDiskCacheStorage(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	s, err := newDiskCacheStorage(&DiskCacheStorageSpec{Dir: dir, MaxBytes: 1000})
+	assert.NoError(err)
+
+	v, err := s.Get("a")
+	assert.NoError(err)
+	assert.Nil(v)
+
+	assert.NoError(s.Set("a", []byte("value a"), time.Minute, []string{"t1", "t2"}))
+	assert.NoError(s.Set("b", []byte("value\nb"), time.Minute, []string{"t2"}))
+	assert.NoError(s.Set("c", []byte("value c"), time.Minute, []string{"t3"}))
+
+	v, err = s.Get("b")
+	assert.NoError(err)
+	assert.Equal("value\nb", string(v))
+
+	// expired
+	assert.NoError(s.Set("d", []byte("value d"), -time.Second, nil))
+	v, _ = s.Get("d")
+	assert.Nil(v)
+	_, err = os.Stat(s.fileName("d"))
+	assert.True(os.IsNotExist(err))
+
+	// reload from disk
+	s2, err := newDiskCacheStorage(&DiskCacheStorageSpec{Dir: dir, MaxBytes: 1000})
+	assert.NoError(err)
+	v, _ = s2.Get("a")
+	assert.Equal("value a", string(v))
+	n, err := s2.PurgeTag("t2")
+	assert.NoError(err)
+	assert.Equal(2, n)
+	v, _ = s2.Get("a")
+	assert.Nil(v)
+	v, _ = s2.Get("c")
+	assert.Equal("value c", string(v))
+
+	n, err = s2.Delete("c", "x")
+	assert.NoError(err)
+	assert.Equal(1, n)
+	assert.Equal(int64(0), s2.size)
+	assert.Empty(s2.tags)
+
+	// evict the least recently used values
+	value := []byte(strings.Repeat("x", 250))
+	for _, k := range []string{"k1", "k2", "k3"} {
+		assert.NoError(s2.Set(k, value, time.Minute, nil))
+	}
+	s2.Get("k1")
+	assert.NoError(s2.Set("k4", value, time.Minute, nil))
+	v, _ = s2.Get("k2")
+	assert.Nil(v)
+	v, _ = s2.Get("k1")
+	assert.NotNil(v)
+	assert.LessOrEqual(s2.size, int64(1000))
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	assert.Len(files, 3)
+	s2.Close()
+}
+
+func TestRESPCacheStorage(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := newFakeRESPServer(t)
+	defer srv.close()
+
+	s, err := newRESPCacheStorage(&RESPCacheStorageSpec{Address: srv.addr()}, "test")
+	assert.NoError(err)
+	defer s.Close()
+
+	v, err := s.Get("a")
+	assert.NoError(err)
+	assert.Nil(v)
+
+	assert.NoError(s.Set("a", []byte("value a"), time.Minute, []string{"t1", "t2"}))
+	assert.NoError(s.Set("b", []byte("value b"), time.Minute, []string{"t2"}))
+	assert.NoError(s.Set("c", []byte("value c"), 0, []string{"t2"}))
+
+	v, err = s.Get("a")
+	assert.NoError(err)
+	assert.Equal("value a", string(v))
+	v, _ = s.Get("c")
+	assert.Nil(v)
+
+	srv.mu.Lock()
+	assert.Contains(srv.kv, "{easegress:httpcache:test}:e:a")
+	assert.Len(srv.sets["{easegress:httpcache:test}:t:t2"], 2)
+	srv.mu.Unlock()
+
+	n, err := s.PurgeTag("t2")
+	assert.NoError(err)
+	assert.Equal(2, n)
+	v, _ = s.Get("b")
+	assert.Nil(v)
+
+	assert.NoError(s.Set("a", []byte("value a"), time.Minute, nil))
+	n, err = s.Delete("a")
+	assert.NoError(err)
+	assert.Equal(1, n)
+	n, err = s.Delete()
+	assert.NoError(err)
+	assert.Equal(0, n)
+}
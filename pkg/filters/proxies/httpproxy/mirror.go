@@ -0,0 +1,357 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpproxy
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+)
+
+const (
+	mirrorFieldStatus = "status"
+	mirrorFieldHeader = "header"
+	mirrorFieldBody   = "body"
+	mirrorFieldError  = "error"
+
+	defaultMirrorMaxSamples = 10
+)
+
+type (
+	// MirrorSpec is the spec of traffic mirroring, it is only valid in
+	// the mirror pool.
+	MirrorSpec struct {
+		// SamplingPercentage is the percentage of matched requests to be
+		// mirrored, default is 100, and 0 disables mirroring.
+		SamplingPercentage *float64 `json:"samplingPercentage,omitempty" jsonschema:"minimum=0,maximum=100"`
+		// MaxBodySize is the max body size of mirrored requests, requests
+		// with larger or stream bodies are not mirrored. It also limits
+		// the size of response bodies read in diff mode. Zero means no
+		// limit on requests, and 4MB on responses.
+		MaxBodySize int64           `json:"maxBodySize,omitempty" jsonschema:"minimum=0"`
+		Diff        *MirrorDiffSpec `json:"diff,omitempty"`
+	}
+
+	// MirrorDiffSpec is the spec of the diff mode of traffic mirroring,
+	// which compares the response of the mirror pool with the response
+	// of the primary pool.
+	MirrorDiffSpec struct {
+		// Headers is the headers to compare.
+		Headers []string `json:"headers,omitempty" jsonschema:"uniqueItems=true"`
+		// JSONPaths is the paths of JSON bodies to compare, in GJSON syntax.
+		JSONPaths []string `json:"jsonPaths,omitempty" jsonschema:"uniqueItems=true"`
+		// MaxSamples is the max number of mismatched samples to keep,
+		// default is 10.
+		MaxSamples int `json:"maxSamples,omitempty" jsonschema:"minimum=0"`
+	}
+
+	// MirrorDiffStatus is the status of the diff mode of traffic mirroring.
+	MirrorDiffStatus struct {
+		Compared   uint64              `json:"compared"`
+		Mismatched uint64              `json:"mismatched"`
+		Fields     map[string]uint64   `json:"fields,omitempty"`
+		Samples    []*MirrorDiffSample `json:"samples,omitempty"`
+	}
+
+	// MirrorDiffSample is a sample of mismatched responses.
+	MirrorDiffSample struct {
+		Time       string                `json:"time"`
+		Method     string                `json:"method"`
+		URL        string                `json:"url"`
+		Mismatches []*MirrorDiffMismatch `json:"mismatches"`
+	}
+
+	// MirrorDiffMismatch is a mismatched field of a sample.
+	MirrorDiffMismatch struct {
+		Field   string `json:"field"`
+		Name    string `json:"name,omitempty"`
+		Primary string `json:"primary"`
+		Mirror  string `json:"mirror"`
+	}
+
+	// mirrorResponse is the snapshot of a response to compare.
+	mirrorResponse struct {
+		statusCode int
+		header     http.Header
+		// body is nil if the body is a stream or is too large.
+		body []byte
+		err  error
+	}
+
+	mirror struct {
+		sp   *ServerPool
+		spec *MirrorSpec
+
+		lock       sync.Mutex
+		compared   uint64
+		mismatched uint64
+		fields     map[string]uint64
+		samples    []*MirrorDiffSample
+	}
+)
+
+// Validate validates the MirrorSpec.
+func (spec *MirrorSpec) Validate() error {
+	if p := spec.SamplingPercentage; p != nil && (*p < 0 || *p > 100) {
+		return fmt.Errorf("samplingPercentage must be in [0, 100]")
+	}
+	if spec.MaxBodySize < 0 {
+		return fmt.Errorf("maxBodySize must not be negative")
+	}
+	if spec.Diff != nil && spec.Diff.MaxSamples < 0 {
+		return fmt.Errorf("maxSamples must not be negative")
+	}
+	return nil
+}
+
+func newMirror(sp *ServerPool, spec *MirrorSpec) *mirror {
+	return &mirror{
+		sp:     sp,
+		spec:   spec,
+		fields: map[string]uint64{},
+	}
+}
+
+// shouldMirror returns whether the request should be mirrored.
+func (m *mirror) shouldMirror(req *httpprot.Request) bool {
+	if m == nil {
+		return true
+	}
+
+	if p := m.spec.SamplingPercentage; p != nil && *p < 100 && rand.Float64()*100 >= *p {
+		return false
+	}
+
+	if m.spec.MaxBodySize > 0 {
+		if req.IsStream() || req.PayloadSize() > m.spec.MaxBodySize {
+			return false
+		}
+	}
+
+	return true
+}
+
+// diffEnabled returns whether the diff mode is enabled.
+func (m *mirror) diffEnabled() bool {
+	return m != nil && m.spec.Diff != nil
+}
+
+func (m *mirror) maxBodySize() int64 {
+	if m == nil || m.spec.MaxBodySize == 0 {
+		return httpprot.DefaultMaxPayloadSize
+	}
+	return m.spec.MaxBodySize
+}
+
+// snapshotResponse takes a snapshot of the output response of ctx, it
+// must be called in the goroutine handling the request.
+func (m *mirror) snapshotResponse(ctx *context.Context) *mirrorResponse {
+	resp, _ := ctx.GetOutputResponse().(*httpprot.Response)
+	if resp == nil {
+		return &mirrorResponse{err: fmt.Errorf("no response")}
+	}
+
+	mr := &mirrorResponse{
+		statusCode: resp.StatusCode(),
+		header:     resp.HTTPHeader().Clone(),
+	}
+	if !resp.IsStream() && resp.PayloadSize() <= m.maxBodySize() {
+		mr.body = resp.RawPayload()
+	}
+	return mr
+}
+
+// handleMirror sends the request to the mirror pool, if primary is not
+// nil, it waits for the snapshot of the primary response from primary and
+// compares the two responses.
+func (sp *ServerPool) handleMirror(ctx *context.Context, primary <-chan *mirrorResponse) {
+	spCtx := &serverPoolContext{
+		Context: ctx,
+		req:     ctx.GetInputRequest().(*httpprot.Request),
+	}
+
+	mr := sp.sendMirror(spCtx)
+	if primary != nil {
+		sp.mirror.compare(spCtx.req, <-primary, mr)
+	}
+}
+
+func (sp *ServerPool) sendMirror(spCtx *serverPoolContext) *mirrorResponse {
+	lb := sp.LoadBalancer()
+	svr := lb.ChooseServer(spCtx.req)
+	if svr == nil {
+		return &mirrorResponse{err: fmt.Errorf("no available server")}
+	}
+
+	err := spCtx.prepareRequest(sp, svr, spCtx.req.Context(), true)
+	if err != nil {
+		logger.Errorf("%s: failed to prepare request: %v", sp.Name, err)
+		lb.ReturnServer(svr, spCtx.req, nil)
+		return &mirrorResponse{err: err}
+	}
+
+	resp, err := fnSendRequest(spCtx.stdReq, sp.httpClient())
+	if err != nil {
+		lb.ReturnServer(svr, spCtx.req, nil)
+		return &mirrorResponse{err: err}
+	}
+
+	mr := &mirrorResponse{
+		statusCode: resp.StatusCode,
+		header:     resp.Header,
+	}
+	if sp.mirror.diffEnabled() {
+		limit := sp.mirror.maxBodySize()
+		body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+		if err == nil && int64(len(body)) <= limit {
+			mr.body = body
+		}
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if r, err := httpprot.NewResponse(resp); err == nil {
+		lb.ReturnServer(svr, spCtx.req, r)
+	} else {
+		lb.ReturnServer(svr, spCtx.req, nil)
+	}
+	return mr
+}
+
+// diff returns the mismatches of the primary response and the mirror
+// response, an error of the mirror is also a mismatch.
+func (m *mirror) diff(primary, mirror *mirrorResponse) []*MirrorDiffMismatch {
+	if mirror.err != nil {
+		return []*MirrorDiffMismatch{{
+			Field:  mirrorFieldError,
+			Mirror: mirror.err.Error(),
+		}}
+	}
+
+	var mismatches []*MirrorDiffMismatch
+	if primary.statusCode != mirror.statusCode {
+		mismatches = append(mismatches, &MirrorDiffMismatch{
+			Field:   mirrorFieldStatus,
+			Primary: fmt.Sprint(primary.statusCode),
+			Mirror:  fmt.Sprint(mirror.statusCode),
+		})
+	}
+
+	for _, h := range m.spec.Diff.Headers {
+		pv, mv := primary.header.Get(h), mirror.header.Get(h)
+		if pv != mv {
+			mismatches = append(mismatches, &MirrorDiffMismatch{
+				Field:   mirrorFieldHeader,
+				Name:    h,
+				Primary: pv,
+				Mirror:  mv,
+			})
+		}
+	}
+
+	// bodies are compared only when both of them are available.
+	if primary.body == nil || mirror.body == nil {
+		return mismatches
+	}
+	for _, path := range m.spec.Diff.JSONPaths {
+		pv, mv := gjson.GetBytes(primary.body, path).Raw, gjson.GetBytes(mirror.body, path).Raw
+		if pv != mv {
+			mismatches = append(mismatches, &MirrorDiffMismatch{
+				Field:   mirrorFieldBody,
+				Name:    path,
+				Primary: pv,
+				Mirror:  mv,
+			})
+		}
+	}
+
+	return mismatches
+}
+
+// compare compares the primary response and the mirror response, and
+// records the result. Nothing is compared if the primary failed.
+func (m *mirror) compare(req *httpprot.Request, primary, mirror *mirrorResponse) {
+	if primary.err != nil {
+		return
+	}
+
+	mismatches := m.diff(primary, mirror)
+	m.sp.exportMirrorMetrics(mismatches)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.compared++
+	if len(mismatches) == 0 {
+		return
+	}
+
+	m.mismatched++
+	fields := map[string]struct{}{}
+	for _, mm := range mismatches {
+		fields[mm.Field] = struct{}{}
+	}
+	for f := range fields {
+		m.fields[f]++
+	}
+
+	maxSamples := m.spec.Diff.MaxSamples
+	if maxSamples == 0 {
+		maxSamples = defaultMirrorMaxSamples
+	}
+	sample := &MirrorDiffSample{
+		Time:       time.Now().Format(time.RFC3339),
+		Method:     req.Method(),
+		URL:        req.Std().URL.String(),
+		Mismatches: mismatches,
+	}
+	m.samples = append(m.samples, sample)
+	if len(m.samples) > maxSamples {
+		m.samples = m.samples[len(m.samples)-maxSamples:]
+	}
+}
+
+func (m *mirror) status() *MirrorDiffStatus {
+	if !m.diffEnabled() {
+		return nil
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	s := &MirrorDiffStatus{
+		Compared:   m.compared,
+		Mismatched: m.mismatched,
+		Fields:     make(map[string]uint64, len(m.fields)),
+		Samples:    append([]*MirrorDiffSample(nil), m.samples...),
+	}
+	for k, v := range m.fields {
+		s.Fields[k] = v
+	}
+	return s
+}
@@ -0,0 +1,235 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpproxy
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/resilience"
+	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMirrorSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &MirrorSpec{}
+	assert.NoError(spec.Validate())
+	percentage := 101.0
+	spec.SamplingPercentage = &percentage
+	assert.Error(spec.Validate())
+	percentage = 0
+	assert.NoError(spec.Validate())
+	percentage = 10
+	spec.MaxBodySize = -1
+	assert.Error(spec.Validate())
+	spec.MaxBodySize = 1024
+	spec.Diff = &MirrorDiffSpec{MaxSamples: -1}
+	assert.Error(spec.Validate())
+	spec.Diff.MaxSamples = 1
+	assert.NoError(spec.Validate())
+
+	// mirror is only allowed in mirror pool
+	yamlConfig := `
+name: proxy
+kind: Proxy
+pools:
+- servers:
+  - url: http://127.0.0.1:9095
+  mirror:
+    samplingPercentage: 10
+`
+	proxySpec := &Spec{}
+	assert.NoError(codectool.Unmarshal([]byte(yamlConfig), proxySpec))
+	assert.Error(proxySpec.Validate())
+
+	yamlConfig = `
+name: proxy
+kind: Proxy
+pools:
+- servers:
+  - url: http://127.0.0.1:9095
+mirrorPool:
+  filter:
+    headers:
+      "X-Mirror":
+        exact: mirror
+  servers:
+  - url: http://127.0.0.3:9095
+  mirror:
+    samplingPercentage: 200
+`
+	proxySpec = &Spec{}
+	assert.NoError(codectool.Unmarshal([]byte(yamlConfig), proxySpec))
+	assert.Error(proxySpec.Validate())
+}
+
+func TestMirrorShouldMirror(t *testing.T) {
+	assert := assert.New(t)
+
+	var m *mirror
+	stdr, _ := http.NewRequest(http.MethodPost, "https://www.megaease.com", strings.NewReader("0123456789"))
+	req, _ := httpprot.NewRequest(stdr)
+	req.FetchPayload(0)
+	assert.True(m.shouldMirror(req))
+
+	m = newMirror(nil, &MirrorSpec{MaxBodySize: 5})
+	assert.False(m.shouldMirror(req))
+	m.spec.MaxBodySize = 10
+	assert.True(m.shouldMirror(req))
+
+	percentage := 30.0
+	m.spec.SamplingPercentage = &percentage
+	count := 0
+	for i := 0; i < 10000; i++ {
+		if m.shouldMirror(req) {
+			count++
+		}
+	}
+	assert.InDelta(3000, count, 300)
+
+	// zero disables mirroring
+	percentage = 0
+	for i := 0; i < 100; i++ {
+		assert.False(m.shouldMirror(req))
+	}
+}
+
+func TestProxyMirrorDiff(t *testing.T) {
+	assert := assert.New(t)
+
+	const yamlConfig = `
+name: proxy
+kind: Proxy
+pools:
+- servers:
+  - url: http://127.0.0.1:9095
+mirrorPool:
+  filter:
+    headers:
+      "X-Mirror":
+        exact: mirror
+  servers:
+  - url: http://127.0.0.3:9095
+  mirror:
+    diff:
+      headers: [X-Version]
+      jsonPaths: [name, items.#]
+      maxSamples: 1
+`
+	proxy := newTestProxy(yamlConfig, assert)
+	proxy.InjectResiliencePolicy(make(map[string]resilience.Policy))
+
+	old := fnSendRequest
+	defer func() { fnSendRequest = old }()
+	// close waits for the mirror goroutines before the function is restored.
+	defer proxy.Close()
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		body := `{"name": "easegress", "items": [1, 2, 3]}`
+		if r.URL.Host == "127.0.0.3:9095" && r.URL.Path == "/mismatch" {
+			body = `{"name": "easegress", "items": [1, 2]}`
+		}
+		if r.URL.Host == "127.0.0.1:9095" && r.URL.Path == "/panic" {
+			panic("primary pool panics")
+		}
+		return &http.Response{
+			StatusCode:    http.StatusOK,
+			Header:        http.Header{"X-Version": []string{"v1"}},
+			ContentLength: int64(len(body)),
+			Body:          io.NopCloser(strings.NewReader(body)),
+		}, nil
+	}
+
+	for _, path := range []string{"/match", "/mismatch"} {
+		stdr, _ := http.NewRequest(http.MethodGet, "https://www.megaease.com"+path, nil)
+		stdr.Header.Set("X-Mirror", "mirror")
+		ctx := getCtx(stdr)
+		assert.Equal("", proxy.Handle(ctx))
+	}
+
+	var s *MirrorDiffStatus
+	assert.Eventually(func() bool {
+		s = proxy.mirrorPool.status().MirrorDiff
+		return s.Compared == 2
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Equal(uint64(1), s.Mismatched)
+	assert.Equal(uint64(1), s.Fields[mirrorFieldBody])
+	assert.Len(s.Samples, 1)
+	assert.Equal("https://www.megaease.com/mismatch", s.Samples[0].URL)
+	assert.Equal([]*MirrorDiffMismatch{{
+		Field:   mirrorFieldBody,
+		Name:    "items.#",
+		Primary: "3",
+		Mirror:  "2",
+	}}, s.Samples[0].Mismatches)
+
+	// the mirror goroutine still completes when the primary pool panics.
+	stdr, _ := http.NewRequest(http.MethodGet, "https://www.megaease.com/panic", nil)
+	stdr.Header.Set("X-Mirror", "mirror")
+	assert.Panics(func() { proxy.Handle(getCtx(stdr)) })
+	done := make(chan struct{})
+	go func() {
+		proxy.mirrorWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		assert.Fail("mirror goroutine is blocked")
+	}
+}
+
+func TestMirrorDiff(t *testing.T) {
+	assert := assert.New(t)
+
+	m := newMirror(nil, &MirrorSpec{Diff: &MirrorDiffSpec{
+		Headers:   []string{"X-Version"},
+		JSONPaths: []string{"name"},
+	}})
+
+	primary := &mirrorResponse{
+		statusCode: 200,
+		header:     http.Header{"X-Version": []string{"v1"}},
+		body:       []byte(`{"name": "a"}`),
+	}
+	mirror := &mirrorResponse{
+		statusCode: 500,
+		header:     http.Header{"X-Version": []string{"v2"}},
+		body:       []byte(`{"name": "b"}`),
+	}
+	mismatches := m.diff(primary, mirror)
+	assert.Len(mismatches, 3)
+	assert.Equal(mirrorFieldStatus, mismatches[0].Field)
+	assert.Equal(mirrorFieldHeader, mismatches[1].Field)
+	assert.Equal(mirrorFieldBody, mismatches[2].Field)
+
+	// bodies are not compared if one of them is not available.
+	mirror.body = nil
+	assert.Len(m.diff(primary, mirror), 2)
+
+	mirror = &mirrorResponse{err: io.EOF}
+	mismatches = m.diff(primary, mirror)
+	assert.Len(mismatches, 1)
+	assert.Equal(mirrorFieldError, mismatches[0].Field)
+}
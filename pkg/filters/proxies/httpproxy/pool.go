@@ -22,8 +22,11 @@ import (
 	stdcontext "context"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	gohttpstat "github.com/tcnksm/go-httpstat"
@@ -31,6 +34,7 @@ import (
 	"github.com/megaease/easegress/v2/pkg/context"
 	"github.com/megaease/easegress/v2/pkg/filters/proxies"
 	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols/grpcprot"
 	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
 	"github.com/megaease/easegress/v2/pkg/protocols/httpprot/httpstat"
 	"github.com/megaease/easegress/v2/pkg/resilience"
@@ -38,6 +42,7 @@ import (
 	"github.com/megaease/easegress/v2/pkg/util/fasttime"
 	"github.com/megaease/easegress/v2/pkg/util/httphelper"
 	"github.com/megaease/easegress/v2/pkg/util/prometheushelper"
+	"github.com/megaease/easegress/v2/pkg/util/proxyprotocol"
 	"github.com/megaease/easegress/v2/pkg/util/readers"
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -89,6 +94,61 @@ type serverPoolContext struct {
 	stdResp *http.Response
 
 	respCallbackBody *readers.CallbackReader
+
+	// cancel cancels the request to the server when streaming is
+	// enabled, as the request outlives the handler function if the
+	// response is a stream. headerTimer cancels the request if the
+	// response header is not received before timeout.
+	cancel      stdcontext.CancelCauseFunc
+	headerTimer *time.Timer
+	streaming   bool
+
+	// cacheLookup is the result of looking up the HTTP cache, and
+	// conditional is true if the validators of the stored response are
+	// added to the request to revalidate it.
+	cacheLookup *httpCacheLookup
+	conditional bool
+
+	// parent is the context of the request if this is the context of an
+	// attempt, an attempt buffers its tags, and its result is committed
+	// to the parent by commitAttempt, so that attempts of a hedged request
+	// can run concurrently.
+	parent    *serverPoolContext
+	tags      []func() string
+	lock      sync.Mutex
+	succeeded bool
+
+	// outputHeader is the header of the output response before any
+	// attempt is committed, every committed attempt merges its header
+	// into a copy of it, so that the header of a failed attempt doesn't
+	// leak into the final response.
+	outputHeader http.Header
+}
+
+// newAttempt creates a context for an attempt of the request.
+func (spCtx *serverPoolContext) newAttempt() *serverPoolContext {
+	return &serverPoolContext{
+		Context:     spCtx.Context,
+		startTime:   spCtx.startTime,
+		req:         spCtx.req,
+		cacheLookup: spCtx.cacheLookup,
+		parent:      spCtx,
+	}
+}
+
+// AddTag adds a tag to the context, tags of an attempt are buffered.
+func (spCtx *serverPoolContext) AddTag(tag string) {
+	spCtx.LazyAddTag(func() string { return tag })
+}
+
+// LazyAddTag adds a tag to the context in a lazy fashion, tags of an
+// attempt are buffered.
+func (spCtx *serverPoolContext) LazyAddTag(lazyTagFunc func() string) {
+	if spCtx.parent == nil {
+		spCtx.Context.LazyAddTag(lazyTagFunc)
+		return
+	}
+	spCtx.tags = append(spCtx.tags, lazyTagFunc)
 }
 
 func (spCtx *serverPoolContext) prepareRequest(pool *ServerPool, svr *Server, ctx stdcontext.Context, mirror bool) error {
@@ -116,6 +176,10 @@ func (spCtx *serverPoolContext) prepareRequest(pool *ServerPool, svr *Server, ct
 		payload = bytes.NewReader(data)
 	}
 
+	if pool.spec.ProxyProtocol != "" {
+		ctx = proxyProtocolContext(ctx, req)
+	}
+
 	stdr, err := http.NewRequestWithContext(ctx, req.Method(), url, payload)
 	if err != nil {
 		return err
@@ -124,6 +188,11 @@ func (spCtx *serverPoolContext) prepareRequest(pool *ServerPool, svr *Server, ct
 
 	stdr.Header = req.HTTPHeader().Clone()
 	httphelper.RemoveHopByHopHeaders(stdr.Header)
+	setGRPCTimeout(ctx, stdr.Header)
+
+	if l := spCtx.cacheLookup; l != nil && !mirror && req.Method() == http.MethodGet {
+		spCtx.conditional = l.addConditionals(stdr.Header)
+	}
 
 	// only set host when server address is not host name OR
 	// server is explicitly told to keep the host of the request.
@@ -155,14 +224,25 @@ type ServerPool struct {
 	spec         *ServerPoolSpec
 	failureCodes map[int]struct{}
 
-	timeout               time.Duration
-	retryWrapper          resilience.Wrapper
-	circuitBreakerWrapper resilience.Wrapper
+	timeout                    time.Duration
+	retryWrapper               resilience.Wrapper
+	circuitBreakerWrapper      resilience.Wrapper
+	adaptiveConcurrencyWrapper *resilience.AdaptiveConcurrencyWrapper
+	bulkheadWrapper            *resilience.BulkheadWrapper
 
 	httpStat      *httpstat.HTTPStat
 	memoryCache   *MemoryCache
+	httpCache     *HTTPCache
+	mirror        *mirror
+	streaming     *streaming
+	coalescer     *coalescer
 	metrics       *metrics
 	healthChecker proxies.HealthChecker
+
+	// client is used instead of the client of the proxy if the pool
+	// needs a dedicated one, like when PROXY protocol or HTTP/2 is
+	// enabled.
+	client *http.Client
 }
 
 // ServerPoolSpec is the spec for a server pool.
@@ -177,6 +257,35 @@ type ServerPoolSpec struct {
 	CircuitBreakerPolicy string                `json:"circuitBreakerPolicy,omitempty"`
 	MemoryCache          *MemoryCacheSpec      `json:"memoryCache,omitempty"`
 	HealthCheck          *ProxyHealthCheckSpec `json:"healthCheck,omitempty"`
+	Mirror               *MirrorSpec           `json:"mirror,omitempty"`
+
+	// HTTPCache is a shared HTTP cache following RFC 9111, it can't be
+	// used together with MemoryCache.
+	HTTPCache *HTTPCacheSpec `json:"httpCache,omitempty"`
+
+	// ProxyProtocol is the version of PROXY protocol header sent to
+	// servers, empty means PROXY protocol is disabled.
+	ProxyProtocol string `json:"proxyProtocol,omitempty" jsonschema:"enum=,enum=v1,enum=v2"`
+
+	// HTTP2 forces HTTP/2 or h2c to servers, the protocol is negotiated
+	// by the client if it is empty.
+	HTTP2 *HTTP2Spec `json:"http2,omitempty"`
+
+	// HTTP3 sends requests to servers with HTTP/3, and falls back to
+	// TCP when QUIC fails unless the fallback is disabled.
+	HTTP3 *HTTP3Spec `json:"http3,omitempty"`
+
+	// Streaming enables streaming responses, which are exempted from
+	// buffering, memoryCache and compression, and timeout only applies
+	// to the response header of them.
+	Streaming *StreamingSpec `json:"streaming,omitempty"`
+
+	// Coalescing collapses concurrent identical requests into one
+	// request to the server.
+	Coalescing *CoalescingSpec `json:"coalescing,omitempty"`
+
+	AdaptiveConcurrencyPolicy string `json:"adaptiveConcurrencyPolicy,omitempty"`
+	BulkheadPolicy            string `json:"bulkheadPolicy,omitempty"`
 
 	// FailureCodes would be 5xx if it isn't assigned any value.
 	FailureCodes []int `json:"failureCodes,omitempty" jsonschema:"uniqueItems=true"`
@@ -186,18 +295,67 @@ func (spec *ServerPoolSpec) Validate() error {
 	if err := spec.BaseServerPoolSpec.Validate(); err != nil {
 		return err
 	}
+	if err := proxyprotocol.ValidateVersion(spec.ProxyProtocol); err != nil {
+		return err
+	}
+	if spec.HTTP2 != nil {
+		if spec.ProxyProtocol != "" {
+			return fmt.Errorf("proxyProtocol and http2 can't be set at the same time")
+		}
+		if err := spec.HTTP2.Validate(spec.Servers); err != nil {
+			return err
+		}
+	}
+	if spec.HTTP3 != nil {
+		if spec.ProxyProtocol != "" || spec.HTTP2 != nil {
+			return fmt.Errorf("http3 can't be set together with proxyProtocol or http2")
+		}
+		if err := spec.HTTP3.Validate(spec.Servers); err != nil {
+			return err
+		}
+	}
 	if spec.ServiceName != "" && spec.HealthCheck != nil {
 		return fmt.Errorf("serviceName and healthCheck can't be set at the same time")
 	}
 	if spec.HealthCheck != nil {
-		return spec.HealthCheck.Validate()
+		if err := spec.HealthCheck.Validate(); err != nil {
+			return err
+		}
+	}
+	if spec.Streaming != nil {
+		if err := spec.Streaming.Validate(); err != nil {
+			return err
+		}
+	}
+	if spec.Coalescing != nil {
+		if err := spec.Coalescing.Validate(); err != nil {
+			return err
+		}
+	}
+	if spec.HTTPCache != nil {
+		if spec.MemoryCache != nil {
+			return fmt.Errorf("memoryCache and httpCache can't be set at the same time")
+		}
+		if err := spec.HTTPCache.Validate(); err != nil {
+			return err
+		}
+	}
+	if spec.Mirror != nil {
+		return spec.Mirror.Validate()
 	}
 	return nil
 }
 
 // ServerPoolStatus is the status of Pool.
 type ServerPoolStatus struct {
-	Stat *httpstat.Status `json:"stat"`
+	Stat             *httpstat.Status                `json:"stat"`
+	OutlierDetection *proxies.OutlierDetectionStatus `json:"outlierDetection,omitempty"`
+
+	AdaptiveConcurrency *resilience.AdaptiveConcurrencyStatus `json:"adaptiveConcurrency,omitempty"`
+	Bulkhead            *resilience.BulkheadStatus            `json:"bulkhead,omitempty"`
+	MirrorDiff          *MirrorDiffStatus                     `json:"mirrorDiff,omitempty"`
+	Coalescing          *CoalescingStatus                     `json:"coalescing,omitempty"`
+	WebSocket           *WebSocketStatus                      `json:"webSocket,omitempty"`
 }
 
 // NewServerPool creates a new server pool according to spec.
@@ -219,12 +377,32 @@ func NewServerPool(proxy *Proxy, spec *ServerPoolSpec, name string) *ServerPool
 		sp.filter = NewRequestMatcher(spec.Filter)
 	}
 
+	// metrics must be ready before the load balancer is created, as the
+	// outlier detection reports metrics through it.
+	sp.metrics = sp.newMetrics(name)
+
 	sp.BaseServerPool.Init(sp, proxy.super, name, &spec.BaseServerPoolSpec)
 
 	if spec.MemoryCache != nil {
 		sp.memoryCache = NewMemoryCache(spec.MemoryCache)
 	}
 
+	if spec.HTTPCache != nil {
+		sp.httpCache = NewHTTPCache(spec.HTTPCache)
+	}
+
+	if spec.Mirror != nil {
+		sp.mirror = newMirror(sp, spec.Mirror)
+	}
+
+	if spec.Streaming != nil {
+		sp.streaming = newStreaming(spec.Streaming)
+	}
+
+	if spec.Coalescing != nil {
+		sp.coalescer = newCoalescer(spec.Coalescing, proxy.spec.Compression != nil)
+	}
+
 	if spec.Timeout != "" {
 		sp.timeout, _ = time.ParseDuration(spec.Timeout)
 	}
@@ -234,19 +412,90 @@ func NewServerPool(proxy *Proxy, spec *ServerPoolSpec, name string) *ServerPool
 		sp.failureCodes[code] = struct{}{}
 	}
 
-	sp.metrics = sp.newMetrics(name)
+	if spec.ProxyProtocol != "" || spec.HTTP2 != nil || spec.HTTP3 != nil {
+		clientSpec := &HTTPClientSpec{
+			MaxRedirection: &proxy.spec.MaxRedirection,
+			ProxyProtocol:  spec.ProxyProtocol,
+			HTTP2:          spec.HTTP2,
+			HTTP3:          spec.HTTP3,
+		}
+		sp.client = HTTPClient(tlsConfig, clientSpec, 0)
+	}
+
 	return sp
 }
 
+// Close closes the server pool, its HTTP cache and the connections of
+// its dedicated client.
+func (sp *ServerPool) Close() {
+	sp.BaseServerPool.Close()
+	if sp.httpCache != nil {
+		sp.httpCache.Close()
+	}
+	if sp.client != nil {
+		sp.client.CloseIdleConnections()
+	}
+}
+
+// httpClient returns the HTTP client to send requests to servers.
+func (sp *ServerPool) httpClient() *http.Client {
+	if sp.client != nil {
+		return sp.client
+	}
+	return sp.proxy.client
+}
+
+// proxyProtocolContext returns a context which carries the addresses of
+// the client connection for the PROXY protocol header.
+func proxyProtocolContext(ctx stdcontext.Context, req *httpprot.Request) stdcontext.Context {
+	var src net.Addr
+	if addr, err := net.ResolveTCPAddr("tcp", req.Std().RemoteAddr); err == nil {
+		src = addr
+	}
+	dst, _ := req.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return proxyprotocol.NewContext(ctx, src, dst)
+}
+
+// setGRPCTimeout sets the grpc-timeout header to the time left before the
+// deadline of ctx, if the request is a gRPC one or already has the header,
+// so that the server is aware of the deadline.
+func setGRPCTimeout(ctx stdcontext.Context, header http.Header) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return
+	}
+	if header.Get(grpcprot.TimeoutHeader) == "" && !strings.HasPrefix(header.Get("Content-Type"), "application/grpc") {
+		return
+	}
+	header.Set(grpcprot.TimeoutHeader, grpcprot.FormatTimeout(time.Until(deadline)))
+}
+
 // CreateLoadBalancer creates a load balancer according to spec.
 func (sp *ServerPool) CreateLoadBalancer(spec *LoadBalanceSpec, servers []*Server) LoadBalancer {
 	lb := proxies.NewGeneralLoadBalancer(spec, servers)
+	lb.SetLocalLabels(sp.LocalLabels())
+	lb.SetOutlierListener(sp.exportOutlierMetrics)
 	lb.Init(proxies.NewHTTPSessionSticker, sp.healthChecker, nil)
 	return lb
 }
 
 func (sp *ServerPool) status() *ServerPoolStatus {
-	s := &ServerPoolStatus{Stat: sp.httpStat.Status()}
+	s := &ServerPoolStatus{
+		Stat:             sp.httpStat.Status(),
+		OutlierDetection: sp.OutlierDetectionStatus(),
+	}
+	if sp.adaptiveConcurrencyWrapper != nil {
+		s.AdaptiveConcurrency = sp.adaptiveConcurrencyWrapper.Status()
+	}
+	if sp.bulkheadWrapper != nil {
+		s.Bulkhead = sp.bulkheadWrapper.Status()
+	}
+	if sp.mirror != nil {
+		s.MirrorDiff = sp.mirror.status()
+	}
+	if sp.coalescer != nil {
+		s.Coalescing = sp.coalescer.status()
+	}
 	return s
 }
 
@@ -277,6 +526,32 @@ func (sp *ServerPool) InjectResiliencePolicy(policies map[string]resilience.Poli
 		}
 		sp.circuitBreakerWrapper = policy.CreateWrapper()
 	}
+
+	name = sp.spec.AdaptiveConcurrencyPolicy
+	if name != "" {
+		p := policies[name]
+		if p == nil {
+			panic(fmt.Errorf("adaptiveConcurrency policy %s not found", name))
+		}
+		policy, ok := p.(*resilience.AdaptiveConcurrencyPolicy)
+		if !ok {
+			panic(fmt.Errorf("policy %s is not an adaptiveConcurrency policy", name))
+		}
+		sp.adaptiveConcurrencyWrapper = policy.CreateWrapper().(*resilience.AdaptiveConcurrencyWrapper)
+	}
+
+	name = sp.spec.BulkheadPolicy
+	if name != "" {
+		p := policies[name]
+		if p == nil {
+			panic(fmt.Errorf("bulkhead policy %s not found", name))
+		}
+		policy, ok := p.(*resilience.BulkheadPolicy)
+		if !ok {
+			panic(fmt.Errorf("policy %s is not a bulkhead policy", name))
+		}
+		sp.bulkheadWrapper = policy.CreateWrapper().(*resilience.BulkheadWrapper)
+	}
 }
 
 func (sp *ServerPool) collectMetrics(spCtx *serverPoolContext) {
@@ -320,38 +595,12 @@ func (sp *ServerPool) collectMetrics(spCtx *serverPoolContext) {
 	})
 }
 
-func (sp *ServerPool) handleMirror(spCtx *serverPoolContext) {
-	svr := sp.LoadBalancer().ChooseServer(spCtx.req)
-	if svr == nil {
-		return
-	}
-
-	err := spCtx.prepareRequest(sp, svr, spCtx.req.Context(), true)
-	if err != nil {
-		logger.Errorf("%s: failed to prepare request: %v", sp.Name, err)
-		return
-	}
-
-	resp, err := fnSendRequest(spCtx.stdReq, sp.proxy.client)
-	if err != nil {
-		return
-	}
-
-	io.Copy(io.Discard, resp.Body)
-	resp.Body.Close()
-}
-
-func (sp *ServerPool) handle(ctx *context.Context, mirror bool) string {
+func (sp *ServerPool) handle(ctx *context.Context) string {
 	spCtx := &serverPoolContext{
 		Context: ctx,
 		req:     ctx.GetInputRequest().(*httpprot.Request),
 	}
 
-	if mirror {
-		sp.handleMirror(spCtx)
-		return ""
-	}
-
 	spCtx.startTime = fasttime.Now()
 	defer sp.collectMetrics(spCtx)
 
@@ -362,30 +611,66 @@ func (sp *ServerPool) handle(ctx *context.Context, mirror bool) string {
 		return ""
 	}
 
+	if sp.httpCache != nil {
+		spCtx.cacheLookup = sp.httpCache.lookup(spCtx.req, fasttime.Now())
+		if sp.buildResponseFromHTTPCache(spCtx) {
+			if sp.inFailureCodes(spCtx.resp.StatusCode()) {
+				return resultFailureCode
+			}
+			return ""
+		}
+	}
+
+	if sp.coalescer != nil {
+		return sp.handleCoalesced(spCtx)
+	}
+	return sp.handleRequest(spCtx)
+}
+
+// handleRequest sends the request to a server with resilience policies,
+// and builds the response.
+func (sp *ServerPool) handleRequest(spCtx *serverPoolContext) string {
+	ctx := spCtx.Context
+
 	// wrap the handler function to meet the requirement of resilience
 	// wrappers.
 	handler := func(stdctx stdcontext.Context) error {
-		if sp.timeout > 0 {
+		// this function could be called more than once, even
+		// concurrently when the request is hedged, so every call
+		// works on its own context and commits the result at last.
+		attempt := spCtx.newAttempt()
+
+		if sp.streaming != nil {
+			var cancel stdcontext.CancelCauseFunc
+			stdctx, cancel = stdcontext.WithCancelCause(stdctx)
+			attempt.cancel = cancel
+			if sp.timeout > 0 {
+				attempt.headerTimer = time.AfterFunc(sp.timeout, func() {
+					cancel(stdcontext.DeadlineExceeded)
+				})
+			}
+			// the request is canceled when the body is closed if the
+			// response is a stream.
+			defer func() {
+				if attempt.resp == nil || !attempt.resp.IsStream() {
+					cancel(nil)
+				}
+			}()
+		} else if sp.timeout > 0 {
 			var cancel stdcontext.CancelFunc
 			stdctx, cancel = stdcontext.WithTimeout(stdctx, sp.timeout)
 			defer cancel()
 		}
 
-		// this function could be called more than once, and these
-		// fields need to be reset before each call.
-		spCtx.stdReq = nil
-		spCtx.resp = nil
-		spCtx.stdResp = nil
-		spCtx.respCallbackBody = nil
-
 		spanName := sp.spec.SpanName
 		if spanName == "" {
 			spanName = sp.Name
 		}
-		spCtx.span = ctx.Span().NewChild(spanName)
-		defer spCtx.span.End()
+		attempt.span = ctx.Span().NewChild(spanName)
+		defer attempt.span.End()
 
-		return sp.doHandle(stdctx, spCtx)
+		err := sp.doHandle(stdctx, attempt)
+		return sp.commitAttempt(spCtx, attempt, err)
 	}
 
 	// resilience wrappers, note that it is impossible to retry a stream
@@ -396,15 +681,50 @@ func (sp *ServerPool) handle(ctx *context.Context, mirror bool) string {
 	if sp.circuitBreakerWrapper != nil {
 		handler = sp.circuitBreakerWrapper.Wrap(handler)
 	}
+	if sp.adaptiveConcurrencyWrapper != nil {
+		handler = sp.adaptiveConcurrencyWrapper.Wrap(handler)
+	}
+	if sp.bulkheadWrapper != nil {
+		handler = sp.bulkheadWrapper.Wrap(handler)
+	}
 
-	// call the handler.
-	err := handler(spCtx.req.Context())
+	// call the handler, the upstream request is bounded by the deadline
+	// of the pipeline.
+	info := &resilience.RequestInfo{
+		Method:    spCtx.req.Method(),
+		Hedgeable: true,
+		OnRelease: ctx.OnFinish,
+	}
+	err := handler(resilience.WithRequestInfo(ctx.WithDeadline(spCtx.req.Context()), info))
 	if err == nil {
+		if spCtx.streaming {
+			spCtx.SetData(FlushIntervalDataKey, sp.streaming.flushInterval)
+		}
 		return ""
 	}
 
-	// CircuitBreaker is the most outside resiliencer, if the error
-	// is ErrShortCircuited, we are sure the response is nil.
+	// serve the stale response if it is allowed when the server fails.
+	if l := spCtx.cacheLookup; l != nil && l.staleIfError {
+		if spCtx.resp != nil && spCtx.resp.IsStream() {
+			spCtx.respCallbackBody.Close()
+		}
+		spCtx.AddTag("cache stale-if-error")
+		sp.buildResponseFromCacheEntry(spCtx, l.entry, l.age, false)
+		return ""
+	}
+
+	// Bulkhead and AdaptiveConcurrency are the most outside
+	// resiliencers, if the request is rejected, we are sure the
+	// response is nil.
+	if re, ok := err.(*resilience.RejectedError); ok {
+		logger.Debugf("%s: rejected by policy %s", sp.Name, re.Policy)
+		spCtx.AddTag("rejected")
+		sp.buildFailureResponse(spCtx, re.StatusCode)
+		return resultRejected
+	}
+
+	// CircuitBreaker is outside of retry, if the error is
+	// ErrShortCircuited, we are sure the response is nil.
 	if err == resilience.ErrShortCircuited {
 		logger.Errorf("%s: short circuited by circuit break policy", sp.Name)
 		spCtx.AddTag("short circuited")
@@ -426,7 +746,8 @@ func (sp *ServerPool) handle(ctx *context.Context, mirror bool) string {
 }
 
 func (sp *ServerPool) doHandle(stdctx stdcontext.Context, spCtx *serverPoolContext) error {
-	svr := sp.LoadBalancer().ChooseServer(spCtx.req)
+	lb := sp.LoadBalancer()
+	svr := lb.ChooseServer(spCtx.req)
 
 	// if there's no available server.
 	if svr == nil {
@@ -436,24 +757,37 @@ func (sp *ServerPool) doHandle(stdctx stdcontext.Context, spCtx *serverPoolConte
 
 	// prepare the request to send.
 	statResult := &gohttpstat.Result{}
-	stdctx = gohttpstat.WithHTTPStat(stdctx, statResult)
+	// NOTE: the trace hooks of HTTP/2 are called from different
+	// goroutines concurrently, which is not supported by gohttpstat,
+	// and HTTP/3 doesn't call them at all.
+	if sp.spec.HTTP2 == nil && sp.spec.HTTP3 == nil {
+		stdctx = gohttpstat.WithHTTPStat(stdctx, statResult)
+	}
 	if err := spCtx.prepareRequest(sp, svr, stdctx, false); err != nil {
 		logger.Errorf("%s: failed to prepare request: %v", sp.Name, err)
+		lb.ReturnServer(svr, spCtx.req, nil)
 		return serverPoolError{http.StatusInternalServerError, resultInternalError}
 	}
 
-	resp, err := fnSendRequest(spCtx.stdReq, sp.proxy.client)
+	resp, err := fnSendRequest(spCtx.stdReq, sp.httpClient())
+	if spCtx.headerTimer != nil {
+		spCtx.headerTimer.Stop()
+	}
 	if err != nil {
 		logger.Errorf("%s: failed to send request: %v", sp.Name, err)
+		lb.ReturnServer(svr, spCtx.req, nil)
 
 		statResult.End(fasttime.Now())
 		spCtx.LazyAddTag(func() string {
 			return fmt.Sprintf("trace %v", statResult)
 		})
 
-		if err := spCtx.stdReq.Context().Err(); err == nil {
+		if err := stdcontext.Cause(spCtx.stdReq.Context()); err == nil {
 			return serverPoolError{http.StatusServiceUnavailable, resultServerError}
 		} else if err == stdcontext.DeadlineExceeded {
+			if spCtx.DeadlineExceeded() {
+				return serverPoolError{http.StatusGatewayTimeout, resultTimeout}
+			}
 			return serverPoolError{http.StatusRequestTimeout, resultTimeout}
 		}
 
@@ -464,10 +798,11 @@ func (sp *ServerPool) doHandle(stdctx stdcontext.Context, spCtx *serverPoolConte
 
 	spCtx.stdResp = resp
 	if err = sp.buildResponse(spCtx); err != nil {
+		lb.ReturnServer(svr, spCtx.req, nil)
 		return serverPoolError{http.StatusInternalServerError, resultInternalError}
 	}
 
-	sp.LoadBalancer().ReturnServer(svr, spCtx.req, spCtx.resp)
+	lb.ReturnServer(svr, spCtx.req, spCtx.resp)
 
 	spCtx.LazyAddTag(func() string {
 		return fmt.Sprintf("status code: %d", resp.StatusCode)
@@ -504,7 +839,12 @@ func (sp *ServerPool) buildResponse(spCtx *serverPoolContext) (err error) {
 	spCtx.stdResp.Body = body
 	spCtx.respCallbackBody = body
 
-	if sp.proxy.compression != nil {
+	streaming := isStreamingResponse(spCtx.stdResp, sp.spec.Streaming)
+	if streaming {
+		spCtx.AddTag("streaming")
+	}
+
+	if sp.proxy.compression != nil && !streaming {
 		if sp.proxy.compression.compress(spCtx.stdReq, spCtx.stdResp) {
 			spCtx.AddTag("gzip")
 		}
@@ -521,6 +861,11 @@ func (sp *ServerPool) buildResponse(spCtx *serverPoolContext) (err error) {
 	if maxBodySize == 0 {
 		maxBodySize = sp.proxy.spec.ServerMaxBodySize
 	}
+	if streaming && sp.streaming != nil {
+		maxBodySize = -1
+		sp.streaming.watch(body, spCtx.cancel)
+		spCtx.streaming = true
+	}
 	if err = resp.FetchPayload(maxBodySize); err != nil {
 		logger.Errorf("%s: failed to fetch response payload: %v, please consider to set serverMaxBodySize of Proxy to -1.", sp.Name, err)
 		body.Close()
@@ -531,25 +876,71 @@ func (sp *ServerPool) buildResponse(spCtx *serverPoolContext) (err error) {
 		body.Close()
 	}
 
-	if sp.memoryCache != nil {
+	if sp.memoryCache != nil && !streaming {
 		sp.memoryCache.Store(spCtx.req, resp)
 	}
 
-	if r, _ := spCtx.GetOutputResponse().(*httpprot.Response); r != nil {
-		header := sp.mergeResponseHeader(r.HTTPHeader(), resp.HTTPHeader())
-		resp.Std().Header = header
-
-		// reuse the existing output response, this is to align with
-		// buildResponseFromCache and buildFailureResponse and other filters.
-		*r = *resp
-		resp = r
+	if sp.httpCache != nil && !streaming {
+		resp = sp.updateHTTPCache(spCtx, resp)
 	}
 
 	spCtx.resp = resp
-	spCtx.SetOutputResponse(resp)
 	return nil
 }
 
+// commitAttempt commits the result of an attempt to the context of the
+// request. The first successful attempt wins, attempts finished after it
+// are discarded.
+func (sp *ServerPool) commitAttempt(spCtx, attempt *serverPoolContext, err error) error {
+	spCtx.lock.Lock()
+	defer spCtx.lock.Unlock()
+
+	if spCtx.succeeded {
+		if attempt.respCallbackBody != nil {
+			attempt.respCallbackBody.Close()
+		}
+		return nil
+	}
+
+	for _, tag := range attempt.tags {
+		spCtx.LazyAddTag(tag)
+	}
+
+	// the response of a previously committed attempt is replaced, close
+	// its body if it is a stream, other bodies are already closed.
+	if spCtx.resp != nil && spCtx.resp.IsStream() && spCtx.respCallbackBody != nil {
+		spCtx.respCallbackBody.Close()
+	}
+
+	spCtx.span = attempt.span
+	spCtx.stdReq = attempt.stdReq
+	spCtx.stdResp = attempt.stdResp
+	spCtx.respCallbackBody = attempt.respCallbackBody
+	spCtx.streaming = attempt.streaming
+	spCtx.resp = nil
+
+	if resp := attempt.resp; resp != nil {
+		if r, _ := spCtx.GetOutputResponse().(*httpprot.Response); r != nil {
+			if spCtx.outputHeader == nil {
+				spCtx.outputHeader = r.HTTPHeader().Clone()
+			}
+			header := sp.mergeResponseHeader(spCtx.outputHeader.Clone(), resp.HTTPHeader())
+			resp.Std().Header = header
+
+			// reuse the existing output response, this is to align with
+			// buildResponseFromCache and buildFailureResponse and other filters.
+			*r = *resp
+			resp = r
+		}
+
+		spCtx.resp = resp
+		spCtx.SetOutputResponse(resp)
+	}
+
+	spCtx.succeeded = err == nil
+	return err
+}
+
 func (sp *ServerPool) buildResponseFromCache(spCtx *serverPoolContext) bool {
 	if sp.memoryCache == nil {
 		return false
@@ -598,6 +989,165 @@ func (sp *ServerPool) buildResponseFromCache(spCtx *serverPoolContext) bool {
 	return true
 }
 
+// buildResponseFromHTTPCache builds the response from the HTTP cache if
+// there is a fresh stored response, or a stale one which can be served
+// while it is being revalidated.
+func (sp *ServerPool) buildResponseFromHTTPCache(spCtx *serverPoolContext) bool {
+	l := spCtx.cacheLookup
+	switch {
+	case l == nil:
+		return false
+	case l.entry != nil && l.fresh:
+		spCtx.AddTag("cache hit")
+	case l.entry != nil && l.staleWhileRevalidate:
+		spCtx.AddTag("cache stale")
+		sp.revalidateInBackground(spCtx.req, l)
+	case l.reqCC.has("only-if-cached"):
+		sp.buildFailureResponse(spCtx, http.StatusGatewayTimeout)
+		return true
+	default:
+		return false
+	}
+
+	notModified := l.entry.matchConditional(l.reqHeader)
+	sp.buildResponseFromCacheEntry(spCtx, l.entry, l.age, notModified)
+	return true
+}
+
+// buildResponseFromCacheEntry builds the response from a stored response
+// of the HTTP cache.
+func (sp *ServerPool) buildResponseFromCacheEntry(spCtx *serverPoolContext, entry *httpCacheEntry, age time.Duration, notModified bool) {
+	resp, _ := spCtx.GetOutputResponse().(*httpprot.Response)
+	if resp == nil {
+		resp, _ = httpprot.NewResponse(nil)
+	}
+
+	header := resp.HTTPHeader()
+	for k, v := range entry.Header {
+		header[k] = v
+	}
+	header.Set("Age", strconv.FormatInt(int64(age/time.Second), 10))
+
+	switch {
+	case notModified:
+		header.Del("Content-Length")
+		resp.SetStatusCode(http.StatusNotModified)
+		resp.SetPayload(nil)
+	case spCtx.req.Method() == http.MethodHead:
+		resp.SetStatusCode(entry.StatusCode)
+		resp.SetPayload(nil)
+	default:
+		resp.SetStatusCode(entry.StatusCode)
+		resp.SetPayload(entry.Body)
+	}
+
+	spCtx.resp = resp
+	spCtx.SetOutputResponse(resp)
+}
+
+// updateHTTPCache updates the HTTP cache with the response of the server,
+// if the response is a 304 of a revalidation, the returned response is
+// built from the updated stored response.
+func (sp *ServerPool) updateHTTPCache(spCtx *serverPoolContext, resp *httpprot.Response) *httpprot.Response {
+	c := sp.httpCache
+	l := spCtx.cacheLookup
+	if l == nil {
+		c.invalidate(spCtx.req, resp.StatusCode())
+		return resp
+	}
+
+	now := fasttime.Now()
+	if !spCtx.conditional || resp.StatusCode() != http.StatusNotModified {
+		c.store(l, resp, spCtx.startTime, now)
+		return resp
+	}
+
+	entry := c.freshen(l, resp.HTTPHeader(), spCtx.startTime, now)
+	spCtx.AddTag("cache revalidated")
+
+	r, _ := httpprot.NewResponse(nil)
+	for k, v := range entry.Header {
+		r.HTTPHeader()[k] = v
+	}
+	r.SetStatusCode(entry.StatusCode)
+	r.SetPayload(entry.Body)
+	return r
+}
+
+// revalidateInBackground revalidates the stored response of the lookup
+// in background, the request to the server is prepared before the
+// function returns, as the request could be changed after it is handled.
+func (sp *ServerPool) revalidateInBackground(req *httpprot.Request, l *httpCacheLookup) {
+	c := sp.httpCache
+	if !c.startRevalidation(l.key) {
+		return
+	}
+
+	lb := sp.LoadBalancer()
+	svr := lb.ChooseServer(req)
+	if svr == nil {
+		c.finishRevalidation(l.key)
+		return
+	}
+
+	timeout := sp.timeout
+	if timeout <= 0 {
+		timeout = defaultRevalidationTimeout
+	}
+	stdctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), timeout)
+
+	lookup := *l
+	lookup.reqHeader = l.reqHeader.Clone()
+	spCtx := &serverPoolContext{
+		req:         req,
+		startTime:   fasttime.Now(),
+		cacheLookup: &lookup,
+	}
+	if err := spCtx.prepareRequest(sp, svr, stdctx, false); err != nil {
+		logger.Errorf("%s: failed to prepare request to revalidate: %v", sp.Name, err)
+		lb.ReturnServer(svr, req, nil)
+		cancel()
+		c.finishRevalidation(l.key)
+		return
+	}
+
+	go func() {
+		defer c.finishRevalidation(l.key)
+		defer cancel()
+
+		stdResp, err := fnSendRequest(spCtx.stdReq, sp.httpClient())
+		if err != nil {
+			logger.Warnf("%s: failed to revalidate %s: %v", sp.Name, l.key, err)
+			lb.ReturnServer(svr, req, nil)
+			return
+		}
+		defer stdResp.Body.Close()
+
+		httphelper.RemoveHopByHopHeaders(stdResp.Header)
+		if sp.proxy.compression != nil && stdResp.StatusCode != http.StatusNotModified {
+			sp.proxy.compression.compress(spCtx.stdReq, stdResp)
+		}
+
+		resp, err := httpprot.NewResponse(stdResp)
+		if err != nil {
+			lb.ReturnServer(svr, req, nil)
+			return
+		}
+		lb.ReturnServer(svr, req, resp)
+
+		if err = resp.FetchPayload(int64(c.maxEntryBytes)); err != nil {
+			return
+		}
+
+		now := fasttime.Now()
+		if spCtx.conditional && resp.StatusCode() == http.StatusNotModified {
+			c.freshen(&lookup, resp.HTTPHeader(), spCtx.startTime, now)
+		} else {
+			c.store(&lookup, resp, spCtx.startTime, now)
+		}
+	}()
+}
+
 func (sp *ServerPool) buildFailureResponse(spCtx *serverPoolContext, statusCode int) {
 	resp, _ := spCtx.GetOutputResponse().(*httpprot.Response)
 	if resp == nil {
@@ -630,6 +1180,10 @@ type (
 		ResponseBodySize           prometheus.ObserverVec
 		RequestBodySizePercentage  prometheus.ObserverVec
 		ResponseBodySizePercentage prometheus.ObserverVec
+		OutlierEjections           *prometheus.CounterVec
+		OutlierEjectedServers      *prometheus.GaugeVec
+		MirrorDiffs                *prometheus.CounterVec
+		MirrorMismatches           *prometheus.CounterVec
 	}
 )
 
@@ -679,10 +1233,22 @@ func (sp *ServerPool) newMetrics(name string) *metrics {
 				Objectives: prometheushelper.DefaultObjectives(),
 			},
 			proxyLabels).MustCurryWith(commonLabels),
+		OutlierEjections: prometheushelper.NewCounter("proxy_outlier_ejections",
+			"the total count of servers ejected by outlier detection",
+			append(proxyLabels, "reason")).MustCurryWith(commonLabels),
+		OutlierEjectedServers: prometheushelper.NewGauge("proxy_outlier_ejected_servers",
+			"the count of servers currently ejected by outlier detection",
+			proxyLabels).MustCurryWith(commonLabels),
+		MirrorDiffs: prometheushelper.NewCounter("proxy_mirror_diffs",
+			"the total count of mirrored responses compared with primary responses",
+			append(proxyLabels, "result")).MustCurryWith(commonLabels),
+		MirrorMismatches: prometheushelper.NewCounter("proxy_mirror_mismatches",
+			"the total count of mismatched fields of mirrored responses",
+			append(proxyLabels, "field")).MustCurryWith(commonLabels),
 	}
 }
 
-func (sp *ServerPool) exportPrometheusMetrics(stat *httpstat.Metric) {
+func (sp *ServerPool) metricLabels() prometheus.Labels {
 	labels := prometheus.Labels{
 		"loadBalancePolicy": "",
 		"filterPolicy":      "",
@@ -693,6 +1259,39 @@ func (sp *ServerPool) exportPrometheusMetrics(stat *httpstat.Metric) {
 	if sp.spec.Filter != nil {
 		labels["filterPolicy"] = sp.spec.Filter.Policy
 	}
+	return labels
+}
+
+func (sp *ServerPool) exportOutlierMetrics(svr *Server, ejected bool, reason string) {
+	labels := sp.metricLabels()
+	if !ejected {
+		sp.metrics.OutlierEjectedServers.With(labels).Dec()
+		return
+	}
+	sp.metrics.OutlierEjectedServers.With(labels).Inc()
+	labels["reason"] = reason
+	sp.metrics.OutlierEjections.With(labels).Inc()
+}
+
+func (sp *ServerPool) exportMirrorMetrics(mismatches []*MirrorDiffMismatch) {
+	labels := sp.metricLabels()
+	if len(mismatches) == 0 {
+		labels["result"] = "match"
+		sp.metrics.MirrorDiffs.With(labels).Inc()
+		return
+	}
+
+	labels["result"] = "mismatch"
+	sp.metrics.MirrorDiffs.With(labels).Inc()
+	delete(labels, "result")
+	for _, mm := range mismatches {
+		labels["field"] = mm.Field
+		sp.metrics.MirrorMismatches.With(labels).Inc()
+	}
+}
+
+func (sp *ServerPool) exportPrometheusMetrics(stat *httpstat.Metric) {
+	labels := sp.metricLabels()
 	sp.metrics.TotalConnections.With(labels).Inc()
 	if stat.StatusCode >= 400 {
 		sp.metrics.TotalErrorConnections.With(labels).Inc()
@@ -84,6 +84,8 @@ func TestInjectResilience(t *testing.T) {
 	yamlConfig := `spanName: test
 retryPolicy: retry
 circuitBreakerPolicy: circuitBreaker
+adaptiveConcurrencyPolicy: adaptiveConcurrency
+bulkheadPolicy: bulkhead
 servers:
 - url: http://192.168.1.1
 `
@@ -110,10 +112,26 @@ servers:
 	assert.Panics(func() { sp.InjectResiliencePolicy(policies) })
 
 	policies["circuitBreaker"] = &resilience.CircuitBreakerPolicy{}
+	assert.Panics(func() { sp.InjectResiliencePolicy(policies) })
+
+	policies["adaptiveConcurrency"] = &resilience.RetryPolicy{}
+	assert.Panics(func() { sp.InjectResiliencePolicy(policies) })
+
+	policies["adaptiveConcurrency"] = &resilience.AdaptiveConcurrencyPolicy{}
+	assert.Panics(func() { sp.InjectResiliencePolicy(policies) })
+
+	policies["bulkhead"] = &resilience.RetryPolicy{}
+	assert.Panics(func() { sp.InjectResiliencePolicy(policies) })
+
+	policies["bulkhead"] = &resilience.BulkheadPolicy{}
 	assert.NotPanics(func() { sp.InjectResiliencePolicy(policies) })
 
 	assert.NotNil(sp.retryWrapper)
 	assert.NotNil(sp.circuitBreakerWrapper)
+	assert.NotNil(sp.adaptiveConcurrencyWrapper)
+	assert.NotNil(sp.bulkheadWrapper)
+	assert.NotNil(sp.status().AdaptiveConcurrency)
+	assert.NotNil(sp.status().Bulkhead)
 }
 
 func TestBuildResponseFromCache(t *testing.T) {
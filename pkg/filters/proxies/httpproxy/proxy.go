@@ -26,6 +26,7 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/megaease/easegress/v2/pkg/context"
@@ -35,6 +36,7 @@ import (
 	"github.com/megaease/easegress/v2/pkg/resilience"
 	"github.com/megaease/easegress/v2/pkg/supervisor"
 	"github.com/megaease/easegress/v2/pkg/util/easemonitor"
+	"github.com/megaease/easegress/v2/pkg/util/proxyprotocol"
 )
 
 const (
@@ -49,6 +51,7 @@ const (
 	// result for resilience
 	resultTimeout        = "timeout"
 	resultShortCircuited = "shortCircuited"
+	resultRejected       = "rejected"
 )
 
 var kind = &filters.Kind{
@@ -61,6 +64,7 @@ var kind = &filters.Kind{
 		resultFailureCode,
 		resultTimeout,
 		resultShortCircuited,
+		resultRejected,
 	},
 	DefaultSpec: func() filters.Spec {
 		return &Spec{
@@ -98,6 +102,8 @@ type (
 		mainPool       *ServerPool
 		candidatePools []*ServerPool
 		mirrorPool     *ServerPool
+		// mirrorWG waits for the goroutines sending mirror requests.
+		mirrorWG sync.WaitGroup
 
 		client *http.Client
 
@@ -138,6 +144,14 @@ type (
 		MaxIdleConns        int
 		MaxIdleConnsPerHost int
 		MaxRedirection      *int
+		// ProxyProtocol is the version of PROXY protocol header sent at
+		// the beginning of connections, connections are not reused when
+		// it is set, as a header carries the address of a single client.
+		ProxyProtocol string
+		// HTTP2 sends requests with HTTP/2 or h2c when it is set.
+		HTTP2 *HTTP2Spec
+		// HTTP3 sends requests with HTTP/3 when it is set.
+		HTTP3 *HTTP3Spec
 	}
 
 	// Server is the backend server.
@@ -177,6 +191,21 @@ func (s *Spec) Validate() error {
 		if s.MirrorPool.MemoryCache != nil {
 			return fmt.Errorf("memoryCache must be empty in mirrorPool")
 		}
+		if err := s.MirrorPool.Validate(); err != nil {
+			return fmt.Errorf("mirrorPool: %v", err)
+		}
+	}
+
+	for i, pool := range s.Pools {
+		if pool.Mirror != nil {
+			return fmt.Errorf("pool %d: mirror is only allowed in mirrorPool", i)
+		}
+	}
+
+	if s.Compression != nil {
+		if err := s.Compression.Validate(); err != nil {
+			return fmt.Errorf("compression: %v", err)
+		}
 	}
 
 	return nil
@@ -189,11 +218,15 @@ func HTTPClient(tlsCfg *tls.Config, spec *HTTPClientSpec, timeout time.Duration)
 			KeepAlive: 60 * time.Second,
 		}).DialContext(ctx, network, addr)
 	}
+	if spec.ProxyProtocol != "" {
+		dialFunc = proxyprotocol.DialContext(spec.ProxyProtocol, dialFunc)
+	}
 
-	client := &http.Client{
-		// NOTE: Timeout could be no limit, real client or server could cancel it.
-		Timeout: timeout,
-		Transport: &http.Transport{
+	var transport http.RoundTripper
+	if spec.HTTP2 != nil {
+		transport = newHTTP2Transport(tlsCfg, spec.HTTP2, dialFunc)
+	} else {
+		transport = &http.Transport{
 			Proxy:              http.ProxyFromEnvironment,
 			DialContext:        dialFunc,
 			TLSClientConfig:    tlsCfg,
@@ -205,7 +238,17 @@ func HTTPClient(tlsCfg *tls.Config, spec *HTTPClientSpec, timeout time.Duration)
 			IdleConnTimeout:       90 * time.Second,
 			TLSHandshakeTimeout:   10 * time.Second,
 			ExpectContinueTimeout: 1 * time.Second,
-		},
+			DisableKeepAlives:     spec.ProxyProtocol != "",
+		}
+		if spec.HTTP3 != nil {
+			transport = newHTTP3Transport(tlsCfg, spec.HTTP3, transport)
+		}
+	}
+
+	client := &http.Client{
+		// NOTE: Timeout could be no limit, real client or server could cancel it.
+		Timeout:   timeout,
+		Transport: transport,
 	}
 	if spec.MaxRedirection != nil {
 		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
@@ -335,6 +378,7 @@ func (p *Proxy) Close() {
 	}
 
 	if p.mirrorPool != nil {
+		p.mirrorWG.Wait()
 		p.mirrorPool.Close()
 	}
 }
@@ -343,8 +387,21 @@ func (p *Proxy) Close() {
 func (p *Proxy) Handle(ctx *context.Context) (result string) {
 	req := ctx.GetInputRequest().(*httpprot.Request)
 
-	if p.mirrorPool != nil && p.mirrorPool.filter.Match(req) {
-		go p.mirrorPool.handle(ctx, true)
+	var primary chan *mirrorResponse
+	if mp := p.mirrorPool; mp != nil && mp.filter.Match(req) && mp.mirror.shouldMirror(req) {
+		if mp.mirror.diffEnabled() {
+			primary = make(chan *mirrorResponse, 1)
+			// the snapshot is sent in a defer, so that the mirror goroutine
+			// doesn't wait forever if the primary pool panics.
+			defer func() {
+				primary <- mp.mirror.snapshotResponse(ctx)
+			}()
+		}
+		p.mirrorWG.Add(1)
+		go func() {
+			defer p.mirrorWG.Done()
+			mp.handleMirror(ctx, primary)
+		}()
 	}
 
 	sp := p.mainPool
@@ -355,7 +412,7 @@ func (p *Proxy) Handle(ctx *context.Context) (result string) {
 		}
 	}
 
-	return sp.handle(ctx, false)
+	return sp.handle(ctx)
 }
 
 // InjectResiliencePolicy injects resilience policies to the proxy.
@@ -20,6 +20,7 @@ package httpproxy
 import (
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -32,12 +33,14 @@ import (
 	"github.com/megaease/easegress/v2/pkg/filters"
 	"github.com/megaease/easegress/v2/pkg/logger"
 	"github.com/megaease/easegress/v2/pkg/option"
+	"github.com/megaease/easegress/v2/pkg/protocols/grpcprot"
 	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
 	"github.com/megaease/easegress/v2/pkg/protocols/httpprot/httpstat"
 	"github.com/megaease/easegress/v2/pkg/resilience"
 	"github.com/megaease/easegress/v2/pkg/supervisor"
 	"github.com/megaease/easegress/v2/pkg/tracing"
 	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/megaease/easegress/v2/pkg/util/proxyprotocol"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -166,6 +169,7 @@ compression:
 	// direct set fnSendRequest to different function will cause data race since we use goroutine
 	// for mirror.
 	var fnKind int32
+	old := fnSendRequest
 	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
 		kind := atomic.LoadInt32(&fnKind)
 		switch kind {
@@ -231,7 +235,175 @@ compression:
 		assert.NotEmpty(ctx.Tags())
 	}
 
+	// close waits for the mirror goroutines, so the function can be
+	// restored safely.
 	proxy.Close()
+	fnSendRequest = old
+}
+
+func TestProxyHedging(t *testing.T) {
+	assert := assert.New(t)
+
+	const yamlConfig = `
+name: proxy
+kind: Proxy
+pools:
+- servers:
+  - url: http://127.0.0.1:9095
+  - url: http://127.0.0.1:9096
+  loadBalance:
+    policy: roundRobin
+  retryPolicy: hedging
+`
+	proxy := newTestProxy(yamlConfig, assert)
+	defer proxy.Close()
+
+	policy := &resilience.RetryPolicy{
+		RetryRule: resilience.RetryRule{
+			MaxAttempts: 1,
+			Hedging:     &resilience.HedgingSpec{Delay: "10ms"},
+		},
+	}
+	proxy.InjectResiliencePolicy(map[string]resilience.Policy{"hedging": policy})
+
+	// the first server is slow, the request is hedged to the second one.
+	old := fnSendRequest
+	defer func() { fnSendRequest = old }()
+	var slowCancelled int32
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		if r.URL.Host == "127.0.0.1:9095" {
+			select {
+			case <-r.Context().Done():
+				atomic.StoreInt32(&slowCancelled, 1)
+				return nil, r.Context().Err()
+			case <-time.After(200 * time.Millisecond):
+			}
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"X-Server": []string{r.URL.Host}},
+			Body:       io.NopCloser(strings.NewReader("this is the body")),
+		}, nil
+	}
+
+	stdr, _ := http.NewRequest(http.MethodGet, "https://www.megaease.com", nil)
+	ctx := getCtx(stdr)
+	assert.Equal("", proxy.Handle(ctx))
+	resp := ctx.GetOutputResponse().(*httpprot.Response)
+	assert.Equal("127.0.0.1:9096", resp.HTTPHeader().Get("X-Server"))
+	assert.Eventually(func() bool {
+		return atomic.LoadInt32(&slowCancelled) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// requests with non-idempotent methods are not hedged.
+	stdr, _ = http.NewRequest(http.MethodPost, "https://www.megaease.com", nil)
+	ctx = getCtx(stdr)
+	assert.Equal("", proxy.Handle(ctx))
+	resp = ctx.GetOutputResponse().(*httpprot.Response)
+	assert.Equal("127.0.0.1:9095", resp.HTTPHeader().Get("X-Server"))
+}
+
+func TestProxyRetryHeader(t *testing.T) {
+	assert := assert.New(t)
+
+	const yamlConfig = `
+name: proxy
+kind: Proxy
+pools:
+- servers:
+  - url: http://127.0.0.1:9095
+  failureCodes: [500]
+  retryPolicy: retry
+`
+	proxy := newTestProxy(yamlConfig, assert)
+	defer proxy.Close()
+
+	policy := &resilience.RetryPolicy{
+		RetryRule: resilience.RetryRule{MaxAttempts: 3, WaitDuration: "1ms"},
+	}
+	proxy.InjectResiliencePolicy(map[string]resilience.Policy{"retry": policy})
+
+	// the headers of failed attempts must not be in the final response.
+	old := fnSendRequest
+	defer func() { fnSendRequest = old }()
+	var attempts int32
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		code := http.StatusInternalServerError
+		if atomic.AddInt32(&attempts, 1) == 3 {
+			code = http.StatusOK
+		}
+		return &http.Response{
+			StatusCode: code,
+			Header:     http.Header{"X-Server": []string{"server"}},
+			Body:       io.NopCloser(strings.NewReader("this is the body")),
+		}, nil
+	}
+
+	stdr, _ := http.NewRequest(http.MethodGet, "https://www.megaease.com", nil)
+	ctx := getCtx(stdr)
+	resp, _ := httpprot.NewResponse(nil)
+	resp.HTTPHeader().Set("X-Foo", "foo")
+	ctx.SetResponse(context.DefaultNamespace, resp)
+	assert.Equal("", proxy.Handle(ctx))
+	resp = ctx.GetOutputResponse().(*httpprot.Response)
+	assert.Equal(int32(3), atomic.LoadInt32(&attempts))
+	assert.Equal(http.StatusOK, resp.StatusCode())
+	assert.Equal([]string{"server"}, resp.HTTPHeader().Values("X-Server"))
+	assert.Equal([]string{"foo"}, resp.HTTPHeader().Values("X-Foo"))
+}
+
+func TestProxyDeadline(t *testing.T) {
+	assert := assert.New(t)
+
+	const yamlConfig = `
+name: proxy
+kind: Proxy
+pools:
+- servers:
+  - url: http://127.0.0.1:9095
+`
+	proxy := newTestProxy(yamlConfig, assert)
+	defer proxy.Close()
+
+	old := fnSendRequest
+	defer func() { fnSendRequest = old }()
+	var grpcTimeout atomic.Value
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		grpcTimeout.Store(r.Header.Get("grpc-timeout"))
+		select {
+		case <-r.Context().Done():
+			return nil, r.Context().Err()
+		case <-time.After(time.Second):
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader("this is the body")),
+		}, nil
+	}
+
+	// the upstream request is canceled at the deadline of the pipeline.
+	stdr, _ := http.NewRequest(http.MethodPost, "https://www.megaease.com", nil)
+	stdr.Header.Set("Content-Type", "application/grpc")
+	ctx := getCtx(stdr)
+	ctx.SetTimeout(50 * time.Millisecond)
+	start := time.Now()
+	assert.Equal(resultTimeout, proxy.Handle(ctx))
+	assert.Less(time.Since(start), 500*time.Millisecond)
+	resp := ctx.GetOutputResponse().(*httpprot.Response)
+	assert.Equal(http.StatusGatewayTimeout, resp.StatusCode())
+	d, err := grpcprot.ParseTimeout(grpcTimeout.Load().(string))
+	assert.NoError(err)
+	assert.LessOrEqual(d, 50*time.Millisecond)
+	assert.Greater(d, time.Duration(0))
+	ctx.Finish()
+
+	// grpc-timeout is not added to other requests.
+	stdr, _ = http.NewRequest(http.MethodGet, "https://www.megaease.com", nil)
+	ctx = getCtx(stdr)
+	ctx.SetTimeout(10 * time.Millisecond)
+	assert.Equal(resultTimeout, proxy.Handle(ctx))
+	assert.Equal("", grpcTimeout.Load())
+	ctx.Finish()
 }
 
 func TestSpecValidate(t *testing.T) {
@@ -396,3 +568,49 @@ func TestToMetrics(t *testing.T) {
 	metrics := s.ToMetrics("test")
 	assert.Equal(3, len(metrics))
 }
+
+func TestProxyProtocol(t *testing.T) {
+	assert := assert.New(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(err)
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.RemoteAddr))
+		}),
+	}
+	go srv.Serve(proxyprotocol.NewListener(ln, &proxyprotocol.Spec{TrustedCIDRs: []string{"127.0.0.1"}}))
+	defer srv.Close()
+
+	old := fnSendRequest
+	defer func() { fnSendRequest = old }()
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		return client.Do(r)
+	}
+
+	for _, version := range []string{proxyprotocol.Version1, proxyprotocol.Version2} {
+		yamlConfig := fmt.Sprintf(`
+name: proxy
+kind: Proxy
+pools:
+- servers:
+  - url: http://%s
+  proxyProtocol: %s
+`, ln.Addr().String(), version)
+		proxy := newTestProxy(yamlConfig, assert)
+		assert.NotNil(proxy.mainPool.client)
+
+		stdr, _ := http.NewRequest(http.MethodGet, "http://www.megaease.com/", nil)
+		stdr.RemoteAddr = "192.168.1.1:56324"
+		ctx := getCtx(stdr)
+		assert.Equal("", proxy.Handle(ctx))
+
+		resp := ctx.GetResponse(context.DefaultNamespace).(*httpprot.Response)
+		assert.Equal("192.168.1.1:56324", string(resp.RawPayload()))
+		proxy.Close()
+	}
+
+	spec := &ServerPoolSpec{ProxyProtocol: "v3"}
+	spec.Servers = []*Server{{URL: "http://127.0.0.1"}}
+	assert.Error(spec.Validate())
+}
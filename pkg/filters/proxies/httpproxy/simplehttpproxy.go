@@ -112,6 +112,13 @@ func (s *SimpleHTTPProxySpec) Validate() error {
 		}
 	}
 
+	// Validate Compression
+	if s.Compression != nil {
+		if err := s.Compression.Validate(); err != nil {
+			return fmt.Errorf("compression: %v", err)
+		}
+	}
+
 	return nil
 }
 
@@ -256,7 +263,10 @@ func (shp *SimpleHTTPProxy) doRequestWithRetry(req *httpprot.Request) (*http.Res
 		handler = shp.retryWrapper.Wrap(handler)
 	}
 
-	err := handler(req.Context())
+	// the handler is not safe for concurrent calls, so the request
+	// is never hedged.
+	info := &resilience.RequestInfo{Method: req.Method()}
+	err := handler(resilience.WithRequestInfo(req.Context(), info))
 	return resp, err
 }
 
@@ -0,0 +1,148 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpproxy
+
+import (
+	stdcontext "context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/util/readers"
+)
+
+const (
+	// FlushIntervalDataKey is the key of the context data which tells the
+	// HTTPServer how to flush a streaming response, its value is a
+	// time.Duration, and zero means to flush after every write.
+	FlushIntervalDataKey = "HTTP_RESPONSE_FLUSH_INTERVAL"
+
+	mimeEventStream = "text/event-stream"
+)
+
+var errStreamIdleTimeout = errors.New("streaming response idle timeout")
+
+type (
+	// StreamingSpec describes how streaming responses, like Server-Sent
+	// Events, are handled.
+	StreamingSpec struct {
+		// ContentTypes are media types of streaming responses in addition
+		// to text/event-stream, items like 'application/*' are supported.
+		ContentTypes []string `json:"contentTypes,omitempty"`
+		// FlushInterval is the interval to flush a streaming response to
+		// the client, it is flushed after every write if empty.
+		FlushInterval string `json:"flushInterval,omitempty" jsonschema:"format=duration"`
+		// IdleTimeout closes a streaming response if no data is received
+		// from the server within this duration.
+		IdleTimeout string `json:"idleTimeout,omitempty" jsonschema:"format=duration"`
+	}
+
+	streaming struct {
+		spec          *StreamingSpec
+		flushInterval time.Duration
+		idleTimeout   time.Duration
+	}
+)
+
+// Validate validates StreamingSpec.
+func (spec *StreamingSpec) Validate() error {
+	for _, ct := range spec.ContentTypes {
+		if _, err := path.Match(ct, ""); err != nil {
+			return fmt.Errorf("invalid content type %q: %v", ct, err)
+		}
+	}
+
+	for _, d := range []string{spec.FlushInterval, spec.IdleTimeout} {
+		if d == "" {
+			continue
+		}
+		if v, err := time.ParseDuration(d); err != nil {
+			return err
+		} else if v < 0 {
+			return fmt.Errorf("duration %s must not be negative", d)
+		}
+	}
+
+	return nil
+}
+
+func newStreaming(spec *StreamingSpec) *streaming {
+	s := &streaming{spec: spec}
+	if spec.FlushInterval != "" {
+		s.flushInterval, _ = time.ParseDuration(spec.FlushInterval)
+	}
+	if spec.IdleTimeout != "" {
+		s.idleTimeout, _ = time.ParseDuration(spec.IdleTimeout)
+	}
+	return s
+}
+
+// isStreamingResponse returns whether resp is a streaming response, it is
+// always false if streaming is not enabled. A Server-Sent Events response
+// is always a streaming response when streaming is enabled.
+func isStreamingResponse(resp *http.Response, spec *StreamingSpec) bool {
+	if spec == nil {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+
+	if mediaType == mimeEventStream {
+		return true
+	}
+
+	for _, ct := range spec.ContentTypes {
+		if ok, _ := path.Match(strings.ToLower(ct), mediaType); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// watch watches the body of a streaming response, cancels the request
+// when the body is closed or no data is received within idle timeout.
+func (s *streaming) watch(body *readers.CallbackReader, cancel stdcontext.CancelCauseFunc) {
+	if s.idleTimeout > 0 {
+		timer := time.AfterFunc(s.idleTimeout, func() {
+			cancel(errStreamIdleTimeout)
+		})
+
+		body.OnAfter(func(total int, p []byte, err error) {
+			if err != nil {
+				timer.Stop()
+			} else {
+				timer.Reset(s.idleTimeout)
+			}
+		})
+		body.OnClose(func() {
+			timer.Stop()
+		})
+	}
+
+	body.OnClose(func() {
+		cancel(nil)
+	})
+}
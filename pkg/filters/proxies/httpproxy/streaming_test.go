@@ -0,0 +1,159 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpproxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamingSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &StreamingSpec{}
+	assert.NoError(spec.Validate())
+
+	spec = &StreamingSpec{
+		ContentTypes:  []string{"application/x-ndjson", "application/*"},
+		FlushInterval: "100ms",
+		IdleTimeout:   "30s",
+	}
+	assert.NoError(spec.Validate())
+
+	spec.IdleTimeout = "-1s"
+	assert.Error(spec.Validate())
+
+	spec.IdleTimeout = "abc"
+	assert.Error(spec.Validate())
+
+	spec.IdleTimeout = ""
+	spec.ContentTypes = []string{"text/["}
+	assert.Error(spec.Validate())
+}
+
+func TestIsStreamingResponse(t *testing.T) {
+	assert := assert.New(t)
+
+	resp := &http.Response{Header: http.Header{}}
+	assert.False(isStreamingResponse(resp, nil))
+
+	// Server-Sent Events responses are streaming responses only when
+	// streaming is enabled.
+	resp.Header.Set("Content-Type", "text/event-stream; charset=utf-8")
+	assert.False(isStreamingResponse(resp, nil))
+	assert.True(isStreamingResponse(resp, &StreamingSpec{}))
+
+	resp.Header.Set("Content-Type", "application/x-ndjson")
+	assert.False(isStreamingResponse(resp, nil))
+	assert.False(isStreamingResponse(resp, &StreamingSpec{}))
+	assert.True(isStreamingResponse(resp, &StreamingSpec{ContentTypes: []string{"application/x-ndjson"}}))
+	assert.True(isStreamingResponse(resp, &StreamingSpec{ContentTypes: []string{"Application/*"}}))
+}
+
+func TestStreaming(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		n := 5
+		if r.URL.Path == "/idle" {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			fmt.Fprintf(w, "data: %d\n\n", i)
+			w.(http.Flusher).Flush()
+			time.Sleep(50 * time.Millisecond)
+		}
+		if r.URL.Path == "/idle" {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(3 * time.Second):
+			}
+		}
+	}))
+	defer srv.Close()
+
+	old := fnSendRequest
+	defer func() { fnSendRequest = old }()
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		return client.Do(r)
+	}
+
+	yamlConfig := fmt.Sprintf(`
+name: proxy
+kind: Proxy
+compression:
+  minLength: 1
+pools:
+- servers:
+  - url: %s
+  timeout: 100ms
+  memoryCache:
+    expiration: 10s
+    maxEntryBytes: 4096
+    codes: [200]
+    methods: [GET]
+  streaming:
+    flushInterval: 10ms
+    idleTimeout: 200ms
+`, srv.URL)
+	proxy := newTestProxy(yamlConfig, assert)
+	defer proxy.Close()
+
+	// the stream lasts longer than the timeout of the pool.
+	for i := 0; i < 2; i++ {
+		stdr, _ := http.NewRequest(http.MethodGet, "http://www.megaease.com/", nil)
+		ctx := getCtx(stdr)
+		assert.Equal("", proxy.Handle(ctx))
+
+		resp := ctx.GetResponse(context.DefaultNamespace).(*httpprot.Response)
+		assert.True(resp.IsStream())
+		assert.Empty(resp.Header().Get("Content-Encoding"))
+		assert.Equal(10*time.Millisecond, ctx.GetData(FlushIntervalDataKey))
+
+		data, err := io.ReadAll(resp.GetPayload())
+		assert.NoError(err)
+		assert.Equal(5, strings.Count(string(data), "data: "))
+		resp.Close()
+		ctx.Finish()
+	}
+
+	// the stream is closed if it is idle.
+	stdr, _ := http.NewRequest(http.MethodGet, "http://www.megaease.com/idle", nil)
+	ctx := getCtx(stdr)
+	assert.Equal("", proxy.Handle(ctx))
+
+	resp := ctx.GetResponse(context.DefaultNamespace).(*httpprot.Response)
+	start := time.Now()
+	data, err := io.ReadAll(resp.GetPayload())
+	assert.Error(err)
+	assert.Less(time.Since(start), 2*time.Second)
+	assert.Equal("data: 0\n\n", string(data))
+	resp.Close()
+	ctx.Finish()
+}
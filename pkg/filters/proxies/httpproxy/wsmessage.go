@@ -0,0 +1,401 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpproxy
+
+import (
+	stdctx "context"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xeipuuv/gojsonschema"
+	"golang.org/x/time/rate"
+	"nhooyr.io/websocket"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/object/rawconfigtrafficcontroller"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/supervisor"
+	"github.com/megaease/easegress/v2/pkg/tracing"
+	"github.com/megaease/easegress/v2/pkg/util/dynamicobject"
+	"github.com/megaease/easegress/v2/pkg/util/fasttime"
+)
+
+const (
+	wsViolationClose = "close"
+	wsViolationDrop  = "drop"
+
+	// wsDirectionHeader and wsMessageTypeHeader are set to the requests
+	// of the message pipelines.
+	wsDirectionHeader   = "X-Easegress-WebSocket-Direction"
+	wsMessageTypeHeader = "X-Easegress-WebSocket-Message-Type"
+
+	// maxCloseReasonLen is the max length of the reason of a close frame.
+	maxCloseReasonLen = 123
+)
+
+// fnGetPipeline gets the handler of a pipeline, it is a variable so
+// that it can be replaced in testing.
+var fnGetPipeline = func(super *supervisor.Supervisor, name string) (context.Handler, bool) {
+	if super == nil {
+		return nil, false
+	}
+	entity, exists := super.GetSystemController(rawconfigtrafficcontroller.Kind)
+	if !exists {
+		return nil, false
+	}
+	rctc, ok := entity.Instance().(*rawconfigtrafficcontroller.RawConfigTrafficController)
+	if !ok {
+		return nil, false
+	}
+	return rctc.GetPipeline(name)
+}
+
+type (
+	// WebSocketMessageSpec describes the message-level processing of the
+	// WebSocket connections, the size of messages is limited by the
+	// ClientMaxMsgSize and ServerMaxMsgSize of the pool.
+	WebSocketMessageSpec struct {
+		// ConnectionRate is the max number of client messages per second
+		// of a connection.
+		ConnectionRate float64 `json:"connectionRate,omitempty" jsonschema:"minimum=0"`
+		// ClientRate is the max number of client messages per second of
+		// all connections sharing the same client key.
+		ClientRate float64 `json:"clientRate,omitempty" jsonschema:"minimum=0"`
+		// ClientKey is the header to get the client key from, the real
+		// IP of the client is used if it is empty or the header is absent.
+		ClientKey string `json:"clientKey,omitempty"`
+		// Burst is the burst of the rate limits.
+		Burst int `json:"burst,omitempty" jsonschema:"minimum=0"`
+		// JSONSchema validates the text messages from clients.
+		JSONSchema dynamicobject.DynamicObject `json:"jsonSchema,omitempty"`
+		// ClientPipeline transforms the messages from clients.
+		ClientPipeline string `json:"clientPipeline,omitempty"`
+		// ServerPipeline transforms the messages from servers.
+		ServerPipeline string `json:"serverPipeline,omitempty"`
+		// OnViolation is the action on messages violating the above
+		// policies, close (default) or drop.
+		OnViolation string `json:"onViolation,omitempty" jsonschema:"enum=,enum=close,enum=drop"`
+	}
+
+	// WebSocketStatus is the status of the WebSocket connections of a pool.
+	WebSocketStatus struct {
+		ActiveConnections int64  `json:"activeConnections"`
+		TotalConnections  uint64 `json:"totalConnections"`
+		ClientMessages    uint64 `json:"clientMessages"`
+		ServerMessages    uint64 `json:"serverMessages"`
+		DroppedMessages   uint64 `json:"droppedMessages"`
+	}
+
+	// wsMessageProcessor applies the message policies of a pool.
+	wsMessageProcessor struct {
+		spec   *WebSocketMessageSpec
+		super  *supervisor.Supervisor
+		schema *gojsonschema.Schema
+
+		mu             sync.Mutex
+		clientLimiters map[string]*wsClientLimiter
+	}
+
+	wsClientLimiter struct {
+		limiter *rate.Limiter
+		refs    int
+	}
+
+	// wsConnection is a proxied WebSocket connection.
+	wsConnection struct {
+		sp        *WebSocketServerPool
+		req       *httpprot.Request
+		clientKey string
+		startAt   time.Time
+
+		connLimiter   *rate.Limiter
+		clientLimiter *rate.Limiter
+
+		clientMessages atomic.Uint64
+		clientBytes    atomic.Uint64
+		serverMessages atomic.Uint64
+		serverBytes    atomic.Uint64
+		dropped        atomic.Uint64
+	}
+
+	// wsViolation is a policy violation which closes the connection.
+	wsViolation struct {
+		code   websocket.StatusCode
+		reason string
+	}
+
+	// wsStat is the statistics of the WebSocket connections of a pool.
+	wsStat struct {
+		activeConnections atomic.Int64
+		totalConnections  atomic.Uint64
+		clientMessages    atomic.Uint64
+		serverMessages    atomic.Uint64
+		droppedMessages   atomic.Uint64
+	}
+)
+
+// Validate validates WebSocketMessageSpec.
+func (s *WebSocketMessageSpec) Validate() error {
+	if len(s.JSONSchema) > 0 {
+		if _, err := gojsonschema.NewSchema(gojsonschema.NewGoLoader(s.JSONSchema)); err != nil {
+			return fmt.Errorf("invalid jsonSchema: %v", err)
+		}
+	}
+	return nil
+}
+
+func (s *WebSocketMessageSpec) burst(r float64) int {
+	if s.Burst > 0 {
+		return s.Burst
+	}
+	return int(math.Max(1, math.Ceil(r)))
+}
+
+func newWSMessageProcessor(spec *WebSocketMessageSpec, super *supervisor.Supervisor) *wsMessageProcessor {
+	mp := &wsMessageProcessor{
+		spec:           spec,
+		super:          super,
+		clientLimiters: map[string]*wsClientLimiter{},
+	}
+	if len(spec.JSONSchema) > 0 {
+		// the schema is checked in Validate, so the error is ignored.
+		mp.schema, _ = gojsonschema.NewSchema(gojsonschema.NewGoLoader(spec.JSONSchema))
+	}
+	return mp
+}
+
+func (mp *wsMessageProcessor) acquireClientLimiter(key string) *rate.Limiter {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	cl := mp.clientLimiters[key]
+	if cl == nil {
+		r := mp.spec.ClientRate
+		cl = &wsClientLimiter{limiter: rate.NewLimiter(rate.Limit(r), mp.spec.burst(r))}
+		mp.clientLimiters[key] = cl
+	}
+	cl.refs++
+	return cl.limiter
+}
+
+func (mp *wsMessageProcessor) releaseClientLimiter(key string) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	cl := mp.clientLimiters[key]
+	if cl == nil {
+		return
+	}
+	cl.refs--
+	if cl.refs <= 0 {
+		delete(mp.clientLimiters, key)
+	}
+}
+
+// check checks the message against the policies, it returns nil if the
+// message is allowed.
+func (mp *wsMessageProcessor) check(c *wsConnection, fromClient bool, t websocket.MessageType, m []byte) *wsViolation {
+	if !fromClient {
+		return nil
+	}
+
+	if c.connLimiter != nil && !c.connLimiter.Allow() {
+		return &wsViolation{code: websocket.StatusPolicyViolation, reason: "connection message rate exceeded"}
+	}
+	if c.clientLimiter != nil && !c.clientLimiter.Allow() {
+		return &wsViolation{code: websocket.StatusPolicyViolation, reason: "client message rate exceeded"}
+	}
+
+	if mp.schema != nil && t == websocket.MessageText {
+		result, err := mp.schema.Validate(gojsonschema.NewBytesLoader(m))
+		if err != nil {
+			return &wsViolation{code: websocket.StatusInvalidFramePayloadData, reason: "invalid JSON message"}
+		}
+		if !result.Valid() {
+			reason := "invalid message"
+			if errs := result.Errors(); len(errs) > 0 {
+				reason = "invalid message: " + errs[0].String()
+			}
+			return &wsViolation{code: websocket.StatusPolicyViolation, reason: reason}
+		}
+	}
+
+	return nil
+}
+
+// runPipeline passes the message to the pipeline as the body of an HTTP
+// request. The message is dropped if the pipeline responds with an error
+// status code, otherwise, it is replaced by the response body if it is
+// not empty, or by the request body.
+func (mp *wsMessageProcessor) runPipeline(name string, c *wsConnection, fromClient bool, t websocket.MessageType, m []byte) ([]byte, bool) {
+	handler, ok := fnGetPipeline(mp.super, name)
+	if !ok {
+		logger.Errorf("%s: pipeline %s not found, message is forwarded as is", c.sp.Name, name)
+		return m, true
+	}
+
+	stdr, err := http.NewRequestWithContext(stdctx.Background(), http.MethodPost, c.req.URL().String(), nil)
+	if err != nil {
+		logger.Errorf("%s: failed to create request for pipeline %s: %v", c.sp.Name, name, err)
+		return m, true
+	}
+	stdr.Header = c.req.HTTPHeader().Clone()
+	stdr.Host = c.req.Host()
+	stdr.RemoteAddr = c.req.RemoteAddr
+	if fromClient {
+		stdr.Header.Set(wsDirectionHeader, "client")
+	} else {
+		stdr.Header.Set(wsDirectionHeader, "server")
+	}
+	if t == websocket.MessageText {
+		stdr.Header.Set(wsMessageTypeHeader, "text")
+	} else {
+		stdr.Header.Set(wsMessageTypeHeader, "binary")
+	}
+
+	req, _ := httpprot.NewRequest(stdr)
+	req.SetPayload(m)
+	resp, _ := httpprot.NewResponse(nil)
+
+	ctx := context.New(tracing.NoopSpan)
+	defer ctx.Finish()
+	ctx.SetRequest(context.DefaultNamespace, req)
+	ctx.SetResponse(context.DefaultNamespace, resp)
+	handler.Handle(ctx)
+
+	if resp, ok := ctx.GetResponse(context.DefaultNamespace).(*httpprot.Response); ok {
+		if resp.StatusCode() >= 400 {
+			return nil, false
+		}
+		if p := resp.RawPayload(); len(p) > 0 {
+			return append([]byte(nil), p...), true
+		}
+	}
+	if req, ok := ctx.GetRequest(context.DefaultNamespace).(*httpprot.Request); ok {
+		return append([]byte(nil), req.RawPayload()...), true
+	}
+	return m, true
+}
+
+func (sp *WebSocketServerPool) newConnection(req *httpprot.Request) *wsConnection {
+	c := &wsConnection{
+		sp:      sp,
+		req:     req,
+		startAt: fasttime.Now(),
+	}
+
+	if mp := sp.messages; mp != nil {
+		if r := mp.spec.ConnectionRate; r > 0 {
+			c.connLimiter = rate.NewLimiter(rate.Limit(r), mp.spec.burst(r))
+		}
+		if mp.spec.ClientRate > 0 {
+			if mp.spec.ClientKey != "" {
+				c.clientKey = req.HTTPHeader().Get(mp.spec.ClientKey)
+			}
+			if c.clientKey == "" {
+				c.clientKey = req.RealIP()
+			}
+			c.clientLimiter = mp.acquireClientLimiter(c.clientKey)
+		}
+	}
+
+	sp.wsStat.totalConnections.Add(1)
+	sp.wsStat.activeConnections.Add(1)
+	return c
+}
+
+func (c *wsConnection) close() {
+	if c.clientLimiter != nil {
+		c.sp.messages.releaseClientLimiter(c.clientKey)
+	}
+	c.sp.wsStat.activeConnections.Add(-1)
+}
+
+// process processes a message, it returns the message to forward and
+// whether to forward it, and a violation if the connection should be
+// closed.
+func (c *wsConnection) process(fromClient bool, t websocket.MessageType, m []byte) ([]byte, bool, *wsViolation) {
+	stat := &c.sp.wsStat
+	if fromClient {
+		c.clientMessages.Add(1)
+		c.clientBytes.Add(uint64(len(m)))
+		stat.clientMessages.Add(1)
+	} else {
+		c.serverMessages.Add(1)
+		c.serverBytes.Add(uint64(len(m)))
+		stat.serverMessages.Add(1)
+	}
+
+	mp := c.sp.messages
+	if mp == nil {
+		return m, true, nil
+	}
+
+	if v := mp.check(c, fromClient, t, m); v != nil {
+		if mp.spec.OnViolation == wsViolationDrop {
+			c.drop()
+			return nil, false, nil
+		}
+		if len(v.reason) > maxCloseReasonLen {
+			v.reason = v.reason[:maxCloseReasonLen]
+		}
+		return nil, false, v
+	}
+
+	pipeline := mp.spec.ServerPipeline
+	if fromClient {
+		pipeline = mp.spec.ClientPipeline
+	}
+	if pipeline == "" {
+		return m, true, nil
+	}
+
+	m, ok := mp.runPipeline(pipeline, c, fromClient, t, m)
+	if !ok {
+		c.drop()
+	}
+	return m, ok, nil
+}
+
+func (c *wsConnection) drop() {
+	c.dropped.Add(1)
+	c.sp.wsStat.droppedMessages.Add(1)
+}
+
+// tag returns the tag of the connection for the access log.
+func (c *wsConnection) tag() string {
+	return fmt.Sprintf("websocket client %d/%dB server %d/%dB dropped %d duration %s",
+		c.clientMessages.Load(), c.clientBytes.Load(),
+		c.serverMessages.Load(), c.serverBytes.Load(),
+		c.dropped.Load(), fasttime.Since(c.startAt).Round(time.Millisecond))
+}
+
+func (s *wsStat) status() *WebSocketStatus {
+	return &WebSocketStatus{
+		ActiveConnections: s.activeConnections.Load(),
+		TotalConnections:  s.totalConnections.Load(),
+		ClientMessages:    s.clientMessages.Load(),
+		ServerMessages:    s.serverMessages.Load(),
+		DroppedMessages:   s.droppedMessages.Load(),
+	}
+}
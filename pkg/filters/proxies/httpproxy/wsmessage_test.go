@@ -0,0 +1,244 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpproxy
+
+import (
+	stdctx "context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/context/contexttest"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/supervisor"
+	"github.com/stretchr/testify/assert"
+	"nhooyr.io/websocket"
+)
+
+// newTestWebSocketServers starts an echo server and a server proxying
+// WebSocket connections to it with the pool spec.
+func newTestWebSocketServers(poolSpec string, assert *assert.Assertions) (*WebSocketProxy, *httptest.Server, func()) {
+	echo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close(websocket.StatusNormalClosure, "")
+		for {
+			t, m, err := conn.Read(stdctx.Background())
+			if err != nil {
+				return
+			}
+			if conn.Write(stdctx.Background(), t, m) != nil {
+				return
+			}
+		}
+	}))
+
+	yamlConfig := fmt.Sprintf(`
+name: wsproxy
+kind: WebSocketProxy
+pools:
+- servers:
+  - url: %s
+%s
+`, echo.URL, poolSpec)
+	proxy := newTestWebSocketProxy(yamlConfig, assert)
+
+	front := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := getCtx(r)
+		ctx.SetData("HTTP_RESPONSE_WRITER", w)
+		proxy.Handle(ctx)
+	}))
+
+	return proxy, front, func() {
+		front.Close()
+		proxy.Close()
+		echo.Close()
+	}
+}
+
+func dialTestWebSocket(url string, header http.Header, assert *assert.Assertions) *websocket.Conn {
+	conn, _, err := websocket.Dial(stdctx.Background(), "ws"+strings.TrimPrefix(url, "http"), &websocket.DialOptions{HTTPHeader: header})
+	assert.NoError(err)
+	return conn
+}
+
+func readTestWebSocket(conn *websocket.Conn) (string, error) {
+	ctx, cancel := stdctx.WithTimeout(stdctx.Background(), time.Second)
+	defer cancel()
+	_, m, err := conn.Read(ctx)
+	return string(m), err
+}
+
+func TestWebSocketMessageSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &WebSocketMessageSpec{}
+	assert.NoError(spec.Validate())
+	assert.Equal(3, spec.burst(2.5))
+	spec.Burst = 10
+	assert.Equal(10, spec.burst(2.5))
+
+	spec.JSONSchema = map[string]interface{}{"type": 1}
+	assert.Error(spec.Validate())
+
+	poolSpec := &WebSocketServerPoolSpec{Messages: spec}
+	poolSpec.Servers = []*Server{{URL: "ws://127.0.0.1:9095"}}
+	assert.Error(poolSpec.Validate())
+}
+
+func TestWebSocketMessagesMetrics(t *testing.T) {
+	assert := assert.New(t)
+
+	proxy, front, closeAll := newTestWebSocketServers("", assert)
+	defer closeAll()
+
+	conn := dialTestWebSocket(front.URL, nil, assert)
+	assert.NoError(conn.Write(stdctx.Background(), websocket.MessageText, []byte("hello")))
+	m, err := readTestWebSocket(conn)
+	assert.NoError(err)
+	assert.Equal("hello", m)
+
+	ws := proxy.mainPool.status().WebSocket
+	assert.Equal(int64(1), ws.ActiveConnections)
+	assert.Equal(uint64(1), ws.TotalConnections)
+	assert.Equal(uint64(1), ws.ClientMessages)
+	assert.Equal(uint64(1), ws.ServerMessages)
+
+	conn.Close(websocket.StatusNormalClosure, "")
+	time.Sleep(100 * time.Millisecond)
+	ws = proxy.mainPool.status().WebSocket
+	assert.Equal(int64(0), ws.ActiveConnections)
+	assert.Equal(uint64(1), ws.TotalConnections)
+}
+
+func TestWebSocketMessagesPolicies(t *testing.T) {
+	assert := assert.New(t)
+
+	_, front, closeAll := newTestWebSocketServers(`
+  clientMaxMsgSize: 10
+  messages:
+    jsonSchema:
+      type: object
+      required: [name]
+`, assert)
+	defer closeAll()
+
+	conn := dialTestWebSocket(front.URL, nil, assert)
+	assert.NoError(conn.Write(stdctx.Background(), websocket.MessageText, []byte(`{"name":1}`)))
+	m, err := readTestWebSocket(conn)
+	assert.NoError(err)
+	assert.Equal(`{"name":1}`, m)
+
+	assert.NoError(conn.Write(stdctx.Background(), websocket.MessageText, []byte(`{"a":1}`)))
+	_, err = readTestWebSocket(conn)
+	assert.Equal(websocket.StatusPolicyViolation, websocket.CloseStatus(err))
+
+	conn = dialTestWebSocket(front.URL, nil, assert)
+	assert.NoError(conn.Write(stdctx.Background(), websocket.MessageBinary, []byte(`01234567890`)))
+	_, err = readTestWebSocket(conn)
+	assert.Equal(websocket.StatusMessageTooBig, websocket.CloseStatus(err))
+}
+
+func TestWebSocketMessagesRateLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	proxy, front, closeAll := newTestWebSocketServers(`
+  messages:
+    clientRate: 0.001
+    clientKey: X-Client
+    burst: 2
+    onViolation: drop
+`, assert)
+	defer closeAll()
+
+	header := http.Header{"X-Client": []string{"a"}}
+	conn1 := dialTestWebSocket(front.URL, header, assert)
+	conn2 := dialTestWebSocket(front.URL, header, assert)
+	conn3 := dialTestWebSocket(front.URL, http.Header{"X-Client": []string{"b"}}, assert)
+
+	// the two connections of client a share the burst of 2.
+	for i, conn := range []*websocket.Conn{conn1, conn2, conn1} {
+		assert.NoError(conn.Write(stdctx.Background(), websocket.MessageText, []byte(fmt.Sprint(i))))
+	}
+	m, err := readTestWebSocket(conn1)
+	assert.NoError(err)
+	assert.Equal("0", m)
+	m, err = readTestWebSocket(conn2)
+	assert.NoError(err)
+	assert.Equal("1", m)
+	_, err = readTestWebSocket(conn1)
+	assert.Error(err)
+
+	assert.NoError(conn3.Write(stdctx.Background(), websocket.MessageText, []byte("b")))
+	m, err = readTestWebSocket(conn3)
+	assert.NoError(err)
+	assert.Equal("b", m)
+
+	assert.Equal(uint64(1), proxy.mainPool.status().WebSocket.DroppedMessages)
+	conn2.Close(websocket.StatusNormalClosure, "")
+	conn3.Close(websocket.StatusNormalClosure, "")
+}
+
+func TestWebSocketMessagesPipeline(t *testing.T) {
+	assert := assert.New(t)
+
+	handler := &contexttest.MockedHandler{
+		MockedHandle: func(ctx *context.Context) string {
+			req := ctx.GetInputRequest().(*httpprot.Request)
+			resp := ctx.GetOutputResponse().(*httpprot.Response)
+			payload := string(req.RawPayload())
+			switch {
+			case payload == "drop":
+				resp.SetStatusCode(http.StatusForbidden)
+			case req.HTTPHeader().Get(wsDirectionHeader) == "client":
+				req.SetPayload(strings.ToUpper(payload))
+			default:
+				resp.SetPayload(payload + " from " + req.HTTPHeader().Get(wsMessageTypeHeader))
+			}
+			return ""
+		},
+	}
+	getPipeline := fnGetPipeline
+	fnGetPipeline = func(super *supervisor.Supervisor, name string) (context.Handler, bool) {
+		return handler, name == "message-pipeline"
+	}
+	defer func() {
+		fnGetPipeline = getPipeline
+	}()
+
+	_, front, closeAll := newTestWebSocketServers(`
+  messages:
+    clientPipeline: message-pipeline
+    serverPipeline: message-pipeline
+`, assert)
+	defer closeAll()
+
+	conn := dialTestWebSocket(front.URL, nil, assert)
+	assert.NoError(conn.Write(stdctx.Background(), websocket.MessageText, []byte("drop")))
+	assert.NoError(conn.Write(stdctx.Background(), websocket.MessageText, []byte("hello")))
+	m, err := readTestWebSocket(conn)
+	assert.NoError(err)
+	assert.Equal("HELLO from text", m)
+	conn.Close(websocket.StatusNormalClosure, "")
+}
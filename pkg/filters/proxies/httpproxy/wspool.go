@@ -28,6 +28,7 @@ import (
 	"github.com/megaease/easegress/v2/pkg/context"
 	"github.com/megaease/easegress/v2/pkg/filters/proxies"
 	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols"
 	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
 	"github.com/megaease/easegress/v2/pkg/protocols/httpprot/httpstat"
 	"github.com/megaease/easegress/v2/pkg/util/fasttime"
@@ -43,6 +44,8 @@ type WebSocketServerPool struct {
 	spec          *WebSocketServerPoolSpec
 	httpStat      *httpstat.HTTPStat
 	healthChecker proxies.HealthChecker
+	messages      *wsMessageProcessor
+	wsStat        wsStat
 }
 
 // WebSocketServerPoolSpec is the spec for a server pool.
@@ -55,6 +58,20 @@ type WebSocketServerPoolSpec struct {
 	OriginPatterns     []string            `json:"originPatterns,omitempty"`
 
 	HealthCheck *WSProxyHealthCheckSpec `json:"healthCheck,omitempty"`
+	Messages    *WebSocketMessageSpec   `json:"messages,omitempty"`
+}
+
+// Validate validates WebSocketServerPoolSpec.
+func (spec *WebSocketServerPoolSpec) Validate() error {
+	if err := spec.BaseServerPoolSpec.Validate(); err != nil {
+		return err
+	}
+	if spec.Messages != nil {
+		if err := spec.Messages.Validate(); err != nil {
+			return fmt.Errorf("messages: %v", err)
+		}
+	}
+	return nil
 }
 
 // NewWebSocketServerPool creates a new server pool according to spec.
@@ -68,6 +85,9 @@ func NewWebSocketServerPool(proxy *WebSocketProxy, spec *WebSocketServerPoolSpec
 	if spec.Filter != nil {
 		sp.filter = NewRequestMatcher(spec.Filter)
 	}
+	if spec.Messages != nil {
+		sp.messages = newWSMessageProcessor(spec.Messages, proxy.super)
+	}
 	sp.Init(sp, proxy.super, name, &spec.BaseServerPoolSpec)
 	return sp
 }
@@ -75,6 +95,7 @@ func NewWebSocketServerPool(proxy *WebSocketProxy, spec *WebSocketServerPoolSpec
 // CreateLoadBalancer creates a load balancer according to spec.
 func (sp *WebSocketServerPool) CreateLoadBalancer(spec *LoadBalanceSpec, servers []*Server) LoadBalancer {
 	lb := proxies.NewGeneralLoadBalancer(spec, servers)
+	lb.SetLocalLabels(sp.LocalLabels())
 	lb.Init(proxies.NewHTTPSessionSticker, sp.healthChecker, nil)
 	return lb
 }
@@ -186,7 +207,8 @@ func (sp *WebSocketServerPool) dialServer(svr *Server, req *httpprot.Request) (*
 
 func (sp *WebSocketServerPool) handle(ctx *context.Context) (result string) {
 	req := ctx.GetInputRequest().(*httpprot.Request)
-	svr := sp.LoadBalancer().ChooseServer(req)
+	lb := sp.LoadBalancer()
+	svr := lb.ChooseServer(req)
 
 	metric := &httpstat.Metric{}
 	startTime := fasttime.Now()
@@ -203,6 +225,13 @@ func (sp *WebSocketServerPool) handle(ctx *context.Context) (result string) {
 		return resultInternalError
 	}
 
+	// the server is returned after the connection is closed, svrResp is
+	// nil if the server failed to serve the request.
+	var svrResp protocols.Response
+	defer func() {
+		lb.ReturnServer(svr, req, svrResp)
+	}()
+
 	stdw, _ := ctx.GetData("HTTP_RESPONSE_WRITER").(http.ResponseWriter)
 	if stdw == nil {
 		logger.Errorf("%s: cannot get response writer from context", sp.Name)
@@ -223,6 +252,7 @@ func (sp *WebSocketServerPool) handle(ctx *context.Context) (result string) {
 	if err != nil {
 		logger.Errorf("%s: failed to establish client connection: %v", sp.Name, err)
 		sp.buildFailureResponse(ctx, http.StatusBadRequest)
+		svrResp = ctx.GetOutputResponse()
 		metric.StatusCode = http.StatusBadRequest
 		return resultClientError
 	}
@@ -239,6 +269,9 @@ func (sp *WebSocketServerPool) handle(ctx *context.Context) (result string) {
 		return resultServerError
 	}
 
+	conn := sp.newConnection(req)
+	defer conn.close()
+
 	var wg sync.WaitGroup
 	wg.Add(2)
 
@@ -258,6 +291,15 @@ func (sp *WebSocketServerPool) handle(ctx *context.Context) (result string) {
 				}
 				break
 			}
+			m, ok, v := conn.process(true, t, m)
+			if v != nil {
+				clntConn.Close(v.code, v.reason)
+				svrConn.Close(websocket.StatusNormalClosure, "")
+				break
+			}
+			if !ok {
+				continue
+			}
 			err = svrConn.Write(stdctx.Background(), t, m)
 			if err != nil {
 				if cs := websocket.CloseStatus(err); cs == websocket.StatusNormalClosure {
@@ -286,6 +328,15 @@ func (sp *WebSocketServerPool) handle(ctx *context.Context) (result string) {
 				}
 				break
 			}
+			m, ok, v := conn.process(false, t, m)
+			if v != nil {
+				clntConn.Close(v.code, v.reason)
+				svrConn.Close(websocket.StatusNormalClosure, "")
+				break
+			}
+			if !ok {
+				continue
+			}
 			err = clntConn.Write(stdctx.Background(), t, m)
 			if err != nil {
 				if cs := websocket.CloseStatus(err); cs == websocket.StatusNormalClosure {
@@ -311,8 +362,10 @@ func (sp *WebSocketServerPool) handle(ctx *context.Context) (result string) {
 
 	wg.Wait()
 	close(stop)
+	ctx.AddTag(conn.tag())
 
 	sp.buildSuccessResponse(ctx, resp)
+	svrResp = ctx.GetOutputResponse()
 	metric.StatusCode = http.StatusSwitchingProtocols
 	ctx.SetData("HTTP_METRIC", metric)
 	return
@@ -320,6 +373,8 @@ func (sp *WebSocketServerPool) handle(ctx *context.Context) (result string) {
 
 func (sp *WebSocketServerPool) status() *ServerPoolStatus {
 	return &ServerPoolStatus{
-		Stat: sp.httpStat.Status(),
+		Stat:             sp.httpStat.Status(),
+		OutlierDetection: sp.OutlierDetectionStatus(),
+		WebSocket:        sp.wsStat.status(),
 	}
 }
@@ -0,0 +1,324 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package l4proxy provides the server pool of layer 4 proxies, which is
+// shared by the TCPServer and the UDPServer.
+package l4proxy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/filters/proxies"
+	"github.com/megaease/easegress/v2/pkg/protocols"
+	"github.com/megaease/easegress/v2/pkg/supervisor"
+)
+
+const defaultConnectTimeout = 5 * time.Second
+
+type (
+	// Server is the backend server.
+	Server = proxies.Server
+	// LoadBalancer is the load balancer.
+	LoadBalancer = proxies.LoadBalancer
+	// LoadBalanceSpec is the spec of a load balancer.
+	LoadBalanceSpec = proxies.LoadBalanceSpec
+	// BaseServerPool is the base of a server pool.
+	BaseServerPool = proxies.ServerPoolBase
+	// BaseServerPoolSpec is the spec of BaseServerPool.
+	BaseServerPoolSpec = proxies.ServerPoolBaseSpec
+)
+
+// supportedPolicies is the load balance policies which do not depend on
+// the content of requests.
+var supportedPolicies = map[string]struct{}{
+	"":                                      {},
+	proxies.LoadBalancePolicyRoundRobin:     {},
+	proxies.LoadBalancePolicyRandom:         {},
+	proxies.LoadBalancePolicyWeightedRandom: {},
+	proxies.LoadBalancePolicyIPHash:         {},
+	proxies.LoadBalancePolicyLeastConn:      {},
+}
+
+// ServerPoolSpec is the spec of a layer 4 server pool. Servers are in
+// the format of scheme://host:port, the scheme is ignored, and the port
+// is required unless the scheme is http or https.
+type ServerPoolSpec struct {
+	BaseServerPoolSpec `json:",inline"`
+
+	// ConnectTimeout is the timeout of connecting to servers, default
+	// is 5s.
+	ConnectTimeout string                `json:"connectTimeout,omitempty" jsonschema:"format=duration"`
+	HealthCheck    *ProxyHealthCheckSpec `json:"healthCheck,omitempty"`
+}
+
+// ProxyHealthCheckSpec is the spec of layer 4 health check, a TCP connect
+// check is used if no probe is configured.
+type ProxyHealthCheckSpec struct {
+	proxies.HealthCheckSpec  `json:",inline"`
+	proxies.HealthProbesSpec `json:",inline"`
+}
+
+// ServerPoolStatus is the status of a server pool.
+type ServerPoolStatus struct {
+	OutlierDetection *proxies.OutlierDetectionStatus `json:"outlierDetection,omitempty"`
+}
+
+// Validate validates ServerPoolSpec.
+func (spec *ServerPoolSpec) Validate() error {
+	if err := spec.BaseServerPoolSpec.Validate(); err != nil {
+		return err
+	}
+
+	for _, svr := range spec.Servers {
+		if _, err := svr.Addr(0); err != nil {
+			return err
+		}
+	}
+
+	if spec.LoadBalance != nil {
+		if _, ok := supportedPolicies[spec.LoadBalance.Policy]; !ok {
+			return fmt.Errorf("unsupported load balance policy: %s", spec.LoadBalance.Policy)
+		}
+		if spec.LoadBalance.StickySession != nil {
+			return fmt.Errorf("sticky session is not supported")
+		}
+	}
+
+	if spec.ConnectTimeout != "" {
+		if _, err := time.ParseDuration(spec.ConnectTimeout); err != nil {
+			return err
+		}
+	}
+
+	if spec.ServiceName != "" && spec.HealthCheck != nil {
+		return fmt.Errorf("serviceName and healthCheck can't be set at the same time")
+	}
+	if spec.HealthCheck != nil {
+		return spec.HealthCheck.Validate()
+	}
+	return nil
+}
+
+// Validate validates ProxyHealthCheckSpec.
+func (spec *ProxyHealthCheckSpec) Validate() error {
+	return spec.HealthProbesSpec.Validate()
+}
+
+// ServerPool is a layer 4 server pool.
+type ServerPool struct {
+	BaseServerPool
+
+	spec           *ServerPoolSpec
+	connectTimeout time.Duration
+	healthChecker  proxies.HealthChecker
+}
+
+// NewServerPool creates a new server pool according to spec.
+func NewServerPool(super *supervisor.Supervisor, spec *ServerPoolSpec, name string) *ServerPool {
+	sp := &ServerPool{
+		spec:           spec,
+		connectTimeout: defaultConnectTimeout,
+	}
+
+	if spec.ConnectTimeout != "" {
+		sp.connectTimeout, _ = time.ParseDuration(spec.ConnectTimeout)
+	}
+
+	if hc := spec.HealthCheck; hc != nil {
+		var tcp proxies.HealthChecker
+		if hc.IsEmpty() {
+			tcp = proxies.NewTCPHealthChecker(hc.HealthCheckSpec, &proxies.TCPHealthCheckSpec{})
+		}
+		sp.healthChecker = hc.NewHealthChecker(hc.HealthCheckSpec, nil, tcp)
+	}
+
+	sp.BaseServerPool.Init(sp, super, name, &spec.BaseServerPoolSpec)
+	return sp
+}
+
+// CreateLoadBalancer creates a load balancer according to spec.
+func (sp *ServerPool) CreateLoadBalancer(spec *LoadBalanceSpec, servers []*Server) LoadBalancer {
+	lb := proxies.NewGeneralLoadBalancer(spec, servers)
+	lb.SetLocalLabels(sp.LocalLabels())
+	lb.Init(nil, sp.healthChecker, nil)
+	return lb
+}
+
+// Status returns the status of the server pool.
+func (sp *ServerPool) Status() *ServerPoolStatus {
+	return &ServerPoolStatus{
+		OutlierDetection: sp.OutlierDetectionStatus(),
+	}
+}
+
+// Dial chooses a server for the client and connects to it with network,
+// the server is returned to the load balancer when the connection is
+// closed.
+func (sp *ServerPool) Dial(network string, clientIP string) (*Conn, error) {
+	lb := sp.LoadBalancer()
+	req := &request{realIP: clientIP}
+	svr := lb.ChooseServer(req)
+	if svr == nil {
+		return nil, fmt.Errorf("%s: no available server", sp.Name)
+	}
+
+	addr, err := svr.Addr(0)
+	if err != nil {
+		lb.ReturnServer(svr, req, nil)
+		return nil, err
+	}
+
+	conn, err := net.DialTimeout(network, addr, sp.connectTimeout)
+	if err != nil {
+		lb.ReturnServer(svr, req, nil)
+		return nil, err
+	}
+
+	return &Conn{Conn: conn, Server: svr, lb: lb, req: req}, nil
+}
+
+// Conn is a connection to a server of a server pool.
+type Conn struct {
+	net.Conn
+	Server *Server
+
+	lb   LoadBalancer
+	req  *request
+	once sync.Once
+}
+
+// Close closes the connection and returns the server to the load balancer.
+func (c *Conn) Close() error {
+	c.once.Do(func() {
+		c.lb.ReturnServer(c.Server, c.req, &response{})
+	})
+	return c.Conn.Close()
+}
+
+// CloseWrite shuts down the writing side of the connection, the
+// connection is closed if it does not support half close.
+func (c *Conn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Close()
+}
+
+// request is the request of a connection, it is used by load balance
+// policies only.
+type request struct {
+	realIP string
+}
+
+var _ protocols.Request = (*request)(nil)
+
+// Header returns nil as there is no header.
+func (r *request) Header() protocols.Header {
+	return nil
+}
+
+// RealIP returns the IP address of the client.
+func (r *request) RealIP() string {
+	return r.realIP
+}
+
+// IsStream returns true.
+func (r *request) IsStream() bool {
+	return true
+}
+
+// SetPayload is not supported.
+func (r *request) SetPayload(payload interface{}) {
+	panic("not implemented")
+}
+
+// GetPayload returns an empty reader.
+func (r *request) GetPayload() io.Reader {
+	return http.NoBody
+}
+
+// RawPayload is not supported.
+func (r *request) RawPayload() []byte {
+	panic("not implemented")
+}
+
+// PayloadSize returns 0.
+func (r *request) PayloadSize() int64 {
+	return 0
+}
+
+// ToBuilderRequest is not supported.
+func (r *request) ToBuilderRequest(name string) interface{} {
+	panic("not implemented")
+}
+
+// Close closes the request.
+func (r *request) Close() {}
+
+// response is the response of a successful connection, it is used by
+// load balancers to tell success from failure.
+type response struct{}
+
+var _ protocols.Response = (*response)(nil)
+
+// Header returns nil as there is no header.
+func (r *response) Header() protocols.Header {
+	return nil
+}
+
+// Trailer returns nil as there is no trailer.
+func (r *response) Trailer() protocols.Trailer {
+	return nil
+}
+
+// IsStream returns true.
+func (r *response) IsStream() bool {
+	return true
+}
+
+// SetPayload is not supported.
+func (r *response) SetPayload(payload interface{}) {
+	panic("not implemented")
+}
+
+// GetPayload returns an empty reader.
+func (r *response) GetPayload() io.Reader {
+	return http.NoBody
+}
+
+// RawPayload is not supported.
+func (r *response) RawPayload() []byte {
+	panic("not implemented")
+}
+
+// PayloadSize returns 0.
+func (r *response) PayloadSize() int64 {
+	return 0
+}
+
+// ToBuilderResponse is not supported.
+func (r *response) ToBuilderResponse(name string) interface{} {
+	panic("not implemented")
+}
+
+// Close closes the response.
+func (r *response) Close() {}
@@ -0,0 +1,114 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package l4proxy
+
+import (
+	"net"
+	"testing"
+
+	"github.com/megaease/easegress/v2/pkg/filters/proxies"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerPoolSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &ServerPoolSpec{}
+	assert.Error(spec.Validate())
+
+	spec.Servers = []*Server{{URL: "tcp://127.0.0.1"}}
+	assert.Error(spec.Validate())
+
+	spec.Servers[0].URL = "tcp://127.0.0.1:5432"
+	assert.NoError(spec.Validate())
+
+	spec.ConnectTimeout = "abc"
+	assert.Error(spec.Validate())
+	spec.ConnectTimeout = "1s"
+	assert.NoError(spec.Validate())
+
+	spec.LoadBalance = &LoadBalanceSpec{Policy: proxies.LoadBalancePolicyHeaderHash}
+	assert.Error(spec.Validate())
+	spec.LoadBalance.Policy = proxies.LoadBalancePolicyIPHash
+	assert.NoError(spec.Validate())
+	spec.LoadBalance.StickySession = &proxies.StickySessionSpec{}
+	assert.Error(spec.Validate())
+	spec.LoadBalance.StickySession = nil
+
+	spec.HealthCheck = &ProxyHealthCheckSpec{}
+	assert.NoError(spec.Validate())
+	spec.HealthCheck.Mode = "xor"
+	assert.Error(spec.Validate())
+	spec.HealthCheck.Mode = ""
+
+	spec.ServiceName = "demo"
+	assert.Error(spec.Validate())
+}
+
+func TestServerPoolDial(t *testing.T) {
+	assert := assert.New(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("hello"))
+			conn.Close()
+		}
+	}()
+
+	spec := &ServerPoolSpec{
+		BaseServerPoolSpec: BaseServerPoolSpec{
+			Servers: []*Server{{URL: "tcp://" + ln.Addr().String()}},
+			LoadBalance: &LoadBalanceSpec{
+				Policy: proxies.LoadBalancePolicyLeastConn,
+			},
+		},
+	}
+	assert.NoError(spec.Validate())
+
+	sp := NewServerPool(nil, spec, "test")
+	defer sp.Close()
+
+	conn, err := sp.Dial("tcp", "192.168.1.1")
+	assert.NoError(err)
+	assert.Equal(spec.Servers[0], conn.Server)
+	buf := make([]byte, 5)
+	_, err = conn.Read(buf)
+	assert.NoError(err)
+	assert.Equal("hello", string(buf))
+	assert.NoError(conn.Close())
+	conn.Close()
+	assert.NotNil(sp.Status())
+
+	spec.Servers[0].URL = "tcp://127.0.0.1:1"
+	sp2 := NewServerPool(nil, spec, "test2")
+	defer sp2.Close()
+	_, err = sp2.Dial("tcp", "192.168.1.1")
+	assert.Error(err)
+
+	sp3 := NewServerPool(nil, &ServerPoolSpec{}, "test3")
+	defer sp3.Close()
+	_, err = sp3.Dial("tcp", "192.168.1.1")
+	assert.Error(err)
+}
@@ -0,0 +1,239 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxies
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/protocols"
+	"github.com/megaease/easegress/v2/pkg/util/fasttime"
+)
+
+const (
+	// defaultEWMADecay is the default decay time of the EWMA latency.
+	defaultEWMADecay = 10 * time.Second
+
+	// ewmaPenalty is the cost of a server which has in-flight requests
+	// but no latency observed yet, it makes such servers less likely to
+	// be chosen until their latency is known.
+	ewmaPenalty = float64(math.MaxInt64 >> 16)
+)
+
+// LoadAwarePolicy is a load balance policy which chooses servers by their
+// runtime load, it is notified when a server is chosen and when the server
+// is returned.
+type LoadAwarePolicy interface {
+	LoadBalancePolicy
+	OnChoose(svr *Server, req protocols.Request)
+	OnReturn(svr *Server, req protocols.Request, resp protocols.Response)
+}
+
+// serverLoad is the runtime load statistics of a server.
+type serverLoad struct {
+	inflight atomic.Int64
+
+	// startedAt is the time in unix nano when the server becomes available,
+	// zero means the server is not in slow start.
+	startedAt atomic.Int64
+
+	lock      sync.Mutex
+	ewma      float64 // EWMA of latency in nanoseconds
+	updatedAt time.Time
+}
+
+// observe updates the peak EWMA latency with rtt. Latency peaks are taken
+// immediately, while decreases are smoothed according to decay.
+func (sl *serverLoad) observe(rtt time.Duration, decay time.Duration) {
+	now := fasttime.Now()
+
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	v := float64(rtt)
+	if v > sl.ewma || sl.updatedAt.IsZero() {
+		sl.ewma = v
+	} else {
+		w := math.Exp(-float64(now.Sub(sl.updatedAt)) / float64(decay))
+		sl.ewma = sl.ewma*w + v*(1-w)
+	}
+	sl.updatedAt = now
+}
+
+// cost returns the peak EWMA cost of the server, which is the decayed
+// latency multiplied by the number of in-flight requests plus one.
+func (sl *serverLoad) cost(decay time.Duration) float64 {
+	inflight := float64(sl.inflight.Load())
+
+	sl.lock.Lock()
+	ewma, updatedAt := sl.ewma, sl.updatedAt
+	sl.lock.Unlock()
+
+	if ewma == 0 {
+		if inflight == 0 {
+			return 0
+		}
+		return ewmaPenalty + inflight
+	}
+
+	// decay the latency towards zero while there's no new observation, so
+	// that a server which was slow has a chance to be chosen again.
+	elapsed := fasttime.Since(updatedAt)
+	ewma *= math.Exp(-float64(elapsed) / float64(decay))
+
+	return ewma * (inflight + 1)
+}
+
+// serverWeight returns the weight of the server, servers without weight
+// are treated as weight 1.
+func serverWeight(svr *Server) int {
+	if svr.Weight <= 0 {
+		return 1
+	}
+	return svr.Weight
+}
+
+// LeastConnLoadBalancePolicy is a load balance policy that chooses the
+// server with the least in-flight requests, weights are taken into account.
+type LeastConnLoadBalancePolicy struct{}
+
+// ChooseServer chooses the server with the least in-flight requests.
+func (lbp *LeastConnLoadBalancePolicy) ChooseServer(req protocols.Request, sg *ServerGroup) *Server {
+	n := len(sg.Servers)
+
+	// start from a random position, so that servers with the same load
+	// have the same chance to be chosen.
+	start := rand.Intn(n)
+
+	var chosen *Server
+	minLoad := math.MaxFloat64
+	for i := 0; i < n; i++ {
+		svr := sg.Servers[(start+i)%n]
+		load := float64(svr.load.inflight.Load()+1) / sg.weight(svr)
+		if load < minLoad {
+			chosen, minLoad = svr, load
+		}
+	}
+
+	return chosen
+}
+
+// OnChoose increases the in-flight requests of the server.
+func (lbp *LeastConnLoadBalancePolicy) OnChoose(svr *Server, req protocols.Request) {
+	svr.load.inflight.Add(1)
+}
+
+// OnReturn decreases the in-flight requests of the server.
+func (lbp *LeastConnLoadBalancePolicy) OnReturn(svr *Server, req protocols.Request, resp protocols.Response) {
+	svr.load.inflight.Add(-1)
+}
+
+// ewmaRequestKey is the key to record the start times of a request, a
+// request could be sent to the same server more than once, even
+// concurrently when it is hedged.
+type ewmaRequestKey struct {
+	svr *Server
+	req protocols.Request
+}
+
+// EWMALoadBalancePolicy is a load balance policy that chooses a server by
+// peak EWMA (exponentially weighted moving average) latency. It picks two
+// servers randomly and chooses the one with the lower cost, which is the
+// latency multiplied by the number of in-flight requests.
+type EWMALoadBalancePolicy struct {
+	decay time.Duration
+
+	// starts records the start times of in-flight requests, the start
+	// times of the same key are in the order of OnChoose, and an entry
+	// is deleted once all its requests are returned.
+	lock   sync.Mutex
+	starts map[ewmaRequestKey][]time.Time
+}
+
+// NewEWMALoadBalancePolicy creates a new EWMALoadBalancePolicy.
+func NewEWMALoadBalancePolicy(spec *LoadBalanceSpec) *EWMALoadBalancePolicy {
+	decay, _ := time.ParseDuration(spec.EWMADecay)
+	if decay <= 0 {
+		decay = defaultEWMADecay
+	}
+	return &EWMALoadBalancePolicy{
+		decay:  decay,
+		starts: map[ewmaRequestKey][]time.Time{},
+	}
+}
+
+// ChooseServer chooses a server by the power of two choices.
+func (lbp *EWMALoadBalancePolicy) ChooseServer(req protocols.Request, sg *ServerGroup) *Server {
+	n := len(sg.Servers)
+	if n == 1 {
+		return sg.Servers[0]
+	}
+
+	i := rand.Intn(n)
+	j := rand.Intn(n - 1)
+	if j >= i {
+		j++
+	}
+
+	s1, s2 := sg.Servers[i], sg.Servers[j]
+	c1 := s1.load.cost(lbp.decay) / sg.weight(s1)
+	c2 := s2.load.cost(lbp.decay) / sg.weight(s2)
+	if c2 < c1 {
+		return s2
+	}
+	return s1
+}
+
+// OnChoose increases the in-flight requests of the server and records
+// the start time of the request.
+func (lbp *EWMALoadBalancePolicy) OnChoose(svr *Server, req protocols.Request) {
+	svr.load.inflight.Add(1)
+
+	key := ewmaRequestKey{svr: svr, req: req}
+	lbp.lock.Lock()
+	lbp.starts[key] = append(lbp.starts[key], fasttime.Now())
+	lbp.lock.Unlock()
+}
+
+// OnReturn decreases the in-flight requests of the server and updates its
+// latency.
+func (lbp *EWMALoadBalancePolicy) OnReturn(svr *Server, req protocols.Request, resp protocols.Response) {
+	svr.load.inflight.Add(-1)
+
+	// the earliest request is returned first, which is the best guess as
+	// the requests of the same key can't be told apart.
+	key := ewmaRequestKey{svr: svr, req: req}
+	lbp.lock.Lock()
+	starts := lbp.starts[key]
+	if len(starts) == 0 {
+		lbp.lock.Unlock()
+		return
+	}
+	start := starts[0]
+	if len(starts) == 1 {
+		delete(lbp.starts, key)
+	} else {
+		lbp.starts[key] = starts[1:]
+	}
+	lbp.lock.Unlock()
+
+	svr.load.observe(fasttime.Since(start), lbp.decay)
+}
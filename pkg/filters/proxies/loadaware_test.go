@@ -0,0 +1,148 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxies
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeastConnLoadBalancePolicy(t *testing.T) {
+	assert := assert.New(t)
+	servers := prepareServers(3)
+	for _, s := range servers {
+		s.Weight = 0
+	}
+
+	lb := NewGeneralLoadBalancer(&LoadBalanceSpec{Policy: LoadBalancePolicyLeastConn}, servers)
+	lb.Init(nil, nil, nil)
+
+	// requests are not returned, so they should be evenly distributed.
+	counter := map[*Server]int{}
+	for i := 0; i < 30; i++ {
+		counter[lb.ChooseServer(nil)]++
+	}
+	for _, s := range servers {
+		assert.Equal(10, counter[s])
+		assert.Equal(int64(10), s.load.inflight.Load())
+	}
+
+	// return all requests of servers[1], it should be chosen next.
+	for i := 0; i < 10; i++ {
+		lb.ReturnServer(servers[1], nil, nil)
+	}
+	assert.Same(servers[1], lb.ChooseServer(nil))
+
+	// weighted
+	servers = prepareServers(2)
+	lb = NewGeneralLoadBalancer(&LoadBalanceSpec{Policy: LoadBalancePolicyLeastConn}, servers)
+	lb.Init(nil, nil, nil)
+	counter = map[*Server]int{}
+	for i := 0; i < 30; i++ {
+		counter[lb.ChooseServer(nil)]++
+	}
+	assert.InDelta(10, counter[servers[0]], 1)
+	assert.InDelta(20, counter[servers[1]], 1)
+}
+
+func TestEWMALoadBalancePolicy(t *testing.T) {
+	assert := assert.New(t)
+	servers := prepareServers(2)
+	for _, s := range servers {
+		s.Weight = 0
+	}
+
+	spec := &LoadBalanceSpec{Policy: LoadBalancePolicyEWMA, EWMADecay: "1h"}
+	lb := NewGeneralLoadBalancer(spec, servers)
+	lb.Init(nil, nil, nil)
+	assert.Equal(time.Hour, lb.lbp.(*EWMALoadBalancePolicy).decay)
+
+	servers[0].load.observe(100*time.Millisecond, time.Hour)
+	servers[1].load.observe(time.Millisecond, time.Hour)
+
+	for i := 0; i < 10; i++ {
+		req, _ := httpprot.NewRequest(&http.Request{Header: http.Header{}})
+		svr := lb.ChooseServer(req)
+		assert.Same(servers[1], svr)
+		lb.ReturnServer(svr, req, nil)
+	}
+	assert.Equal(int64(0), servers[1].load.inflight.Load())
+
+	// the same request is sent to the same server twice, both start
+	// times are kept, and the entry is deleted after both are returned.
+	lbp := lb.lbp.(*EWMALoadBalancePolicy)
+	assert.Empty(lbp.starts)
+	req, _ := httpprot.NewRequest(&http.Request{Header: http.Header{}})
+	lbp.OnChoose(servers[1], req)
+	lbp.OnChoose(servers[1], req)
+	assert.Len(lbp.starts[ewmaRequestKey{svr: servers[1], req: req}], 2)
+	lbp.OnReturn(servers[1], req, nil)
+	assert.Len(lbp.starts[ewmaRequestKey{svr: servers[1], req: req}], 1)
+	lbp.OnReturn(servers[1], req, nil)
+	assert.Empty(lbp.starts)
+	assert.Equal(int64(0), servers[1].load.inflight.Load())
+
+	// a server with in-flight requests but without latency is penalized.
+	sl := &serverLoad{}
+	assert.Equal(0.0, sl.cost(time.Hour))
+	sl.inflight.Add(1)
+	assert.Greater(sl.cost(time.Hour), servers[0].load.cost(time.Hour))
+
+	// peak is taken immediately, decrease is smoothed.
+	sl.observe(10*time.Millisecond, time.Hour)
+	sl.observe(time.Second, time.Hour)
+	assert.Equal(float64(time.Second), sl.ewma)
+	sl.observe(time.Millisecond, time.Hour)
+	assert.Greater(sl.ewma, float64(time.Millisecond))
+
+	// default decay
+	lb = NewGeneralLoadBalancer(&LoadBalanceSpec{Policy: LoadBalancePolicyEWMA}, prepareServers(1))
+	lb.Init(nil, nil, nil)
+	assert.Equal(defaultEWMADecay, lb.lbp.(*EWMALoadBalancePolicy).decay)
+	assert.NotNil(lb.ChooseServer(nil))
+}
+
+func TestLoadAwarePolicyWithStickySession(t *testing.T) {
+	assert := assert.New(t)
+	servers := prepareServers(3)
+	spec := &LoadBalanceSpec{
+		Policy: LoadBalancePolicyLeastConn,
+		StickySession: &StickySessionSpec{
+			Mode: StickySessionModeDurationBased,
+		},
+	}
+	lb := NewGeneralLoadBalancer(spec, servers)
+	lb.Init(NewHTTPSessionSticker, nil, nil)
+
+	for i := 0; i < 10; i++ {
+		req, _ := httpprot.NewRequest(&http.Request{Header: http.Header{}})
+		svr := lb.ChooseServer(req)
+		// failed requests are returned with a nil response, which must
+		// not be passed to the session sticker.
+		assert.NotPanics(func() { lb.ReturnServer(svr, req, nil) }, fmt.Sprintf("request %d", i))
+	}
+
+	for _, s := range servers {
+		assert.Equal(int64(0), s.load.inflight.Load())
+	}
+}
@@ -21,11 +21,13 @@ import (
 	"fmt"
 	"hash/fnv"
 	"math/rand"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/megaease/easegress/v2/pkg/logger"
 	"github.com/megaease/easegress/v2/pkg/protocols"
+	"github.com/megaease/easegress/v2/pkg/util/fasttime"
 )
 
 const (
@@ -42,9 +44,19 @@ const (
 	// LoadBalancePolicyCookieHash is the load balance policy of HTTP cookie hash,
 	// which is the shorthand of headerHash with hash key Set-Cookie.
 	LoadBalancePolicyCookieHash = "cookieHash"
+	// LoadBalancePolicyLeastConn is the load balance policy of least connections.
+	LoadBalancePolicyLeastConn = "leastConn"
+	// LoadBalancePolicyEWMA is the load balance policy of peak EWMA latency.
+	LoadBalancePolicyEWMA = "ewma"
+	// LoadBalancePolicyConsistentHash is the load balance policy of consistent hash.
+	LoadBalancePolicyConsistentHash = "consistentHash"
 )
 
 // LoadBalancer is the interface of a load balancer.
+//
+// Every server returned by ChooseServer must be returned by ReturnServer
+// once the request completes, resp is nil if the request failed before a
+// response was received.
 type LoadBalancer interface {
 	ChooseServer(req protocols.Request) *Server
 	ReturnServer(server *Server, req protocols.Request, resp protocols.Response)
@@ -61,11 +73,47 @@ type LoadBalanceSpec struct {
 	HeaderHashKey string             `json:"headerHashKey,omitempty"`
 	ForwardKey    string             `json:"forwardKey,omitempty"`
 	StickySession *StickySessionSpec `json:"stickySession,omitempty"`
+	// ConsistentHash is the spec of the consistentHash policy.
+	ConsistentHash *ConsistentHashSpec `json:"consistentHash,omitempty"`
+	// OutlierDetection is the spec of outlier detection (passive health check).
+	OutlierDetection *OutlierDetectionSpec `json:"outlierDetection,omitempty"`
+	// SlowStart is the spec of slow start of weightedRandom, leastConn
+	// and ewma, the ring weights of consistentHash are not affected.
+	SlowStart *SlowStartSpec `json:"slowStart,omitempty"`
+	// Locality is the spec of locality aware routing.
+	Locality *LocalitySpec `json:"locality,omitempty"`
+	// EWMADecay is the decay time of the latency in ewma policy, default is 10s.
+	EWMADecay string `json:"ewmaDecay,omitempty" jsonschema:"format=duration"`
 	// Deprecated: HealthCheck is protocol related. It should be moved to protocol spec.
 	// This one is kept for backward compatibility.
 	HealthCheck *HealthCheckSpec `json:"healthCheck,omitempty"`
 }
 
+// Validate validates the LoadBalanceSpec.
+func (spec *LoadBalanceSpec) Validate() error {
+	if spec.ConsistentHash != nil {
+		if err := spec.ConsistentHash.Validate(); err != nil {
+			return fmt.Errorf("invalid consistentHash: %v", err)
+		}
+	}
+	if spec.OutlierDetection != nil {
+		if err := spec.OutlierDetection.Validate(); err != nil {
+			return fmt.Errorf("invalid outlierDetection: %v", err)
+		}
+	}
+	if spec.SlowStart != nil {
+		if err := spec.SlowStart.Validate(); err != nil {
+			return fmt.Errorf("invalid slowStart: %v", err)
+		}
+	}
+	if spec.Locality != nil {
+		if err := spec.Locality.Validate(); err != nil {
+			return fmt.Errorf("invalid locality: %v", err)
+		}
+	}
+	return nil
+}
+
 // LoadBalancePolicy is the interface of a load balance policy.
 type LoadBalancePolicy interface {
 	ChooseServer(req protocols.Request, sg *ServerGroup) *Server
@@ -76,25 +124,61 @@ type GeneralLoadBalancer struct {
 	spec           *LoadBalanceSpec
 	servers        []*Server
 	healthyServers atomic.Pointer[ServerGroup]
+	lock           sync.Mutex
 
 	done chan struct{}
 
-	lbp    LoadBalancePolicy
-	ss     SessionSticker
-	hc     HealthChecker
-	hcSpec *HealthCheckSpec
+	lbp      LoadBalancePolicy
+	lap      LoadAwarePolicy
+	ss       SessionSticker
+	hc       HealthChecker
+	hcSpec   *HealthCheckSpec
+	od       *outlierDetector
+	listener OutlierListener
+
+	slowStart   *slowStart
+	localLabels map[string]string
+	locality    *locality
 }
 
 // NewGeneralLoadBalancer creates a new GeneralLoadBalancer.
 func NewGeneralLoadBalancer(spec *LoadBalanceSpec, servers []*Server) *GeneralLoadBalancer {
 	lb := &GeneralLoadBalancer{
-		spec:    spec,
-		servers: servers,
+		spec:      spec,
+		servers:   servers,
+		slowStart: newSlowStart(spec.SlowStart),
 	}
-	lb.healthyServers.Store(newServerGroup(servers))
+	lb.healthyServers.Store(lb.newServerGroup(servers))
 	return lb
 }
 
+func (glb *GeneralLoadBalancer) newServerGroup(servers []*Server) *ServerGroup {
+	sg := newServerGroup(servers)
+	sg.slowStart = glb.slowStart
+	if glb.locality != nil {
+		sg.priorities = glb.locality.build(servers, glb.servers)
+		if sg.priorities != nil {
+			for _, g := range sg.priorities.groups {
+				g.slowStart = glb.slowStart
+				g.parent = sg
+			}
+		}
+	}
+	return sg
+}
+
+// SetOutlierListener sets the listener of outlier detection events, it
+// must be called before Init.
+func (glb *GeneralLoadBalancer) SetOutlierListener(listener OutlierListener) {
+	glb.listener = listener
+}
+
+// SetLocalLabels sets the labels of the Easegress member, which are used by
+// locality aware routing, it must be called before Init.
+func (glb *GeneralLoadBalancer) SetLocalLabels(labels map[string]string) {
+	glb.localLabels = labels
+}
+
 // Init initializes the load balancer.
 func (glb *GeneralLoadBalancer) Init(
 	fnNewSessionSticker func(*StickySessionSpec) SessionSticker,
@@ -116,12 +200,19 @@ func (glb *GeneralLoadBalancer) Init(
 			lbp = &HeaderHashLoadBalancePolicy{spec: glb.spec}
 		case LoadBalancePolicyCookieHash:
 			lbp = &HeaderHashLoadBalancePolicy{spec: &LoadBalanceSpec{HeaderHashKey: "Cookie"}}
+		case LoadBalancePolicyLeastConn:
+			lbp = &LeastConnLoadBalancePolicy{}
+		case LoadBalancePolicyEWMA:
+			lbp = NewEWMALoadBalancePolicy(glb.spec)
+		case LoadBalancePolicyConsistentHash:
+			lbp = NewConsistentHashLoadBalancePolicy(glb.spec.ConsistentHash)
 		default:
 			logger.Errorf("unsupported load balancing policy: %s", glb.spec.Policy)
 			lbp = &RoundRobinLoadBalancePolicy{}
 		}
 	}
 	glb.lbp = lbp
+	glb.lap, _ = lbp.(LoadAwarePolicy)
 
 	// sticky session
 	if glb.spec.StickySession != nil {
@@ -130,6 +221,17 @@ func (glb *GeneralLoadBalancer) Init(
 		glb.ss = ss
 	}
 
+	// outlier detection
+	if glb.spec.OutlierDetection != nil {
+		glb.od = newOutlierDetector(glb.spec.OutlierDetection, glb.servers, glb.updateHealthyServers, glb.listener)
+	}
+
+	// locality aware routing
+	if glb.spec.Locality != nil {
+		glb.locality = newLocality(glb.spec.Locality, glb.localLabels)
+		glb.updateHealthyServers()
+	}
+
 	if hc == nil {
 		return
 	}
@@ -163,9 +265,10 @@ func (glb *GeneralLoadBalancer) Init(
 func (glb *GeneralLoadBalancer) checkServers() {
 	changed := false
 
-	servers := make([]*Server, 0, len(glb.servers))
 	for _, svr := range glb.servers {
 		succ := glb.hc.Check(svr)
+
+		glb.lock.Lock()
 		if succ {
 			if svr.HealthCounter < 0 {
 				svr.HealthCounter = 0
@@ -174,6 +277,7 @@ func (glb *GeneralLoadBalancer) checkServers() {
 			if svr.Unhealth && svr.HealthCounter >= glb.hcSpec.Passes {
 				logger.Warnf("server:%v becomes healthy.", svr.ID())
 				svr.Unhealth = false
+				svr.markStarted()
 				changed = true
 			}
 		} else {
@@ -187,22 +291,46 @@ func (glb *GeneralLoadBalancer) checkServers() {
 				changed = true
 			}
 		}
+		glb.lock.Unlock()
+	}
 
-		if svr.Healthy() {
-			servers = append(servers, svr)
-		}
+	if changed {
+		glb.updateHealthyServers()
 	}
+}
 
-	if !changed {
-		return
+// updateHealthyServers updates the healthy servers, which are servers
+// passed the health check and not ejected by outlier detection.
+func (glb *GeneralLoadBalancer) updateHealthyServers() {
+	glb.lock.Lock()
+	defer glb.lock.Unlock()
+
+	servers := make([]*Server, 0, len(glb.servers))
+	for _, svr := range glb.servers {
+		if !svr.Healthy() {
+			continue
+		}
+		if glb.od != nil && glb.od.isEjected(svr) {
+			continue
+		}
+		servers = append(servers, svr)
 	}
 
-	glb.healthyServers.Store(newServerGroup(servers))
+	glb.healthyServers.Store(glb.newServerGroup(servers))
 	if glb.ss != nil {
 		glb.ss.UpdateServers(servers)
 	}
 }
 
+// OutlierDetectionStatus returns the status of outlier detection, it
+// returns nil if outlier detection is not enabled.
+func (glb *GeneralLoadBalancer) OutlierDetectionStatus() *OutlierDetectionStatus {
+	if glb.od == nil {
+		return nil
+	}
+	return glb.od.status()
+}
+
 // ChooseServer chooses a server according to the load balancing spec.
 func (glb *GeneralLoadBalancer) ChooseServer(req protocols.Request) *Server {
 	sg := glb.healthyServers.Load()
@@ -210,18 +338,32 @@ func (glb *GeneralLoadBalancer) ChooseServer(req protocols.Request) *Server {
 		return nil
 	}
 
+	var svr *Server
 	if glb.ss != nil {
-		if svr := glb.ss.GetServer(req, sg); svr != nil {
-			return svr
+		svr = glb.ss.GetServer(req, sg)
+	}
+	if svr == nil {
+		if sg.priorities != nil {
+			sg = sg.priorities.choose()
 		}
+		svr = glb.lbp.ChooseServer(req, sg)
 	}
 
-	return glb.lbp.ChooseServer(req, sg)
+	if glb.lap != nil && svr != nil {
+		glb.lap.OnChoose(svr, req)
+	}
+	return svr
 }
 
 // ReturnServer returns a server to the load balancer.
 func (glb *GeneralLoadBalancer) ReturnServer(server *Server, req protocols.Request, resp protocols.Response) {
-	if glb.ss != nil {
+	if glb.lap != nil {
+		glb.lap.OnReturn(server, req, resp)
+	}
+	if glb.od != nil {
+		glb.od.record(server, resp)
+	}
+	if glb.ss != nil && resp != nil {
 		glb.ss.ReturnServer(server, req, resp)
 	}
 }
@@ -235,6 +377,9 @@ func (glb *GeneralLoadBalancer) Close() {
 	if glb.ss != nil {
 		glb.ss.Close()
 	}
+	if glb.od != nil {
+		glb.od.close()
+	}
 }
 
 // RandomLoadBalancePolicy is a load balance policy that chooses a server randomly.
@@ -261,6 +406,10 @@ type WeightedRandomLoadBalancePolicy struct{}
 
 // ChooseServer chooses a server randomly by weight.
 func (lbp *WeightedRandomLoadBalancePolicy) ChooseServer(req protocols.Request, sg *ServerGroup) *Server {
+	if sg.inSlowStart(fasttime.Now()) {
+		return lbp.chooseServerInSlowStart(sg)
+	}
+
 	w := rand.Intn(sg.TotalWeight)
 	for _, svr := range sg.Servers {
 		w -= svr.Weight
@@ -272,6 +421,28 @@ func (lbp *WeightedRandomLoadBalancePolicy) ChooseServer(req protocols.Request,
 	panic(fmt.Errorf("BUG: should not run to here, total weight=%d", sg.TotalWeight))
 }
 
+// chooseServerInSlowStart chooses a server randomly by the effective weight
+// when some servers are in slow start, the weight of a server is the same
+// as the one used when no server is in slow start, so servers with zero
+// weight still get no traffic.
+func (lbp *WeightedRandomLoadBalancePolicy) chooseServerInSlowStart(sg *ServerGroup) *Server {
+	weights := make([]float64, len(sg.Servers))
+	total := 0.0
+	for i, svr := range sg.Servers {
+		weights[i] = float64(svr.Weight) * sg.slowStartFactor(svr)
+		total += weights[i]
+	}
+
+	w := rand.Float64() * total
+	for i, svr := range sg.Servers {
+		w -= weights[i]
+		if w < 0 {
+			return svr
+		}
+	}
+	return sg.Servers[len(sg.Servers)-1]
+}
+
 // IPHashLoadBalancePolicy is a load balance policy that chooses a server by ip hash.
 type IPHashLoadBalancePolicy struct{}
 
@@ -0,0 +1,187 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxies
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/util/stringtool"
+)
+
+const (
+	// LocalityLabelZone is the default label key of zone.
+	LocalityLabelZone = "zone"
+	// LocalityLabelRegion is the default label key of region.
+	LocalityLabelRegion = "region"
+)
+
+// LocalitySpec is the spec of locality aware routing, which prefers servers
+// in the same locality as the Easegress member, and spills over to other
+// servers only when the local servers are not healthy enough.
+//
+// The locality is identified by the labels of servers and the labels of the
+// Easegress member. Servers are divided into priority levels according to
+// LabelKeys: servers matching all keys are in level 0, servers matching all
+// keys except the first one are in level 1, and so on, servers matching
+// none of the keys are in the last level.
+type LocalitySpec struct {
+	// LabelKeys is the label keys to match, from the finest to the
+	// coarsest, default is [zone, region].
+	LabelKeys []string `json:"labelKeys,omitempty" jsonschema:"uniqueItems=true"`
+	// OverprovisioningFactor is the overprovisioning factor in percentage,
+	// a level receives all its traffic while its healthy percentage
+	// multiplied by this factor is not less than 100. Default is 140.
+	OverprovisioningFactor int `json:"overprovisioningFactor,omitempty" jsonschema:"minimum=100"`
+}
+
+// Validate validates the LocalitySpec.
+func (spec *LocalitySpec) Validate() error {
+	for i, key := range spec.LabelKeys {
+		if key == "" {
+			return fmt.Errorf("label key must not be empty")
+		}
+		if stringtool.StrInSlice(key, spec.LabelKeys[i+1:]) {
+			return fmt.Errorf("duplicated label key: %s", key)
+		}
+	}
+	if spec.OverprovisioningFactor != 0 && spec.OverprovisioningFactor < 100 {
+		return fmt.Errorf("overprovisioningFactor must be at least 100")
+	}
+	return nil
+}
+
+// locality divides servers into priority levels.
+type locality struct {
+	keys        []string
+	localLabels map[string]string
+	factor      float64
+}
+
+func newLocality(spec *LocalitySpec, localLabels map[string]string) *locality {
+	if spec == nil {
+		return nil
+	}
+
+	if len(localLabels) == 0 {
+		logger.Warnf("locality aware routing is enabled, but the labels of the member is empty")
+	}
+
+	l := &locality{
+		keys:        spec.LabelKeys,
+		localLabels: localLabels,
+		factor:      float64(spec.OverprovisioningFactor) / 100,
+	}
+	if len(l.keys) == 0 {
+		l.keys = []string{LocalityLabelZone, LocalityLabelRegion}
+	}
+	if l.factor < 1 {
+		l.factor = 1.4
+	}
+	return l
+}
+
+// level returns the priority level of the server, 0 is the highest.
+func (l *locality) level(svr *Server) int {
+	for i := range l.keys {
+		if l.match(svr, l.keys[i:]) {
+			return i
+		}
+	}
+	return len(l.keys)
+}
+
+func (l *locality) match(svr *Server, keys []string) bool {
+	for _, key := range keys {
+		v := l.localLabels[key]
+		if v == "" || svr.Labels[key] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// build builds the priority levels of the healthy servers, all is all the
+// servers of the load balancer, which is used to calculate the healthy
+// percentage of the levels. It returns nil if all healthy servers should
+// be used.
+func (l *locality) build(healthy []*Server, all []*Server) *priorityLevels {
+	n := len(l.keys) + 1
+	totals := make([]int, n)
+	for _, svr := range all {
+		totals[l.level(svr)]++
+	}
+
+	servers := make([][]*Server, n)
+	for _, svr := range healthy {
+		lv := l.level(svr)
+		servers[lv] = append(servers[lv], svr)
+	}
+
+	// calculate the load of each level like Envoy does, a level takes the
+	// load according to its health, and the remaining load spills over to
+	// lower levels.
+	pl := &priorityLevels{}
+	remaining, totalHealth := 100.0, 0.0
+	for i := 0; i < n; i++ {
+		if totals[i] == 0 || len(servers[i]) == 0 {
+			continue
+		}
+		health := math.Min(100, 100*l.factor*float64(len(servers[i]))/float64(totals[i]))
+		load := math.Min(remaining, health)
+		if load <= 0 {
+			break
+		}
+		remaining -= load
+		totalHealth += load
+		pl.groups = append(pl.groups, newServerGroup(servers[i]))
+		pl.loads = append(pl.loads, totalHealth)
+	}
+
+	// all healthy servers are in the same level, no need to choose level.
+	if len(pl.groups) == 0 || (len(pl.groups) == 1 && len(pl.groups[0].Servers) == len(healthy)) {
+		return nil
+	}
+	return pl
+}
+
+// priorityLevels is the healthy servers divided into priority levels.
+type priorityLevels struct {
+	groups []*ServerGroup
+	// loads is the accumulated loads of the levels, the load of the last
+	// level may be less than 100 if the levels are not healthy enough,
+	// the load is normalized in this case.
+	loads []float64
+}
+
+// choose chooses a level by the loads.
+func (pl *priorityLevels) choose() *ServerGroup {
+	if len(pl.groups) == 1 {
+		return pl.groups[0]
+	}
+
+	r := rand.Float64() * pl.loads[len(pl.loads)-1]
+	for i, load := range pl.loads {
+		if r < load {
+			return pl.groups[i]
+		}
+	}
+	return pl.groups[len(pl.groups)-1]
+}
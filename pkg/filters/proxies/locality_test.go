@@ -0,0 +1,181 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxies
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalitySpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &LocalitySpec{}
+	assert.NoError(spec.Validate())
+
+	spec.LabelKeys = []string{"zone", ""}
+	assert.Error(spec.Validate())
+	spec.LabelKeys = []string{"zone", "zone"}
+	assert.Error(spec.Validate())
+	spec.LabelKeys = []string{"zone", "region"}
+	assert.NoError(spec.Validate())
+
+	spec.OverprovisioningFactor = 50
+	assert.Error(spec.Validate())
+	lbSpec := &LoadBalanceSpec{Locality: spec}
+	assert.Error(lbSpec.Validate())
+}
+
+// prepareLocalityServers prepares 10 servers, 4 in zone a, 3 in zone b of
+// the same region, and 3 in another region.
+func prepareLocalityServers() []*Server {
+	servers := prepareServers(10)
+	for i, s := range servers {
+		switch {
+		case i < 4:
+			s.Labels = map[string]string{"zone": "a", "region": "r1"}
+		case i < 7:
+			s.Labels = map[string]string{"zone": "b", "region": "r1"}
+		default:
+			s.Labels = map[string]string{"zone": "c", "region": "r2"}
+		}
+	}
+	return servers
+}
+
+func TestLocalityLevel(t *testing.T) {
+	assert := assert.New(t)
+
+	servers := prepareLocalityServers()
+	l := newLocality(&LocalitySpec{}, map[string]string{"zone": "a", "region": "r1"})
+	assert.Equal(0, l.level(servers[0]))
+	assert.Equal(1, l.level(servers[4]))
+	assert.Equal(2, l.level(servers[7]))
+	assert.Equal(2, l.level(&Server{}))
+
+	// no local labels, all servers are in the last level.
+	l = newLocality(&LocalitySpec{}, nil)
+	assert.Equal(2, l.level(servers[0]))
+	assert.Nil(l.build(servers, servers))
+}
+
+func TestLocalityFailover(t *testing.T) {
+	assert := assert.New(t)
+
+	servers := prepareLocalityServers()
+	spec := &LoadBalanceSpec{
+		Policy:   LoadBalancePolicyRoundRobin,
+		Locality: &LocalitySpec{},
+	}
+	lb := NewGeneralLoadBalancer(spec, servers)
+	lb.SetLocalLabels(map[string]string{"zone": "a", "region": "r1"})
+	lb.Init(nil, nil, nil)
+
+	countLevels := func() []int {
+		levels := make([]int, 3)
+		for i := 0; i < 10000; i++ {
+			levels[lb.locality.level(lb.ChooseServer(nil))]++
+		}
+		return levels
+	}
+
+	// all traffic goes to the local zone.
+	levels := countLevels()
+	assert.Equal(10000, levels[0])
+
+	// 3 of 4 servers are healthy, 75% * 1.4 > 100%, no spill over.
+	servers[0].Unhealth = true
+	lb.updateHealthyServers()
+	levels = countLevels()
+	assert.Equal(10000, levels[0])
+
+	// 2 of 4 servers are healthy, 70% traffic stays in the local zone,
+	// the rest spills over to the same region.
+	servers[1].Unhealth = true
+	lb.updateHealthyServers()
+	levels = countLevels()
+	assert.InDelta(7000, levels[0], 500)
+	assert.InDelta(3000, levels[1], 500)
+	assert.Zero(levels[2])
+
+	// the local zone is down, and 1 of 3 servers in the same region is
+	// healthy, the traffic is normalized among the available levels.
+	servers[2].Unhealth = true
+	servers[3].Unhealth = true
+	servers[4].Unhealth = true
+	servers[5].Unhealth = true
+	lb.updateHealthyServers()
+	levels = countLevels()
+	assert.Zero(levels[0])
+	assert.InDelta(10000*46.67/100, levels[1], 500)
+	assert.InDelta(10000*53.33/100, levels[2], 500)
+}
+
+func TestLocalityConsistentHash(t *testing.T) {
+	assert := assert.New(t)
+
+	servers := prepareLocalityServers()
+	spec := &LoadBalanceSpec{
+		Policy:   LoadBalancePolicyConsistentHash,
+		Locality: &LocalitySpec{},
+	}
+	lb := NewGeneralLoadBalancer(spec, servers)
+	lb.SetLocalLabels(map[string]string{"zone": "a", "region": "r1"})
+	lb.Init(nil, nil, nil)
+	lbp := lb.lbp.(*ConsistentHashLoadBalancePolicy)
+	req, _ := httpprot.NewRequest(&http.Request{RemoteAddr: "192.168.1.1:1234"})
+
+	// traffic spills over to the second level, a ring is built for each
+	// level, and the rings are reused when the chosen level changes.
+	servers[0].Unhealth = true
+	servers[1].Unhealth = true
+	lb.updateHealthyServers()
+	sg := lb.healthyServers.Load()
+	for i := 0; i < 100; i++ {
+		lb.ChooseServer(req)
+	}
+	c := lbp.cache.Load()
+	assert.Equal(sg, c.root)
+	assert.Len(c.rings, 2)
+	ring := c.rings[sg.priorities.groups[0]]
+	for i := 0; i < 100; i++ {
+		lb.ChooseServer(req)
+	}
+	assert.Same(ring, lbp.cache.Load().rings[sg.priorities.groups[0]])
+
+	// the rings are dropped when the healthy servers change.
+	servers[0].Unhealth = false
+	lb.updateHealthyServers()
+	lb.ChooseServer(req)
+	c = lbp.cache.Load()
+	assert.Equal(lb.healthyServers.Load(), c.root)
+	assert.Len(c.rings, 1)
+}
+
+func TestLocalityDisabled(t *testing.T) {
+	assert := assert.New(t)
+
+	servers := prepareLocalityServers()
+	lb := NewGeneralLoadBalancer(&LoadBalanceSpec{}, servers)
+	lb.SetLocalLabels(map[string]string{"zone": "a", "region": "r1"})
+	lb.Init(nil, nil, nil)
+	assert.Nil(lb.healthyServers.Load().priorities)
+}
@@ -0,0 +1,428 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxies
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/protocols"
+	"github.com/megaease/easegress/v2/pkg/protocols/grpcprot"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	// OutlierReasonConsecutiveErrors is the ejection reason of consecutive errors.
+	OutlierReasonConsecutiveErrors = "consecutiveErrors"
+	// OutlierReasonSuccessRate is the ejection reason of low success rate.
+	OutlierReasonSuccessRate = "successRate"
+	// OutlierReasonTimeout is the reason of bringing back a server after its
+	// ejection time expires.
+	OutlierReasonTimeout = "timeout"
+	// OutlierReasonClosed is the reason of bringing back a server when the
+	// load balancer is closed.
+	OutlierReasonClosed = "closed"
+)
+
+// OutlierDetectionSpec is the spec of outlier detection, which ejects
+// servers from the load balancer according to their responses.
+type OutlierDetectionSpec struct {
+	// ConsecutiveErrors is the number of consecutive errors (connection
+	// errors or 5xx responses) to eject a server, default is 5.
+	ConsecutiveErrors int `json:"consecutiveErrors,omitempty" jsonschema:"minimum=1"`
+	// Interval is the interval of the success rate analysis and ejection
+	// time checking, default is 10s.
+	Interval string `json:"interval,omitempty" jsonschema:"format=duration"`
+	// BaseEjectionTime is the base ejection time, the actual ejection time
+	// is the base ejection time multiplied by the number of times the server
+	// has been ejected, default is 30s.
+	BaseEjectionTime string `json:"baseEjectionTime,omitempty" jsonschema:"format=duration"`
+	// MaxEjectionTime is the maximum ejection time, default is 300s.
+	MaxEjectionTime string `json:"maxEjectionTime,omitempty" jsonschema:"format=duration"`
+	// MaxEjectionPercent is the maximum percentage of servers that can be
+	// ejected, default is 10, at least one server can be ejected.
+	MaxEjectionPercent int `json:"maxEjectionPercent,omitempty" jsonschema:"minimum=0,maximum=100"`
+	// SuccessRateMinimumHosts is the minimum number of servers with enough
+	// requests to perform the success rate analysis, default is 5.
+	SuccessRateMinimumHosts int `json:"successRateMinimumHosts,omitempty" jsonschema:"minimum=1"`
+	// SuccessRateRequestVolume is the minimum number of requests in an
+	// interval for a server to be included in the success rate analysis,
+	// default is 100.
+	SuccessRateRequestVolume int `json:"successRateRequestVolume,omitempty" jsonschema:"minimum=1"`
+	// SuccessRateStdevFactor is used to determine the ejection threshold
+	// of the success rate analysis, which is: mean - (stdev * factor),
+	// default is 1.9.
+	SuccessRateStdevFactor float64 `json:"successRateStdevFactor,omitempty" jsonschema:"minimum=0"`
+}
+
+// Validate validates the OutlierDetectionSpec.
+func (spec *OutlierDetectionSpec) Validate() error {
+	durations := map[string]string{
+		"interval":         spec.Interval,
+		"baseEjectionTime": spec.BaseEjectionTime,
+		"maxEjectionTime":  spec.MaxEjectionTime,
+	}
+	for name, v := range durations {
+		if v == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(v); err != nil {
+			return fmt.Errorf("invalid %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// OutlierDetectionStatus is the status of outlier detection.
+type OutlierDetectionStatus struct {
+	TotalEjections uint64                 `json:"totalEjections"`
+	EjectedServers []*EjectedServerStatus `json:"ejectedServers,omitempty"`
+}
+
+// EjectedServerStatus is the status of an ejected server.
+type EjectedServerStatus struct {
+	URL           string    `json:"url"`
+	Reason        string    `json:"reason"`
+	EjectedAt     time.Time `json:"ejectedAt"`
+	EjectedUntil  time.Time `json:"ejectedUntil"`
+	EjectionCount int       `json:"ejectionCount"`
+}
+
+// OutlierListener is notified when a server is ejected or brought back by
+// outlier detection.
+type OutlierListener func(svr *Server, ejected bool, reason string)
+
+// outlierStats is the outlier detection statistics of a server.
+type outlierStats struct {
+	consecutiveErrors int
+	success           int
+	total             int
+
+	ejected       bool
+	reason        string
+	ejectedAt     time.Time
+	ejectionCount int
+}
+
+// outlierDetector detects outliers of a load balancer.
+type outlierDetector struct {
+	spec             *OutlierDetectionSpec
+	interval         time.Duration
+	baseEjectionTime time.Duration
+	maxEjectionTime  time.Duration
+
+	lock           sync.Mutex
+	stats          map[*Server]*outlierStats
+	ejected        int
+	totalEjections uint64
+
+	onChange func()
+	listener OutlierListener
+	done     chan struct{}
+}
+
+func parseDurationDefault(s string, dft time.Duration) time.Duration {
+	d, _ := time.ParseDuration(s)
+	if d <= 0 {
+		d = dft
+	}
+	return d
+}
+
+func newOutlierDetector(spec *OutlierDetectionSpec, servers []*Server, onChange func(), listener OutlierListener) *outlierDetector {
+	od := &outlierDetector{
+		spec:             spec,
+		interval:         parseDurationDefault(spec.Interval, 10*time.Second),
+		baseEjectionTime: parseDurationDefault(spec.BaseEjectionTime, 30*time.Second),
+		maxEjectionTime:  parseDurationDefault(spec.MaxEjectionTime, 300*time.Second),
+		stats:            make(map[*Server]*outlierStats, len(servers)),
+		onChange:         onChange,
+		listener:         listener,
+		done:             make(chan struct{}),
+	}
+
+	for _, svr := range servers {
+		od.stats[svr] = &outlierStats{}
+	}
+
+	go od.run()
+	return od
+}
+
+func (od *outlierDetector) consecutiveErrors() int {
+	if od.spec.ConsecutiveErrors <= 0 {
+		return 5
+	}
+	return od.spec.ConsecutiveErrors
+}
+
+func (od *outlierDetector) maxEjectionPercent() int {
+	if od.spec.MaxEjectionPercent <= 0 {
+		return 10
+	}
+	return od.spec.MaxEjectionPercent
+}
+
+func (od *outlierDetector) run() {
+	ticker := time.NewTicker(od.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-od.done:
+			return
+		case <-ticker.C:
+			od.check(time.Now())
+		}
+	}
+}
+
+// isFailure returns whether the response means a failure of the server,
+// a nil response means the request failed before a response was received.
+func isFailure(resp protocols.Response) bool {
+	switch r := resp.(type) {
+	case nil:
+		return true
+	case *httpprot.Response:
+		return r.StatusCode() >= 500
+	case *grpcprot.Response:
+		switch codes.Code(r.StatusCode()) {
+		case codes.Unknown, codes.Internal, codes.Unavailable, codes.DataLoss:
+			return true
+		}
+	}
+	return false
+}
+
+// canEject returns whether one more server can be ejected, the caller
+// must hold the lock.
+func (od *outlierDetector) canEject() bool {
+	return float64(od.ejected)*100/float64(len(od.stats)) < float64(od.maxEjectionPercent())
+}
+
+// ejectionTime returns the ejection time of a server, the caller must hold
+// the lock.
+func (od *outlierDetector) ejectionTime(st *outlierStats) time.Duration {
+	d := od.baseEjectionTime * time.Duration(st.ejectionCount)
+	if d > od.maxEjectionTime {
+		d = od.maxEjectionTime
+	}
+	return d
+}
+
+// eject ejects a server, the caller must hold the lock.
+func (od *outlierDetector) eject(svr *Server, st *outlierStats, reason string, now time.Time) {
+	st.ejected = true
+	st.reason = reason
+	st.ejectedAt = now
+	st.ejectionCount++
+	od.ejected++
+	od.totalEjections++
+	logger.Warnf("server:%v is ejected by outlier detection, reason: %s, ejection time: %v",
+		svr.ID(), reason, od.ejectionTime(st))
+}
+
+// record records the result of a request.
+func (od *outlierDetector) record(svr *Server, resp protocols.Response) {
+	failed := isFailure(resp)
+
+	od.lock.Lock()
+	st := od.stats[svr]
+	if st == nil || st.ejected {
+		od.lock.Unlock()
+		return
+	}
+
+	st.total++
+	if !failed {
+		st.success++
+		st.consecutiveErrors = 0
+		od.lock.Unlock()
+		return
+	}
+
+	st.consecutiveErrors++
+	if st.consecutiveErrors < od.consecutiveErrors() || !od.canEject() {
+		od.lock.Unlock()
+		return
+	}
+	od.eject(svr, st, OutlierReasonConsecutiveErrors, time.Now())
+	od.lock.Unlock()
+
+	od.onChange()
+	if od.listener != nil {
+		od.listener(svr, true, OutlierReasonConsecutiveErrors)
+	}
+}
+
+type outlierEvent struct {
+	svr     *Server
+	ejected bool
+	reason  string
+}
+
+// check brings back servers whose ejection time expired and ejects
+// servers by success rate.
+func (od *outlierDetector) check(now time.Time) {
+	var events []outlierEvent
+
+	od.lock.Lock()
+
+	for svr, st := range od.stats {
+		if st.ejected {
+			if now.Sub(st.ejectedAt) >= od.ejectionTime(st) {
+				st.ejected = false
+				st.consecutiveErrors = 0
+				od.ejected--
+				svr.markStarted()
+				logger.Warnf("server:%v is brought back by outlier detection.", svr.ID())
+				events = append(events, outlierEvent{svr, false, OutlierReasonTimeout})
+			}
+		} else if st.ejectionCount > 0 && now.Sub(st.ejectedAt) >= od.ejectionTime(st)+od.interval {
+			// the server has been healthy for an interval after it was
+			// brought back, decrease its ejection count.
+			st.ejectionCount--
+		}
+	}
+
+	events = append(events, od.checkSuccessRate(now)...)
+
+	for _, st := range od.stats {
+		st.success, st.total = 0, 0
+	}
+
+	od.lock.Unlock()
+
+	if len(events) == 0 {
+		return
+	}
+
+	od.onChange()
+	if od.listener != nil {
+		for _, e := range events {
+			od.listener(e.svr, e.ejected, e.reason)
+		}
+	}
+}
+
+// checkSuccessRate ejects servers whose success rate is lower than the
+// threshold, the caller must hold the lock.
+func (od *outlierDetector) checkSuccessRate(now time.Time) []outlierEvent {
+	volume := od.spec.SuccessRateRequestVolume
+	if volume <= 0 {
+		volume = 100
+	}
+	minHosts := od.spec.SuccessRateMinimumHosts
+	if minHosts <= 0 {
+		minHosts = 5
+	}
+	factor := od.spec.SuccessRateStdevFactor
+	if factor <= 0 {
+		factor = 1.9
+	}
+
+	rates := map[*Server]float64{}
+	sum := 0.0
+	for svr, st := range od.stats {
+		if st.ejected || st.total < volume {
+			continue
+		}
+		rate := float64(st.success) / float64(st.total)
+		rates[svr] = rate
+		sum += rate
+	}
+
+	if len(rates) < minHosts {
+		return nil
+	}
+
+	mean := sum / float64(len(rates))
+	variance := 0.0
+	for _, rate := range rates {
+		variance += (rate - mean) * (rate - mean)
+	}
+	stdev := math.Sqrt(variance / float64(len(rates)))
+	threshold := mean - stdev*factor
+
+	var events []outlierEvent
+	for svr, rate := range rates {
+		if rate >= threshold || !od.canEject() {
+			continue
+		}
+		od.eject(svr, od.stats[svr], OutlierReasonSuccessRate, now)
+		events = append(events, outlierEvent{svr, true, OutlierReasonSuccessRate})
+	}
+	return events
+}
+
+// isEjected returns whether the server is ejected.
+func (od *outlierDetector) isEjected(svr *Server) bool {
+	od.lock.Lock()
+	defer od.lock.Unlock()
+
+	st := od.stats[svr]
+	return st != nil && st.ejected
+}
+
+// status returns the status of the outlier detector.
+func (od *outlierDetector) status() *OutlierDetectionStatus {
+	od.lock.Lock()
+	defer od.lock.Unlock()
+
+	s := &OutlierDetectionStatus{TotalEjections: od.totalEjections}
+	for svr, st := range od.stats {
+		if !st.ejected {
+			continue
+		}
+		s.EjectedServers = append(s.EjectedServers, &EjectedServerStatus{
+			URL:           svr.URL,
+			Reason:        st.reason,
+			EjectedAt:     st.ejectedAt,
+			EjectedUntil:  st.ejectedAt.Add(od.ejectionTime(st)),
+			EjectionCount: st.ejectionCount,
+		})
+	}
+	return s
+}
+
+// close stops the outlier detector, ejected servers are reported as
+// brought back to keep the listener consistent.
+func (od *outlierDetector) close() {
+	close(od.done)
+
+	if od.listener == nil {
+		return
+	}
+
+	var ejected []*Server
+	od.lock.Lock()
+	for svr, st := range od.stats {
+		if st.ejected {
+			ejected = append(ejected, svr)
+		}
+	}
+	od.lock.Unlock()
+
+	for _, svr := range ejected {
+		od.listener(svr, false, OutlierReasonClosed)
+	}
+}
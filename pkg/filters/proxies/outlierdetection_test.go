@@ -0,0 +1,181 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxies
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/protocols"
+	"github.com/megaease/easegress/v2/pkg/protocols/grpcprot"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newTestHTTPResponse(code int) protocols.Response {
+	resp, _ := httpprot.NewResponse(&http.Response{StatusCode: code, Header: http.Header{}})
+	return resp
+}
+
+func TestOutlierIsFailure(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(isFailure(nil))
+	assert.True(isFailure(newTestHTTPResponse(503)))
+	assert.False(isFailure(newTestHTTPResponse(404)))
+
+	resp := grpcprot.NewResponse()
+	assert.False(isFailure(resp))
+	resp.SetStatus(status.New(codes.Unavailable, "unavailable"))
+	assert.True(isFailure(resp))
+	resp.SetStatus(status.New(codes.NotFound, "not found"))
+	assert.False(isFailure(resp))
+}
+
+func TestOutlierConsecutiveErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	servers := prepareServers(10)
+	spec := &LoadBalanceSpec{
+		Policy: LoadBalancePolicyRoundRobin,
+		OutlierDetection: &OutlierDetectionSpec{
+			ConsecutiveErrors:  3,
+			Interval:           "1h",
+			BaseEjectionTime:   "1m",
+			MaxEjectionTime:    "3m",
+			MaxEjectionPercent: 20,
+		},
+	}
+	assert.NoError(spec.Validate())
+
+	events := map[string]int{}
+	lb := NewGeneralLoadBalancer(spec, servers)
+	lb.SetOutlierListener(func(svr *Server, ejected bool, reason string) {
+		events[reason]++
+	})
+	lb.Init(nil, nil, nil)
+
+	// success resets the consecutive errors.
+	lb.ReturnServer(servers[0], nil, nil)
+	lb.ReturnServer(servers[0], nil, nil)
+	lb.ReturnServer(servers[0], nil, newTestHTTPResponse(200))
+	lb.ReturnServer(servers[0], nil, nil)
+	assert.Len(lb.healthyServers.Load().Servers, 10)
+
+	lb.ReturnServer(servers[0], nil, newTestHTTPResponse(500))
+	lb.ReturnServer(servers[0], nil, nil)
+	assert.Len(lb.healthyServers.Load().Servers, 9)
+	for i := 0; i < 10; i++ {
+		assert.NotSame(servers[0], lb.ChooseServer(nil))
+	}
+
+	// at most 20% servers can be ejected
+	for _, svr := range servers[1:] {
+		for i := 0; i < 3; i++ {
+			lb.ReturnServer(svr, nil, nil)
+		}
+	}
+	assert.Len(lb.healthyServers.Load().Servers, 8)
+	assert.Equal(2, events[OutlierReasonConsecutiveErrors])
+
+	s := lb.OutlierDetectionStatus()
+	assert.Equal(uint64(2), s.TotalEjections)
+	assert.Len(s.EjectedServers, 2)
+
+	// bring back after the ejection time.
+	od := lb.od
+	now := time.Now()
+	od.check(now.Add(time.Minute))
+	assert.Len(lb.healthyServers.Load().Servers, 10)
+	assert.Equal(2, events[OutlierReasonTimeout])
+
+	// the ejection time grows with the ejection count.
+	for i := 0; i < 3; i++ {
+		lb.ReturnServer(servers[0], nil, nil)
+	}
+	assert.Len(lb.healthyServers.Load().Servers, 9)
+	od.check(time.Now().Add(time.Minute))
+	assert.Len(lb.healthyServers.Load().Servers, 9)
+	od.check(time.Now().Add(2 * time.Minute))
+	assert.Len(lb.healthyServers.Load().Servers, 10)
+
+	lb.Close()
+	assert.Equal(3, events[OutlierReasonConsecutiveErrors])
+}
+
+func TestOutlierSuccessRate(t *testing.T) {
+	assert := assert.New(t)
+
+	servers := prepareServers(6)
+	spec := &LoadBalanceSpec{
+		OutlierDetection: &OutlierDetectionSpec{
+			ConsecutiveErrors:        1000,
+			Interval:                 "1h",
+			SuccessRateMinimumHosts:  5,
+			SuccessRateRequestVolume: 10,
+			MaxEjectionPercent:       50,
+		},
+	}
+	lb := NewGeneralLoadBalancer(spec, servers)
+	lb.Init(nil, nil, nil)
+	defer lb.Close()
+
+	ok, failed := newTestHTTPResponse(200), newTestHTTPResponse(502)
+	for i, svr := range servers {
+		for j := 0; j < 100; j++ {
+			// servers[5] fails half of the requests
+			if i == 5 && j%2 == 0 {
+				lb.ReturnServer(svr, nil, failed)
+			} else {
+				lb.ReturnServer(svr, nil, ok)
+			}
+		}
+	}
+
+	lb.od.check(time.Now())
+	sg := lb.healthyServers.Load()
+	assert.Len(sg.Servers, 5)
+	assert.NotContains(sg.Servers, servers[5])
+
+	s := lb.OutlierDetectionStatus()
+	assert.Equal(OutlierReasonSuccessRate, s.EjectedServers[0].Reason)
+
+	// not enough hosts with request volume.
+	lb.od.check(time.Now())
+	assert.Len(lb.healthyServers.Load().Servers, 5)
+}
+
+func TestOutlierDetectionSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &OutlierDetectionSpec{Interval: "abc"}
+	assert.Error(spec.Validate())
+
+	lbSpec := &LoadBalanceSpec{OutlierDetection: spec}
+	assert.Error(lbSpec.Validate())
+
+	spec.Interval = "5s"
+	assert.NoError(lbSpec.Validate())
+
+	lb := NewGeneralLoadBalancer(&LoadBalanceSpec{}, prepareServers(1))
+	lb.Init(nil, nil, nil)
+	assert.Nil(lb.OutlierDetectionStatus())
+}
@@ -21,20 +21,31 @@ import (
 	"fmt"
 	"net"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/util/fasttime"
 )
 
 // Server is a backend proxy server.
 type Server struct {
-	URL            string   `json:"url" jsonschema:"required,format=url"`
-	Tags           []string `json:"tags,omitempty" jsonschema:"uniqueItems=true"`
-	Weight         int      `json:"weight,omitempty" jsonschema:"minimum=0,maximum=100"`
-	KeepHost       bool     `json:"keepHost,omitempty" jsonschema:"default=false"`
-	AddrIsHostName bool     `json:"-"`
-	Unhealth       bool     `json:"-"`
+	URL      string   `json:"url" jsonschema:"required,format=url"`
+	Tags     []string `json:"tags,omitempty" jsonschema:"uniqueItems=true"`
+	Weight   int      `json:"weight,omitempty" jsonschema:"minimum=0,maximum=100"`
+	KeepHost bool     `json:"keepHost,omitempty" jsonschema:"default=false"`
+	// Labels is the labels of the server, such as zone and region, which
+	// are used by locality aware routing.
+	Labels         map[string]string `json:"labels,omitempty"`
+	AddrIsHostName bool              `json:"-"`
+	Unhealth       bool              `json:"-"`
 	// HealthCounter is used to count the number of successive health checks
 	// result, positive for healthy, negative for unhealthy
 	HealthCounter int `json:"-"`
+
+	// load is the runtime load statistics of the server, it is only
+	// maintained when the load balance policy is load aware.
+	load serverLoad
 }
 
 // String implements the Stringer interface.
@@ -72,6 +83,33 @@ func (s *Server) CheckAddrPattern() {
 	s.AddrIsHostName = net.ParseIP(host) == nil
 }
 
+// Addr returns the address of the server in host:port format, port
+// overrides the port in the URL if it is not zero. The port defaults to
+// 80 or 443 for http(ws) or https(wss) URLs, and is required for others.
+func (s *Server) Addr(port int) (string, error) {
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("invalid server url: %s", s.URL)
+	}
+
+	if port != 0 {
+		return net.JoinHostPort(u.Hostname(), strconv.Itoa(port)), nil
+	}
+	if u.Port() != "" {
+		return u.Host, nil
+	}
+	switch u.Scheme {
+	case "http", "ws":
+		return net.JoinHostPort(u.Hostname(), "80"), nil
+	case "https", "wss":
+		return net.JoinHostPort(u.Hostname(), "443"), nil
+	}
+	return "", fmt.Errorf("port is required in server url: %s", s.URL)
+}
+
 // Healthy returns whether the server is healthy
 func (s *Server) Healthy() bool {
 	return !s.Unhealth
@@ -81,6 +119,14 @@ func (s *Server) Healthy() bool {
 type ServerGroup struct {
 	TotalWeight int
 	Servers     []*Server
+
+	slowStart *slowStart
+	// priorities is the servers divided into priority levels by locality,
+	// it is nil if locality aware routing is not enabled or not needed.
+	priorities *priorityLevels
+	// parent is the group this group is a priority level of, it is nil
+	// if this group is not a priority level.
+	parent *ServerGroup
 }
 
 func newServerGroup(servers []*Server) *ServerGroup {
@@ -90,3 +136,32 @@ func newServerGroup(servers []*Server) *ServerGroup {
 	}
 	return sg
 }
+
+// inSlowStart returns whether any server of the group is in slow start.
+func (sg *ServerGroup) inSlowStart(now time.Time) bool {
+	if sg.slowStart == nil {
+		return false
+	}
+	for _, svr := range sg.Servers {
+		if sg.slowStart.factor(svr, now) < 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// weight returns the effective weight of a server, servers without weight
+// are treated as weight 1, and the weight is scaled down if the server is
+// in slow start.
+func (sg *ServerGroup) weight(svr *Server) float64 {
+	return float64(serverWeight(svr)) * sg.slowStartFactor(svr)
+}
+
+// slowStartFactor returns the slow start weight factor of a server, it is
+// 1 if slow start is not enabled.
+func (sg *ServerGroup) slowStartFactor(svr *Server) float64 {
+	if sg.slowStart == nil {
+		return 1
+	}
+	return sg.slowStart.factor(svr, fasttime.Now())
+}
@@ -69,3 +69,33 @@ func TestCheckAddrPattern(t *testing.T) {
 	server.CheckAddrPattern()
 	assert.True(server.AddrIsHostName, "address should not be IP:port")
 }
+
+func TestAddr(t *testing.T) {
+	assert := assert.New(t)
+
+	addr, err := (&Server{URL: "http://127.0.0.1:8080"}).Addr(0)
+	assert.NoError(err)
+	assert.Equal("127.0.0.1:8080", addr)
+
+	addr, err = (&Server{URL: "http://127.0.0.1:8080"}).Addr(9090)
+	assert.NoError(err)
+	assert.Equal("127.0.0.1:9090", addr)
+
+	addr, err = (&Server{URL: "https://[::1]"}).Addr(0)
+	assert.NoError(err)
+	assert.Equal("[::1]:443", addr)
+
+	addr, err = (&Server{URL: "http://www.megaease.com"}).Addr(0)
+	assert.NoError(err)
+	assert.Equal("www.megaease.com:80", addr)
+
+	addr, err = (&Server{URL: "tcp://127.0.0.1:5432"}).Addr(0)
+	assert.NoError(err)
+	assert.Equal("127.0.0.1:5432", addr)
+
+	_, err = (&Server{URL: "tcp://127.0.0.1"}).Addr(0)
+	assert.Error(err)
+
+	_, err = (&Server{URL: "127.0.0.1"}).Addr(0)
+	assert.Error(err)
+}
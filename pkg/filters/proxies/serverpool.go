@@ -41,6 +41,13 @@ type ServerPoolBase struct {
 	done         chan struct{}
 	wg           sync.WaitGroup
 	loadBalancer atomic.Value
+
+	// serverIDs is the IDs of the servers of the current load balancer,
+	// it is used to find out newly added servers from service registry.
+	serverIDs map[string]struct{}
+
+	// localLabels is the labels of the Easegress member.
+	localLabels map[string]string
 }
 
 // ServerPoolBaseSpec is the spec for a base server pool.
@@ -74,6 +81,12 @@ func (sps *ServerPoolBaseSpec) Validate() error {
 		return fmt.Errorf("can not open health check for service discovery")
 	}
 
+	if sps.LoadBalance != nil {
+		if err := sps.LoadBalance.Validate(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -82,6 +95,9 @@ func (spb *ServerPoolBase) Init(spImpl ServerPoolImpl, super *supervisor.Supervi
 	spb.spImpl = spImpl
 	spb.Name = name
 	spb.done = make(chan struct{})
+	if super != nil && super.Options() != nil {
+		spb.localLabels = super.Options().Labels
+	}
 
 	if spec.ServiceRegistry == "" || spec.ServiceName == "" {
 		spb.createLoadBalancer(spec.LoadBalance, spec.Servers)
@@ -125,6 +141,24 @@ func (spb *ServerPoolBase) LoadBalancer() LoadBalancer {
 	return nil
 }
 
+// LocalLabels returns the labels of the Easegress member, which are used by
+// locality aware routing.
+func (spb *ServerPoolBase) LocalLabels() map[string]string {
+	return spb.localLabels
+}
+
+// OutlierDetectionStatus returns the outlier detection status of the
+// current load balancer, it returns nil if outlier detection is not enabled.
+func (spb *ServerPoolBase) OutlierDetectionStatus() *OutlierDetectionStatus {
+	lb, ok := spb.LoadBalancer().(interface {
+		OutlierDetectionStatus() *OutlierDetectionStatus
+	})
+	if !ok {
+		return nil
+	}
+	return lb.OutlierDetectionStatus()
+}
+
 func (spb *ServerPoolBase) createLoadBalancer(spec *LoadBalanceSpec, servers []*Server) {
 	for _, server := range servers {
 		server.CheckAddrPattern()
@@ -134,6 +168,12 @@ func (spb *ServerPoolBase) createLoadBalancer(spec *LoadBalanceSpec, servers []*
 		spec = &LoadBalanceSpec{}
 	}
 
+	serverIDs := make(map[string]struct{}, len(servers))
+	for _, server := range servers {
+		serverIDs[server.ID()] = struct{}{}
+	}
+	spb.serverIDs = serverIDs
+
 	lb := spb.spImpl.CreateLoadBalancer(spec, servers)
 	if old := spb.loadBalancer.Swap(lb); old != nil {
 		old.(LoadBalancer).Close()
@@ -158,6 +198,7 @@ func (spb *ServerPoolBase) useService(spec *ServerPoolBaseSpec, instances map[st
 				URL:    instance.URL(),
 				Tags:   instance.Tags,
 				Weight: instance.Weight,
+				Labels: instance.Labels,
 			})
 		}
 	}
@@ -166,6 +207,14 @@ func (spb *ServerPoolBase) useService(spec *ServerPoolBaseSpec, instances map[st
 		msgFmt := "%s/%s: no service instance satisfy tags: %v"
 		logger.Warnf(msgFmt, spec.ServiceRegistry, spec.ServiceName, spec.ServerTags)
 		servers = spec.Servers
+	} else if spb.serverIDs != nil {
+		// servers newly added to the service registry enter slow start,
+		// but not the ones at the very beginning.
+		for _, server := range servers {
+			if _, ok := spb.serverIDs[server.ID()]; !ok {
+				server.markStarted()
+			}
+		}
 	}
 
 	spb.createLoadBalancer(spec.LoadBalance, servers)
@@ -0,0 +1,113 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxies
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/util/fasttime"
+)
+
+// SlowStartSpec is the spec of slow start, which ramps the weight of a
+// server up gradually after it becomes available. It doesn't apply to the
+// consistent hash policy, whose ring would be rebuilt as the weights
+// change, remapping keys again and again during the window.
+type SlowStartSpec struct {
+	// Window is the duration of the slow start.
+	Window string `json:"window" jsonschema:"required,format=duration"`
+	// Aggression controls the speed of the ramp, the weight factor of a
+	// server is (elapsed / window) ^ (1 / aggression). Default is 1.0, which
+	// is linear, larger values ramp up faster at the beginning.
+	Aggression float64 `json:"aggression,omitempty" jsonschema:"minimum=0"`
+	// MinWeightPercent is the minimum weight factor in percentage, default
+	// is 10.
+	MinWeightPercent int `json:"minWeightPercent,omitempty" jsonschema:"minimum=1,maximum=100"`
+}
+
+// Validate validates the SlowStartSpec.
+func (spec *SlowStartSpec) Validate() error {
+	window, err := time.ParseDuration(spec.Window)
+	if err != nil {
+		return fmt.Errorf("invalid window: %v", err)
+	}
+	if window <= 0 {
+		return fmt.Errorf("window must be positive")
+	}
+	if spec.Aggression < 0 {
+		return fmt.Errorf("aggression must not be negative")
+	}
+	return nil
+}
+
+// slowStart calculates the weight factor of servers in slow start.
+type slowStart struct {
+	window     time.Duration
+	aggression float64
+	minFactor  float64
+}
+
+func newSlowStart(spec *SlowStartSpec) *slowStart {
+	if spec == nil {
+		return nil
+	}
+
+	window, _ := time.ParseDuration(spec.Window)
+	if window <= 0 {
+		return nil
+	}
+
+	ss := &slowStart{
+		window:     window,
+		aggression: spec.Aggression,
+		minFactor:  float64(spec.MinWeightPercent) / 100,
+	}
+	if ss.aggression <= 0 {
+		ss.aggression = 1
+	}
+	if ss.minFactor <= 0 || ss.minFactor > 1 {
+		ss.minFactor = 0.1
+	}
+	return ss
+}
+
+// factor returns the weight factor of the server, which is in (0, 1].
+func (ss *slowStart) factor(svr *Server, now time.Time) float64 {
+	startedAt := svr.load.startedAt.Load()
+	if startedAt == 0 {
+		return 1
+	}
+
+	elapsed := now.Sub(time.Unix(0, startedAt))
+	if elapsed >= ss.window {
+		return 1
+	}
+	if elapsed < 0 {
+		elapsed = 0
+	}
+
+	f := math.Pow(float64(elapsed)/float64(ss.window), 1/ss.aggression)
+	return math.Max(f, ss.minFactor)
+}
+
+// markStarted marks the server as just become available, so that it
+// enters slow start.
+func (svr *Server) markStarted() {
+	svr.load.startedAt.Store(fasttime.NowUnixNano())
+}
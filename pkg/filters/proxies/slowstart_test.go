@@ -0,0 +1,143 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxies
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlowStartSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &SlowStartSpec{}
+	assert.Error(spec.Validate())
+	spec.Window = "-1s"
+	assert.Error(spec.Validate())
+	spec.Window = "30s"
+	assert.NoError(spec.Validate())
+	spec.Aggression = -1
+	assert.Error(spec.Validate())
+
+	lbSpec := &LoadBalanceSpec{SlowStart: spec}
+	assert.Error(lbSpec.Validate())
+
+	assert.Nil(newSlowStart(nil))
+	assert.Nil(newSlowStart(&SlowStartSpec{Window: "abc"}))
+}
+
+func TestSlowStartFactor(t *testing.T) {
+	assert := assert.New(t)
+
+	ss := newSlowStart(&SlowStartSpec{Window: "100s"})
+	svr := &Server{}
+	now := time.Now()
+
+	// not in slow start
+	assert.Equal(1.0, ss.factor(svr, now))
+
+	svr.load.startedAt.Store(now.UnixNano())
+	assert.Equal(0.1, ss.factor(svr, now))
+	assert.InDelta(0.5, ss.factor(svr, now.Add(50*time.Second)), 0.0001)
+	assert.Equal(1.0, ss.factor(svr, now.Add(100*time.Second)))
+
+	ss = newSlowStart(&SlowStartSpec{Window: "100s", Aggression: 2, MinWeightPercent: 1})
+	assert.Equal(0.01, ss.factor(svr, now))
+	assert.InDelta(0.5, ss.factor(svr, now.Add(25*time.Second)), 0.0001)
+}
+
+func TestSlowStartWeightedPolicies(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, policy := range []string{LoadBalancePolicyWeightedRandom, LoadBalancePolicyLeastConn} {
+		servers := prepareServers(2)
+		for _, s := range servers {
+			s.Weight = 10
+		}
+		spec := &LoadBalanceSpec{
+			Policy:    policy,
+			SlowStart: &SlowStartSpec{Window: "1h"},
+		}
+		lb := NewGeneralLoadBalancer(spec, servers)
+		lb.Init(nil, nil, nil)
+
+		// servers[1] just started, its weight is 10% of servers[0].
+		servers[1].markStarted()
+		assert.True(lb.healthyServers.Load().inSlowStart(time.Now()))
+
+		counter := map[*Server]int{}
+		for i := 0; i < 11000; i++ {
+			counter[lb.ChooseServer(nil)]++
+		}
+		assert.Greater(counter[servers[0]], counter[servers[1]]*5, policy)
+	}
+}
+
+func TestSlowStartZeroWeight(t *testing.T) {
+	assert := assert.New(t)
+
+	servers := prepareServers(3)
+	servers[0].Weight = 10
+	servers[1].Weight = 10
+	servers[2].Weight = 0
+	spec := &LoadBalanceSpec{
+		Policy:    LoadBalancePolicyWeightedRandom,
+		SlowStart: &SlowStartSpec{Window: "1h"},
+	}
+	lb := NewGeneralLoadBalancer(spec, servers)
+	lb.Init(nil, nil, nil)
+
+	// servers with zero weight get no traffic, whether or not others are
+	// in slow start.
+	for _, started := range []bool{false, true} {
+		if started {
+			servers[1].markStarted()
+		}
+		assert.Equal(started, lb.healthyServers.Load().inSlowStart(time.Now()))
+		for i := 0; i < 1000; i++ {
+			assert.NotEqual(servers[2], lb.ChooseServer(nil))
+		}
+	}
+}
+
+func TestSlowStartAfterHealthy(t *testing.T) {
+	assert := assert.New(t)
+
+	servers := prepareServers(2)
+	spec := &LoadBalanceSpec{
+		Policy:    LoadBalancePolicyWeightedRandom,
+		SlowStart: &SlowStartSpec{Window: "1h"},
+		HealthCheck: &HealthCheckSpec{
+			Interval: "1h",
+		},
+	}
+	lb := NewGeneralLoadBalancer(spec, servers)
+	hc := &MockHealthChecker{Result: false}
+	lb.Init(nil, hc, nil)
+	defer lb.Close()
+	assert.Len(lb.healthyServers.Load().Servers, 0)
+
+	hc.Result = true
+	lb.checkServers()
+	assert.Len(lb.healthyServers.Load().Servers, 2)
+	for _, s := range servers {
+		assert.NotZero(s.load.startedAt.Load())
+	}
+}
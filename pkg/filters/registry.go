@@ -20,6 +20,8 @@ package filters
 import (
 	"fmt"
 	"sort"
+
+	"github.com/megaease/easegress/v2/pkg/util/stringtool"
 )
 
 // kinds is the filter kind registry.
@@ -76,6 +78,28 @@ func GetKind(name string) *Kind {
 	return kinds[name]
 }
 
+// GetResults returns all possible results of the filter of spec, except
+// the normal result, which are the Results of its kind, and the results
+// defined by the spec if it implements Resulter.
+func GetResults(spec Spec) []string {
+	k := kinds[spec.Kind()]
+	if k == nil {
+		return nil
+	}
+	r, ok := spec.(Resulter)
+	if !ok {
+		return k.Results
+	}
+
+	results := append([]string{}, k.Results...)
+	for _, result := range r.Results() {
+		if result != "" && !stringtool.StrInSlice(result, results) {
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
 // Create creates a filter instance of kind.
 func Create(spec Spec) Filter {
 	k := kinds[spec.Kind()]
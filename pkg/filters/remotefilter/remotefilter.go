@@ -225,18 +225,15 @@ func (rf *RemoteFilter) Handle(ctx *context.Context) (result string) {
 	errPrefix = "marshal context"
 	ctxBuff := rf.marshalHTTPContext(r, w, reqBody, respBody)
 
-	var (
-		req *http.Request
-		err error
-	)
-
+	// the request is bounded by both the timeout and the deadline of
+	// the pipeline.
+	stdctx := ctx.WithDeadline(stdcontext.Background())
 	if rf.spec.timeout > 0 {
-		timeoutCtx, cancelFunc := stdcontext.WithTimeout(stdcontext.Background(), rf.spec.timeout)
+		var cancelFunc stdcontext.CancelFunc
+		stdctx, cancelFunc = stdcontext.WithTimeout(stdctx, rf.spec.timeout)
 		defer cancelFunc()
-		req, err = http.NewRequestWithContext(timeoutCtx, http.MethodPost, rf.spec.URL, bytes.NewReader(ctxBuff))
-	} else {
-		req, err = http.NewRequest(http.MethodPost, rf.spec.URL, bytes.NewReader(ctxBuff))
 	}
+	req, err := http.NewRequestWithContext(stdctx, http.MethodPost, rf.spec.URL, bytes.NewReader(ctxBuff))
 
 	if err != nil {
 		logger.Errorf("BUG: new request failed: %v", err)
@@ -473,5 +473,6 @@ func (c *ConsulServiceRegistry) catalogServiceToServiceInstance(catalogService *
 		Port:         uint16(catalogService.ServicePort),
 		Tags:         catalogService.ServiceTags,
 		Address:      serviceAddress,
+		Labels:       catalogService.ServiceMeta,
 	}
 }
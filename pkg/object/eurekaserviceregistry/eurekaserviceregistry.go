@@ -410,6 +410,9 @@ func (e *EurekaServiceRegistry) instanceInfoToServiceInstances(info *eurekaapi.I
 		InstanceID:   info.InstanceID,
 		Address:      address,
 	}
+	if info.Metadata != nil {
+		baseServiceInstanceSpec.Labels = info.Metadata.Map
+	}
 
 	if info.Port != nil && info.Port.Enabled {
 		plain := baseServiceInstanceSpec
@@ -0,0 +1,279 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpcserver
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+	"google.golang.org/grpc"
+
+	"github.com/megaease/easegress/v2/pkg/logger"
+)
+
+const (
+	sniffTimeout = 10 * time.Second
+
+	// defaultClientMaxBodySize is the default max size of the bodies of
+	// gRPC-Web and HTTP/JSON requests, which is the default max size of
+	// messages received by the gRPC server.
+	defaultClientMaxBodySize = 4 * 1024 * 1024
+)
+
+var errBodyTooLarge = errors.New("request body too large")
+
+type (
+	// gateway serves the HTTP/1.1 requests of a GRPCServer, it converts
+	// gRPC-Web and HTTP/JSON requests to gRPC requests and passes them
+	// to the gRPC server.
+	gateway struct {
+		srv         *grpc.Server
+		grpcWeb     *GRPCWebSpec
+		transcoder  *transcoder
+		maxBodySize int64
+	}
+
+	// splitListener dispatches the accepted connections to the gRPC
+	// server or the gateway according to the HTTP/2 client preface.
+	splitListener struct {
+		net.Listener
+
+		grpcConns chan net.Conn
+		httpConns chan net.Conn
+
+		done      chan struct{}
+		err       error
+		closeOnce sync.Once
+	}
+
+	subListener struct {
+		sl    *splitListener
+		conns chan net.Conn
+	}
+
+	sniffedConn struct {
+		net.Conn
+		r *bufio.Reader
+	}
+)
+
+func newGateway(spec *Spec, srv *grpc.Server) (*gateway, error) {
+	g := &gateway{
+		srv:         srv,
+		grpcWeb:     spec.GRPCWeb,
+		maxBodySize: spec.ClientMaxBodySize,
+	}
+	if g.maxBodySize <= 0 {
+		g.maxBodySize = defaultClientMaxBodySize
+	}
+	if spec.JSONTranscoding != nil {
+		t, err := newTranscoder(spec.JSONTranscoding)
+		if err != nil {
+			return nil, err
+		}
+		t.maxBodySize = g.maxBodySize
+		g.transcoder = t
+	}
+	return g, nil
+}
+
+// readBody reads the whole body, it returns errBodyTooLarge if the body
+// is larger than maxSize.
+func readBody(body io.Reader, maxSize int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, errBodyTooLarge
+	}
+	return data, nil
+}
+
+func (g *gateway) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch {
+	case g.grpcWeb != nil && isPreflightRequest(req):
+		g.handlePreflight(w, req)
+	case g.grpcWeb != nil && isGRPCWebRequest(req):
+		g.handleGRPCWeb(w, req)
+	case g.transcoder != nil:
+		g.transcoder.handle(g.srv, w, req)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func isPreflightRequest(req *http.Request) bool {
+	return req.Method == http.MethodOptions &&
+		req.Header.Get("Origin") != "" &&
+		req.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// corsPolicy is how a cross-origin request is answered, allowOrigin is
+// the value of Access-Control-Allow-Origin, and credentials are allowed
+// only if the origin is explicitly allowed.
+type corsPolicy struct {
+	allowOrigin string
+	credentials bool
+}
+
+// setHeader sets the CORS headers of the policy to h.
+func (p *corsPolicy) setHeader(h http.Header) {
+	h.Set("Access-Control-Allow-Origin", p.allowOrigin)
+	if p.credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+		h.Add("Vary", "Origin")
+	}
+}
+
+// corsPolicyOf returns the CORS policy of origin, it returns nil if the
+// origin is not allowed. All origins are allowed without credentials if
+// AllowedOrigins is empty or contains "*".
+func (g *gateway) corsPolicyOf(origin string) *corsPolicy {
+	wildcard := len(g.grpcWeb.AllowedOrigins) == 0
+	for _, o := range g.grpcWeb.AllowedOrigins {
+		if strings.EqualFold(o, origin) {
+			return &corsPolicy{allowOrigin: origin, credentials: true}
+		}
+		wildcard = wildcard || o == "*"
+	}
+	if wildcard {
+		return &corsPolicy{allowOrigin: "*"}
+	}
+	return nil
+}
+
+func (g *gateway) handlePreflight(w http.ResponseWriter, req *http.Request) {
+	cors := g.corsPolicyOf(req.Header.Get("Origin"))
+	if cors == nil {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	h := w.Header()
+	cors.setHeader(h)
+	h.Set("Access-Control-Allow-Methods", req.Header.Get("Access-Control-Request-Method"))
+	if v := req.Header.Get("Access-Control-Request-Headers"); v != "" {
+		h.Set("Access-Control-Allow-Headers", v)
+	}
+	h.Set("Access-Control-Max-Age", "600")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func newSplitListener(l net.Listener) *splitListener {
+	sl := &splitListener{
+		Listener:  l,
+		grpcConns: make(chan net.Conn),
+		httpConns: make(chan net.Conn),
+		done:      make(chan struct{}),
+	}
+	go sl.run()
+	return sl
+}
+
+func (sl *splitListener) run() {
+	for {
+		conn, err := sl.Listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			sl.closeWithError(err)
+			return
+		}
+		go sl.dispatch(conn)
+	}
+}
+
+func (sl *splitListener) dispatch(conn net.Conn) {
+	conn.SetReadDeadline(time.Now().Add(sniffTimeout))
+	r := bufio.NewReader(conn)
+
+	conns := sl.grpcConns
+	for i := 1; i <= len(http2.ClientPreface); i++ {
+		buf, err := r.Peek(i)
+		if err != nil {
+			logger.Debugf("sniff connection from %s failed: %v", conn.RemoteAddr(), err)
+			conn.Close()
+			return
+		}
+		if buf[i-1] != http2.ClientPreface[i-1] {
+			conns = sl.httpConns
+			break
+		}
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	select {
+	case conns <- &sniffedConn{Conn: conn, r: r}:
+	case <-sl.done:
+		conn.Close()
+	}
+}
+
+// Close closes the underlying listener, it is safe to be called
+// multiple times.
+func (sl *splitListener) Close() error {
+	return sl.closeWithError(net.ErrClosed)
+}
+
+func (sl *splitListener) closeWithError(cause error) error {
+	var err error
+	sl.closeOnce.Do(func() {
+		sl.err = cause
+		close(sl.done)
+		err = sl.Listener.Close()
+	})
+	return err
+}
+
+func (sl *splitListener) grpcListener() net.Listener {
+	return &subListener{sl: sl, conns: sl.grpcConns}
+}
+
+func (sl *splitListener) httpListener() net.Listener {
+	return &subListener{sl: sl, conns: sl.httpConns}
+}
+
+func (l *subListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.sl.done:
+		return nil, l.sl.err
+	}
+}
+
+func (l *subListener) Close() error {
+	return l.sl.Close()
+}
+
+func (l *subListener) Addr() net.Addr {
+	return l.sl.Addr()
+}
+
+func (c *sniffedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
@@ -0,0 +1,247 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpcserver
+
+import (
+	stdcontext "context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/context/contexttest"
+	"github.com/megaease/easegress/v2/pkg/protocols/grpcprot"
+	"github.com/megaease/easegress/v2/pkg/supervisor"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func TestGateway(t *testing.T) {
+	assert := assert.New(t)
+
+	yamlSpec := fmt.Sprintf(`
+kind: GRPCServer
+port: 18852
+name: server-grpc
+rules:
+- methods:
+  - methodPrefix: /
+    backend: mock
+grpcWeb:
+  allowedOrigins: ["http://allowed.com"]
+jsonTranscoding:
+  descriptorSet: %s
+clientMaxBodySize: 1024
+`, testDescriptorSet())
+	spec, err := supervisor.NewSpec(yamlSpec)
+	assert.NoError(err)
+
+	tc, err := newTranscoder(spec.ObjectSpec().(*Spec).JSONTranscoding)
+	assert.NoError(err)
+	sayHello := tc.routes[0].method
+	nameField := sayHello.Input().Fields().ByName("name")
+	countField := sayHello.Input().Fields().ByName("count")
+	messageField := sayHello.Output().Fields().ByName("message")
+
+	// The handler replies "hello <name> <count>", or NotFound if the
+	// name is "nobody".
+	handler := &contexttest.MockedHandler{
+		MockedHandle: func(ctx *context.Context) string {
+			stream := ctx.GetInputRequest().(*grpcprot.Request).GetServerStream()
+			resp := grpcprot.NewResponse()
+			ctx.SetResponse(context.DefaultNamespace, resp)
+
+			in := dynamicpb.NewMessage(sayHello.Input())
+			if err := stream.RecvMsg(in); err != nil {
+				resp.SetStatus(status.New(codes.InvalidArgument, err.Error()))
+				return ""
+			}
+			name := in.Get(nameField).String()
+			if name == "nobody" {
+				resp.SetStatus(status.New(codes.NotFound, "no such person"))
+				return ""
+			}
+
+			out := dynamicpb.NewMessage(sayHello.Output())
+			out.Set(messageField, protoreflect.ValueOfString(fmt.Sprintf("hello %s %d", name, in.Get(countField).Int())))
+			stream.SendMsg(out)
+			return ""
+		},
+	}
+	mapper := &contexttest.MockedMuxMapper{
+		MockedGetHandler: func(name string) (context.Handler, bool) {
+			return handler, true
+		},
+	}
+
+	r := newRuntime(spec, mapper)
+	r.reload(spec, mapper)
+	defer r.Close()
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(stateRunning, r.getState())
+
+	// Native gRPC.
+	conn, err := grpc.NewClient("127.0.0.1:18852", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(err)
+	defer conn.Close()
+	in := dynamicpb.NewMessage(sayHello.Input())
+	in.Set(nameField, protoreflect.ValueOfString("grpc"))
+	out := dynamicpb.NewMessage(sayHello.Output())
+	err = conn.Invoke(stdcontext.Background(), "/test.Greeter/SayHello", in, out)
+	assert.NoError(err)
+	assert.Equal("hello grpc 0", out.Get(messageField).String())
+
+	// gRPC-Web.
+	in.Set(nameField, protoreflect.ValueOfString("web"))
+	payload, _ := proto.Marshal(in)
+	frame := append([]byte{0, 0, 0, 0, byte(len(payload))}, payload...)
+	parseFrames := func(data []byte) (*dynamicpb.Message, string) {
+		msg := dynamicpb.NewMessage(sayHello.Output())
+		trailers := ""
+		for len(data) >= 5 {
+			size := int(data[4])
+			if data[0] == grpcWebTrailerFlag {
+				trailers = string(data[5 : 5+size])
+			} else {
+				proto.Unmarshal(data[5:5+size], msg)
+			}
+			data = data[5+size:]
+		}
+		return msg, trailers
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, "http://127.0.0.1:18852/test.Greeter/SayHello", strings.NewReader(string(frame)))
+	req.Header.Set("Content-Type", "application/grpc-web+proto")
+	req.Header.Set("Origin", "http://allowed.com")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(200, resp.StatusCode)
+	assert.Equal("application/grpc-web+proto", resp.Header.Get("Content-Type"))
+	assert.Equal("http://allowed.com", resp.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal("true", resp.Header.Get("Access-Control-Allow-Credentials"))
+	msg, trailers := parseFrames(body)
+	assert.Equal("hello web 0", msg.Get(messageField).String())
+	assert.Contains(trailers, "grpc-status: 0\r\n")
+
+	req, _ = http.NewRequest(http.MethodPost, "http://127.0.0.1:18852/test.Greeter/SayHello", strings.NewReader(base64.StdEncoding.EncodeToString(frame)))
+	req.Header.Set("Content-Type", "application/grpc-web-text")
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(err)
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	body, err = decodeGRPCWebText(body)
+	assert.NoError(err)
+	msg, trailers = parseFrames(body)
+	assert.Equal("hello web 0", msg.Get(messageField).String())
+	assert.Contains(trailers, "grpc-status: 0\r\n")
+
+	req, _ = http.NewRequest(http.MethodPost, "http://127.0.0.1:18852/test.Greeter/SayHello", strings.NewReader(string(frame)))
+	req.Header.Set("Content-Type", "application/grpc-web")
+	req.Header.Set("Origin", "http://denied.com")
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(err)
+	resp.Body.Close()
+	assert.Equal(http.StatusForbidden, resp.StatusCode)
+
+	// the body is too large.
+	req, _ = http.NewRequest(http.MethodPost, "http://127.0.0.1:18852/test.Greeter/SayHello", strings.NewReader(strings.Repeat("a", 1025)))
+	req.Header.Set("Content-Type", "application/grpc-web")
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(err)
+	resp.Body.Close()
+	assert.Equal(http.StatusRequestEntityTooLarge, resp.StatusCode)
+
+	// CORS preflight.
+	req, _ = http.NewRequest(http.MethodOptions, "http://127.0.0.1:18852/test.Greeter/SayHello", nil)
+	req.Header.Set("Origin", "http://allowed.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	req.Header.Set("Access-Control-Request-Headers", "content-type,x-grpc-web")
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(err)
+	resp.Body.Close()
+	assert.Equal(http.StatusNoContent, resp.StatusCode)
+	assert.Equal("content-type,x-grpc-web", resp.Header.Get("Access-Control-Allow-Headers"))
+
+	// HTTP/JSON transcoding.
+	doJSON := func(method, url, body string) (int, string) {
+		req, _ := http.NewRequest(method, url, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(err)
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+
+	code, body2 := doJSON(http.MethodGet, "http://127.0.0.1:18852/v1/hello/bob?count=2", "")
+	assert.Equal(200, code)
+	assert.JSONEq(`{"message":"hello bob 2"}`, body2)
+
+	code, body2 = doJSON(http.MethodPost, "http://127.0.0.1:18852/v1/hello", `{"name":"amy","count":5}`)
+	assert.Equal(200, code)
+	assert.JSONEq(`{"message":"hello amy 5"}`, body2)
+
+	code, body2 = doJSON(http.MethodGet, "http://127.0.0.1:18852/v1/hello/nobody", "")
+	assert.Equal(404, code)
+	assert.JSONEq(`{"code":5,"message":"no such person"}`, body2)
+
+	code, _ = doJSON(http.MethodPost, "http://127.0.0.1:18852/v1/hello", `{"name":1}`)
+	assert.Equal(400, code)
+
+	code, _ = doJSON(http.MethodGet, "http://127.0.0.1:18852/v2/unknown", "")
+	assert.Equal(404, code)
+
+	code, _ = doJSON(http.MethodPost, "http://127.0.0.1:18852/v1/hello", `{"name":"`+strings.Repeat("a", 1024)+`"}`)
+	assert.Equal(http.StatusTooManyRequests, code)
+}
+
+func TestCORSPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	// all origins are allowed without credentials.
+	g := &gateway{grpcWeb: &GRPCWebSpec{}}
+	assert.Equal(&corsPolicy{allowOrigin: "*"}, g.corsPolicyOf("http://a.com"))
+
+	g.grpcWeb.AllowedOrigins = []string{"*", "http://a.com"}
+	assert.Equal(&corsPolicy{allowOrigin: "http://A.com", credentials: true}, g.corsPolicyOf("http://A.com"))
+	assert.Equal(&corsPolicy{allowOrigin: "*"}, g.corsPolicyOf("http://b.com"))
+
+	g.grpcWeb.AllowedOrigins = []string{"http://a.com"}
+	assert.Nil(g.corsPolicyOf("http://b.com"))
+
+	h := http.Header{}
+	(&corsPolicy{allowOrigin: "*"}).setHeader(h)
+	assert.Equal("*", h.Get("Access-Control-Allow-Origin"))
+	assert.Empty(h.Get("Access-Control-Allow-Credentials"))
+	h = http.Header{}
+	g.corsPolicyOf("http://a.com").setHeader(h)
+	assert.Equal("http://a.com", h.Get("Access-Control-Allow-Origin"))
+	assert.Equal("true", h.Get("Access-Control-Allow-Credentials"))
+	assert.Equal("Origin", h.Get("Vary"))
+}
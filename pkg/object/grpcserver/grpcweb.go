@@ -0,0 +1,254 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpcserver
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/http2"
+)
+
+const (
+	grpcWebContentType     = "application/grpc-web"
+	grpcWebTextContentType = "application/grpc-web-text"
+	grpcContentType        = "application/grpc"
+
+	// grpcWebTrailerFlag marks the frame carrying the trailers in the
+	// response body of gRPC-Web.
+	grpcWebTrailerFlag = 0x80
+)
+
+// grpcWebResponseWriter converts the response of the gRPC server to the
+// gRPC-Web format, the trailers are appended to the body as a frame.
+type grpcWebResponseWriter struct {
+	w           http.ResponseWriter
+	header      http.Header
+	contentType string
+	text        bool
+	cors        *corsPolicy
+	wroteHeader bool
+
+	// encoder is the base64 encoder of the body in text mode.
+	encoder io.WriteCloser
+}
+
+func isGRPCWebRequest(req *http.Request) bool {
+	return strings.HasPrefix(req.Header.Get("Content-Type"), grpcWebContentType)
+}
+
+func (g *gateway) handleGRPCWeb(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "invalid gRPC-Web request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var cors *corsPolicy
+	if origin := req.Header.Get("Origin"); origin != "" {
+		if cors = g.corsPolicyOf(origin); cors == nil {
+			http.Error(w, "origin not allowed", http.StatusForbidden)
+			return
+		}
+	}
+
+	// gRPC-Web doesn't support client streaming, so read the whole body
+	// before writing anything, this is required by HTTP/1.1 too.
+	body, err := readBody(req.Body, g.maxBodySize)
+	if err == errBodyTooLarge {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("read body failed: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	ct := req.Header.Get("Content-Type")
+	text := strings.HasPrefix(ct, grpcWebTextContentType)
+	if text {
+		body, err = decodeGRPCWebText(body)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("decode body failed: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	grpcReq := req.Clone(req.Context())
+	grpcReq.Proto, grpcReq.ProtoMajor, grpcReq.ProtoMinor = "HTTP/2.0", 2, 0
+	grpcReq.Body = io.NopCloser(bytes.NewReader(body))
+	grpcReq.ContentLength = int64(len(body))
+	grpcReq.Header.Del("Content-Length")
+	grpcReq.Header.Del("Connection")
+	grpcReq.Header.Set("Content-Type", grpcContentTypeOf(ct, text))
+
+	ww := newGRPCWebResponseWriter(w, ct, text, cors)
+	g.srv.ServeHTTP(ww, grpcReq)
+	ww.finish()
+}
+
+// grpcContentTypeOf converts the content type of gRPC-Web to gRPC,
+// the sub type, e.g. +proto, is kept.
+func grpcContentTypeOf(ct string, text bool) string {
+	if text {
+		return grpcContentType + strings.TrimPrefix(ct, grpcWebTextContentType)
+	}
+	return grpcContentType + strings.TrimPrefix(ct, grpcWebContentType)
+}
+
+// decodeGRPCWebText decodes the body of a grpc-web-text request, the
+// body could be the concatenation of several padded base64 strings.
+func decodeGRPCWebText(data []byte) ([]byte, error) {
+	data = bytes.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' || r == ' ' || r == '\t' {
+			return -1
+		}
+		return r
+	}, data)
+	if len(data)%4 != 0 {
+		return nil, fmt.Errorf("invalid base64 length %d", len(data))
+	}
+
+	result := make([]byte, 0, base64.StdEncoding.DecodedLen(len(data)))
+	buf := make([]byte, 3)
+	for i := 0; i < len(data); i += 4 {
+		n, err := base64.StdEncoding.Decode(buf, data[i:i+4])
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, buf[:n]...)
+	}
+	return result, nil
+}
+
+func newGRPCWebResponseWriter(w http.ResponseWriter, ct string, text bool, cors *corsPolicy) *grpcWebResponseWriter {
+	ww := &grpcWebResponseWriter{
+		w:           w,
+		header:      http.Header{},
+		contentType: ct,
+		text:        text,
+		cors:        cors,
+	}
+	if text {
+		ww.encoder = base64.NewEncoder(base64.StdEncoding, w)
+	}
+	return ww
+}
+
+func (ww *grpcWebResponseWriter) Header() http.Header {
+	return ww.header
+}
+
+func (ww *grpcWebResponseWriter) WriteHeader(code int) {
+	if ww.wroteHeader {
+		return
+	}
+	ww.wroteHeader = true
+
+	trailers := ww.declaredTrailers()
+	h := ww.w.Header()
+	for k, v := range ww.header {
+		if k == "Trailer" || trailers[k] || strings.HasPrefix(k, http2.TrailerPrefix) {
+			continue
+		}
+		h[k] = v
+	}
+	h.Set("Content-Type", ww.contentType)
+	h.Del("Content-Length")
+	if ww.cors != nil {
+		ww.cors.setHeader(h)
+		h.Set("Access-Control-Expose-Headers", "Grpc-Status, Grpc-Message, Grpc-Status-Details-Bin")
+	}
+	ww.w.WriteHeader(code)
+}
+
+func (ww *grpcWebResponseWriter) Write(p []byte) (int, error) {
+	ww.WriteHeader(http.StatusOK)
+	if ww.text {
+		return ww.encoder.Write(p)
+	}
+	return ww.w.Write(p)
+}
+
+func (ww *grpcWebResponseWriter) Flush() {
+	ww.WriteHeader(http.StatusOK)
+	if f, ok := ww.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (ww *grpcWebResponseWriter) declaredTrailers() map[string]bool {
+	trailers := map[string]bool{}
+	for _, v := range ww.header["Trailer"] {
+		for _, k := range strings.Split(v, ",") {
+			trailers[http.CanonicalHeaderKey(strings.TrimSpace(k))] = true
+		}
+	}
+	return trailers
+}
+
+// trailerFrame builds the frame of the trailers, the keys of the
+// trailers are in lower case as required by gRPC-Web.
+func (ww *grpcWebResponseWriter) trailerFrame() []byte {
+	trailers := ww.declaredTrailers()
+
+	var lines []string
+	for k, vs := range ww.header {
+		if strings.HasPrefix(k, http2.TrailerPrefix) {
+			k = strings.TrimPrefix(k, http2.TrailerPrefix)
+		} else if !trailers[k] {
+			continue
+		}
+		for _, v := range vs {
+			lines = append(lines, strings.ToLower(k)+": "+v+"\r\n")
+		}
+	}
+	sort.Strings(lines)
+
+	payload := strings.Join(lines, "")
+	frame := make([]byte, 5, 5+len(payload))
+	frame[0] = grpcWebTrailerFlag
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(payload)))
+	return append(frame, payload...)
+}
+
+// finish writes the trailer frame, it must be called after the gRPC
+// server finishes serving the request.
+func (ww *grpcWebResponseWriter) finish() {
+	ww.WriteHeader(http.StatusOK)
+	frame := ww.trailerFrame()
+	if ww.text {
+		// Close the encoder to flush the pending bytes of the messages,
+		// so that the trailer frame is encoded separately.
+		ww.encoder.Close()
+		ww.encoder = base64.NewEncoder(base64.StdEncoding, ww.w)
+		ww.encoder.Write(frame)
+		ww.encoder.Close()
+	} else {
+		ww.w.Write(frame)
+	}
+	if f, ok := ww.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
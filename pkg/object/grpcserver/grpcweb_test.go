@@ -0,0 +1,87 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpcserver
+
+import (
+	"encoding/base64"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http2"
+)
+
+func TestDecodeGRPCWebText(t *testing.T) {
+	assert := assert.New(t)
+
+	data := base64.StdEncoding.EncodeToString([]byte("ab")) + "\r\n" + base64.StdEncoding.EncodeToString([]byte("cde"))
+	result, err := decodeGRPCWebText([]byte(data))
+	assert.NoError(err)
+	assert.Equal("abcde", string(result))
+
+	_, err = decodeGRPCWebText([]byte("abc"))
+	assert.Error(err)
+	_, err = decodeGRPCWebText([]byte("a!!!"))
+	assert.Error(err)
+
+	assert.Equal("application/grpc+proto", grpcContentTypeOf("application/grpc-web+proto", false))
+	assert.Equal("application/grpc", grpcContentTypeOf("application/grpc-web-text", true))
+}
+
+func TestGRPCWebResponseWriter(t *testing.T) {
+	assert := assert.New(t)
+
+	w := httptest.NewRecorder()
+	cors := &corsPolicy{allowOrigin: "http://a.com", credentials: true}
+	ww := newGRPCWebResponseWriter(w, "application/grpc-web+proto", false, cors)
+	h := ww.Header()
+	h.Set("Content-Type", "application/grpc+proto")
+	h.Add("Trailer", "Grpc-Status")
+	h.Add("Trailer", "Grpc-Message")
+	h.Set("X-Header", "1")
+	ww.Write([]byte{0, 0, 0, 0, 1, 'a'})
+	ww.Flush()
+	h.Set("Grpc-Status", "0")
+	h.Add(http2.TrailerPrefix+"x-trailer", "2")
+	ww.finish()
+
+	assert.Equal(200, w.Code)
+	assert.Equal("application/grpc-web+proto", w.Header().Get("Content-Type"))
+	assert.Equal("1", w.Header().Get("X-Header"))
+	assert.Equal("http://a.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal("true", w.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Empty(w.Header().Get("Trailer"))
+
+	trailers := "grpc-status: 0\r\nx-trailer: 2\r\n"
+	expected := append([]byte{0, 0, 0, 0, 1, 'a', 0x80, 0, 0, 0, byte(len(trailers))}, trailers...)
+	assert.Equal(expected, w.Body.Bytes())
+
+	w = httptest.NewRecorder()
+	ww = newGRPCWebResponseWriter(w, "application/grpc-web-text", true, nil)
+	ww.Write([]byte{0, 0, 0, 0, 1, 'a'})
+	ww.Header().Set("Grpc-Status", "0")
+	ww.Header().Add("Trailer", "Grpc-Status")
+	ww.finish()
+
+	assert.Empty(w.Header().Get("Access-Control-Allow-Origin"))
+	data, err := decodeGRPCWebText(w.Body.Bytes())
+	assert.NoError(err)
+	trailers = "grpc-status: 0\r\n"
+	expected = append([]byte{0, 0, 0, 0, 1, 'a', 0x80, 0, 0, 0, byte(len(trailers))}, trailers...)
+	assert.Equal(expected, data)
+}
@@ -305,13 +305,19 @@ func (mi *muxInstance) handler(request *grpcprot.Request) (result error) {
 	startAt := fasttime.Now()
 	ctx := context.New(tracing.NoopSpan)
 	ctx.SetRequest(context.DefaultNamespace, request)
+	// the deadline of the client, which is sent in grpc-timeout.
+	if deadline, ok := request.Context().Deadline(); ok {
+		ctx.SetDeadline(deadline)
+	}
 
 	defer func() {
 		var resp *grpcprot.Response
 		if err := recover(); err != nil {
 			logger.Warnf("gRPC server %s: panic %v, stack: \n%s\n", mi.superSpec.Name(), err, debug.Stack())
 			resp = buildFailureResponse(ctx, status.Newf(codes.Internal, "gRPC server %s: panic", mi.superSpec.Name()))
-		} else if v := ctx.GetResponse(context.DefaultNamespace); v == nil {
+		} else if v := ctx.GetResponse(context.DefaultNamespace); v == nil && ctx.DeadlineExceeded() {
+			resp = buildFailureResponse(ctx, status.Newf(codes.DeadlineExceeded, "gRPC server %s: deadline exceeded", mi.superSpec.Name()))
+		} else if v == nil {
 			resp = buildFailureResponse(ctx, status.Newf(codes.Internal, "gRPC server %s: response is nil ", mi.superSpec.Name()))
 		} else if r, ok := v.(*grpcprot.Response); !ok {
 			resp = buildFailureResponse(ctx, status.Newf(codes.Internal, "gRPC server %s: response type is %T", mi.superSpec.Name(), v))
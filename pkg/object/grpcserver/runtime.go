@@ -18,7 +18,10 @@
 package grpcserver
 
 import (
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
 	"reflect"
 	"sync/atomic"
 	"time"
@@ -29,6 +32,7 @@ import (
 	"github.com/megaease/easegress/v2/pkg/logger"
 	"github.com/megaease/easegress/v2/pkg/supervisor"
 	"github.com/megaease/easegress/v2/pkg/util/limitlistener"
+	"github.com/megaease/easegress/v2/pkg/util/proxyprotocol"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/encoding"
 	"google.golang.org/grpc/keepalive"
@@ -70,6 +74,7 @@ type (
 		superSpec *supervisor.Spec
 		spec      *Spec
 		s         *grpc.Server
+		gateway   *http.Server
 		mux       *mux
 		roundNum  uint64
 		eventChan chan interface{}
@@ -224,6 +229,8 @@ func (r *runtime) startServer() {
 		r.setError(err)
 		return
 	}
+	listen = proxyprotocol.NewListener(listen, r.spec.ProxyProtocol)
+
 	opts := []grpc.ServerOption{grpc.UnknownServiceHandler(r.mux.handler)}
 	keepaliveOpts := r.buildServerKeepaliveOpt()
 
@@ -237,8 +244,33 @@ func (r *runtime) startServer() {
 	r.s = grpc.NewServer(opts...)
 	// avoid data race
 	srv := r.s
+
+	var grpcListener net.Listener = limitListener
+	if r.spec.GRPCWeb != nil || r.spec.JSONTranscoding != nil {
+		g, err := newGateway(r.spec, srv)
+		if err != nil {
+			limitListener.Close()
+			r.setState(stateFailed)
+			r.setError(err)
+			return
+		}
+
+		// Native gRPC connections start with the HTTP/2 client preface,
+		// others are HTTP/1.1 connections served by the gateway.
+		sl := newSplitListener(limitListener)
+		grpcListener = sl.grpcListener()
+		r.gateway = &http.Server{Handler: g}
+		hs := r.gateway
+		go func() {
+			err := hs.Serve(sl.httpListener())
+			if err != nil && err != http.ErrServerClosed && !errors.Is(err, net.ErrClosed) {
+				logger.Errorf("gRPC server %s: gateway serve failed: %v", r.superSpec.Name(), err)
+			}
+		}()
+	}
+
 	go func() {
-		err := srv.Serve(limitListener)
+		err := srv.Serve(grpcListener)
 		if err != nil {
 			r.eventChan <- &eventServeFailed{
 				err:      err,
@@ -288,6 +320,10 @@ func (r *runtime) closeServer() {
 	if r.s != nil {
 		r.s.GracefulStop()
 	}
+	if r.gateway != nil {
+		r.gateway.Close()
+		r.gateway = nil
+	}
 }
 
 func (r *runtime) checkFailed(timeout time.Duration) {
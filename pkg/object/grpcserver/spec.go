@@ -22,6 +22,7 @@ import (
 	"regexp"
 
 	"github.com/megaease/easegress/v2/pkg/util/ipfilter"
+	"github.com/megaease/easegress/v2/pkg/util/proxyprotocol"
 )
 
 type (
@@ -50,6 +51,44 @@ type (
 		CacheSize     uint32         `json:"cacheSize,omitempty"`
 		GlobalFilter  string         `json:"globalFilter,omitempty"`
 		XForwardedFor bool           `json:"xForwardedFor,omitempty"`
+
+		// ProxyProtocol enables parsing PROXY protocol headers of
+		// connections from trusted sources.
+		ProxyProtocol *proxyprotocol.Spec `json:"proxyProtocol,omitempty"`
+
+		// GRPCWeb enables accepting gRPC-Web requests over HTTP/1.1.
+		GRPCWeb *GRPCWebSpec `json:"grpcWeb,omitempty"`
+		// JSONTranscoding enables transcoding HTTP/JSON requests to gRPC.
+		JSONTranscoding *JSONTranscodingSpec `json:"jsonTranscoding,omitempty"`
+		// ClientMaxBodySize is the max size of the bodies of gRPC-Web and
+		// HTTP/JSON requests, default is 4MB.
+		ClientMaxBodySize int64 `json:"clientMaxBodySize,omitempty" jsonschema:"minimum=0"`
+	}
+
+	// GRPCWebSpec describes the gRPC-Web support of the server.
+	GRPCWebSpec struct {
+		// AllowedOrigins are the origins allowed to send cross-origin
+		// requests, all origins are allowed without credentials if it is
+		// empty or contains "*".
+		AllowedOrigins []string `json:"allowedOrigins,omitempty"`
+	}
+
+	// JSONTranscodingSpec describes the HTTP/JSON to gRPC transcoding,
+	// the HTTP routes are generated from the google.api.http annotations
+	// of the methods in the descriptor set.
+	JSONTranscodingSpec struct {
+		// DescriptorSet is the base64 encoded FileDescriptorSet generated
+		// by protoc with --include_imports.
+		DescriptorSet string `json:"descriptorSet,omitempty" jsonschema:"format=base64"`
+		// DescriptorSetFile is the path of the FileDescriptorSet file.
+		DescriptorSetFile string `json:"descriptorSetFile,omitempty"`
+		// UseProtoNames uses the proto field names instead of the
+		// lowerCamelCase JSON names in responses.
+		UseProtoNames bool `json:"useProtoNames,omitempty"`
+		// EmitUnpopulated emits the fields with default values in responses.
+		EmitUnpopulated bool `json:"emitUnpopulated,omitempty"`
+		// DiscardUnknown ignores the unknown fields in request bodies.
+		DiscardUnknown bool `json:"discardUnknown,omitempty"`
 	}
 
 	// Rule is first level entry of router.
@@ -90,6 +129,21 @@ type (
 	}
 )
 
+// Validate validates Spec.
+func (s *Spec) Validate() error {
+	if s.ProxyProtocol != nil {
+		if err := s.ProxyProtocol.Validate(); err != nil {
+			return err
+		}
+	}
+	if s.JSONTranscoding != nil {
+		if _, err := newTranscoder(s.JSONTranscoding); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (h *Header) initHeaderRoute() {
 	h.headerRE = regexp.MustCompile(h.Regexp)
 }
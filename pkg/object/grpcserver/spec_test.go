@@ -80,3 +80,29 @@ func TestHeaderValidate(t *testing.T) {
 	h.Values = []string{"a"}
 	assert.NoError(t, h.Validate())
 }
+
+func TestProxyProtocol(t *testing.T) {
+	assert := assert.New(t)
+	superSpecYaml := `
+name: grpc-server-test
+kind: GRPCServer
+port: 10080
+proxyProtocol:
+  trustedCIDRs: ["10.0.0.0/8"]
+  headerTimeout: 3s
+`
+
+	_, err := supervisor.NewSpec(superSpecYaml)
+	assert.NoError(err)
+
+	superSpecYaml = `
+name: grpc-server-test
+kind: GRPCServer
+port: 10080
+proxyProtocol:
+  trustedCIDRs: ["10.0.0.0/a"]
+`
+
+	_, err = supervisor.NewSpec(superSpecYaml)
+	assert.Error(err)
+}
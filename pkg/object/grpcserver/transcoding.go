@@ -0,0 +1,761 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpcserver
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+type (
+	// transcoder transcodes HTTP/JSON requests to gRPC requests
+	// according to the google.api.http annotations.
+	transcoder struct {
+		routes      []*transcodingRoute
+		marshal     protojson.MarshalOptions
+		unmarshal   protojson.UnmarshalOptions
+		maxBodySize int64
+	}
+
+	transcodingRoute struct {
+		httpMethod   string
+		template     *pathTemplate
+		body         string
+		responseBody string
+		fullMethod   string
+		method       protoreflect.MethodDescriptor
+	}
+
+	// pathTemplate is the path template of google.api.http, the syntax is:
+	//
+	//	Template = "/" Segments [ Verb ] ;
+	//	Segments = Segment { "/" Segment } ;
+	//	Segment  = "*" | "**" | LITERAL | Variable ;
+	//	Variable = "{" FieldPath [ "=" Segments ] "}" ;
+	//	FieldPath = IDENT { "." IDENT } ;
+	//	Verb     = ":" LITERAL ;
+	pathTemplate struct {
+		segments  []string
+		variables []*templateVariable
+		verb      string
+	}
+
+	// templateVariable binds the segments in [start, end) to a field,
+	// end is -1 if the variable ends with "**".
+	templateVariable struct {
+		fieldPath string
+		start     int
+		end       int
+	}
+
+	// grpcResponseRecorder records the response of the gRPC server.
+	grpcResponseRecorder struct {
+		header http.Header
+		body   bytes.Buffer
+	}
+
+	transcodingError struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+)
+
+func newTranscoder(spec *JSONTranscodingSpec) (*transcoder, error) {
+	data, err := loadDescriptorSet(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	fds := &descriptorpb.FileDescriptorSet{}
+	if err = proto.Unmarshal(data, fds); err != nil {
+		return nil, fmt.Errorf("unmarshal descriptor set failed: %v", err)
+	}
+	files, err := protodesc.NewFiles(fds)
+	if err != nil {
+		return nil, fmt.Errorf("build descriptors failed: %v", err)
+	}
+
+	types := dynamicpb.NewTypes(files)
+	t := &transcoder{
+		maxBodySize: defaultClientMaxBodySize,
+		marshal: protojson.MarshalOptions{
+			UseProtoNames:   spec.UseProtoNames,
+			EmitUnpopulated: spec.EmitUnpopulated,
+			Resolver:        types,
+		},
+		unmarshal: protojson.UnmarshalOptions{
+			DiscardUnknown: spec.DiscardUnknown,
+			Resolver:       types,
+		},
+	}
+
+	files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		services := fd.Services()
+		for i := 0; i < services.Len() && err == nil; i++ {
+			methods := services.Get(i).Methods()
+			for j := 0; j < methods.Len() && err == nil; j++ {
+				err = t.addMethod(methods.Get(j))
+			}
+		}
+		return err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(t.routes) == 0 {
+		return nil, fmt.Errorf("no method has google.api.http annotation")
+	}
+	return t, nil
+}
+
+func loadDescriptorSet(spec *JSONTranscodingSpec) ([]byte, error) {
+	switch {
+	case spec.DescriptorSet != "" && spec.DescriptorSetFile != "":
+		return nil, fmt.Errorf("descriptorSet and descriptorSetFile are exclusive")
+	case spec.DescriptorSet != "":
+		data, err := base64.StdEncoding.DecodeString(spec.DescriptorSet)
+		if err != nil {
+			return nil, fmt.Errorf("decode descriptor set failed: %v", err)
+		}
+		return data, nil
+	case spec.DescriptorSetFile != "":
+		data, err := os.ReadFile(spec.DescriptorSetFile)
+		if err != nil {
+			return nil, fmt.Errorf("read descriptor set failed: %v", err)
+		}
+		return data, nil
+	default:
+		return nil, fmt.Errorf("both descriptorSet and descriptorSetFile are empty")
+	}
+}
+
+func (t *transcoder) addMethod(md protoreflect.MethodDescriptor) error {
+	opts, ok := md.Options().(*descriptorpb.MethodOptions)
+	if !ok || opts == nil || !proto.HasExtension(opts, annotations.E_Http) {
+		return nil
+	}
+	// Client streaming can't be expressed by a single HTTP request.
+	if md.IsStreamingClient() {
+		return nil
+	}
+
+	rule := proto.GetExtension(opts, annotations.E_Http).(*annotations.HttpRule)
+	rules := append([]*annotations.HttpRule{rule}, rule.GetAdditionalBindings()...)
+	fullMethod := fmt.Sprintf("/%s/%s", md.Parent().FullName(), md.Name())
+
+	for _, rule := range rules {
+		var httpMethod, path string
+		switch p := rule.GetPattern().(type) {
+		case *annotations.HttpRule_Get:
+			httpMethod, path = http.MethodGet, p.Get
+		case *annotations.HttpRule_Put:
+			httpMethod, path = http.MethodPut, p.Put
+		case *annotations.HttpRule_Post:
+			httpMethod, path = http.MethodPost, p.Post
+		case *annotations.HttpRule_Delete:
+			httpMethod, path = http.MethodDelete, p.Delete
+		case *annotations.HttpRule_Patch:
+			httpMethod, path = http.MethodPatch, p.Patch
+		case *annotations.HttpRule_Custom:
+			httpMethod, path = p.Custom.GetKind(), p.Custom.GetPath()
+		default:
+			continue
+		}
+
+		template, err := parsePathTemplate(path)
+		if err != nil {
+			return fmt.Errorf("method %s: %v", fullMethod, err)
+		}
+		for _, v := range template.variables {
+			if _, err := findField(md.Input(), v.fieldPath); err != nil {
+				return fmt.Errorf("method %s: %v", fullMethod, err)
+			}
+		}
+		if body := rule.GetBody(); body != "" && body != "*" {
+			if _, err := findField(md.Input(), body); err != nil {
+				return fmt.Errorf("method %s: %v", fullMethod, err)
+			}
+		}
+		if rb := rule.GetResponseBody(); rb != "" && md.Output().Fields().ByName(protoreflect.Name(rb)) == nil {
+			return fmt.Errorf("method %s: response body field %s not found", fullMethod, rb)
+		}
+
+		t.routes = append(t.routes, &transcodingRoute{
+			httpMethod:   httpMethod,
+			template:     template,
+			body:         rule.GetBody(),
+			responseBody: rule.GetResponseBody(),
+			fullMethod:   fullMethod,
+			method:       md,
+		})
+	}
+	return nil
+}
+
+func parsePathTemplate(path string) (*pathTemplate, error) {
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("path template %q must start with /", path)
+	}
+
+	t := &pathTemplate{}
+	s := path[1:]
+	if i := strings.LastIndexByte(s, ':'); i >= 0 && !strings.ContainsAny(s[i:], "/}") {
+		s, t.verb = s[:i], s[i+1:]
+	}
+
+	for s != "" {
+		var seg string
+		if s[0] == '{' {
+			end := strings.IndexByte(s, '}')
+			if end < 0 {
+				return nil, fmt.Errorf("path template %q: unclosed variable", path)
+			}
+			seg, s = s[1:end], s[end+1:]
+
+			v := &templateVariable{fieldPath: seg, start: len(t.segments)}
+			segs := []string{"*"}
+			if i := strings.IndexByte(seg, '='); i >= 0 {
+				v.fieldPath, segs = seg[:i], strings.Split(seg[i+1:], "/")
+			}
+			if v.fieldPath == "" {
+				return nil, fmt.Errorf("path template %q: empty variable", path)
+			}
+			t.segments = append(t.segments, segs...)
+			v.end = len(t.segments)
+			if segs[len(segs)-1] == "**" {
+				v.end = -1
+			}
+			t.variables = append(t.variables, v)
+		} else {
+			end := strings.IndexByte(s, '/')
+			if end < 0 {
+				end = len(s)
+			}
+			seg, s = s[:end], s[end:]
+			if seg == "" {
+				return nil, fmt.Errorf("path template %q: empty segment", path)
+			}
+			t.segments = append(t.segments, seg)
+		}
+
+		if s != "" {
+			if s[0] != '/' || len(s) == 1 {
+				return nil, fmt.Errorf("path template %q: invalid segment separator", path)
+			}
+			s = s[1:]
+		}
+	}
+
+	for i, seg := range t.segments {
+		if seg == "**" && i != len(t.segments)-1 {
+			return nil, fmt.Errorf("path template %q: ** must be the last segment", path)
+		}
+	}
+	return t, nil
+}
+
+// match matches the escaped path against the template, and returns the
+// values of the variables if matched.
+func (t *pathTemplate) match(path string) (map[string]string, bool) {
+	if !strings.HasPrefix(path, "/") {
+		return nil, false
+	}
+	path = path[1:]
+	if t.verb != "" {
+		if !strings.HasSuffix(path, ":"+t.verb) {
+			return nil, false
+		}
+		path = strings.TrimSuffix(path, ":"+t.verb)
+	}
+
+	var parts []string
+	if path != "" {
+		parts = strings.Split(path, "/")
+	}
+
+	n := len(t.segments)
+	deep := n > 0 && t.segments[n-1] == "**"
+	if deep && len(parts) < n-1 || !deep && len(parts) != n {
+		return nil, false
+	}
+
+	for i, seg := range t.segments {
+		switch seg {
+		case "**":
+		case "*":
+			if parts[i] == "" {
+				return nil, false
+			}
+		default:
+			if parts[i] != seg {
+				return nil, false
+			}
+		}
+	}
+
+	vars := map[string]string{}
+	for _, v := range t.variables {
+		end := v.end
+		if end < 0 {
+			end = len(parts)
+		}
+		values := make([]string, 0, end-v.start)
+		for _, p := range parts[v.start:end] {
+			value, err := url.PathUnescape(p)
+			if err != nil {
+				return nil, false
+			}
+			values = append(values, value)
+		}
+		vars[v.fieldPath] = strings.Join(values, "/")
+	}
+	return vars, true
+}
+
+func (t *transcoder) match(req *http.Request) (*transcodingRoute, map[string]string) {
+	path := req.URL.EscapedPath()
+	for _, rt := range t.routes {
+		if rt.httpMethod != req.Method {
+			continue
+		}
+		if vars, ok := rt.template.match(path); ok {
+			return rt, vars
+		}
+	}
+	return nil, nil
+}
+
+func (t *transcoder) handle(srv *grpc.Server, w http.ResponseWriter, req *http.Request) {
+	rt, vars := t.match(req)
+	if rt == nil {
+		writeTranscodingError(w, codes.NotFound, fmt.Sprintf("no route for %s %s", req.Method, req.URL.Path))
+		return
+	}
+
+	payload, err := t.buildRequestMessage(rt, vars, req)
+	if err == errBodyTooLarge {
+		writeTranscodingError(w, codes.ResourceExhausted, err.Error())
+		return
+	}
+	if err != nil {
+		writeTranscodingError(w, codes.InvalidArgument, err.Error())
+		return
+	}
+
+	grpcReq, err := buildGRPCRequest(rt, req, payload)
+	if err != nil {
+		writeTranscodingError(w, codes.Internal, err.Error())
+		return
+	}
+
+	rec := &grpcResponseRecorder{header: http.Header{}}
+	srv.ServeHTTP(rec, grpcReq)
+
+	code, msg := rec.status()
+	if code != codes.OK {
+		copyResponseMetadata(w.Header(), rec.header)
+		writeTranscodingError(w, code, msg)
+		return
+	}
+
+	body, err := t.buildResponseBody(rt, rec.body.Bytes())
+	if err != nil {
+		writeTranscodingError(w, codes.Internal, err.Error())
+		return
+	}
+
+	copyResponseMetadata(w.Header(), rec.header)
+	if rt.method.IsStreamingServer() {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+func (t *transcoder) buildRequestMessage(rt *transcodingRoute, vars map[string]string, req *http.Request) ([]byte, error) {
+	msg := dynamicpb.NewMessage(rt.method.Input())
+
+	if rt.body != "" {
+		data, err := readBody(req.Body, t.maxBodySize)
+		if err == errBodyTooLarge {
+			return nil, err
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read body failed: %v", err)
+		}
+		if len(bytes.TrimSpace(data)) > 0 {
+			if err = t.unmarshalBody(msg, rt.body, data); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for fp, value := range vars {
+		if err := setField(msg, fp, value); err != nil {
+			return nil, err
+		}
+	}
+
+	if rt.body != "*" {
+		for key, values := range req.URL.Query() {
+			if _, ok := vars[key]; ok {
+				continue
+			}
+			if _, err := findField(msg.Descriptor(), key); err != nil {
+				// Ignore the unknown query parameters.
+				continue
+			}
+			for _, value := range values {
+				if err := setField(msg, key, value); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	return proto.Marshal(msg)
+}
+
+func (t *transcoder) unmarshalBody(msg *dynamicpb.Message, fieldPath string, data []byte) error {
+	if fieldPath == "*" {
+		if err := t.unmarshal.Unmarshal(data, msg); err != nil {
+			return fmt.Errorf("unmarshal body failed: %v", err)
+		}
+		return nil
+	}
+
+	parent, fd, err := mutableField(msg, fieldPath)
+	if err != nil {
+		return err
+	}
+
+	// Wrap the body as the field of its parent message, so that all
+	// kinds of fields are unmarshalled in the same way.
+	wrapped, err := json.Marshal(map[string]json.RawMessage{fd.JSONName(): data})
+	if err != nil {
+		return fmt.Errorf("unmarshal body failed: %v", err)
+	}
+	tmp := parent.New().Interface()
+	if err = t.unmarshal.Unmarshal(wrapped, tmp); err != nil {
+		return fmt.Errorf("unmarshal body failed: %v", err)
+	}
+	parent.Set(fd, tmp.ProtoReflect().Get(fd))
+	return nil
+}
+
+func (t *transcoder) buildResponseBody(rt *transcodingRoute, data []byte) ([]byte, error) {
+	var result []byte
+	for len(data) > 0 {
+		if len(data) < 5 {
+			return nil, fmt.Errorf("malformed gRPC response")
+		}
+		if data[0] != 0 {
+			return nil, fmt.Errorf("compressed gRPC response is not supported")
+		}
+		size := binary.BigEndian.Uint32(data[1:5])
+		if uint32(len(data)-5) < size {
+			return nil, fmt.Errorf("malformed gRPC response")
+		}
+
+		msg := dynamicpb.NewMessage(rt.method.Output())
+		if err := proto.Unmarshal(data[5:5+size], msg); err != nil {
+			return nil, fmt.Errorf("unmarshal response failed: %v", err)
+		}
+		data = data[5+size:]
+
+		body, err := t.marshalResponse(rt, msg)
+		if err != nil {
+			return nil, err
+		}
+		if !rt.method.IsStreamingServer() {
+			return body, nil
+		}
+		result = append(append(result, body...), '\n')
+	}
+
+	if !rt.method.IsStreamingServer() {
+		return nil, fmt.Errorf("empty gRPC response")
+	}
+	return result, nil
+}
+
+func (t *transcoder) marshalResponse(rt *transcodingRoute, msg *dynamicpb.Message) ([]byte, error) {
+	if rt.responseBody == "" {
+		return t.marshal.Marshal(msg)
+	}
+
+	fd := msg.Descriptor().Fields().ByName(protoreflect.Name(rt.responseBody))
+	opts := t.marshal
+	opts.EmitUnpopulated = true
+	data, err := opts.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]json.RawMessage{}
+	if err = json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	name := fd.JSONName()
+	if opts.UseProtoNames {
+		name = string(fd.Name())
+	}
+	return fields[name], nil
+}
+
+// buildGRPCRequest builds the request to the gRPC server, the headers of
+// the original request are passed as metadata.
+func buildGRPCRequest(rt *transcodingRoute, req *http.Request, payload []byte) (*http.Request, error) {
+	frame := make([]byte, 5, 5+len(payload))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(payload)))
+	frame = append(frame, payload...)
+
+	grpcReq, err := http.NewRequestWithContext(req.Context(), http.MethodPost, rt.fullMethod, bytes.NewReader(frame))
+	if err != nil {
+		return nil, err
+	}
+	grpcReq.Proto, grpcReq.ProtoMajor, grpcReq.ProtoMinor = "HTTP/2.0", 2, 0
+	grpcReq.Host = req.Host
+	grpcReq.RemoteAddr = req.RemoteAddr
+
+	for k, v := range req.Header {
+		switch k {
+		case "Content-Type", "Content-Length", "Connection", "Keep-Alive",
+			"Te", "Transfer-Encoding", "Upgrade", "Accept-Encoding":
+			continue
+		}
+		grpcReq.Header[k] = v
+	}
+	grpcReq.Header.Set("Content-Type", grpcContentType)
+	return grpcReq, nil
+}
+
+// findField finds the field of the field path, e.g. "a.b.c", in the
+// message, the intermediate fields must be singular messages.
+func findField(md protoreflect.MessageDescriptor, fieldPath string) (protoreflect.FieldDescriptor, error) {
+	names := strings.Split(fieldPath, ".")
+	for i, name := range names {
+		fd := md.Fields().ByName(protoreflect.Name(name))
+		if fd == nil {
+			fd = md.Fields().ByJSONName(name)
+		}
+		if fd == nil {
+			return nil, fmt.Errorf("field %s not found in %s", fieldPath, md.FullName())
+		}
+		if i == len(names)-1 {
+			return fd, nil
+		}
+		if fd.Message() == nil || fd.IsList() || fd.IsMap() {
+			return nil, fmt.Errorf("field %s of %s is not a message", name, md.FullName())
+		}
+		md = fd.Message()
+	}
+	return nil, fmt.Errorf("empty field path")
+}
+
+// mutableField returns the parent message and the descriptor of the
+// field, the intermediate messages are created if necessary.
+func mutableField(msg protoreflect.Message, fieldPath string) (protoreflect.Message, protoreflect.FieldDescriptor, error) {
+	if _, err := findField(msg.Descriptor(), fieldPath); err != nil {
+		return nil, nil, err
+	}
+
+	names := strings.Split(fieldPath, ".")
+	for _, name := range names[:len(names)-1] {
+		fd, _ := findField(msg.Descriptor(), name)
+		msg = msg.Mutable(fd).Message()
+	}
+	fd, _ := findField(msg.Descriptor(), names[len(names)-1])
+	return msg, fd, nil
+}
+
+// setField sets the value of the field from a string, the value is
+// appended if the field is repeated.
+func setField(msg protoreflect.Message, fieldPath string, value string) error {
+	parent, fd, err := mutableField(msg, fieldPath)
+	if err != nil {
+		return err
+	}
+	if fd.IsMap() {
+		return fmt.Errorf("field %s is a map", fieldPath)
+	}
+
+	v, err := parseFieldValue(fd, value)
+	if err != nil {
+		return fmt.Errorf("invalid value %q of field %s: %v", value, fieldPath, err)
+	}
+	if fd.IsList() {
+		parent.Mutable(fd).List().Append(v)
+	} else {
+		parent.Set(fd, v)
+	}
+	return nil
+}
+
+func parseFieldValue(fd protoreflect.FieldDescriptor, value string) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(value), nil
+	case protoreflect.BytesKind:
+		b, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			b, err = base64.URLEncoding.DecodeString(value)
+		}
+		return protoreflect.ValueOfBytes(b), err
+	case protoreflect.BoolKind:
+		b, err := strconv.ParseBool(value)
+		return protoreflect.ValueOfBool(b), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		i, err := strconv.ParseInt(value, 10, 32)
+		return protoreflect.ValueOfInt32(int32(i)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		i, err := strconv.ParseInt(value, 10, 64)
+		return protoreflect.ValueOfInt64(i), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		u, err := strconv.ParseUint(value, 10, 32)
+		return protoreflect.ValueOfUint32(uint32(u)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		u, err := strconv.ParseUint(value, 10, 64)
+		return protoreflect.ValueOfUint64(u), err
+	case protoreflect.FloatKind:
+		f, err := strconv.ParseFloat(value, 32)
+		return protoreflect.ValueOfFloat32(float32(f)), err
+	case protoreflect.DoubleKind:
+		f, err := strconv.ParseFloat(value, 64)
+		return protoreflect.ValueOfFloat64(f), err
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByName(protoreflect.Name(value)); ev != nil {
+			return protoreflect.ValueOfEnum(ev.Number()), nil
+		}
+		i, err := strconv.ParseInt(value, 10, 32)
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(i)), err
+	default:
+		return protoreflect.Value{}, fmt.Errorf("unsupported field kind %s", fd.Kind())
+	}
+}
+
+// copyResponseMetadata copies the response metadata of the gRPC server
+// to the HTTP response.
+func copyResponseMetadata(dst, src http.Header) {
+	trailers := map[string]bool{}
+	for _, v := range src["Trailer"] {
+		for _, k := range strings.Split(v, ",") {
+			trailers[http.CanonicalHeaderKey(strings.TrimSpace(k))] = true
+		}
+	}
+	for k, v := range src {
+		if k == "Trailer" || k == "Content-Type" || trailers[k] || strings.HasPrefix(k, "Grpc-") || strings.Contains(k, ":") {
+			continue
+		}
+		dst[k] = v
+	}
+}
+
+func writeTranscodingError(w http.ResponseWriter, code codes.Code, msg string) {
+	body, _ := json.Marshal(&transcodingError{Code: int(code), Message: msg})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatusFromCode(code))
+	w.Write(body)
+}
+
+// httpStatusFromCode maps gRPC status codes to HTTP status codes, see
+// https://github.com/googleapis/googleapis/blob/master/google/rpc/code.proto
+func httpStatusFromCode(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499
+	case codes.InvalidArgument, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.FailedPrecondition:
+		return http.StatusBadRequest
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (rec *grpcResponseRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *grpcResponseRecorder) WriteHeader(code int) {
+}
+
+func (rec *grpcResponseRecorder) Write(p []byte) (int, error) {
+	return rec.body.Write(p)
+}
+
+func (rec *grpcResponseRecorder) Flush() {
+}
+
+// status returns the gRPC status of the response, the message is
+// percent decoded.
+func (rec *grpcResponseRecorder) status() (codes.Code, string) {
+	s := rec.header.Get("Grpc-Status")
+	if s == "" {
+		return codes.Unknown, "gRPC status is missing"
+	}
+	code, err := strconv.Atoi(s)
+	if err != nil {
+		return codes.Unknown, fmt.Sprintf("invalid gRPC status %q", s)
+	}
+
+	msg := rec.header.Get("Grpc-Message")
+	if m, err := url.PathUnescape(msg); err == nil {
+		msg = m
+	}
+	return codes.Code(code), msg
+}
@@ -0,0 +1,256 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpcserver
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// testDescriptorSet returns the descriptor set of:
+//
+//	package test;
+//	message HelloRequest { string name = 1; int32 count = 2; repeated string tags = 3; }
+//	message HelloReply { string message = 1; }
+//	service Greeter {
+//	  rpc SayHello(HelloRequest) returns (HelloReply) {
+//	    option (google.api.http) = {
+//	      get: "/v1/hello/{name}"
+//	      additional_bindings { post: "/v1/hello" body: "*" }
+//	    };
+//	  }
+//	  rpc Echo(HelloRequest) returns (HelloReply);
+//	}
+func testDescriptorSet() string {
+	field := func(name string, num int32, typ descriptorpb.FieldDescriptorProto_Type, label descriptorpb.FieldDescriptorProto_Label) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			Number:   proto.Int32(num),
+			Type:     typ.Enum(),
+			Label:    label.Enum(),
+			JsonName: proto.String(name),
+		}
+	}
+	optional := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+	repeated := descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+
+	opts := &descriptorpb.MethodOptions{}
+	proto.SetExtension(opts, annotations.E_Http, &annotations.HttpRule{
+		Pattern: &annotations.HttpRule_Get{Get: "/v1/hello/{name}"},
+		AdditionalBindings: []*annotations.HttpRule{{
+			Pattern: &annotations.HttpRule_Post{Post: "/v1/hello"},
+			Body:    "*",
+		}},
+	})
+
+	fds := &descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{{
+			Name:    proto.String("test.proto"),
+			Package: proto.String("test"),
+			Syntax:  proto.String("proto3"),
+			MessageType: []*descriptorpb.DescriptorProto{{
+				Name: proto.String("HelloRequest"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, optional),
+					field("count", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32, optional),
+					field("tags", 3, descriptorpb.FieldDescriptorProto_TYPE_STRING, repeated),
+				},
+			}, {
+				Name: proto.String("HelloReply"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("message", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, optional),
+				},
+			}},
+			Service: []*descriptorpb.ServiceDescriptorProto{{
+				Name: proto.String("Greeter"),
+				Method: []*descriptorpb.MethodDescriptorProto{{
+					Name:       proto.String("SayHello"),
+					InputType:  proto.String(".test.HelloRequest"),
+					OutputType: proto.String(".test.HelloReply"),
+					Options:    opts,
+				}, {
+					Name:       proto.String("Echo"),
+					InputType:  proto.String(".test.HelloRequest"),
+					OutputType: proto.String(".test.HelloReply"),
+				}},
+			}},
+		}},
+	}
+
+	data, _ := proto.Marshal(fds)
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+func TestPathTemplate(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, path := range []string{"v1", "/v1/{name", "/v1//a", "/v1/", "/v1/**/a", "/v1/{}"} {
+		_, err := parsePathTemplate(path)
+		assert.Error(err, path)
+	}
+
+	cases := []struct {
+		template string
+		path     string
+		vars     map[string]string
+	}{
+		{"/v1/hello/{name}", "/v1/hello/bob", map[string]string{"name": "bob"}},
+		{"/v1/hello/{name}", "/v1/hello/b%2Fo", map[string]string{"name": "b/o"}},
+		{"/v1/hello/{name}", "/v1/hello/bob/x", nil},
+		{"/v1/hello/{name}", "/v1/hello/", nil},
+		{"/v1/{name=shelves/*}/books", "/v1/shelves/1/books", map[string]string{"name": "shelves/1"}},
+		{"/v1/{book.id}:publish", "/v1/7:publish", map[string]string{"book.id": "7"}},
+		{"/v1/{book.id}:publish", "/v1/7", nil},
+		{"/v1/{path=**}", "/v1/a/b/c", map[string]string{"path": "a/b/c"}},
+		{"/v1/*/x", "/v1/a/x", map[string]string{}},
+		{"/v1/*/x", "/v1/a/y", nil},
+	}
+	for _, c := range cases {
+		tmpl, err := parsePathTemplate(c.template)
+		assert.NoError(err, c.template)
+		vars, ok := tmpl.match(c.path)
+		assert.Equal(c.vars != nil, ok, c.template+" "+c.path)
+		if ok {
+			assert.Equal(c.vars, vars, c.template+" "+c.path)
+		}
+	}
+}
+
+func TestNewTranscoder(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := newTranscoder(&JSONTranscodingSpec{})
+	assert.Error(err)
+	_, err = newTranscoder(&JSONTranscodingSpec{DescriptorSet: "a", DescriptorSetFile: "b"})
+	assert.Error(err)
+	_, err = newTranscoder(&JSONTranscodingSpec{DescriptorSet: "!!!"})
+	assert.Error(err)
+	_, err = newTranscoder(&JSONTranscodingSpec{DescriptorSetFile: "/not/exist"})
+	assert.Error(err)
+
+	tc, err := newTranscoder(&JSONTranscodingSpec{DescriptorSet: testDescriptorSet()})
+	assert.NoError(err)
+	assert.Len(tc.routes, 2)
+	assert.Equal("/test.Greeter/SayHello", tc.routes[0].fullMethod)
+
+	spec := &Spec{JSONTranscoding: &JSONTranscodingSpec{DescriptorSet: "!!!"}}
+	assert.Error(spec.Validate())
+}
+
+func TestBuildRequestMessage(t *testing.T) {
+	assert := assert.New(t)
+
+	tc, err := newTranscoder(&JSONTranscodingSpec{DescriptorSet: testDescriptorSet()})
+	assert.NoError(err)
+
+	req := httptest.NewRequest("GET", "/v1/hello/bob?count=3&tags=a&tags=b&unknown=1", nil)
+	rt, vars := tc.match(req)
+	assert.NotNil(rt)
+	data, err := tc.buildRequestMessage(rt, vars, req)
+	assert.NoError(err)
+
+	msg := dynamicpb.NewMessage(rt.method.Input())
+	assert.NoError(proto.Unmarshal(data, msg))
+	js, _ := tc.marshal.Marshal(msg)
+	assert.JSONEq(`{"name":"bob","count":3,"tags":["a","b"]}`, string(js))
+
+	req = httptest.NewRequest("GET", "/v1/hello/bob?count=x", nil)
+	rt, vars = tc.match(req)
+	_, err = tc.buildRequestMessage(rt, vars, req)
+	assert.Error(err)
+
+	req = httptest.NewRequest("POST", "/v1/hello", strings.NewReader(`{"name":"amy","count":1}`))
+	rt, vars = tc.match(req)
+	assert.NotNil(rt)
+	data, err = tc.buildRequestMessage(rt, vars, req)
+	assert.NoError(err)
+	msg = dynamicpb.NewMessage(rt.method.Input())
+	assert.NoError(proto.Unmarshal(data, msg))
+	js, _ = tc.marshal.Marshal(msg)
+	assert.JSONEq(`{"name":"amy","count":1}`, string(js))
+
+	req = httptest.NewRequest("POST", "/v1/hello", strings.NewReader(`{"bad":1}`))
+	rt, vars = tc.match(req)
+	_, err = tc.buildRequestMessage(rt, vars, req)
+	assert.Error(err)
+
+	req = httptest.NewRequest("DELETE", "/v1/hello", nil)
+	rt, _ = tc.match(req)
+	assert.Nil(rt)
+}
+
+func TestBuildResponseBody(t *testing.T) {
+	assert := assert.New(t)
+
+	tc, err := newTranscoder(&JSONTranscodingSpec{DescriptorSet: testDescriptorSet()})
+	assert.NoError(err)
+	rt := tc.routes[0]
+
+	msg := dynamicpb.NewMessage(rt.method.Output())
+	msg.Set(rt.method.Output().Fields().ByName("message"), protoreflect.ValueOfString("hi"))
+	payload, _ := proto.Marshal(msg)
+	frame := append([]byte{0, 0, 0, 0, byte(len(payload))}, payload...)
+
+	body, err := tc.buildResponseBody(rt, frame)
+	assert.NoError(err)
+	assert.JSONEq(`{"message":"hi"}`, string(body))
+
+	rt.responseBody = "message"
+	body, err = tc.buildResponseBody(rt, frame)
+	assert.NoError(err)
+	assert.Equal(`"hi"`, string(body))
+
+	_, err = tc.buildResponseBody(rt, nil)
+	assert.Error(err)
+	_, err = tc.buildResponseBody(rt, frame[:3])
+	assert.Error(err)
+	frame[0] = 1
+	_, err = tc.buildResponseBody(rt, frame)
+	assert.Error(err)
+}
+
+func TestResponseRecorderStatus(t *testing.T) {
+	assert := assert.New(t)
+
+	rec := &grpcResponseRecorder{header: map[string][]string{}}
+	code, _ := rec.status()
+	assert.Equal(codes.Unknown, code)
+
+	rec.header.Set("Grpc-Status", "5")
+	rec.header.Set("Grpc-Message", "not%20found")
+	code, msg := rec.status()
+	assert.Equal(codes.NotFound, code)
+	assert.Equal("not found", msg)
+	assert.Equal(404, httpStatusFromCode(code))
+
+	w := httptest.NewRecorder()
+	writeTranscodingError(w, codes.Unavailable, "oops")
+	assert.Equal(503, w.Code)
+	assert.True(bytes.Contains(w.Body.Bytes(), []byte(`"code":14`)))
+}
@@ -26,6 +26,7 @@ import (
 	"reflect"
 	"regexp"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"text/template"
 	"time"
@@ -36,6 +37,7 @@ import (
 
 	lru "github.com/hashicorp/golang-lru"
 	"github.com/megaease/easegress/v2/pkg/object/globalfilter"
+	"github.com/megaease/easegress/v2/pkg/protocols/grpcprot"
 	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
 
 	"github.com/megaease/easegress/v2/pkg/context"
@@ -94,19 +96,21 @@ type (
 	}
 
 	accessLog struct {
-		Time        string
-		RemoteAddr  string
-		RealIP      string
-		Method      string
-		URI         string
-		Proto       string
-		StatusCode  int
-		Duration    time.Duration
-		ReqSize     uint64
-		RespSize    uint64
-		ReqHeaders  string
-		RespHeaders string
-		Tags        string
+		Time           string
+		RemoteAddr     string
+		RealIP         string
+		Method         string
+		URI            string
+		Proto          string
+		StatusCode     int
+		Duration       time.Duration
+		TTFB           time.Duration
+		StreamDuration time.Duration
+		ReqSize        uint64
+		RespSize       uint64
+		ReqHeaders     string
+		RespHeaders    string
+		Tags           string
 	}
 )
 
@@ -361,9 +365,21 @@ func buildFailureResponse(ctx *context.Context, statusCode int) *httpprot.Respon
 	return resp
 }
 
-func (mi *muxInstance) sendResponse(ctx *context.Context, stdw http.ResponseWriter) (int, uint64, http.Header) {
+// responseResult is the result of sending a response.
+type responseResult struct {
+	statusCode     int
+	size           uint64
+	header         http.Header
+	ttfb           time.Duration
+	streamDuration time.Duration
+}
+
+func (mi *muxInstance) sendResponse(ctx *context.Context, stdw http.ResponseWriter, startAt time.Time) *responseResult {
 	var resp *httpprot.Response
-	if v := ctx.GetResponse(context.DefaultNamespace); v == nil {
+	if v := ctx.GetResponse(context.DefaultNamespace); v == nil && ctx.DeadlineExceeded() {
+		logger.Errorf("%s: response is nil as deadline exceeded", mi.superSpec.Name())
+		resp = buildFailureResponse(ctx, http.StatusGatewayTimeout)
+	} else if v == nil {
 		logger.Errorf("%s: response is nil", mi.superSpec.Name())
 		resp = buildFailureResponse(ctx, http.StatusServiceUnavailable)
 	} else if r, ok := v.(*httpprot.Response); !ok {
@@ -379,9 +395,15 @@ func (mi *muxInstance) sendResponse(ctx *context.Context, stdw http.ResponseWrit
 		header[k] = v
 	}
 	stdw.WriteHeader(resp.StatusCode())
+	headerWrittenAt := fasttime.Now()
 
 	var writer io.Writer
-	if responseIsRealTime(resp) {
+	if flushInterval, ok := ctx.GetData(httpproxy.FlushIntervalDataKey).(time.Duration); ok && flushInterval > 0 {
+		w := NewResponseIntervalFlushWriter(stdw, flushInterval)
+		defer w.Stop()
+		writer = w
+		ctx.AddTag("real time stream: true")
+	} else if ok || responseIsRealTime(resp) {
 		writer = NewResponseFlushWriter(stdw)
 		ctx.AddTag("real time stream: true")
 	} else {
@@ -390,7 +412,13 @@ func (mi *muxInstance) sendResponse(ctx *context.Context, stdw http.ResponseWrit
 	}
 	respBodySize, _ := io.Copy(writer, resp.GetPayload())
 
-	return resp.StatusCode(), uint64(respBodySize) + uint64(resp.MetaSize()), header
+	return &responseResult{
+		statusCode:     resp.StatusCode(),
+		size:           uint64(respBodySize) + uint64(resp.MetaSize()),
+		header:         header,
+		ttfb:           headerWrittenAt.Sub(startAt),
+		streamDuration: fasttime.Since(headerWrittenAt),
+	}
 }
 
 // ResponseFlushWriter is a wrapper of http.ResponseWriter, which flushes the
@@ -421,6 +449,76 @@ func NewResponseFlushWriter(w http.ResponseWriter) *ResponseFlushWriter {
 	}
 }
 
+// ResponseIntervalFlushWriter is a wrapper of http.ResponseWriter, which
+// flushes the response periodically, so that data written in an interval
+// is flushed in a batch.
+type ResponseIntervalFlushWriter struct {
+	w        http.ResponseWriter
+	flusher  http.Flusher
+	interval time.Duration
+
+	mu      sync.Mutex
+	timer   *time.Timer
+	pending bool
+	stopped bool
+}
+
+// NewResponseIntervalFlushWriter creates a ResponseIntervalFlushWriter.
+func NewResponseIntervalFlushWriter(w http.ResponseWriter, interval time.Duration) *ResponseIntervalFlushWriter {
+	flusher, _ := w.(http.Flusher)
+	return &ResponseIntervalFlushWriter{
+		w:        w,
+		flusher:  flusher,
+		interval: interval,
+	}
+}
+
+// Write writes the data to the connection as part of an HTTP reply, the
+// data is flushed when the interval elapses.
+func (w *ResponseIntervalFlushWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	n, err := w.w.Write(p)
+	if w.flusher == nil || w.pending || w.stopped {
+		return n, err
+	}
+
+	w.pending = true
+	if w.timer == nil {
+		w.timer = time.AfterFunc(w.interval, w.flush)
+	} else {
+		w.timer.Reset(w.interval)
+	}
+	return n, err
+}
+
+func (w *ResponseIntervalFlushWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.pending || w.stopped {
+		return
+	}
+	w.pending = false
+	w.flusher.Flush()
+}
+
+// Stop stops the periodic flush and flushes the pending data.
+func (w *ResponseIntervalFlushWriter) Stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.stopped = true
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	if w.pending {
+		w.pending = false
+		w.flusher.Flush()
+	}
+}
+
 // responseIsRealTime returns whether the response needs to be flushed immediately.
 // The response needs to be flushed immediately if the response has no content length (chunked),
 // or the response is a Server-Sent Events response.
@@ -463,6 +561,15 @@ func (mi *muxInstance) serveHTTP(stdw http.ResponseWriter, stdr *http.Request) {
 	reqMetaSize := req.MetaSize()
 	ctx.SetRequest(context.DefaultNamespace, req)
 
+	// Honor the deadline of the client.
+	if v := stdr.Header.Get(grpcprot.TimeoutHeader); v != "" {
+		if timeout, err := grpcprot.ParseTimeout(v); err == nil {
+			ctx.SetDeadline(startAt.Add(timeout))
+		} else {
+			logger.Debugf("%s: %v", mi.superSpec.Name(), err)
+		}
+	}
+
 	// get topN here, as the path could be modified later.
 	topN := mi.topN.Stat(req.Path())
 
@@ -471,12 +578,13 @@ func (mi *muxInstance) serveHTTP(stdw http.ResponseWriter, stdr *http.Request) {
 	ctx.SetRoute(route.route)
 
 	var respHeader http.Header
+	var ttfb, streamDuration time.Duration
 
 	defer func() {
 		metric, _ := ctx.GetData("HTTP_METRIC").(*httpstat.Metric)
 
 		if metric == nil {
-			statusCode, respSize, header := mi.sendResponse(ctx, stdw)
+			result := mi.sendResponse(ctx, stdw, startAt)
 			ctx.Finish()
 
 			// Drain off the body if it has not been, so that we can get the
@@ -484,11 +592,12 @@ func (mi *muxInstance) serveHTTP(stdw http.ResponseWriter, stdr *http.Request) {
 			io.Copy(io.Discard, body)
 
 			metric = &httpstat.Metric{
-				StatusCode: statusCode,
+				StatusCode: result.statusCode,
 				ReqSize:    uint64(reqMetaSize) + uint64(body.BytesRead()),
-				RespSize:   respSize,
+				RespSize:   result.size,
 			}
-			respHeader = header
+			respHeader = result.header
+			ttfb, streamDuration = result.ttfb, result.streamDuration
 		} else { // hijacked, websocket and etc.
 			ctx.Finish()
 		}
@@ -509,19 +618,21 @@ func (mi *muxInstance) serveHTTP(stdw http.ResponseWriter, stdr *http.Request) {
 		// Write access log.
 		logger.LazyHTTPAccess(func() string {
 			log := &accessLog{
-				Time:        fasttime.Format(startAt, fasttime.RFC3339Milli),
-				RemoteAddr:  stdr.RemoteAddr,
-				RealIP:      req.RealIP(),
-				Method:      stdr.Method,
-				URI:         uri,
-				Proto:       stdr.Proto,
-				StatusCode:  metric.StatusCode,
-				Duration:    metric.Duration,
-				ReqSize:     metric.ReqSize,
-				RespSize:    metric.RespSize,
-				Tags:        ctx.Tags(),
-				ReqHeaders:  printHeader(stdr.Header),
-				RespHeaders: printHeader(respHeader),
+				Time:           fasttime.Format(startAt, fasttime.RFC3339Milli),
+				RemoteAddr:     stdr.RemoteAddr,
+				RealIP:         req.RealIP(),
+				Method:         stdr.Method,
+				URI:            uri,
+				Proto:          stdr.Proto,
+				StatusCode:     metric.StatusCode,
+				Duration:       metric.Duration,
+				TTFB:           ttfb,
+				StreamDuration: streamDuration,
+				ReqSize:        metric.ReqSize,
+				RespSize:       metric.RespSize,
+				Tags:           ctx.Tags(),
+				ReqHeaders:     printHeader(stdr.Header),
+				RespHeaders:    printHeader(respHeader),
 			}
 			return mi.accessLogFormatter.format(log)
 		})
@@ -542,6 +653,10 @@ func (mi *muxInstance) serveHTTP(stdw http.ResponseWriter, stdr *http.Request) {
 	}
 	logger.Debugf("%s: the matched backend(Pipeline) for [%s %s] is %q", mi.superSpec.Name(), req.Method(), req.RequestURI, backend)
 
+	if timeout := route.route.GetTimeout(); timeout > 0 {
+		ctx.SetDeadline(startAt.Add(timeout))
+	}
+
 	route.route.Rewrite(routeCtx)
 	if mi.spec.XForwardedFor {
 		appendXForwardedFor(req)
@@ -26,6 +26,7 @@ import (
 	"strings"
 	"testing"
 	"testing/iotest"
+	"time"
 
 	"github.com/megaease/easegress/v2/pkg/cluster"
 	"github.com/megaease/easegress/v2/pkg/logger"
@@ -34,6 +35,7 @@ import (
 
 	"github.com/megaease/easegress/v2/pkg/context"
 	"github.com/megaease/easegress/v2/pkg/context/contexttest"
+	"github.com/megaease/easegress/v2/pkg/filters/proxies/httpproxy"
 	_ "github.com/megaease/easegress/v2/pkg/object/httpserver/routers/ordered"
 	_ "github.com/megaease/easegress/v2/pkg/object/httpserver/routers/radixtree"
 	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
@@ -228,6 +230,61 @@ rules:
 	assert.Equal(http.StatusBadRequest, stdw.Code)
 }
 
+func TestServeHTTPDeadline(t *testing.T) {
+	assert := assert.New(t)
+
+	mm := &contexttest.MockedMuxMapper{}
+	m := newMux(httpstat.New(), httpstat.NewTopN(10), newMockMetrics(), mm)
+	defer m.close()
+
+	yamlConfig := `
+kind: HTTPServer
+name: test
+port: 8080
+rules:
+- paths:
+  - path: /abc
+    backend: abc-pipeline
+    timeout: 10ms
+  - path: /xyz
+    backend: xyz-pipeline
+`
+	superSpec, err := supervisor.NewSpec(yamlConfig)
+	assert.NoError(err)
+	assert.NotPanics(func() { m.reload(superSpec, mm) })
+
+	var deadline time.Time
+	mm.MockedGetHandler = func(name string) (context.Handler, bool) {
+		return &contexttest.MockedHandler{
+			MockedHandle: func(ctx *context.Context) string {
+				deadline, _ = ctx.Deadline()
+				time.Sleep(20 * time.Millisecond)
+				return ""
+			},
+		}, true
+	}
+
+	// the deadline of the route.
+	stdr, _ := http.NewRequest(http.MethodGet, "http://www.megaease.com/abc", http.NoBody)
+	stdw := httptest.NewRecorder()
+	m.ServeHTTP(stdw, stdr)
+	assert.Equal(http.StatusGatewayTimeout, stdw.Code)
+	assert.False(deadline.IsZero())
+
+	// the deadline of the client.
+	stdr, _ = http.NewRequest(http.MethodGet, "http://www.megaease.com/xyz", http.NoBody)
+	stdr.Header.Set("grpc-timeout", "10m")
+	stdw = httptest.NewRecorder()
+	m.ServeHTTP(stdw, stdr)
+	assert.Equal(http.StatusGatewayTimeout, stdw.Code)
+
+	// no deadline.
+	stdr, _ = http.NewRequest(http.MethodGet, "http://www.megaease.com/xyz", http.NoBody)
+	stdw = httptest.NewRecorder()
+	m.ServeHTTP(stdw, stdr)
+	assert.Equal(http.StatusServiceUnavailable, stdw.Code)
+}
+
 func TestMuxInstanceSearch(t *testing.T) {
 	assert := assert.New(t)
 
@@ -657,6 +714,70 @@ func TestAccessLog(t *testing.T) {
 	assert.Equal(t, "GET 127.0.0.1 [100]", s)
 }
 
+func TestAccessLogStreaming(t *testing.T) {
+	log := &accessLog{
+		Duration:       3 * time.Second,
+		TTFB:           time.Second,
+		StreamDuration: 2 * time.Second,
+	}
+	formatter := newAccessLogFormatter("{{Duration}} ttfb:{{TTFB}} stream:{{StreamDuration}}")
+	s := formatter.format(log)
+	assert.Equal(t, "3s ttfb:1s stream:2s", s)
+}
+
+func TestSendStreamingResponse(t *testing.T) {
+	assert := assert.New(t)
+
+	mi := &muxInstance{superSpec: &supervisor.Spec{}}
+
+	for _, interval := range []time.Duration{0, 50 * time.Millisecond} {
+		ctx := context.New(nil)
+		ctx.SetData(httpproxy.FlushIntervalDataKey, interval)
+		resp, _ := httpprot.NewResponse(nil)
+		resp.SetPayload(strings.NewReader("data: hello\n\n"))
+		ctx.SetResponse(context.DefaultNamespace, resp)
+
+		w := httptest.NewRecorder()
+		startAt := time.Now().Add(-time.Second)
+		result := mi.sendResponse(ctx, w, startAt)
+		assert.Equal(http.StatusOK, result.statusCode)
+		assert.GreaterOrEqual(result.ttfb, 900*time.Millisecond)
+		assert.True(w.Flushed)
+		assert.Equal("data: hello\n\n", w.Body.String())
+		assert.Contains(ctx.Tags(), "real time stream: true")
+	}
+}
+
+func TestResponseIntervalFlushWriter(t *testing.T) {
+	assert := assert.New(t)
+
+	w := httptest.NewRecorder()
+	fw := NewResponseIntervalFlushWriter(w, 20*time.Millisecond)
+	fw.Write([]byte("a"))
+	fw.Write([]byte("b"))
+	assert.False(w.Flushed)
+
+	assert.Eventually(func() bool {
+		fw.mu.Lock()
+		defer fw.mu.Unlock()
+		return w.Flushed
+	}, time.Second, 5*time.Millisecond)
+
+	fw.mu.Lock()
+	w.Flushed = false
+	fw.mu.Unlock()
+	fw.Write([]byte("c"))
+	fw.Stop()
+	assert.True(w.Flushed)
+	assert.Equal("abc", w.Body.String())
+
+	// write after stop is not flushed periodically.
+	w.Flushed = false
+	fw.Write([]byte("d"))
+	time.Sleep(50 * time.Millisecond)
+	assert.False(w.Flushed)
+}
+
 func TestPrintHeader(t *testing.T) {
 	h := http.Header{}
 	h.Set("a", "1")
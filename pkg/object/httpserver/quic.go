@@ -0,0 +1,165 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+const (
+	// altSvcNone disables the Alt-Svc advertisement.
+	altSvcNone = "none"
+	// altSvcAdvertise serves HTTP/1.1 and HTTP/2 over TCP on the same
+	// port, and advertises HTTP/3 with the Alt-Svc header in responses.
+	altSvcAdvertise = "advertise"
+
+	defaultAltSvcMaxAge = 24 * time.Hour
+)
+
+var defaultEarlyDataMethods = []string{http.MethodGet, http.MethodHead, http.MethodOptions}
+
+// QUICSpec is the spec of the QUIC transport of HTTP/3.
+type QUICSpec struct {
+	// MaxIncomingStreams is the max number of concurrent bidirectional
+	// streams of a connection, the default of quic-go is used if zero.
+	MaxIncomingStreams int64 `json:"maxIncomingStreams,omitempty" jsonschema:"minimum=0"`
+	// MaxIncomingUniStreams is the max number of concurrent
+	// unidirectional streams of a connection.
+	MaxIncomingUniStreams int64 `json:"maxIncomingUniStreams,omitempty" jsonschema:"minimum=0"`
+	// MaxIdleTimeout is the max idle time of connections, keepAliveTimeout
+	// is used if it is empty.
+	MaxIdleTimeout string `json:"maxIdleTimeout,omitempty" jsonschema:"format=duration"`
+	// HandshakeIdleTimeout is the idle timeout before the handshake
+	// completes.
+	HandshakeIdleTimeout string `json:"handshakeIdleTimeout,omitempty" jsonschema:"format=duration"`
+
+	// Allow0RTT accepts requests in 0-RTT early data. As early data can
+	// be replayed, only requests of EarlyDataMethods are accepted in it,
+	// others are rejected with 425 Too Early, see RFC 8470.
+	Allow0RTT bool `json:"allow0RTT,omitempty"`
+	// EarlyDataMethods are the methods accepted in early data, default
+	// is GET, HEAD and OPTIONS.
+	EarlyDataMethods []string `json:"earlyDataMethods,omitempty" jsonschema:"uniqueItems=true"`
+
+	// AltSvc is the policy of Alt-Svc advertisement, "none" serves HTTP/3
+	// only, "advertise" also serves HTTP/1.1 and HTTP/2 over TCP on the
+	// same port and advertises HTTP/3 in their responses.
+	AltSvc string `json:"altSvc,omitempty" jsonschema:"enum=,enum=none,enum=advertise"`
+	// AltSvcMaxAge is the max age of the advertisement, default is 24h.
+	AltSvcMaxAge string `json:"altSvcMaxAge,omitempty" jsonschema:"format=duration"`
+}
+
+// Validate validates QUICSpec.
+func (spec *QUICSpec) Validate() error {
+	durations := map[string]string{
+		"maxIdleTimeout":       spec.MaxIdleTimeout,
+		"handshakeIdleTimeout": spec.HandshakeIdleTimeout,
+		"altSvcMaxAge":         spec.AltSvcMaxAge,
+	}
+	for name, d := range durations {
+		if d == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(d); err != nil {
+			return fmt.Errorf("quic: invalid %s %q: %v", name, d, err)
+		}
+	}
+
+	switch spec.AltSvc {
+	case "", altSvcNone, altSvcAdvertise:
+	default:
+		return fmt.Errorf("quic: invalid altSvc %q", spec.AltSvc)
+	}
+
+	if len(spec.EarlyDataMethods) > 0 && !spec.Allow0RTT {
+		return fmt.Errorf("quic: earlyDataMethods requires allow0RTT")
+	}
+	return nil
+}
+
+func (spec *QUICSpec) quicConfig(keepAlive bool, keepAliveTimeout time.Duration) *quic.Config {
+	cfg := &quic.Config{
+		MaxIdleTimeout:        keepAliveTimeout,
+		MaxIncomingStreams:    spec.MaxIncomingStreams,
+		MaxIncomingUniStreams: spec.MaxIncomingUniStreams,
+		Allow0RTT:             spec.Allow0RTT,
+	}
+	if spec.MaxIdleTimeout != "" {
+		cfg.MaxIdleTimeout, _ = time.ParseDuration(spec.MaxIdleTimeout)
+	}
+	if spec.HandshakeIdleTimeout != "" {
+		cfg.HandshakeIdleTimeout, _ = time.ParseDuration(spec.HandshakeIdleTimeout)
+	}
+	if keepAlive {
+		cfg.KeepAlivePeriod = cfg.MaxIdleTimeout
+	}
+	return cfg
+}
+
+func (spec *QUICSpec) advertiseAltSvc() bool {
+	return spec.AltSvc == altSvcAdvertise
+}
+
+// earlyDataHandler wraps the handler to protect it from replayed
+// requests in 0-RTT early data.
+func (spec *QUICSpec) earlyDataHandler(next http.Handler) http.Handler {
+	methods := spec.EarlyDataMethods
+	if len(methods) == 0 {
+		methods = defaultEarlyDataMethods
+	}
+	allowed := map[string]struct{}{}
+	for _, m := range methods {
+		allowed[strings.ToUpper(m)] = struct{}{}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// the TLS handshake isn't complete when the request is received
+		// in early data.
+		if req.TLS == nil || req.TLS.HandshakeComplete {
+			next.ServeHTTP(w, req)
+			return
+		}
+		if _, ok := allowed[req.Method]; !ok {
+			w.WriteHeader(http.StatusTooEarly)
+			return
+		}
+		// tell the upstream servers that the request is in early data.
+		req.Header.Set("Early-Data", "1")
+		next.ServeHTTP(w, req)
+	})
+}
+
+// altSvcHandler wraps the handler to advertise HTTP/3 on the port in
+// responses.
+func (spec *QUICSpec) altSvcHandler(next http.Handler, port uint16) http.Handler {
+	maxAge := defaultAltSvcMaxAge
+	if spec.AltSvcMaxAge != "" {
+		maxAge, _ = time.ParseDuration(spec.AltSvcMaxAge)
+	}
+	altSvc := fmt.Sprintf(`h3=":%d"; ma=%d`, port, int64(maxAge.Seconds()))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Alt-Svc", altSvc)
+		next.ServeHTTP(w, req)
+	})
+}
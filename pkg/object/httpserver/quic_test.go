@@ -0,0 +1,125 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQUICSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &Spec{HTTP3: false, QUIC: &QUICSpec{}}
+	assert.Error(spec.Validate())
+
+	quicSpec := &QUICSpec{MaxIdleTimeout: "30s", AltSvc: "advertise", AltSvcMaxAge: "1h"}
+	assert.NoError(quicSpec.Validate())
+
+	quicSpec.AltSvc = "always"
+	assert.Error(quicSpec.Validate())
+	quicSpec.AltSvc = "none"
+
+	quicSpec.HandshakeIdleTimeout = "abc"
+	assert.Error(quicSpec.Validate())
+	quicSpec.HandshakeIdleTimeout = ""
+
+	quicSpec.EarlyDataMethods = []string{http.MethodGet}
+	assert.Error(quicSpec.Validate())
+	quicSpec.Allow0RTT = true
+	assert.NoError(quicSpec.Validate())
+}
+
+func TestQUICConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &QUICSpec{MaxIncomingStreams: 10, Allow0RTT: true}
+	cfg := spec.quicConfig(true, time.Minute)
+	assert.Equal(int64(10), cfg.MaxIncomingStreams)
+	assert.True(cfg.Allow0RTT)
+	assert.Equal(time.Minute, cfg.MaxIdleTimeout)
+	assert.Equal(time.Minute, cfg.KeepAlivePeriod)
+
+	spec.MaxIdleTimeout = "10s"
+	spec.HandshakeIdleTimeout = "2s"
+	cfg = spec.quicConfig(false, time.Minute)
+	assert.Equal(10*time.Second, cfg.MaxIdleTimeout)
+	assert.Equal(2*time.Second, cfg.HandshakeIdleTimeout)
+	assert.Zero(cfg.KeepAlivePeriod)
+}
+
+func TestQUICEarlyDataHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	var earlyData string
+	next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		earlyData = req.Header.Get("Early-Data")
+	})
+	h := (&QUICSpec{Allow0RTT: true}).earlyDataHandler(next)
+
+	// handshake completed
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.TLS = &tls.ConnectionState{HandshakeComplete: true}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Empty(earlyData)
+
+	// unsafe method in early data
+	req.TLS = &tls.ConnectionState{}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(http.StatusTooEarly, w.Code)
+
+	// safe method in early data
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.TLS = &tls.ConnectionState{}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("1", earlyData)
+
+	// custom methods
+	h = (&QUICSpec{Allow0RTT: true, EarlyDataMethods: []string{"post"}}).earlyDataHandler(next)
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.TLS = &tls.ConnectionState{}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(http.StatusTooEarly, w.Code)
+}
+
+func TestQUICAltSvcHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+
+	h := (&QUICSpec{AltSvc: "advertise"}).altSvcHandler(next, 8443)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(`h3=":8443"; ma=86400`, w.Header().Get("Alt-Svc"))
+
+	h = (&QUICSpec{AltSvc: "advertise", AltSvcMaxAge: "1m"}).altSvcHandler(next, 443)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(`h3=":443"; ma=60`, w.Header().Get("Alt-Svc"))
+}
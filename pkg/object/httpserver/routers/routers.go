@@ -62,6 +62,8 @@ type (
 		GetBackend() string
 		// GetClientMaxBodySize is used to get the clientMaxBodySize corresponding to the route.
 		GetClientMaxBodySize() int64
+		// GetTimeout is used to get the timeout corresponding to the route.
+		GetTimeout() time.Duration
 
 		// NOTE: Currently we only support path information in readonly.
 		// Without further requirements, we choose not to expose too much information.
@@ -23,6 +23,7 @@ import (
 	"net/url"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/megaease/easegress/v2/pkg/filters/proxies/httpproxy"
 	"github.com/megaease/easegress/v2/pkg/logger"
@@ -74,6 +75,7 @@ type Path struct {
 	Backend           string                    `json:"backend,omitempty"`
 	BackendPool       *httpproxy.ServerPoolSpec `json:"backendPool,omitempty"`
 	ClientMaxBodySize int64                     `json:"clientMaxBodySize,omitempty"`
+	Timeout           string                    `json:"timeout,omitempty" jsonschema:"format=duration"`
 	Headers           Headers                   `json:"headers,omitempty"`
 	Queries           Queries                   `json:"queries,omitempty"`
 	MatchAllHeader    bool                      `json:"matchAllHeader,omitempty"`
@@ -84,6 +86,7 @@ type Path struct {
 	method                  MethodType
 	cacheable, matchable    bool
 	backendPoolPipelineName string
+	timeout                 time.Duration
 }
 
 // Headers represents the set of headers.
@@ -196,6 +199,8 @@ func (rule *Rule) AllowIP(ip string) bool {
 func (p *Path) Init(parentIPFilter *ipfilter.IPFilter, serverName string, ruleIndex, pathIndex int) {
 	p.ipFilter = ipfilter.New(p.IPFilterSpec)
 	p.backendPoolPipelineName = GenerateBackendPoolPipeline(serverName, ruleIndex, pathIndex)
+	// the timeout is validated by the json schema.
+	p.timeout, _ = time.ParseDuration(p.Timeout)
 
 	p.Headers.init()
 	p.Queries.init()
@@ -311,6 +316,11 @@ func (p *Path) GetClientMaxBodySize() int64 {
 	return p.ClientMaxBodySize
 }
 
+// GetTimeout is used to get the timeout corresponding to the route.
+func (p *Path) GetTimeout() time.Duration {
+	return p.timeout
+}
+
 // GetExactPath returns the exact path of the route.
 func (p *Path) GetExactPath() string {
 	return p.Path
@@ -21,6 +21,7 @@ import (
 	"net/http"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/megaease/easegress/v2/pkg/logger"
 	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
@@ -199,6 +200,7 @@ func TestPathInit(t *testing.T) {
 		},
 		Backend:           "foo",
 		ClientMaxBodySize: 1000,
+		Timeout:           "3s",
 	}
 
 	path.Init(nil, "", 0, 0)
@@ -209,6 +211,7 @@ func TestPathInit(t *testing.T) {
 	assert.NotNil(path.Queries[0].re)
 	assert.Equal("foo", path.GetBackend())
 	assert.EqualValues(1000, path.GetClientMaxBodySize())
+	assert.Equal(3*time.Second, path.GetTimeout())
 
 	path.Methods = []string{"GET", "POST"}
 	path.Init(nil, "", 0, 0)
@@ -40,6 +40,7 @@ import (
 	"github.com/megaease/easegress/v2/pkg/util/filterwriter"
 	"github.com/megaease/easegress/v2/pkg/util/limitlistener"
 	"github.com/megaease/easegress/v2/pkg/util/prometheushelper"
+	"github.com/megaease/easegress/v2/pkg/util/proxyprotocol"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -254,6 +255,9 @@ func (r *runtime) startServer() {
 
 	if r.spec.HTTP3 {
 		r.startHTTP3Server()
+		if r.spec.QUIC != nil && r.spec.QUIC.advertiseAltSvc() {
+			r.startHTTP1And2Server()
+		}
 	} else {
 		r.startHTTP1And2Server()
 	}
@@ -267,16 +271,25 @@ func (r *runtime) startHTTP3Server() {
 		keepAliveTimeout, _ = time.ParseDuration(r.spec.KeepAliveTimeout)
 	}
 
-	r.server3 = &http3.Server{
-		Addr:      fmt.Sprintf("%s:%d", r.spec.Address, r.spec.Port),
-		Handler:   r.mux,
-		TLSConfig: tlsConfig,
-		QuicConfig: &quic.Config{
-			MaxIdleTimeout: keepAliveTimeout,
-		},
+	var handler http.Handler = r.mux
+	var quicConfig *quic.Config
+	if r.spec.QUIC != nil {
+		quicConfig = r.spec.QUIC.quicConfig(r.spec.KeepAlive, keepAliveTimeout)
+		if r.spec.QUIC.Allow0RTT {
+			handler = r.spec.QUIC.earlyDataHandler(handler)
+		}
+	} else {
+		quicConfig = &quic.Config{MaxIdleTimeout: keepAliveTimeout}
+		if r.spec.KeepAlive {
+			quicConfig.KeepAlivePeriod = keepAliveTimeout
+		}
 	}
-	if r.spec.KeepAlive {
-		r.server3.QuicConfig.KeepAlivePeriod = keepAliveTimeout
+
+	r.server3 = &http3.Server{
+		Addr:       fmt.Sprintf("%s:%d", r.spec.Address, r.spec.Port),
+		Handler:    handler,
+		TLSConfig:  tlsConfig,
+		QuicConfig: quicConfig,
 	}
 
 	// to avoid data race
@@ -302,13 +315,26 @@ func (r *runtime) startHTTP1And2Server() {
 	fw := filterwriter.New(os.Stderr, func(p []byte) bool {
 		return !bytes.Contains(p, []byte("TLS handshake error"))
 	})
+	var handler http.Handler = r.mux
+	if r.spec.HTTP3 && r.spec.QUIC != nil && r.spec.QUIC.advertiseAltSvc() {
+		handler = r.spec.QUIC.altSvcHandler(handler, r.spec.Port)
+	}
+
 	r.server = &http.Server{
 		Addr:        fmt.Sprintf("%s:%d", r.spec.Address, r.spec.Port),
-		Handler:     r.mux,
+		Handler:     handler,
 		IdleTimeout: keepAliveTimeout,
 		ErrorLog:    log.New(fw, "", log.LstdFlags),
 	}
 	r.server.SetKeepAlivesEnabled(r.spec.KeepAlive)
+	if r.spec.H2C {
+		// serve HTTP/2 over cleartext TCP with prior knowledge, along
+		// with HTTP/1.
+		protocols := &http.Protocols{}
+		protocols.SetHTTP1(true)
+		protocols.SetUnencryptedHTTP2(true)
+		r.server.Protocols = protocols
+	}
 
 	listener, err := gnet.Listen("tcp", fmt.Sprintf("%s:%d", r.spec.Address, r.spec.Port))
 	if err != nil {
@@ -317,6 +343,7 @@ func (r *runtime) startHTTP1And2Server() {
 		r.setError(err)
 		return
 	}
+	listener = proxyprotocol.NewListener(listener, r.spec.ProxyProtocol)
 	limitListener := limitlistener.NewLimitListener(listener, r.spec.MaxConnections)
 	r.limitListener = limitListener
 
@@ -349,7 +376,7 @@ func (r *runtime) closeServer() {
 		if err != nil {
 			logger.Warnf("shutdown http3 server %s failed: %v", r.superSpec.Name(), err)
 		}
-		return
+		r.server3 = nil
 	}
 
 	if r.server != nil {
@@ -361,6 +388,7 @@ func (r *runtime) closeServer() {
 			logger.Warnf("shutdown http1/2 server %s failed: %v",
 				r.superSpec.Name(), err)
 		}
+		r.server = nil
 	}
 }
 
@@ -18,6 +18,10 @@
 package httpserver
 
 import (
+	stdcontext "context"
+	"crypto/tls"
+	"net"
+	"net/http"
 	"testing"
 	"time"
 
@@ -25,6 +29,7 @@ import (
 	"github.com/megaease/easegress/v2/pkg/option"
 	"github.com/megaease/easegress/v2/pkg/supervisor"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http2"
 )
 
 func TestNewRuntim(t *testing.T) {
@@ -98,3 +103,63 @@ https: false
 
 	//
 }
+
+func TestH2C(t *testing.T) {
+	assert := assert.New(t)
+
+	yamlConfig := `
+kind: HTTPServer
+name: test
+port: 38083
+keepAlive: true
+https: true
+h2c: true
+autoCert: true
+`
+	_, err := supervisor.NewSpec(yamlConfig)
+	assert.Error(err)
+
+	yamlConfig = `
+kind: HTTPServer
+name: test
+port: 38083
+keepAlive: true
+https: false
+h2c: true
+`
+	superSpec, err := supervisor.NewSpec(yamlConfig)
+	assert.NoError(err)
+
+	r := newRuntime(superSpec, &contexttest.MockedMuxMapper{})
+	r.reload(superSpec, &contexttest.MockedMuxMapper{})
+	defer r.Close()
+
+	assert.Eventually(func() bool {
+		return r.getState() == stateRunning
+	}, time.Second, 10*time.Millisecond)
+
+	client := &http.Client{
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx stdcontext.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, addr)
+			},
+		},
+	}
+
+	var resp *http.Response
+	assert.Eventually(func() bool {
+		resp, err = client.Get("http://127.0.0.1:38083/")
+		return err == nil
+	}, 3*time.Second, 50*time.Millisecond)
+	assert.Equal(2, resp.ProtoMajor)
+	resp.Body.Close()
+
+	// HTTP/1 is still supported.
+	assert.Eventually(func() bool {
+		resp, err = http.Get("http://127.0.0.1:38083/")
+		return err == nil
+	}, 3*time.Second, 50*time.Millisecond)
+	assert.Equal(1, resp.ProtoMajor)
+	resp.Body.Close()
+}
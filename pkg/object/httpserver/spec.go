@@ -27,12 +27,14 @@ import (
 	"github.com/megaease/easegress/v2/pkg/object/httpserver/routers"
 	"github.com/megaease/easegress/v2/pkg/tracing"
 	"github.com/megaease/easegress/v2/pkg/util/ipfilter"
+	"github.com/megaease/easegress/v2/pkg/util/proxyprotocol"
 )
 
 type (
 	// Spec describes the HTTPServer.
 	Spec struct {
 		HTTP3             bool          `json:"http3,omitempty"`
+		H2C               bool          `json:"h2c,omitempty"`
 		KeepAlive         bool          `json:"keepAlive" jsonschema:"required"`
 		HTTPS             bool          `json:"https" jsonschema:"required"`
 		AutoCert          bool          `json:"autoCert,omitempty"`
@@ -61,6 +63,13 @@ type (
 		IPFilter *ipfilter.Spec `json:"ipFilter,omitempty"`
 		Rules    routers.Rules  `json:"rules,omitempty"`
 
+		// ProxyProtocol enables parsing PROXY protocol headers of
+		// connections from trusted sources.
+		ProxyProtocol *proxyprotocol.Spec `json:"proxyProtocol,omitempty"`
+
+		// QUIC tunes the QUIC transport when http3 is enabled.
+		QUIC *QUICSpec `json:"quic,omitempty"`
+
 		GlobalFilter string `json:"globalFilter,omitempty"`
 
 		AccessLogFormat string `json:"accessLogFormat,omitempty"`
@@ -69,6 +78,28 @@ type (
 
 // Validate validates HTTPServerSpec.
 func (spec *Spec) Validate() error {
+	if spec.ProxyProtocol != nil {
+		if spec.HTTP3 {
+			return fmt.Errorf("proxyProtocol is not supported when http3 enabled")
+		}
+		if err := spec.ProxyProtocol.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if spec.QUIC != nil {
+		if !spec.HTTP3 {
+			return fmt.Errorf("quic is only supported when http3 enabled")
+		}
+		if err := spec.QUIC.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if spec.H2C && spec.HTTPS {
+		return fmt.Errorf("h2c is not supported when https enabled")
+	}
+
 	if !spec.HTTPS {
 		if spec.HTTP3 {
 			return fmt.Errorf("https is disabled when http3 enabled")
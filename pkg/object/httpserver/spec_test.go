@@ -115,6 +115,50 @@ rules:
 	superSpec, err = supervisor.NewSpec(yamlConfig)
 	assert.True(strings.Contains(err.Error(), "keepAliveTimeout: invalid duration"))
 	assert.Nil(superSpec)
+
+	yamlConfig = `
+name: http-server-test
+kind: HTTPServer
+port: 10080
+proxyProtocol:
+  trustedCIDRs: ["10.0.0.0/8", "192.168.1.1"]
+rules:
+  - paths:
+    - pathPrefix: /api
+      backend: mock
+`
+	_, err = supervisor.NewSpec(yamlConfig)
+	assert.NoError(err)
+
+	yamlConfig = `
+name: http-server-test
+kind: HTTPServer
+port: 10080
+proxyProtocol:
+  trustedCIDRs: ["10.0.0.0/33"]
+rules:
+  - paths:
+    - pathPrefix: /api
+      backend: mock
+`
+	_, err = supervisor.NewSpec(yamlConfig)
+	assert.Error(err)
+
+	yamlConfig = `
+name: http-server-test
+kind: HTTPServer
+port: 10080
+http3: true
+https: true
+autoCert: true
+proxyProtocol: {}
+rules:
+  - paths:
+    - pathPrefix: /api
+      backend: mock
+`
+	_, err = supervisor.NewSpec(yamlConfig)
+	assert.True(strings.Contains(err.Error(), "proxyProtocol is not supported when http3 enabled"))
 }
 
 func TestTlsConfig(t *testing.T) {
@@ -88,7 +88,8 @@ func (b *pipelineSpecBuilder) addProxy(endpoints []string, ingress *apinetv1.Ing
 		proxyLoadBalance = proxies.LoadBalancePolicyRoundRobin
 	case proxies.LoadBalancePolicyRoundRobin, proxies.LoadBalancePolicyRandom,
 		proxies.LoadBalancePolicyWeightedRandom, proxies.LoadBalancePolicyIPHash,
-		proxies.LoadBalancePolicyHeaderHash:
+		proxies.LoadBalancePolicyHeaderHash, proxies.LoadBalancePolicyLeastConn,
+		proxies.LoadBalancePolicyEWMA, proxies.LoadBalancePolicyConsistentHash:
 	default:
 		return fmt.Errorf("invalid proxy-load-balance: %s", proxyLoadBalance)
 	}
@@ -145,7 +145,7 @@ func (b *pipelineSpecBuilder) appendRetry(rule *resilience.RetryRule) *pipelineS
 		return b
 	}
 
-	spec := resilience.RetryPolicy{
+	spec := &resilience.RetryPolicy{
 		BaseSpec: resilience.BaseSpec{
 			MetaSpec: supervisor.MetaSpec{
 				Name: b.retryName,
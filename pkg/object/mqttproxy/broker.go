@@ -38,6 +38,7 @@ import (
 	"github.com/megaease/easegress/v2/pkg/protocols/mqttprot"
 	"github.com/megaease/easegress/v2/pkg/tracing"
 	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/megaease/easegress/v2/pkg/util/proxyprotocol"
 	"github.com/openzipkin/zipkin-go/model"
 	"github.com/openzipkin/zipkin-go/propagation/b3"
 )
@@ -165,16 +166,18 @@ func (b *Broker) setListener() error {
 		if err != nil {
 			return fmt.Errorf("invalid tls config for mqtt proxy: %v", err)
 		}
-		l, err = tls.Listen("tcp", addr, cfg)
-		if err != nil {
-			return fmt.Errorf("gen mqtt tls tcp listener with addr %v and cfg %v failed: %v", addr, cfg, err)
-		}
-	} else {
-		l, err = net.Listen("tcp", addr)
-		if err != nil {
-			return fmt.Errorf("gen mqtt tcp listener with addr %s failed: %v", addr, err)
-		}
 	}
+
+	l, err = net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("gen mqtt tcp listener with addr %s failed: %v", addr, err)
+	}
+	// PROXY protocol headers are sent before the TLS handshake.
+	l = proxyprotocol.NewListener(l, b.spec.ProxyProtocol)
+	if cfg != nil {
+		l = tls.NewListener(l, cfg)
+	}
+
 	b.tlsCfg = cfg
 	b.listener = l
 	return err
@@ -441,7 +444,7 @@ func (b *Broker) handleConn(conn net.Conn) {
 		logger.SpanErrorf(nil, "first packet received %s that was not Connect", packet.String())
 		return
 	}
-	logger.SpanDebugf(nil, "connection from client %s", connect.ClientIdentifier)
+	logger.SpanDebugf(nil, "connection from client %s, address %s", connect.ClientIdentifier, conn.RemoteAddr())
 
 	client, connack, valid := b.connectionValidation(connect, conn)
 	if !valid {
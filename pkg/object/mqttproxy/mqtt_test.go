@@ -43,6 +43,7 @@ import (
 	"github.com/megaease/easegress/v2/pkg/option"
 	"github.com/megaease/easegress/v2/pkg/supervisor"
 	"github.com/megaease/easegress/v2/pkg/util/codectool"
+	"github.com/megaease/easegress/v2/pkg/util/proxyprotocol"
 	"github.com/openzipkin/zipkin-go/propagation/b3"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -2119,3 +2120,39 @@ func TestBrokerModeWatch(t *testing.T) {
 	assert.True(data.Distributed)
 	assert.Equal(1, data.QoS)
 }
+
+func TestProxyProtocol(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := getDefaultSpec()
+	spec.ProxyProtocol = &proxyprotocol.Spec{TrustedCIDRs: []string{"127.0.0.1"}}
+	assert.NoError(spec.Validate())
+	broker := getBrokerFromSpec(spec, &mockMuxMapper{})
+	assert.NotNil(broker)
+	defer broker.close()
+
+	conn, err := net.Dial("tcp", "127.0.0.1:1883")
+	assert.NoError(err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+
+	_, err = conn.Write([]byte("PROXY TCP4 192.168.1.1 10.0.0.1 56324 1883\r\n"))
+	assert.NoError(err)
+
+	connect := packets.NewControlPacket(packets.Connect).(*packets.ConnectPacket)
+	connect.ProtocolName = "MQTT"
+	connect.ProtocolVersion = 4
+	connect.CleanSession = true
+	connect.ClientIdentifier = "proxy-protocol"
+	connect.Keepalive = 10
+	assert.NoError(connect.Write(conn))
+
+	packet, err := packets.ReadPacket(conn)
+	assert.NoError(err)
+	connack, ok := packet.(*packets.ConnackPacket)
+	assert.True(ok)
+	assert.Equal(byte(packets.Accepted), connack.ReturnCode)
+
+	spec.ProxyProtocol.TrustedCIDRs = []string{"abc"}
+	assert.Error(spec.Validate())
+}
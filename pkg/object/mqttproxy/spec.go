@@ -20,6 +20,8 @@ package mqttproxy
 import (
 	"crypto/tls"
 	"fmt"
+
+	"github.com/megaease/easegress/v2/pkg/util/proxyprotocol"
 )
 
 const (
@@ -74,6 +76,10 @@ type (
 		BrokerMode           bool          `json:"brokerMode,omitempty"`
 		// unit is second, default is 30s
 		RetryInterval int `yaml:"retryInterval,omitempty"`
+
+		// ProxyProtocol enables parsing PROXY protocol headers of
+		// connections from trusted sources.
+		ProxyProtocol *proxyprotocol.Spec `json:"proxyProtocol,omitempty"`
 	}
 
 	// Rule used to route MQTT packets to different pipelines
@@ -105,6 +111,14 @@ type (
 	}
 )
 
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	if spec.ProxyProtocol != nil {
+		return spec.ProxyProtocol.Validate()
+	}
+	return nil
+}
+
 func (spec *Spec) tlsConfig() (*tls.Config, error) {
 	var certificates []tls.Certificate
 
@@ -520,6 +520,7 @@ func (n *NacosServiceRegistry) nacosInstanceToServiceInstance(nacosInstance *mod
 		Address:      nacosInstance.Ip,
 		Port:         uint16(nacosInstance.Port),
 		Weight:       int(nacosInstance.Weight),
+		Labels:       nacosInstance.Metadata,
 	}
 
 	return instance
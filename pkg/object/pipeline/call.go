@@ -0,0 +1,199 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pipeline
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/megaease/easegress/v2/pkg/api"
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/object/trafficcontroller"
+	"github.com/megaease/easegress/v2/pkg/supervisor"
+	"github.com/megaease/easegress/v2/pkg/util/stringtool"
+)
+
+const (
+	// callStackDataKey is the key of the data which stores the names of
+	// the pipelines being called.
+	callStackDataKey = "PIPELINE_CALL_STACK"
+	// callNamespaceDataKey is the key of the data which stores the
+	// traffic controller namespace of the calling pipeline.
+	callNamespaceDataKey = "PIPELINE_CALL_NAMESPACE"
+)
+
+// CallerSpec is the interface of the specs of filters which call other
+// pipelines, it is used to detect call cycles.
+type CallerSpec interface {
+	// CalledPipeline returns the name of the called pipeline.
+	CalledPipeline() string
+}
+
+// GetPipeline returns the running pipeline of name in the namespace of the
+// traffic controller, the default namespace is used if namespace is empty.
+func GetPipeline(super *supervisor.Supervisor, namespace, name string) (*Pipeline, bool) {
+	if super == nil {
+		return nil, false
+	}
+	entity, exists := super.GetSystemController(trafficcontroller.Kind)
+	if !exists {
+		return nil, false
+	}
+	tc, ok := entity.Instance().(*trafficcontroller.TrafficController)
+	if !ok {
+		return nil, false
+	}
+	if namespace == "" {
+		namespace = api.DefaultNamespace
+	}
+	pe, exists := tc.GetPipeline(namespace, name)
+	if !exists {
+		return nil, false
+	}
+	p, ok := pe.Instance().(*Pipeline)
+	return p, ok
+}
+
+// fnGetPipeline gets a running pipeline, it is a variable so that it can
+// be replaced in testing.
+var fnGetPipeline = GetPipeline
+
+// CallerNamespace returns the traffic controller namespace of the pipeline
+// handling ctx, in which the pipelines it calls are looked up. It returns
+// the default namespace if the pipeline is unknown.
+func CallerNamespace(ctx *context.Context) string {
+	if ns, ok := ctx.GetData(callNamespaceDataKey).(string); ok && ns != "" {
+		return ns
+	}
+	return api.DefaultNamespace
+}
+
+// namespaceOf returns the traffic controller namespace of muxMapper.
+func namespaceOf(muxMapper context.MuxMapper) string {
+	if ns, ok := muxMapper.(*trafficcontroller.Namespace); ok {
+		return ns.Name()
+	}
+	return api.DefaultNamespace
+}
+
+// calledPipelines returns the names of the pipelines called by filters
+// of specs.
+func calledPipelines(specs map[string]filters.Spec) []string {
+	var names []string
+	for _, spec := range specs {
+		if c, ok := spec.(CallerSpec); ok {
+			names = append(names, c.CalledPipeline())
+		}
+	}
+	return names
+}
+
+// calledPipelines returns the names of the pipelines called by the
+// filters of the pipeline.
+func (p *Pipeline) calledPipelines() []string {
+	var names []string
+	for _, f := range p.filters {
+		if c, ok := f.Spec().(CallerSpec); ok {
+			names = append(names, c.CalledPipeline())
+		}
+	}
+	return names
+}
+
+// SetObjectName sets the name of the pipeline, it implements
+// supervisor.ObjectNameSetter.
+func (s *Spec) SetObjectName(name string) {
+	s.name = name
+}
+
+// validateCalls checks that the calls to other pipelines don't form a
+// cycle, the called pipelines are looked up from the running ones in the
+// default namespace, and the ones not found are ignored. Cycles across
+// other namespaces are detected at runtime by HandleCall.
+func (s *Spec) validateCalls(specs map[string]filters.Spec) {
+	super := supervisor.GetGlobalSuper()
+	visited := map[string]bool{}
+
+	var visit func(path []string, callees []string)
+	visit = func(path []string, callees []string) {
+		for _, name := range callees {
+			path := append(path, name)
+			if (s.name != "" && name == s.name) || stringtool.StrInSlice(name, path[:len(path)-1]) {
+				panic(fmt.Errorf("pipeline call cycle: %s", strings.Join(path, " -> ")))
+			}
+			if visited[name] {
+				continue
+			}
+			visited[name] = true
+
+			p, ok := fnGetPipeline(super, api.DefaultNamespace, name)
+			if !ok {
+				continue
+			}
+			visit(path, p.calledPipelines())
+		}
+	}
+
+	self := s.name
+	if self == "" {
+		self = "<self>"
+	}
+	visit([]string{self}, calledPipelines(specs))
+}
+
+// HandleCall handles the request as a sub-flow called by a filter of
+// pipeline caller. It shares ctx with the caller, and the nodes of its
+// flow without namespace use the active namespace of ctx. The timeout of
+// the pipeline only bounds the sub-flow, the deadline of the caller is
+// restored when it returns.
+func (p *Pipeline) HandleCall(ctx *context.Context, caller string) (string, error) {
+	name := p.superSpec.Name()
+	stack, _ := ctx.GetData(callStackDataKey).([]string)
+	if len(stack) == 0 {
+		stack = []string{caller}
+	}
+	if stringtool.StrInSlice(name, stack) {
+		return "", fmt.Errorf("pipeline call cycle: %s -> %s", strings.Join(stack, " -> "), name)
+	}
+	prevStack := ctx.GetData(callStackDataKey)
+	ctx.SetData(callStackDataKey, append(stack[:len(stack):len(stack)], name))
+	defer ctx.SetData(callStackDataKey, prevStack)
+
+	ns := ctx.Namespace()
+	if len(p.spec.Data) > 0 {
+		prev := ctx.GetData("PIPELINE")
+		ctx.SetData("PIPELINE", p.spec.Data)
+		defer ctx.SetData("PIPELINE", prev)
+	}
+
+	if p.spec.timeout > 0 {
+		prev, _ := ctx.Deadline()
+		ctx.SetTimeout(p.spec.timeout)
+		defer ctx.ResetDeadline(prev)
+	}
+
+	stats := make([]FilterStat, 0, len(p.flow))
+	result, stats, _ := p.handleFlow(ctx, p.flow, ns, stats)
+	ctx.UseNamespace(ns)
+
+	ctx.LazyAddTag(func() string {
+		return p.serializeStats(stats)
+	})
+	return result, nil
+}
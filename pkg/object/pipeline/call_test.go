@@ -0,0 +1,192 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pipeline
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/supervisor"
+	"github.com/megaease/easegress/v2/pkg/tracing"
+	"github.com/stretchr/testify/assert"
+)
+
+type callerSpec struct {
+	MockedSpec `json:",inline"`
+	Target     string `json:"target"`
+}
+
+func (s *callerSpec) CalledPipeline() string {
+	return s.Target
+}
+
+func (s *callerSpec) Results() []string {
+	return []string{"mapped"}
+}
+
+type callerFilter struct {
+	MockedFilter
+	spec *callerSpec
+}
+
+func (f *callerFilter) Spec() filters.Spec {
+	return f.spec
+}
+
+func (f *callerFilter) Handle(ctx *context.Context) string {
+	p, ok := fnGetPipeline(nil, CallerNamespace(ctx), f.spec.Target)
+	if !ok {
+		return "mapped"
+	}
+	result, err := p.HandleCall(ctx, f.spec.Pipeline())
+	if err != nil {
+		return "mapped"
+	}
+	return result
+}
+
+func callerFilterKind() *filters.Kind {
+	k := MockFilterKind("Caller", nil)
+	k.DefaultSpec = func() filters.Spec {
+		return &callerSpec{}
+	}
+	k.CreateInstance = func(spec filters.Spec) filters.Filter {
+		s := spec.(*callerSpec)
+		return &callerFilter{MockedFilter{kind: k, spec: &s.MockedSpec}, s}
+	}
+	return k
+}
+
+func newCallPipeline(t *testing.T, name, target string) (*Pipeline, error) {
+	yamlConfig := `
+name: ` + name + `
+kind: Pipeline
+flow:
+  - filter: mock
+  - filter: call
+    jumpIf: { mapped: END }
+filters:
+  - name: mock
+    kind: Filter1
+  - name: call
+    kind: Caller
+    target: ` + target + `
+`
+	superSpec, err := supervisor.NewSpec(yamlConfig)
+	if err != nil {
+		return nil, err
+	}
+	p := &Pipeline{}
+	p.Init(superSpec, nil)
+	p.namespace = "test"
+	return p, nil
+}
+
+func TestPipelineCall(t *testing.T) {
+	assert := assert.New(t)
+
+	filters.Register(MockFilterKind("Filter1", nil))
+	filters.Register(callerFilterKind())
+	defer cleanup()
+
+	pipelines := map[string]*Pipeline{}
+	var namespaces []string
+	old := fnGetPipeline
+	fnGetPipeline = func(super *supervisor.Supervisor, namespace, name string) (*Pipeline, bool) {
+		namespaces = append(namespaces, namespace)
+		p, ok := pipelines[name]
+		return p, ok
+	}
+	defer func() { fnGetPipeline = old }()
+
+	// call itself
+	_, err := newCallPipeline(t, "a", "a")
+	assert.Error(err)
+
+	// a -> b -> c
+	a, err := newCallPipeline(t, "a", "b")
+	assert.NoError(err)
+	pipelines["a"] = a
+	b, err := newCallPipeline(t, "b", "c")
+	assert.NoError(err)
+	pipelines["b"] = b
+
+	// c -> a forms a cycle
+	_, err = newCallPipeline(t, "c", "a")
+	assert.ErrorContains(err, "pipeline call cycle")
+
+	// c -> d is fine
+	c, err := newCallPipeline(t, "c", "d")
+	assert.NoError(err)
+	pipelines["c"] = c
+
+	stdReq, _ := http.NewRequest(http.MethodGet, "http://localhost:9095", nil)
+	req, _ := httpprot.NewRequest(stdReq)
+	ctx := context.New(tracing.NoopSpan)
+	ctx.SetRequest(context.DefaultNamespace, req)
+
+	// d is not found, so c returns mapped, which is passed back to a,
+	// and the callees are looked up in the namespace of the caller.
+	namespaces = nil
+	result := a.Handle(ctx)
+	assert.Equal("mapped", result)
+	assert.Equal([]string{"test", "test", "test"}, namespaces)
+	for _, p := range []*Pipeline{a, b, c} {
+		assert.Equal(1, MockGetFilter(p, "mock").(*MockedFilter).count)
+	}
+	tags := ctx.Tags()
+	assert.Contains(tags, "pipeline(a)")
+	assert.Contains(tags, "pipeline(b)")
+	assert.Contains(tags, "pipeline(c)")
+	assert.Nil(ctx.GetData(callStackDataKey))
+
+	// cycles are detected at runtime if pipelines are updated later.
+	c, _ = newCallPipeline(t, "c", "d")
+	pipelines["c"] = c
+	pipelines["d"] = a
+	ctx = context.New(tracing.NoopSpan)
+	ctx.SetRequest(context.DefaultNamespace, req)
+	_, err = a.HandleCall(ctx, "x")
+	assert.NoError(err)
+	_, err = a.HandleCall(ctx, "a")
+	assert.Error(err)
+
+	ctx.SetData(callStackDataKey, []string{"x", "a"})
+	_, err = a.HandleCall(ctx, "x")
+	assert.Error(err)
+
+	// the timeout of the callee bounds the sub-flow only.
+	c.spec.timeout = time.Nanosecond
+	ctx = context.New(tracing.NoopSpan)
+	ctx.SetRequest(context.DefaultNamespace, req)
+	result, err = c.HandleCall(ctx, "x")
+	assert.NoError(err)
+	assert.Equal(ResultDeadlineExceeded, result)
+	_, ok := ctx.Deadline()
+	assert.False(ok)
+
+	deadline := time.Now().Add(time.Hour)
+	ctx.SetDeadline(deadline)
+	c.HandleCall(ctx, "x")
+	d, _ := ctx.Deadline()
+	assert.Equal(deadline, d)
+}
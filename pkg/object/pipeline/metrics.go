@@ -0,0 +1,91 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pipeline
+
+import (
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/util/prometheushelper"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metricResultSuccess is the value of the result label for the empty
+// result.
+const metricResultSuccess = "success"
+
+type (
+	// metrics is the Prometheus metrics of the filters of a pipeline.
+	metrics struct {
+		FilterDuration     prometheus.ObserverVec
+		FilterTotalResults *prometheus.CounterVec
+	}
+)
+
+// filterDurationBuckets returns the buckets of filter durations in
+// milliseconds, filters are usually much faster than a whole request, so
+// finer buckets are added before the default ones.
+func filterDurationBuckets() []float64 {
+	return append([]float64{0.1, 0.5, 1, 5}, prometheushelper.DefaultDurationBuckets()...)
+}
+
+// newMetrics creates the metrics of the pipeline.
+func (p *Pipeline) newMetrics() *metrics {
+	commonLabels := prometheus.Labels{
+		"pipelineName": p.superSpec.Name(),
+		"clusterName":  "",
+		"clusterRole":  "",
+		"instanceName": "",
+	}
+	if super := p.superSpec.Super(); super != nil && super.Options() != nil {
+		opt := super.Options()
+		commonLabels["clusterName"] = opt.ClusterName
+		commonLabels["clusterRole"] = opt.ClusterRole
+		commonLabels["instanceName"] = opt.Name
+	}
+
+	// NOTE: the order of the uncurried labels is the order of the values
+	// passed to WithLabelValues.
+	filterLabels := []string{"clusterName", "clusterRole", "instanceName",
+		"pipelineName", "filterName", "filterKind"}
+	return &metrics{
+		FilterDuration: prometheushelper.NewHistogram(
+			prometheus.HistogramOpts{
+				Name:    "pipeline_filter_duration",
+				Help:    "filter processing duration histogram in milliseconds",
+				Buckets: filterDurationBuckets(),
+			},
+			filterLabels).MustCurryWith(commonLabels),
+		FilterTotalResults: prometheushelper.NewCounter(
+			"pipeline_filter_total_results",
+			"the total count of filter results",
+			append(filterLabels, "result")).MustCurryWith(commonLabels),
+	}
+}
+
+// exportFilterMetrics exports the metrics of a filter execution.
+func (p *Pipeline) exportFilterMetrics(name, kind, result string, d time.Duration) {
+	m := p.metrics
+	if m == nil {
+		return
+	}
+	if result == "" {
+		result = metricResultSuccess
+	}
+	m.FilterDuration.WithLabelValues(name, kind).Observe(float64(d) / float64(time.Millisecond))
+	m.FilterTotalResults.WithLabelValues(name, kind, result).Inc()
+}
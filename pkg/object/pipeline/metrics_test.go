@@ -0,0 +1,107 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pipeline
+
+import (
+	"testing"
+
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/supervisor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+// gatherMetric returns the counter value, or the sample count of a
+// histogram, of the metric whose labels contain all of labels.
+func gatherMetric(t *testing.T, name string, labels map[string]string) float64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	assert.NoError(t, err)
+
+	for _, mf := range families {
+		if mf.GetName() != name {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			matched := 0
+			for _, lp := range m.GetLabel() {
+				if v, ok := labels[lp.GetName()]; ok && v == lp.GetValue() {
+					matched++
+				}
+			}
+			if matched != len(labels) {
+				continue
+			}
+			if h := m.GetHistogram(); h != nil {
+				return float64(h.GetSampleCount())
+			}
+			return m.GetCounter().GetValue()
+		}
+	}
+	return 0
+}
+
+func TestFilterMetrics(t *testing.T) {
+	assert := assert.New(t)
+	filters.Register(branchFilterKind())
+	defer cleanup()
+
+	superSpec, err := supervisor.NewSpec(`
+name: metrics-pipeline-test
+kind: Pipeline
+flow:
+  - filter: ok1
+  - filter: ok1
+    alias: ok1-again
+  - filter: fail1
+filters:
+  - name: ok1
+    kind: BranchFilter
+  - name: fail1
+    kind: BranchFilter
+`)
+	assert.NoError(err)
+	p := &Pipeline{}
+	p.Init(superSpec, nil)
+	defer p.Close()
+
+	for i := 0; i < 3; i++ {
+		_, result := handleParallelPipeline(p)
+		assert.Equal("failed", result)
+	}
+
+	labels := map[string]string{
+		"pipelineName": "metrics-pipeline-test",
+		"filterName":   "ok1",
+		"filterKind":   "BranchFilter",
+	}
+	assert.Equal(3.0, gatherMetric(t, "pipeline_filter_duration", labels))
+	labels["result"] = metricResultSuccess
+	assert.Equal(3.0, gatherMetric(t, "pipeline_filter_total_results", labels))
+
+	labels["filterName"] = "ok1-again"
+	assert.Equal(3.0, gatherMetric(t, "pipeline_filter_total_results", labels))
+
+	labels["filterName"] = "fail1"
+	assert.Equal(0.0, gatherMetric(t, "pipeline_filter_total_results", labels))
+	labels["result"] = "failed"
+	assert.Equal(3.0, gatherMetric(t, "pipeline_filter_total_results", labels))
+
+	// a pipeline without metrics doesn't panic.
+	p2 := &Pipeline{}
+	assert.NotPanics(func() { p2.exportFilterMetrics("a", "b", "", 0) })
+}
@@ -0,0 +1,294 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pipeline
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/logger"
+)
+
+const (
+	// BuiltInFilterParallel is the name of the built-in filter which runs
+	// branches of flow concurrently.
+	BuiltInFilterParallel = "PARALLEL"
+
+	// ParallelKind is the kind of the built-in parallel filter in stats.
+	ParallelKind = "Parallel"
+
+	// ParallelDataKey is the key of the data which stores the results of
+	// the branches of parallel nodes.
+	ParallelDataKey = "PARALLEL"
+
+	// policyAll waits for all branches and succeeds if all of them succeed.
+	policyAll = "all"
+	// policyFirstSuccess succeeds once any branch succeeds.
+	policyFirstSuccess = "firstSuccess"
+	// policyQuorum succeeds once a quorum of branches succeed.
+	policyQuorum = "quorum"
+	// policyTimeout waits for all branches until the timeout, and succeeds
+	// if any of the finished branches succeeds.
+	policyTimeout = "timeout"
+
+	resultParallelFailed  = "failed"
+	resultParallelTimeout = "timeout"
+
+	branchResultSuccess    = "success"
+	branchResultUnfinished = "unfinished"
+)
+
+var parallelResults = []string{resultParallelFailed, resultParallelTimeout}
+
+type (
+	// ParallelSpec describes the branches of a parallel node and the
+	// policy to join them.
+	ParallelSpec struct {
+		Branches []*BranchSpec `json:"branches" jsonschema:"required,minItems=1"`
+		Policy   string        `json:"policy,omitempty" jsonschema:"enum=,enum=all,enum=firstSuccess,enum=quorum,enum=timeout"`
+		Quorum   int           `json:"quorum,omitempty" jsonschema:"minimum=1"`
+		Timeout  string        `json:"timeout,omitempty" jsonschema:"format=duration"`
+	}
+
+	// BranchSpec describes a branch of a parallel node, which is a flow
+	// running in its own namespace.
+	BranchSpec struct {
+		Namespace string `json:"namespace" jsonschema:"required"`
+		// NOTE: Flow is excluded from the json schema, as recursive types
+		// are not supported by the schema generator, it is validated by
+		// Spec.Validate instead.
+		Flow []FlowNode `json:"flow" jsonschema:"-"`
+	}
+
+	branchResult struct {
+		index  int
+		ctx    *context.Context
+		result string
+	}
+)
+
+// Validate validates ParallelSpec.
+func (spec *ParallelSpec) Validate() error {
+	if len(spec.Branches) == 0 {
+		return fmt.Errorf("no branches")
+	}
+
+	namespaces := map[string]struct{}{}
+	for _, b := range spec.Branches {
+		if b.Namespace == "" {
+			return fmt.Errorf("namespace of branches is required")
+		}
+		if _, ok := namespaces[b.Namespace]; ok {
+			return fmt.Errorf("duplicated branch namespace %s", b.Namespace)
+		}
+		namespaces[b.Namespace] = struct{}{}
+		if len(b.Flow) == 0 {
+			return fmt.Errorf("branch %s: flow is empty", b.Namespace)
+		}
+	}
+
+	switch spec.Policy {
+	case "", policyAll, policyFirstSuccess:
+	case policyQuorum:
+		if spec.Quorum < 1 || spec.Quorum > len(spec.Branches) {
+			return fmt.Errorf("quorum must be in [1, %d]", len(spec.Branches))
+		}
+	case policyTimeout:
+		if spec.Timeout == "" {
+			return fmt.Errorf("timeout is required by policy timeout")
+		}
+	default:
+		return fmt.Errorf("invalid policy %s", spec.Policy)
+	}
+
+	if spec.Timeout != "" {
+		if d, err := time.ParseDuration(spec.Timeout); err != nil {
+			return fmt.Errorf("invalid timeout %s: %v", spec.Timeout, err)
+		} else if d <= 0 {
+			return fmt.Errorf("timeout must be positive")
+		}
+	}
+
+	return nil
+}
+
+func (spec *ParallelSpec) timeout() time.Duration {
+	if spec.Timeout == "" {
+		return 0
+	}
+	d, _ := time.ParseDuration(spec.Timeout)
+	return d
+}
+
+// handleParallel runs the branches of the node concurrently, each in a
+// forked context, and joins the finished ones back to ctx according to
+// the policy.
+func (p *Pipeline) handleParallel(ctx *context.Context, node *FlowNode) string {
+	spec := node.Parallel
+	n := len(spec.Branches)
+
+	// all contexts are forked before any branch starts, as ctx must not
+	// be modified during forking. The timeout of the node is the deadline
+	// of the branches, so that they stop when they are not waited.
+	children := make([]*context.Context, n)
+	for i := range spec.Branches {
+		children[i] = ctx.Fork()
+		children[i].SetTimeout(spec.timeout())
+	}
+
+	// the channel is buffered, so branches finished after joining never
+	// block.
+	ch := make(chan *branchResult, n)
+	for i, b := range spec.Branches {
+		go p.runBranch(i, b, children[i], ch)
+	}
+
+	// the branches are not waited beyond the deadline of the request.
+	d := spec.timeout()
+	if deadline, ok := ctx.Deadline(); ok {
+		if left := time.Until(deadline); d <= 0 || left < d {
+			d = max(left, time.Nanosecond)
+		}
+	}
+	var timeoutCh <-chan time.Time
+	if d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+
+	quorum := n
+	switch spec.Policy {
+	case policyFirstSuccess:
+		quorum = 1
+	case policyQuorum:
+		quorum = spec.Quorum
+	}
+
+	finished := make([]*branchResult, n)
+	result, succeeded, failed, done := "", 0, 0, false
+	for !done {
+		select {
+		case br := <-ch:
+			finished[br.index] = br
+			if br.result == "" {
+				succeeded++
+			} else {
+				failed++
+			}
+
+			if spec.Policy == policyTimeout {
+				if succeeded+failed == n {
+					done = true
+					if succeeded == 0 {
+						result = resultParallelFailed
+					}
+				}
+				break
+			}
+			if succeeded >= quorum {
+				done = true
+			} else if failed > n-quorum {
+				done, result = true, resultParallelFailed
+			}
+		case <-timeoutCh:
+			done = true
+			if spec.Policy != policyTimeout || succeeded == 0 {
+				result = resultParallelTimeout
+			}
+		}
+	}
+
+	// join the finished branches in the order of the spec, so the result
+	// is deterministic when branches write to the same namespace.
+	results := make(map[string]string, n)
+	for i, b := range spec.Branches {
+		br := finished[i]
+		if br == nil {
+			results[b.Namespace] = branchResultUnfinished
+			continue
+		}
+		ctx.Join(br.ctx)
+		if br.result == "" {
+			results[b.Namespace] = branchResultSuccess
+		} else {
+			results[b.Namespace] = br.result
+		}
+	}
+	p.setParallelData(ctx, node.filterAlias(), results)
+
+	// the unfinished branches are discarded, cancel them, and finish
+	// their contexts when they are done.
+	for i, br := range finished {
+		if br == nil {
+			children[i].Cancel()
+		}
+	}
+	if unfinished := n - succeeded - failed; unfinished > 0 {
+		go func() {
+			for i := 0; i < unfinished; i++ {
+				br := <-ch
+				br.ctx.Finish()
+			}
+		}()
+	}
+
+	return result
+}
+
+func (p *Pipeline) runBranch(index int, b *BranchSpec, ctx *context.Context, ch chan<- *branchResult) {
+	br := &branchResult{index: index, ctx: ctx}
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Errorf("pipeline %s: branch %s panic: %v, stack trace: \n%s\n",
+				p.superSpec.Name(), b.Namespace, err, debug.Stack())
+			br.result = resultParallelFailed
+		}
+		ch <- br
+	}()
+
+	ctx.UseNamespace(b.Namespace)
+	stats := make([]FilterStat, 0, len(b.Flow))
+	br.result, stats, _ = p.handleFlow(ctx, b.Flow, b.Namespace, stats)
+
+	ctx.LazyAddTag(func() string {
+		var sb strings.Builder
+		sb.WriteString("branch(")
+		sb.WriteString(b.Namespace)
+		sb.WriteString("): ")
+		writeStats(&sb, stats)
+		return sb.String()
+	})
+}
+
+// setParallelData saves the results of the branches to the data of ctx,
+// so that they are available to filters like ResponseBuilder.
+//
+// NOTE: the data map is copied as it may be shared with forked contexts.
+func (p *Pipeline) setParallelData(ctx *context.Context, alias string, results map[string]string) {
+	prev, _ := ctx.GetData(ParallelDataKey).(map[string]interface{})
+	data := make(map[string]interface{}, len(prev)+1)
+	for k, v := range prev {
+		data[k] = v
+	}
+	data[alias] = results
+	ctx.SetData(ParallelDataKey, data)
+}
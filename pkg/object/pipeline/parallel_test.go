@@ -0,0 +1,422 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pipeline
+
+import (
+	stdcontext "context"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/v2/pkg/supervisor"
+	"github.com/megaease/easegress/v2/pkg/tracing"
+	"github.com/stretchr/testify/assert"
+)
+
+// branchFilter sets a response to the active namespace, it sleeps if its
+// name starts with "slow", waits like an upstream request bounded by the
+// context if its name starts with "wait", and fails if its name starts
+// with "fail".
+type branchFilter struct {
+	MockedFilter
+}
+
+// waitStopped counts the "wait" filters stopped by their contexts.
+var waitStopped atomic.Int32
+
+func (f *branchFilter) Handle(ctx *context.Context) string {
+	name := f.spec.Name()
+	if strings.HasPrefix(name, "slow") {
+		time.Sleep(200 * time.Millisecond)
+	}
+	if strings.HasPrefix(name, "wait") {
+		select {
+		case <-ctx.WithDeadline(stdcontext.Background()).Done():
+			waitStopped.Add(1)
+			return "failed"
+		case <-time.After(time.Second):
+		}
+	}
+
+	resp, _ := httpprot.NewResponse(nil)
+	resp.SetPayload([]byte(name))
+	ctx.SetOutputResponse(resp)
+	ctx.SetData(name, ctx.Namespace())
+
+	if strings.HasPrefix(name, "fail") {
+		return "failed"
+	}
+	return ""
+}
+
+func branchFilterKind() *filters.Kind {
+	k := MockFilterKind("BranchFilter", []string{"failed"})
+	k.CreateInstance = func(spec filters.Spec) filters.Filter {
+		return &branchFilter{MockedFilter{kind: k, spec: spec.(*MockedSpec)}}
+	}
+	return k
+}
+
+func newParallelPipeline(t *testing.T, parallel string) *Pipeline {
+	yamlConfig := `
+name: parallel-pipeline-test
+kind: Pipeline
+flow:
+  - filter: PARALLEL
+    alias: fanout
+    parallel:
+` + parallel + `
+    jumpIf:
+      failed: END
+      timeout: END
+  - filter: after
+filters:
+  - name: ok1
+    kind: BranchFilter
+  - name: ok2
+    kind: BranchFilter
+  - name: fail1
+    kind: BranchFilter
+  - name: slow1
+    kind: BranchFilter
+  - name: wait1
+    kind: BranchFilter
+  - name: after
+    kind: BranchFilter
+`
+	superSpec, err := supervisor.NewSpec(yamlConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Pipeline{}
+	p.Init(superSpec, nil)
+	return p
+}
+
+func handleParallelPipeline(p *Pipeline) (*context.Context, string) {
+	stdReq, _ := http.NewRequest(http.MethodGet, "http://localhost:9095", nil)
+	req, _ := httpprot.NewRequest(stdReq)
+	ctx := context.New(tracing.NoopSpan)
+	ctx.SetRequest(context.DefaultNamespace, req)
+	return ctx, p.Handle(ctx)
+}
+
+func branchResults(ctx *context.Context) map[string]string {
+	data := ctx.GetData(ParallelDataKey).(map[string]interface{})
+	return data["fanout"].(map[string]string)
+}
+
+func TestParallelSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+	filters.Register(branchFilterKind())
+	defer cleanup()
+
+	validate := func(parallel string) error {
+		yamlConfig := `
+name: parallel-pipeline-test
+kind: Pipeline
+flow:
+  - filter: PARALLEL
+` + parallel + `
+filters:
+  - name: ok1
+    kind: BranchFilter
+`
+		_, err := supervisor.NewSpec(yamlConfig)
+		return err
+	}
+
+	assert.NoError(validate(`
+    parallel:
+      branches:
+      - namespace: a
+        flow:
+        - filter: ok1
+          jumpIf: { failed: END }
+      - namespace: b
+        flow:
+        - filter: ok1`))
+
+	// parallel is required
+	assert.Error(validate(""))
+
+	// duplicated namespaces
+	assert.Error(validate(`
+    parallel:
+      branches:
+      - namespace: a
+        flow: [{filter: ok1}]
+      - namespace: a
+        flow: [{filter: ok1}]`))
+
+	// unknown filter in branch
+	assert.Error(validate(`
+    parallel:
+      branches:
+      - namespace: a
+        flow: [{filter: ok2}]`))
+
+	// empty flow
+	assert.Error(validate(`
+    parallel:
+      branches:
+      - namespace: a`))
+
+	// invalid quorum
+	assert.Error(validate(`
+    parallel:
+      policy: quorum
+      quorum: 2
+      branches:
+      - namespace: a
+        flow: [{filter: ok1}]`))
+
+	// timeout is required
+	assert.Error(validate(`
+    parallel:
+      policy: timeout
+      branches:
+      - namespace: a
+        flow: [{filter: ok1}]`))
+
+	// parallel on other filters
+	_, err := supervisor.NewSpec(`
+name: parallel-pipeline-test
+kind: Pipeline
+flow:
+  - filter: ok1
+    parallel:
+      branches:
+      - namespace: a
+        flow: [{filter: ok1}]
+filters:
+  - name: ok1
+    kind: BranchFilter
+`)
+	assert.Error(err)
+
+	// PARALLEL is a built-in name
+	_, err = supervisor.NewSpec(`
+name: parallel-pipeline-test
+kind: Pipeline
+filters:
+  - name: PARALLEL
+    kind: BranchFilter
+`)
+	assert.Error(err)
+}
+
+func TestParallelAll(t *testing.T) {
+	assert := assert.New(t)
+	filters.Register(branchFilterKind())
+	defer cleanup()
+
+	p := newParallelPipeline(t, `
+      branches:
+      - namespace: a
+        flow: [{filter: ok1}]
+      - namespace: b
+        flow: [{filter: ok2}]`)
+	defer p.Close()
+
+	ctx, result := handleParallelPipeline(p)
+	defer ctx.Finish()
+	assert.Equal("", result)
+	assert.Equal("ok1", string(ctx.GetResponse("a").RawPayload()))
+	assert.Equal("ok2", string(ctx.GetResponse("b").RawPayload()))
+	assert.Equal("after", string(ctx.GetResponse(context.DefaultNamespace).RawPayload()))
+	assert.Equal("a", ctx.GetData("ok1"))
+	assert.Equal("b", ctx.GetData("ok2"))
+	assert.Equal(map[string]string{"a": "success", "b": "success"}, branchResults(ctx))
+
+	// the request of the parent is visible to branches.
+	assert.NotNil(ctx.GetRequest(context.DefaultNamespace))
+
+	tags := ctx.Tags()
+	assert.Contains(tags, "fanout(")
+	assert.Contains(tags, "branch(a): ok1(")
+	assert.Contains(tags, "branch(b): ok2(")
+
+	p = newParallelPipeline(t, `
+      branches:
+      - namespace: a
+        flow: [{filter: ok1}]
+      - namespace: b
+        flow: [{filter: fail1}]`)
+	defer p.Close()
+
+	ctx, result = handleParallelPipeline(p)
+	defer ctx.Finish()
+	// policy all fails as soon as any branch fails.
+	assert.Equal("failed", result)
+	assert.Equal("failed", branchResults(ctx)["b"])
+	assert.Nil(ctx.GetResponse(context.DefaultNamespace))
+}
+
+func TestParallelFirstSuccess(t *testing.T) {
+	assert := assert.New(t)
+	filters.Register(branchFilterKind())
+	defer cleanup()
+
+	p := newParallelPipeline(t, `
+      policy: firstSuccess
+      branches:
+      - namespace: a
+        flow: [{filter: slow1}]
+      - namespace: b
+        flow: [{filter: fail1}]
+      - namespace: c
+        flow: [{filter: ok1}]`)
+	defer p.Close()
+
+	ctx, result := handleParallelPipeline(p)
+	defer ctx.Finish()
+	assert.Equal("", result)
+	assert.Nil(ctx.GetResponse("a"))
+	assert.Equal("ok1", string(ctx.GetResponse("c").RawPayload()))
+	assert.Equal("unfinished", branchResults(ctx)["a"])
+	assert.Equal("success", branchResults(ctx)["c"])
+
+	// wait for the unfinished branch to be finished.
+	time.Sleep(300 * time.Millisecond)
+
+	// the branches which lose are canceled, and run no more nodes.
+	waitStopped.Store(0)
+	p = newParallelPipeline(t, `
+      policy: firstSuccess
+      branches:
+      - namespace: a
+        flow: [{filter: wait1}, {filter: ok2}]
+      - namespace: b
+        flow: [{filter: slow1}]`)
+	defer p.Close()
+
+	start := time.Now()
+	ctx, result = handleParallelPipeline(p)
+	defer ctx.Finish()
+	assert.Equal("", result)
+	assert.Eventually(func() bool {
+		return waitStopped.Load() == 1
+	}, 100*time.Millisecond, 5*time.Millisecond)
+	assert.Less(time.Since(start), 400*time.Millisecond)
+}
+
+func TestParallelQuorum(t *testing.T) {
+	assert := assert.New(t)
+	filters.Register(branchFilterKind())
+	defer cleanup()
+
+	p := newParallelPipeline(t, `
+      policy: quorum
+      quorum: 2
+      branches:
+      - namespace: a
+        flow: [{filter: ok1}]
+      - namespace: b
+        flow: [{filter: fail1}]
+      - namespace: c
+        flow: [{filter: ok2}]`)
+	defer p.Close()
+
+	ctx, result := handleParallelPipeline(p)
+	defer ctx.Finish()
+	assert.Equal("", result)
+
+	p = newParallelPipeline(t, `
+      policy: quorum
+      quorum: 2
+      branches:
+      - namespace: a
+        flow: [{filter: ok1}]
+      - namespace: b
+        flow: [{filter: fail1}]
+      - namespace: c
+        flow: [{filter: fail1}]`)
+	defer p.Close()
+
+	ctx, result = handleParallelPipeline(p)
+	defer ctx.Finish()
+	assert.Equal("failed", result)
+}
+
+func TestParallelTimeout(t *testing.T) {
+	assert := assert.New(t)
+	filters.Register(branchFilterKind())
+	defer cleanup()
+
+	// policy timeout accepts partial results.
+	p := newParallelPipeline(t, `
+      policy: timeout
+      timeout: 50ms
+      branches:
+      - namespace: a
+        flow: [{filter: ok1}]
+      - namespace: b
+        flow: [{filter: slow1}]`)
+	defer p.Close()
+
+	ctx, result := handleParallelPipeline(p)
+	defer ctx.Finish()
+	assert.Equal("", result)
+	assert.Equal(map[string]string{"a": "success", "b": "unfinished"}, branchResults(ctx))
+	assert.Equal("after", string(ctx.GetResponse(context.DefaultNamespace).RawPayload()))
+
+	// other policies fail on timeout.
+	p = newParallelPipeline(t, `
+      timeout: 50ms
+      branches:
+      - namespace: a
+        flow: [{filter: ok1}]
+      - namespace: b
+        flow: [{filter: slow1}]`)
+	defer p.Close()
+
+	ctx, result = handleParallelPipeline(p)
+	defer ctx.Finish()
+	assert.Equal("timeout", result)
+	assert.Equal("ok1", string(ctx.GetResponse("a").RawPayload()))
+
+	time.Sleep(300 * time.Millisecond)
+
+	// the timeout is the deadline of the branches.
+	waitStopped.Store(0)
+	p = newParallelPipeline(t, `
+      policy: timeout
+      timeout: 50ms
+      branches:
+      - namespace: a
+        flow: [{filter: ok1}]
+      - namespace: b
+        flow: [{filter: wait1}]`)
+	defer p.Close()
+
+	start := time.Now()
+	ctx, result = handleParallelPipeline(p)
+	defer ctx.Finish()
+	assert.Equal("", result)
+	assert.Eventually(func() bool {
+		return waitStopped.Load() == 1
+	}, 100*time.Millisecond, 5*time.Millisecond)
+	assert.Less(time.Since(start), 150*time.Millisecond)
+}
@@ -26,6 +26,8 @@ import (
 	"github.com/megaease/easegress/v2/pkg/api"
 	"github.com/megaease/easegress/v2/pkg/context"
 	"github.com/megaease/easegress/v2/pkg/filters"
+	"github.com/megaease/easegress/v2/pkg/filters/builder"
+	"github.com/megaease/easegress/v2/pkg/logger"
 	"github.com/megaease/easegress/v2/pkg/resilience"
 	"github.com/megaease/easegress/v2/pkg/supervisor"
 	"github.com/megaease/easegress/v2/pkg/util/easemonitor"
@@ -42,6 +44,10 @@ const (
 
 	// BuiltInFilterEnd is the name of the build-in end filter.
 	BuiltInFilterEnd = "END"
+
+	// ResultDeadlineExceeded is the result of the node during which, or
+	// before which, the deadline of the request is exceeded.
+	ResultDeadlineExceeded = "deadlineExceeded"
 )
 
 func init() {
@@ -55,7 +61,7 @@ func init() {
 }
 
 func isBuiltInFilter(name string) bool {
-	return name == BuiltInFilterEnd
+	return name == BuiltInFilterEnd || name == BuiltInFilterParallel
 }
 
 type (
@@ -63,18 +69,33 @@ type (
 	Pipeline struct {
 		superSpec *supervisor.Spec
 		spec      *Spec
+		// namespace is the namespace of the traffic controller which the
+		// pipeline belongs to, the pipelines it calls are looked up in it.
+		namespace string
+		// hasCalls is whether the pipeline calls other pipelines.
+		hasCalls bool
 
 		filters    map[string]filters.Filter
 		flow       []FlowNode
 		resilience map[string]resilience.Policy
+		metrics    *metrics
 	}
 
 	// Spec describes the Pipeline.
 	Spec struct {
+		// name is the name of the pipeline, it is used to detect cycles
+		// of pipeline calls.
+		name string
+		// timeout is the parsed Timeout.
+		timeout time.Duration
+
 		Flow       []FlowNode               `json:"flow,omitempty"`
 		Filters    []map[string]interface{} `json:"filters" jsonschema:"required"`
 		Resilience []map[string]interface{} `json:"resilience,omitempty"`
 		Data       map[string]interface{}   `json:"data,omitempty"`
+		// Timeout is the max duration to handle a request, it becomes the
+		// deadline of the request if the deadline is not set or is later.
+		Timeout string `json:"timeout,omitempty" jsonschema:"format=duration"`
 	}
 
 	// FlowNode describes one node of the pipeline flow.
@@ -83,7 +104,12 @@ type (
 		FilterAlias string            `json:"alias,omitempty"`
 		Namespace   string            `json:"namespace,omitempty"`
 		JumpIf      map[string]string `json:"jumpIf,omitempty"`
-		filter      filters.Filter
+		Parallel    *ParallelSpec     `json:"parallel,omitempty"`
+		// When is a template expression, the node is skipped unless it
+		// evaluates to true.
+		When   string `json:"when,omitempty"`
+		filter filters.Filter
+		when   *builder.Condition
 	}
 
 	// FilterStat records the statistics of a filter.
@@ -116,13 +142,23 @@ func (s *Spec) ValidateJumpIf(specs map[string]filters.Spec) {
 		if node.FilterName == BuiltInFilterEnd {
 			continue
 		}
-		spec := specs[node.FilterName]
-		if spec == nil {
-			panic(fmt.Errorf("filter %s not found", node.FilterName))
+
+		var results []string
+		if node.FilterName == BuiltInFilterParallel {
+			results = s.validateParallel(node, specs)
+		} else if node.Parallel != nil {
+			panic(fmt.Errorf("filter %s: parallel is only allowed in %s", node.FilterName, BuiltInFilterParallel))
+		} else {
+			spec := specs[node.FilterName]
+			if spec == nil {
+				panic(fmt.Errorf("filter %s not found", node.FilterName))
+			}
+			results = filters.GetResults(spec)
 		}
-		results := filters.GetKind(spec.Kind()).Results
+
+		// ResultDeadlineExceeded is a valid result of all nodes.
 		for result, target := range node.JumpIf {
-			if result != "" && !stringtool.StrInSlice(result, results) {
+			if result != "" && result != ResultDeadlineExceeded && !stringtool.StrInSlice(result, results) {
 				msgFmt := "filter %s: result %s is not in %v"
 				panic(fmt.Errorf(msgFmt, node.FilterName, result, results))
 			}
@@ -137,6 +173,40 @@ func (s *Spec) ValidateJumpIf(specs map[string]filters.Spec) {
 	}
 }
 
+// validateParallel validates the parallel node and its branches, and
+// returns the results of the node.
+func (s *Spec) validateParallel(node *FlowNode, specs map[string]filters.Spec) []string {
+	if node.Parallel == nil {
+		panic(fmt.Errorf("%s: parallel is required", node.filterAlias()))
+	}
+	if err := node.Parallel.Validate(); err != nil {
+		panic(fmt.Errorf("%s: %v", node.filterAlias(), err))
+	}
+	for _, b := range node.Parallel.Branches {
+		branch := &Spec{Flow: b.Flow}
+		branch.ValidateJumpIf(specs)
+	}
+	return parallelResults
+}
+
+// validateWhen validates the when expressions of nodes in flow, including
+// the nodes in branches of parallel nodes.
+func validateWhen(flow []FlowNode) {
+	for i := range flow {
+		node := &flow[i]
+		if node.When != "" {
+			if _, err := builder.NewCondition(node.When); err != nil {
+				panic(fmt.Errorf("%s: invalid when expression: %v", node.filterAlias(), err))
+			}
+		}
+		if node.Parallel != nil {
+			for _, b := range node.Parallel.Branches {
+				validateWhen(b.Flow)
+			}
+		}
+	}
+}
+
 // Validate validates Spec.
 func (s *Spec) Validate() (err error) {
 	errPrefix := "filters"
@@ -170,8 +240,13 @@ func (s *Spec) Validate() (err error) {
 	// 2: validate flow
 	errPrefix = "flow"
 	s.ValidateJumpIf(specs)
+	validateWhen(s.Flow)
+
+	// 3: validate pipeline calls
+	errPrefix = "calls"
+	s.validateCalls(specs)
 
-	// 3: validate resilience
+	// 4: validate resilience
 	for _, r := range s.Resilience {
 		_, err := resilience.NewPolicy(r)
 		if err != nil {
@@ -191,6 +266,12 @@ func (p *Pipeline) serializeStats(stats []FilterStat) string {
 	sb.WriteString("pipeline(")
 	sb.WriteString(p.superSpec.Name())
 	sb.WriteString("): ")
+	writeStats(&sb, stats)
+
+	return sb.String()
+}
+
+func writeStats(sb *strings.Builder, stats []FilterStat) {
 	for i := range stats {
 		if i > 0 {
 			sb.WriteString("->")
@@ -206,8 +287,6 @@ func (p *Pipeline) serializeStats(stats []FilterStat) string {
 		sb.WriteString(stat.Duration.String())
 		sb.WriteByte(')')
 	}
-
-	return sb.String()
 }
 
 // Category returns the category of Pipeline.
@@ -228,12 +307,14 @@ func (p *Pipeline) DefaultSpec() interface{} {
 // Init initializes Pipeline.
 func (p *Pipeline) Init(superSpec *supervisor.Spec, muxMapper context.MuxMapper) {
 	p.superSpec, p.spec = superSpec, superSpec.ObjectSpec().(*Spec)
+	p.namespace = namespaceOf(muxMapper)
 	p.reload(nil /*no previous generation*/)
 }
 
 // Inherit inherits previous generation of Pipeline.
 func (p *Pipeline) Inherit(superSpec *supervisor.Spec, previousGeneration supervisor.Object, muxMapper context.MuxMapper) {
 	p.superSpec, p.spec = superSpec, superSpec.ObjectSpec().(*Spec)
+	p.namespace = namespaceOf(muxMapper)
 	p.reload(previousGeneration.(*Pipeline))
 	previousGeneration.Close()
 }
@@ -241,6 +322,8 @@ func (p *Pipeline) Inherit(superSpec *supervisor.Spec, previousGeneration superv
 func (p *Pipeline) reload(previousGeneration *Pipeline) {
 	p.filters = make(map[string]filters.Filter)
 	p.resilience = make(map[string]resilience.Policy)
+	// the timeout is validated by the json schema.
+	p.spec.timeout, _ = time.ParseDuration(p.spec.Timeout)
 
 	super := p.superSpec.Super()
 	pipelineName := p.superSpec.Name()
@@ -296,11 +379,26 @@ func (p *Pipeline) reload(previousGeneration *Pipeline) {
 	}
 
 	p.flow = flow
+	p.bindFilters(flow)
+	p.hasCalls = len(p.calledPipelines()) > 0
+	p.metrics = p.newMetrics()
+}
 
-	// bind filter instance to flow node.
+// bindFilters binds filter instances to the nodes of flow, including the
+// nodes in branches of parallel nodes.
+func (p *Pipeline) bindFilters(flow []FlowNode) {
 	for i := range flow {
 		node := &flow[i]
-		if node.FilterName != BuiltInFilterEnd {
+		if node.When != "" {
+			node.when, _ = builder.NewCondition(node.When)
+		}
+		switch node.FilterName {
+		case BuiltInFilterEnd:
+		case BuiltInFilterParallel:
+			for _, b := range node.Parallel.Branches {
+				p.bindFilters(b.Flow)
+			}
+		default:
 			node.filter = p.filters[node.FilterName]
 		}
 	}
@@ -358,6 +456,10 @@ func (p *Pipeline) Handle(ctx *context.Context) string {
 	if len(p.spec.Data) > 0 {
 		ctx.SetData("PIPELINE", p.spec.Data)
 	}
+	if p.hasCalls {
+		ctx.SetData(callNamespaceDataKey, p.namespace)
+	}
+	ctx.SetTimeout(p.spec.timeout)
 
 	stats := make([]FilterStat, 0, len(p.flow))
 	result, stats, _ := p.doHandle(ctx, p.flow, stats)
@@ -369,15 +471,45 @@ func (p *Pipeline) Handle(ctx *context.Context) string {
 }
 
 func (p *Pipeline) doHandle(ctx *context.Context, flow []FlowNode, stats []FilterStat) (string, []FilterStat, bool) {
+	return p.handleFlow(ctx, flow, "", stats)
+}
+
+// handleFlow handles the request with flow, defaultNs is the namespace
+// of nodes whose namespace is empty.
+//
+// Once the deadline of ctx is exceeded, the result of the current node,
+// or the next node without calling it, is ResultDeadlineExceeded. This
+// happens only once in a flow, so that the nodes after it, e.g. the one
+// building a failure response, still run as usual.
+func (p *Pipeline) handleFlow(ctx *context.Context, flow []FlowNode, defaultNs string, stats []FilterStat) (string, []FilterStat, bool) {
 	result, next, sawEnd := "", "", false
+	deadlineReported := false
+	checkDeadline := func() bool {
+		if !deadlineReported && ctx.DeadlineExceeded() {
+			deadlineReported = true
+			return true
+		}
+		return false
+	}
 
 	for i := range flow {
+		// a canceled context runs no more nodes, e.g. a branch of a
+		// parallel node whose result is discarded.
+		if ctx.Canceled() {
+			break
+		}
+
 		node := &flow[i]
 		alias := node.filterAlias()
 
 		if next != "" && next != alias {
 			continue
 		}
+		next = ""
+
+		if node.when != nil && !p.evalWhen(ctx, node, defaultNs) {
+			continue
+		}
 
 		if node.FilterName == BuiltInFilterEnd {
 			sawEnd = true
@@ -385,15 +517,41 @@ func (p *Pipeline) doHandle(ctx *context.Context, flow []FlowNode, stats []Filte
 		}
 
 		start := fasttime.Now()
-		ctx.UseNamespace(node.Namespace)
+		if node.Namespace != "" {
+			ctx.UseNamespace(node.Namespace)
+		} else {
+			ctx.UseNamespace(defaultNs)
+		}
+
+		var kind string
+		if node.FilterName == BuiltInFilterParallel {
+			kind = ParallelKind
+		} else {
+			kind = node.filter.Kind().Name
+		}
 
-		result = node.filter.Handle(ctx)
+		if checkDeadline() {
+			result = ResultDeadlineExceeded
+		} else {
+			if node.FilterName == BuiltInFilterParallel {
+				result = p.handleParallel(ctx, node)
+			} else {
+				result = node.filter.Handle(ctx)
+			}
+			// a node which succeeded keeps its result, the deadline is
+			// then reported by the next node.
+			if result != "" && checkDeadline() {
+				result = ResultDeadlineExceeded
+			}
+		}
+		duration := fasttime.Since(start)
 		stats = append(stats, FilterStat{
 			Name:     alias,
-			Kind:     node.filter.Kind().Name,
-			Duration: fasttime.Since(start),
+			Kind:     kind,
+			Duration: duration,
 			Result:   result,
 		})
+		p.exportFilterMetrics(alias, kind, result, duration)
 
 		var ok bool
 		if next, ok = node.JumpIf[result]; result != "" && !ok {
@@ -409,6 +567,24 @@ func (p *Pipeline) doHandle(ctx *context.Context, flow []FlowNode, stats []Filte
 	return result, stats, sawEnd
 }
 
+// evalWhen evaluates the when expression of node in its namespace, the
+// node is skipped if the evaluation fails.
+func (p *Pipeline) evalWhen(ctx *context.Context, node *FlowNode, defaultNs string) bool {
+	if node.Namespace != "" {
+		ctx.UseNamespace(node.Namespace)
+	} else {
+		ctx.UseNamespace(defaultNs)
+	}
+
+	ok, err := node.when.Eval(ctx)
+	if err != nil {
+		logger.Warnf("pipeline %s: failed to evaluate when of %s, skip it: %v",
+			p.superSpec.Name(), node.filterAlias(), err)
+		return false
+	}
+	return ok
+}
+
 // Status returns Status generated by Runtime.
 func (p *Pipeline) Status() *supervisor.Status {
 	s := &Status{
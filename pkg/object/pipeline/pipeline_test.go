@@ -22,6 +22,7 @@ import (
 	"net/http"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/megaease/easegress/v2/pkg/context"
 	"github.com/megaease/easegress/v2/pkg/filters"
@@ -282,7 +283,7 @@ filters:
 	if err != nil {
 		t.Errorf("failed to create spec %s", err)
 	}
-	pipeline := Pipeline{nil, nil, map[string]filters.Filter{}, nil, nil}
+	pipeline := Pipeline{filters: map[string]filters.Filter{}}
 	pipeline.Init(superSpec, nil)
 	pipeline.Inherit(superSpec, &pipeline, nil)
 
@@ -330,7 +331,7 @@ filters:
 	if err != nil {
 		t.Errorf("failed to create spec %s", err)
 	}
-	pipeline := Pipeline{nil, nil, map[string]filters.Filter{}, nil, nil}
+	pipeline := Pipeline{filters: map[string]filters.Filter{}}
 	pipeline.Init(superSpec, nil)
 	pipeline.Inherit(superSpec, &pipeline, nil)
 
@@ -498,3 +499,224 @@ filters:
 	assert.NotContains(tags, "filter2")
 	assert.NotContains(tags, "filter3")
 }
+
+func TestWhen(t *testing.T) {
+	assert := assert.New(t)
+
+	filters.Register(MockFilterKind("Filter1", []string{"invalid"}))
+	defer cleanup()
+
+	_, err := supervisor.NewSpec(`
+name: pipeline
+kind: Pipeline
+flow:
+  - filter: filter1
+    when: eq (.req.Method "GET"
+filters:
+  - name: filter1
+    kind: Filter1
+`)
+	assert.Error(err)
+
+	yamlConfig := `
+name: http-pipeline-test
+kind: Pipeline
+flow:
+  - filter: filter1
+    when: eq .req.Method "POST"
+  - filter: filter2
+    when: eq (header .req.Header "X-Canary") "true"
+  - filter: END
+    when: eq .req.URL.Path "/end"
+  - filter: filter3
+    when: .no.such.value
+filters:
+  - name: filter1
+    kind: Filter1
+  - name: filter2
+    kind: Filter1
+  - name: filter3
+    kind: Filter1
+`
+	superSpec, err := supervisor.NewSpec(yamlConfig)
+	assert.Nil(err)
+
+	pipeline := &Pipeline{}
+	pipeline.Init(superSpec, nil)
+	defer pipeline.Close()
+
+	handle := func(path string, canary bool) *http.Request {
+		stdReq, _ := http.NewRequest(http.MethodGet, "http://localhost:9095"+path, nil)
+		if canary {
+			stdReq.Header.Set("X-Canary", "true")
+		}
+		req, _ := httpprot.NewRequest(stdReq)
+		ctx := context.New(tracing.NoopSpan)
+		ctx.SetRequest(context.DefaultNamespace, req)
+		pipeline.Handle(ctx)
+		return stdReq
+	}
+
+	filter1 := MockGetFilter(pipeline, "filter1").(*MockedFilter)
+	filter2 := MockGetFilter(pipeline, "filter2").(*MockedFilter)
+	filter3 := MockGetFilter(pipeline, "filter3").(*MockedFilter)
+
+	stdReq := handle("/", true)
+	assert.Equal(0, filter1.count)
+	assert.Equal(1, filter2.count)
+	assert.Equal(0, filter3.count, "failed evaluation skips the filter")
+	k, v := filter2.HeaderKV()
+	assert.Equal(v, stdReq.Header.Get(k))
+
+	handle("/", false)
+	assert.Equal(1, filter2.count)
+
+	handle("/end", true)
+	assert.Equal(2, filter2.count)
+	assert.Equal(0, filter3.count)
+}
+
+func TestWhenJumpIf(t *testing.T) {
+	assert := assert.New(t)
+
+	k := MockFilterKind("Filter1", []string{"invalid"})
+	k.CreateInstance = func(spec filters.Spec) filters.Filter {
+		return &invalidFilter{MockedFilter{kind: k, spec: spec.(*MockedSpec)}}
+	}
+	filters.Register(k)
+	filters.Register(MockFilterKind("Filter2", nil))
+	defer cleanup()
+
+	// the target of jumpIf is skipped, the following filters still run.
+	yamlConfig := `
+name: http-pipeline-test
+kind: Pipeline
+flow:
+  - filter: filter1
+    jumpIf: { invalid: filter2 }
+  - filter: filter2
+    when: "false"
+  - filter: filter3
+filters:
+  - name: filter1
+    kind: Filter1
+  - name: filter2
+    kind: Filter2
+  - name: filter3
+    kind: Filter2
+`
+	superSpec, err := supervisor.NewSpec(yamlConfig)
+	assert.Nil(err)
+
+	pipeline := &Pipeline{}
+	pipeline.Init(superSpec, nil)
+	defer pipeline.Close()
+
+	ctx := context.New(tracing.NoopSpan)
+	pipeline.Handle(ctx)
+	assert.Equal(0, MockGetFilter(pipeline, "filter2").(*MockedFilter).count)
+	assert.Equal(1, MockGetFilter(pipeline, "filter3").(*MockedFilter).count)
+}
+
+type invalidFilter struct {
+	MockedFilter
+}
+
+func (f *invalidFilter) Handle(ctx *context.Context) string {
+	return "invalid"
+}
+
+func TestDeadline(t *testing.T) {
+	assert := assert.New(t)
+	filters.Register(branchFilterKind())
+	defer cleanup()
+
+	newPipeline := func(spec string) *Pipeline {
+		yamlConfig := `
+name: deadline-pipeline-test
+kind: Pipeline
+` + spec + `
+filters:
+  - name: ok1
+    kind: BranchFilter
+  - name: ok2
+    kind: BranchFilter
+  - name: slow1
+    kind: BranchFilter
+`
+		superSpec, err := supervisor.NewSpec(yamlConfig)
+		assert.NoError(err)
+		p := &Pipeline{}
+		p.Init(superSpec, nil)
+		return p
+	}
+
+	_, err := supervisor.NewSpec(`
+name: deadline-pipeline-test
+kind: Pipeline
+timeout: abc
+filters:
+  - name: ok1
+    kind: BranchFilter
+`)
+	assert.Error(err)
+
+	// the deadline is exceeded in slow1, which succeeds and keeps its
+	// result, ok1 is not called, and the flow jumps to onTimeout.
+	p := newPipeline(`
+timeout: 50ms
+flow:
+  - filter: slow1
+  - filter: ok1
+    jumpIf: { deadlineExceeded: onTimeout }
+  - filter: END
+  - filter: ok2
+    alias: onTimeout
+`)
+	ctx, result := handleParallelPipeline(p)
+	assert.Equal("", result)
+	assert.NotNil(ctx.GetData("slow1"))
+	assert.Nil(ctx.GetData("ok1"))
+	assert.NotNil(ctx.GetData("ok2"))
+	_, ok := ctx.Deadline()
+	assert.True(ok)
+	assert.True(ctx.DeadlineExceeded())
+	assert.NotContains(ctx.Tags(), "slow1(deadlineExceeded,")
+	assert.Contains(ctx.Tags(), "ok1(deadlineExceeded,")
+
+	// the deadline is exceeded before ok1, which is not called.
+	p = newPipeline(`
+flow:
+  - filter: ok1
+  - filter: ok2
+`)
+	stdReq, _ := http.NewRequest(http.MethodGet, "http://localhost:9095", nil)
+	req, _ := httpprot.NewRequest(stdReq)
+	ctx = context.New(tracing.NoopSpan)
+	ctx.SetRequest(context.DefaultNamespace, req)
+	ctx.SetDeadline(time.Now().Add(-time.Second))
+	assert.Equal(ResultDeadlineExceeded, p.Handle(ctx))
+	assert.Nil(ctx.GetData("ok1"))
+	assert.Nil(ctx.GetData("ok2"))
+
+	// the parallel node doesn't wait beyond the deadline.
+	p = newPipeline(`
+timeout: 50ms
+flow:
+  - filter: PARALLEL
+    parallel:
+      branches:
+      - namespace: a
+        flow:
+        - filter: slow1
+      - namespace: b
+        flow:
+        - filter: ok1
+  - filter: ok2
+`)
+	start := time.Now()
+	ctx, result = handleParallelPipeline(p)
+	assert.Equal(ResultDeadlineExceeded, result)
+	assert.Less(time.Since(start), 150*time.Millisecond)
+	assert.Nil(ctx.GetData("ok2"))
+}
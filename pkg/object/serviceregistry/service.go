@@ -43,6 +43,9 @@ type (
 		Tags []string `json:"tags"`
 		// Weight is optional.
 		Weight int `json:"weight"`
+		// Labels is optional, it is the metadata of the instance, such as
+		// zone and region.
+		Labels map[string]string `json:"labels,omitempty"`
 	}
 )
 
@@ -54,6 +57,13 @@ func (s *ServiceInstanceSpec) DeepCopy() *ServiceInstanceSpec {
 		copy.Tags = append(copy.Tags, s.Tags...)
 	}
 
+	if s.Labels != nil {
+		copy.Labels = make(map[string]string, len(s.Labels))
+		for k, v := range s.Labels {
+			copy.Labels[k] = v
+		}
+	}
+
 	return &copy
 }
 
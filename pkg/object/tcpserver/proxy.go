@@ -0,0 +1,259 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tcpserver
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	rejectReasonIPFilter  = "ipFilter"
+	rejectReasonHandshake = "handshake"
+	rejectReasonNoRoute   = "noRoute"
+	rejectReasonUpstream  = "upstream"
+)
+
+// closeWriter is implemented by connections support half close, like
+// *net.TCPConn and *tls.Conn.
+type closeWriter interface {
+	CloseWrite() error
+}
+
+func (r *runtime) handleConn(conn net.Conn) {
+	startTime := time.Now()
+
+	rt := r.router.Load()
+	if rt == nil {
+		conn.Close()
+		return
+	}
+
+	clientIP, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	if !rt.ipFilter.Allow(clientIP) {
+		r.reject(conn, "", rejectReasonIPFilter, nil)
+		return
+	}
+
+	var serverName string
+	var err error
+	switch rt.tlsMode {
+	case TLSModePassthrough:
+		conn.SetReadDeadline(startTime.Add(rt.handshakeTimeout))
+		serverName, conn, err = peekServerName(conn)
+		conn.SetReadDeadline(time.Time{})
+	case TLSModeTerminate:
+		tlsConn := tls.Server(conn, rt.tlsConfig)
+		tlsConn.SetDeadline(startTime.Add(rt.handshakeTimeout))
+		err = tlsConn.Handshake()
+		tlsConn.SetDeadline(time.Time{})
+		serverName = tlsConn.ConnectionState().ServerName
+		conn = tlsConn
+	}
+	if err != nil {
+		r.reject(conn, "", rejectReasonHandshake, err)
+		return
+	}
+
+	route := rt.match(serverName)
+	if route == nil {
+		r.reject(conn, "", rejectReasonNoRoute, fmt.Errorf("no route for sni %q", serverName))
+		return
+	}
+	if !route.ipFilter.Allow(clientIP) {
+		r.reject(conn, route.spec.Name, rejectReasonIPFilter, nil)
+		return
+	}
+
+	upstream, err := route.pool.Dial("tcp", clientIP)
+	if err != nil {
+		r.reject(conn, route.spec.Name, rejectReasonUpstream, err)
+		return
+	}
+
+	labels := prometheus.Labels{"route": route.spec.Name}
+	r.totalConnections.Add(1)
+	r.activeConnections.Add(1)
+	r.metrics.TotalConnections.With(labels).Inc()
+	r.metrics.ActiveConnections.With(labels).Inc()
+
+	received, sent := relay(conn, upstream, rt.idleTimeout)
+
+	r.activeConnections.Add(-1)
+	r.metrics.ActiveConnections.With(labels).Dec()
+	r.metrics.ReceivedBytes.With(labels).Add(float64(received))
+	r.metrics.SentBytes.With(labels).Add(float64(sent))
+	r.metrics.ConnectionDuration.With(labels).Observe(float64(time.Since(startTime).Milliseconds()))
+}
+
+func (r *runtime) reject(conn net.Conn, route, reason string, err error) {
+	conn.Close()
+
+	r.rejectedConnections.Add(1)
+	r.metrics.RejectedConnections.With(prometheus.Labels{
+		"route":  route,
+		"reason": reason,
+	}).Inc()
+
+	if err != nil {
+		logger.Debugf("%s: reject connection from %s: %v", r.superSpec.Name(), conn.RemoteAddr(), err)
+	}
+}
+
+// recordConn is used to read the ClientHello of a connection, it records
+// everything read from the connection and drops everything written.
+type recordConn struct {
+	net.Conn
+	buf bytes.Buffer
+}
+
+func (c *recordConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.buf.Write(p[:n])
+	return n, err
+}
+
+func (c *recordConn) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+// replayConn replays the recorded data before reading from the connection.
+type replayConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *replayConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// CloseWrite implements closeWriter.
+func (c *replayConn) CloseWrite() error {
+	if cw, ok := c.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
+
+// peekServerName reads the ClientHello of a TLS connection to get its
+// SNI, and returns a connection which replays the ClientHello.
+func peekServerName(conn net.Conn) (string, net.Conn, error) {
+	var hello *tls.ClientHelloInfo
+
+	rc := &recordConn{Conn: conn}
+	err := tls.Server(rc, &tls.Config{
+		GetConfigForClient: func(chi *tls.ClientHelloInfo) (*tls.Config, error) {
+			hello = chi
+			return nil, fmt.Errorf("client hello has been read")
+		},
+	}).Handshake()
+
+	if hello == nil {
+		return "", conn, fmt.Errorf("read client hello failed: %v", err)
+	}
+
+	return hello.ServerName, &replayConn{
+		Conn: conn,
+		r:    io.MultiReader(&rc.buf, conn),
+	}, nil
+}
+
+// relay copies data between the client and the upstream until both
+// directions finish, it returns the bytes received from the client and
+// the bytes sent to the client.
+func relay(client, upstream net.Conn, idleTimeout time.Duration) (received, sent int64) {
+	var lastActive atomic.Int64
+	lastActive.Store(time.Now().UnixNano())
+
+	closeAll := func() {
+		client.Close()
+		upstream.Close()
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	if idleTimeout > 0 {
+		go func() {
+			timer := time.NewTimer(idleTimeout)
+			defer timer.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-timer.C:
+					idle := time.Since(time.Unix(0, lastActive.Load()))
+					if idle >= idleTimeout {
+						closeAll()
+						return
+					}
+					timer.Reset(idleTimeout - idle)
+				}
+			}
+		}()
+	}
+
+	pipe := func(dst, src net.Conn) int64 {
+		var total int64
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := src.Read(buf)
+			if n > 0 {
+				lastActive.Store(time.Now().UnixNano())
+				if _, werr := dst.Write(buf[:n]); werr != nil {
+					closeAll()
+					return total
+				}
+				total += int64(n)
+			}
+			if err == io.EOF {
+				if cw, ok := dst.(closeWriter); ok {
+					cw.CloseWrite()
+				} else {
+					closeAll()
+				}
+				return total
+			}
+			if err != nil {
+				closeAll()
+				return total
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		sent = pipe(client, upstream)
+	}()
+	received = pipe(upstream, client)
+	wg.Wait()
+	closeAll()
+
+	return received, sent
+}
@@ -0,0 +1,411 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tcpserver
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/filters/proxies/l4proxy"
+	"github.com/megaease/easegress/v2/pkg/graceupdate"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/supervisor"
+	"github.com/megaease/easegress/v2/pkg/util/ipfilter"
+	"github.com/megaease/easegress/v2/pkg/util/limitlistener"
+	"github.com/megaease/easegress/v2/pkg/util/prometheushelper"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	stateNil     stateType = "nil"
+	stateFailed  stateType = "failed"
+	stateRunning stateType = "running"
+	stateClosed  stateType = "closed"
+
+	checkFailedTimeout = 10 * time.Second
+)
+
+var (
+	errNil = fmt.Errorf("")
+	gnet   = graceupdate.Global
+)
+
+type (
+	stateType string
+
+	eventCheckFailed struct{}
+	eventServeFailed struct {
+		roundNum uint64
+		err      error
+	}
+	eventReload struct {
+		nextSuperSpec *supervisor.Spec
+	}
+	eventClose struct{ done chan struct{} }
+
+	runtime struct {
+		superSpec *supervisor.Spec
+		spec      *Spec
+		router    atomic.Pointer[router]
+		listener  *limitlistener.LimitListener
+		roundNum  uint64
+		eventChan chan interface{}
+		metrics   *metrics
+
+		// status
+		state atomic.Value // stateType
+		err   atomic.Value // error
+
+		totalConnections    atomic.Uint64
+		activeConnections   atomic.Int64
+		rejectedConnections atomic.Uint64
+	}
+
+	// router holds the listener level settings and the routes, it is
+	// replaced as a whole when the spec is updated.
+	router struct {
+		ipFilter         *ipfilter.IPFilter
+		tlsMode          string
+		tlsConfig        *tls.Config
+		handshakeTimeout time.Duration
+		idleTimeout      time.Duration
+		routes           []*route
+	}
+
+	route struct {
+		spec     *Route
+		ipFilter *ipfilter.IPFilter
+		pool     *l4proxy.ServerPool
+	}
+
+	// Status contains all status generated by runtime, for displaying to users.
+	Status struct {
+		Health bool      `json:"health"`
+		State  stateType `json:"state"`
+		Error  string    `json:"error,omitempty"`
+
+		TotalConnections    uint64 `json:"totalConnections"`
+		ActiveConnections   int64  `json:"activeConnections"`
+		RejectedConnections uint64 `json:"rejectedConnections"`
+
+		Routes map[string]*l4proxy.ServerPoolStatus `json:"routes,omitempty"`
+	}
+
+	metrics struct {
+		TotalConnections    *prometheus.CounterVec
+		ActiveConnections   *prometheus.GaugeVec
+		RejectedConnections *prometheus.CounterVec
+		ReceivedBytes       *prometheus.CounterVec
+		SentBytes           *prometheus.CounterVec
+		ConnectionDuration  prometheus.ObserverVec
+	}
+)
+
+func newRuntime(superSpec *supervisor.Spec) *runtime {
+	r := &runtime{
+		superSpec: superSpec,
+		eventChan: make(chan interface{}, 10),
+	}
+	r.metrics = r.newMetrics(superSpec.Name())
+	r.setError(errNil)
+	r.setState(stateNil)
+	go r.fsm()
+	go r.checkFailed(checkFailedTimeout)
+	return r
+}
+
+// Close closes runtime.
+func (r *runtime) Close() {
+	done := make(chan struct{})
+	r.eventChan <- &eventClose{done: done}
+	<-done
+}
+
+// Status is the wrapper of runtime's Status.
+func (r *runtime) Status() *Status {
+	err := r.getError()
+	status := &Status{
+		Health:              err.Error() == errNil.Error(),
+		Error:               err.Error(),
+		State:               r.getState(),
+		TotalConnections:    r.totalConnections.Load(),
+		ActiveConnections:   r.activeConnections.Load(),
+		RejectedConnections: r.rejectedConnections.Load(),
+	}
+
+	if rt := r.router.Load(); rt != nil {
+		status.Routes = make(map[string]*l4proxy.ServerPoolStatus, len(rt.routes))
+		for _, route := range rt.routes {
+			status.Routes[route.spec.Name] = route.pool.Status()
+		}
+	}
+
+	return status
+}
+
+// FSM is the finite-state-machine for the runtime.
+func (r *runtime) fsm() {
+	for e := range r.eventChan {
+		switch e := e.(type) {
+		case *eventCheckFailed:
+			r.handleEventCheckFailed(e)
+		case *eventServeFailed:
+			r.handleEventServeFailed(e)
+		case *eventReload:
+			r.handleEventReload(e)
+		case *eventClose:
+			r.handleEventClose(e)
+			// NOTE: We don't close r.eventChan,
+			// in case of panic of any other goroutines
+			// to send event to it later.
+			return
+		default:
+			logger.Errorf("BUG: unknown event: %T\n", e)
+		}
+	}
+}
+
+func (r *runtime) reload(nextSuperSpec *supervisor.Spec) {
+	r.superSpec = nextSuperSpec
+	nextSpec := nextSuperSpec.ObjectSpec().(*Spec)
+
+	if prev := r.router.Swap(r.newRouter(nextSpec)); prev != nil {
+		prev.close()
+	}
+
+	// r.listener is not created just after the process started and the config load for the first time.
+	if r.listener != nil {
+		r.listener.SetMaxConnection(nextSpec.MaxConnections)
+	}
+
+	if r.spec == nil {
+		r.spec = nextSpec
+		r.startServer()
+		return
+	}
+
+	restart := r.spec.Address != nextSpec.Address || r.spec.Port != nextSpec.Port
+	r.spec = nextSpec
+	if restart || r.getState() != stateRunning {
+		r.closeServer()
+		r.startServer()
+	}
+}
+
+func (r *runtime) newRouter(spec *Spec) *router {
+	rt := &router{
+		ipFilter:         ipfilter.New(spec.IPFilter),
+		tlsMode:          spec.TLSMode,
+		handshakeTimeout: spec.handshakeTimeout(),
+		idleTimeout:      spec.idleTimeout(),
+	}
+
+	if spec.TLSMode == TLSModeTerminate {
+		// NOTE: the spec has been validated, so the error is ignored.
+		rt.tlsConfig, _ = spec.tlsConfig()
+	}
+
+	super := r.superSpec.Super()
+	for _, routeSpec := range spec.Routes {
+		name := fmt.Sprintf("%s/%s", r.superSpec.Name(), routeSpec.Name)
+		rt.routes = append(rt.routes, &route{
+			spec:     routeSpec,
+			ipFilter: ipfilter.New(routeSpec.IPFilter),
+			pool:     l4proxy.NewServerPool(super, routeSpec.Pool, name),
+		})
+	}
+
+	return rt
+}
+
+// match returns the first route matches the server name.
+func (rt *router) match(serverName string) *route {
+	for _, route := range rt.routes {
+		if route.spec.matchSNI(serverName) {
+			return route
+		}
+	}
+	return nil
+}
+
+func (rt *router) close() {
+	for _, route := range rt.routes {
+		route.pool.Close()
+	}
+}
+
+func (r *runtime) setState(state stateType) {
+	r.state.Store(state)
+}
+
+func (r *runtime) getState() stateType {
+	return r.state.Load().(stateType)
+}
+
+func (r *runtime) setError(err error) {
+	if err == nil {
+		r.err.Store(errNil)
+	} else {
+		// NOTE: For type safe.
+		r.err.Store(fmt.Errorf("%v", err))
+	}
+}
+
+func (r *runtime) getError() error {
+	err := r.err.Load()
+	if err == nil {
+		return errNil
+	}
+	return err.(error)
+}
+
+func (r *runtime) startServer() {
+	curRound := atomic.AddUint64(&r.roundNum, 1)
+
+	addr := fmt.Sprintf("%s:%d", r.spec.Address, r.spec.Port)
+	ln, err := gnet.Listen("tcp", addr)
+	if err != nil {
+		r.setState(stateFailed)
+		r.setError(err)
+		return
+	}
+
+	r.setError(errNil)
+	r.setState(stateRunning)
+
+	listener := limitlistener.NewLimitListener(ln, r.spec.MaxConnections)
+	r.listener = listener
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err == nil {
+				go r.handleConn(conn)
+				continue
+			}
+
+			if !errors.Is(err, net.ErrClosed) {
+				r.eventChan <- &eventServeFailed{
+					err:      err,
+					roundNum: curRound,
+				}
+			}
+			return
+		}
+	}()
+}
+
+func (r *runtime) closeServer() {
+	if r.listener == nil {
+		return
+	}
+	if err := r.listener.Close(); err != nil {
+		logger.Warnf("close listener of %s failed: %v", r.superSpec.Name(), err)
+	}
+	r.listener = nil
+}
+
+func (r *runtime) checkFailed(timeout time.Duration) {
+	ticker := time.NewTicker(timeout)
+	for range ticker.C {
+		state := r.getState()
+		if state == stateFailed {
+			r.eventChan <- &eventCheckFailed{}
+		} else if state == stateClosed {
+			ticker.Stop()
+			return
+		}
+	}
+}
+
+func (r *runtime) handleEventCheckFailed(e *eventCheckFailed) {
+	if r.getState() == stateFailed {
+		r.startServer()
+	}
+}
+
+func (r *runtime) handleEventServeFailed(e *eventServeFailed) {
+	if atomic.LoadUint64(&r.roundNum) > e.roundNum {
+		return
+	}
+	r.setState(stateFailed)
+	r.setError(e.err)
+}
+
+func (r *runtime) handleEventReload(e *eventReload) {
+	r.reload(e.nextSuperSpec)
+}
+
+func (r *runtime) handleEventClose(e *eventClose) {
+	r.setState(stateClosed)
+	r.setError(errNil)
+	r.closeServer()
+	if rt := r.router.Swap(nil); rt != nil {
+		rt.close()
+	}
+	close(e.done)
+}
+
+// newMetrics creates the metrics of TCPServer.
+func (r *runtime) newMetrics(name string) *metrics {
+	commonLabels := prometheus.Labels{
+		"tcpServerName": name,
+		"kind":          Kind,
+		"clusterName":   r.superSpec.Super().Options().ClusterName,
+		"clusterRole":   r.superSpec.Super().Options().ClusterRole,
+		"instanceName":  r.superSpec.Super().Options().Name,
+	}
+	tcpserverLabels := []string{
+		"clusterName", "clusterRole", "instanceName",
+		"tcpServerName", "kind", "route",
+	}
+
+	return &metrics{
+		TotalConnections: prometheushelper.NewCounter(
+			"tcpserver_total_connections",
+			"the total count of proxied connections",
+			tcpserverLabels).MustCurryWith(commonLabels),
+		ActiveConnections: prometheushelper.NewGauge(
+			"tcpserver_active_connections",
+			"the count of active proxied connections",
+			tcpserverLabels).MustCurryWith(commonLabels),
+		RejectedConnections: prometheushelper.NewCounter(
+			"tcpserver_rejected_connections",
+			"the total count of rejected connections",
+			append(tcpserverLabels, "reason")).MustCurryWith(commonLabels),
+		ReceivedBytes: prometheushelper.NewCounter(
+			"tcpserver_received_bytes",
+			"the total bytes received from clients",
+			tcpserverLabels).MustCurryWith(commonLabels),
+		SentBytes: prometheushelper.NewCounter(
+			"tcpserver_sent_bytes",
+			"the total bytes sent to clients",
+			tcpserverLabels).MustCurryWith(commonLabels),
+		ConnectionDuration: prometheushelper.NewHistogram(
+			prometheus.HistogramOpts{
+				Name:    "tcpserver_connection_duration",
+				Help:    "the duration of proxied connections in milliseconds",
+				Buckets: prometheushelper.DefaultDurationBuckets(),
+			},
+			tcpserverLabels).MustCurryWith(commonLabels),
+	}
+}
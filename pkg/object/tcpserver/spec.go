@@ -0,0 +1,219 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tcpserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/filters/proxies/l4proxy"
+	"github.com/megaease/easegress/v2/pkg/util/ipfilter"
+)
+
+const (
+	// TLSModeTerminate terminates TLS connections in Easegress and
+	// forwards the decrypted traffic to backends.
+	TLSModeTerminate = "terminate"
+	// TLSModePassthrough forwards TLS connections to backends as is,
+	// only the ClientHello is inspected to get the SNI.
+	TLSModePassthrough = "passthrough"
+
+	defaultHandshakeTimeout = 10 * time.Second
+)
+
+type (
+	// Spec describes the TCPServer.
+	Spec struct {
+		Address        string `json:"address,omitempty"`
+		Port           uint16 `json:"port" jsonschema:"required,minimum=1"`
+		MaxConnections uint32 `json:"maxConnections,omitempty" jsonschema:"minimum=1"`
+
+		// IdleTimeout closes connections which have no data transferred
+		// in either direction for the duration, zero means no timeout.
+		IdleTimeout string `json:"idleTimeout,omitempty" jsonschema:"format=duration"`
+
+		TLSMode          string `json:"tlsMode,omitempty" jsonschema:"enum=,enum=terminate,enum=passthrough"`
+		HandshakeTimeout string `json:"handshakeTimeout,omitempty" jsonschema:"format=duration"`
+		CaCertBase64     string `json:"caCertBase64,omitempty" jsonschema:"format=base64"`
+
+		// Certs saved as map, key is domain name, value is cert
+		Certs map[string]string `json:"certs,omitempty"`
+		// Keys saved as map, key is domain name, value is secret
+		Keys map[string]string `json:"keys,omitempty"`
+
+		IPFilter *ipfilter.Spec `json:"ipFilter,omitempty"`
+		Routes   []*Route       `json:"routes" jsonschema:"required"`
+	}
+
+	// Route routes connections to a server pool. Routes are checked in
+	// order, and the first one matches the SNI of the connection is used,
+	// a route without SNI matches all connections.
+	Route struct {
+		Name string `json:"name" jsonschema:"required"`
+		// SNI is the server names of the route, a name could be a wildcard
+		// like *.example.com, which matches exactly one extra label.
+		SNI      []string                `json:"sni,omitempty"`
+		IPFilter *ipfilter.Spec          `json:"ipFilter,omitempty"`
+		Pool     *l4proxy.ServerPoolSpec `json:"pool" jsonschema:"required"`
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	if spec.IdleTimeout != "" {
+		if _, err := time.ParseDuration(spec.IdleTimeout); err != nil {
+			return fmt.Errorf("invalid idleTimeout: %v", err)
+		}
+	}
+
+	switch spec.TLSMode {
+	case "", TLSModePassthrough:
+	case TLSModeTerminate:
+		if _, err := spec.tlsConfig(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown tlsMode: %s", spec.TLSMode)
+	}
+
+	if spec.HandshakeTimeout != "" {
+		if _, err := time.ParseDuration(spec.HandshakeTimeout); err != nil {
+			return fmt.Errorf("invalid handshakeTimeout: %v", err)
+		}
+	}
+
+	if len(spec.Routes) == 0 {
+		return fmt.Errorf("routes is empty")
+	}
+
+	names := map[string]struct{}{}
+	for _, route := range spec.Routes {
+		if _, ok := names[route.Name]; ok {
+			return fmt.Errorf("route %s is duplicated", route.Name)
+		}
+		names[route.Name] = struct{}{}
+
+		if len(route.SNI) > 0 && spec.TLSMode == "" {
+			return fmt.Errorf("route %s: sni requires tlsMode", route.Name)
+		}
+		for _, sni := range route.SNI {
+			if sni == "" || strings.Contains(strings.TrimPrefix(sni, "*."), "*") {
+				return fmt.Errorf("route %s: invalid sni %q", route.Name, sni)
+			}
+		}
+
+		if route.Pool == nil {
+			return fmt.Errorf("route %s: pool is required", route.Name)
+		}
+		if err := route.Pool.Validate(); err != nil {
+			return fmt.Errorf("route %s: %v", route.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (spec *Spec) handshakeTimeout() time.Duration {
+	if spec.HandshakeTimeout == "" {
+		return defaultHandshakeTimeout
+	}
+	d, _ := time.ParseDuration(spec.HandshakeTimeout)
+	return d
+}
+
+func (spec *Spec) idleTimeout() time.Duration {
+	d, _ := time.ParseDuration(spec.IdleTimeout)
+	return d
+}
+
+func tryDecodeBase64Pem(pem string) []byte {
+	// The pem could in base64 encoding or plain text. It starts with '-' if it is
+	// in plain text, and '-' is not a valid character in standard base64 encoding.
+	// We first try to decode it as base64, and fallback to plain text if failed.
+	d, err := base64.StdEncoding.DecodeString(pem)
+	if err == nil {
+		return d
+	}
+	return []byte(pem)
+}
+
+func (spec *Spec) tlsConfig() (*tls.Config, error) {
+	var certificates []tls.Certificate
+
+	for k, v := range spec.Certs {
+		secret, exists := spec.Keys[k]
+		if !exists {
+			return nil, fmt.Errorf("certs %s hasn't secret corresponded to it", k)
+		}
+
+		certPem := tryDecodeBase64Pem(v)
+		keyPem := tryDecodeBase64Pem(secret)
+		cert, err := tls.X509KeyPair(certPem, keyPem)
+		if err != nil {
+			return nil, fmt.Errorf("generate x509 key pair for %s failed: %s ", k, err)
+		}
+		certificates = append(certificates, cert)
+	}
+
+	if len(certificates) == 0 {
+		return nil, fmt.Errorf("none valid certs and secret")
+	}
+
+	tlsConf := &tls.Config{Certificates: certificates}
+
+	// if caCertBase64 configuration is provided, should enable tls.ClientAuth and
+	// add the root cert
+	if len(spec.CaCertBase64) != 0 {
+		rootCertPem, _ := base64.StdEncoding.DecodeString(spec.CaCertBase64)
+		certPool := x509.NewCertPool()
+		certPool.AppendCertsFromPEM(rootCertPem)
+
+		tlsConf.ClientAuth = tls.RequireAndVerifyClientCert
+		tlsConf.ClientCAs = certPool
+	}
+
+	return tlsConf, nil
+}
+
+// matchSNI returns whether the route matches the server name.
+func (route *Route) matchSNI(serverName string) bool {
+	if len(route.SNI) == 0 {
+		return true
+	}
+
+	serverName = strings.ToLower(strings.TrimSuffix(serverName, "."))
+	for _, sni := range route.SNI {
+		sni = strings.ToLower(sni)
+		if sni == serverName {
+			return true
+		}
+		if !strings.HasPrefix(sni, "*.") {
+			continue
+		}
+		idx := strings.IndexByte(serverName, '.')
+		if idx > 0 && serverName[idx:] == sni[1:] {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,131 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tcpserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/filters/proxies/l4proxy"
+	"github.com/stretchr/testify/assert"
+)
+
+func genCertPem(t *testing.T) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com", "*.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	return string(certPem), string(keyPem)
+}
+
+func newPoolSpec(urls ...string) *l4proxy.ServerPoolSpec {
+	spec := &l4proxy.ServerPoolSpec{}
+	for _, u := range urls {
+		spec.Servers = append(spec.Servers, &l4proxy.Server{URL: u})
+	}
+	return spec
+}
+
+func TestSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &Spec{Port: 10080}
+	assert.Error(spec.Validate())
+
+	spec.Routes = []*Route{{Name: "r1", Pool: newPoolSpec("tcp://127.0.0.1:5432")}}
+	assert.NoError(spec.Validate())
+
+	spec.IdleTimeout = "abc"
+	assert.Error(spec.Validate())
+	spec.IdleTimeout = "1m"
+	assert.NoError(spec.Validate())
+
+	spec.Routes = append(spec.Routes, &Route{Name: "r1", Pool: newPoolSpec("tcp://127.0.0.1:5433")})
+	assert.Error(spec.Validate())
+	spec.Routes[1].Name = "r2"
+	assert.NoError(spec.Validate())
+
+	spec.Routes[1].Pool = nil
+	assert.Error(spec.Validate())
+	spec.Routes[1].Pool = newPoolSpec("tcp://127.0.0.1")
+	assert.Error(spec.Validate())
+	spec.Routes[1].Pool = newPoolSpec("tcp://127.0.0.1:5433")
+
+	spec.Routes[0].SNI = []string{"db.example.com"}
+	assert.Error(spec.Validate())
+
+	spec.TLSMode = TLSModePassthrough
+	assert.NoError(spec.Validate())
+	spec.Routes[0].SNI = []string{"db.*.com"}
+	assert.Error(spec.Validate())
+	spec.Routes[0].SNI = []string{"*.example.com"}
+	assert.NoError(spec.Validate())
+
+	spec.HandshakeTimeout = "abc"
+	assert.Error(spec.Validate())
+	spec.HandshakeTimeout = ""
+
+	spec.TLSMode = TLSModeTerminate
+	assert.Error(spec.Validate())
+
+	cert, key := genCertPem(t)
+	spec.Certs = map[string]string{"example.com": cert}
+	assert.Error(spec.Validate())
+	spec.Keys = map[string]string{"example.com": key}
+	assert.NoError(spec.Validate())
+
+	spec.TLSMode = "unknown"
+	assert.Error(spec.Validate())
+}
+
+func TestMatchSNI(t *testing.T) {
+	assert := assert.New(t)
+
+	route := &Route{}
+	assert.True(route.matchSNI(""))
+	assert.True(route.matchSNI("example.com"))
+
+	route.SNI = []string{"db.example.com", "*.example.org"}
+	assert.False(route.matchSNI(""))
+	assert.True(route.matchSNI("db.example.com"))
+	assert.True(route.matchSNI("DB.Example.com."))
+	assert.False(route.matchSNI("cache.example.com"))
+	assert.True(route.matchSNI("a.example.org"))
+	assert.False(route.matchSNI("a.b.example.org"))
+	assert.False(route.matchSNI("example.org"))
+}
@@ -0,0 +1,98 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package tcpserver implements the TCPServer.
+package tcpserver
+
+import (
+	"strings"
+
+	"github.com/megaease/easegress/v2/pkg/api"
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/supervisor"
+)
+
+const (
+	// Category is the category of TCPServer.
+	Category = supervisor.CategoryTrafficGate
+
+	// Kind is the kind of TCPServer.
+	Kind = "TCPServer"
+)
+
+func init() {
+	supervisor.Register(&TCPServer{})
+	api.RegisterObject(&api.APIResource{
+		Category: Category,
+		Kind:     Kind,
+		Name:     strings.ToLower(Kind),
+		Aliases:  []string{"tcp"},
+	})
+}
+
+// TCPServer is a layer 4 TrafficGate which proxies TCP connections to
+// server pools, with optional TLS termination or passthrough.
+type TCPServer struct {
+	runtime *runtime
+}
+
+// Category returns the category of TCPServer.
+func (ts *TCPServer) Category() supervisor.ObjectCategory {
+	return Category
+}
+
+// Kind returns the kind of TCPServer.
+func (ts *TCPServer) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of TCPServer.
+func (ts *TCPServer) DefaultSpec() interface{} {
+	return &Spec{
+		MaxConnections: 10240,
+	}
+}
+
+// Init initializes TCPServer.
+func (ts *TCPServer) Init(superSpec *supervisor.Spec, muxMapper context.MuxMapper) {
+	ts.runtime = newRuntime(superSpec)
+
+	ts.runtime.eventChan <- &eventReload{
+		nextSuperSpec: superSpec,
+	}
+}
+
+// Inherit inherits previous generation of TCPServer.
+func (ts *TCPServer) Inherit(superSpec *supervisor.Spec, previousGeneration supervisor.Object, muxMapper context.MuxMapper) {
+	ts.runtime = previousGeneration.(*TCPServer).runtime
+
+	ts.runtime.eventChan <- &eventReload{
+		nextSuperSpec: superSpec,
+	}
+}
+
+// Status returns the status of TCPServer.
+func (ts *TCPServer) Status() *supervisor.Status {
+	return &supervisor.Status{
+		ObjectStatus: ts.runtime.Status(),
+	}
+}
+
+// Close closes TCPServer.
+func (ts *TCPServer) Close() {
+	ts.runtime.Close()
+}
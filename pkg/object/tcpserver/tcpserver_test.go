@@ -0,0 +1,270 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tcpserver
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/option"
+	"github.com/megaease/easegress/v2/pkg/supervisor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+// startBackend starts a backend which replies the first chunk it read
+// with its name as prefix.
+func startBackend(t *testing.T, name string, tlsConfig *tls.Config) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	if tlsConfig != nil {
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 1024)
+				n, err := conn.Read(buf)
+				if err != nil {
+					return
+				}
+				conn.Write([]byte(name + ":" + string(buf[:n])))
+			}()
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func freePort(t *testing.T) int {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func request(dial func() (net.Conn, error), msg string) (string, error) {
+	conn, err := dial()
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	if _, err = conn.Write([]byte(msg)); err != nil {
+		return "", err
+	}
+	data, err := io.ReadAll(conn)
+	return string(data), err
+}
+
+func newTCPServer(t *testing.T, yamlConfig string) *TCPServer {
+	super := supervisor.NewMock(option.New(), nil, nil, nil, false, nil, nil)
+	superSpec, err := super.NewSpec(yamlConfig)
+	assert.NoError(t, err)
+
+	ts := &TCPServer{}
+	ts.Init(superSpec, nil)
+	t.Cleanup(ts.Close)
+
+	assert.Eventually(t, func() bool {
+		return ts.Status().ObjectStatus.(*Status).State == stateRunning
+	}, 3*time.Second, 50*time.Millisecond)
+	return ts
+}
+
+func TestTCPServerPlain(t *testing.T) {
+	assert := assert.New(t)
+
+	addrA := startBackend(t, "A", nil)
+	addrB := startBackend(t, "B", nil)
+	port := freePort(t)
+
+	ts := newTCPServer(t, fmt.Sprintf(`
+kind: TCPServer
+name: tcp-plain
+port: %d
+idleTimeout: 1s
+routes:
+- name: default
+  pool:
+    servers:
+    - url: tcp://%s
+`, port, addrA))
+
+	dial := func() (net.Conn, error) {
+		return net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	}
+	resp, err := request(dial, "hello")
+	assert.NoError(err)
+	assert.Equal("A:hello", resp)
+
+	// reload with a new backend and an ip filter blocking everything.
+	super := supervisor.NewMock(option.New(), nil, nil, nil, false, nil, nil)
+	superSpec, err := super.NewSpec(fmt.Sprintf(`
+kind: TCPServer
+name: tcp-plain
+port: %d
+routes:
+- name: default
+  pool:
+    servers:
+    - url: tcp://%s
+`, port, addrB))
+	assert.NoError(err)
+	ts2 := &TCPServer{}
+	ts2.Inherit(superSpec, ts, nil)
+
+	assert.Eventually(func() bool {
+		resp, _ := request(dial, "hello")
+		return resp == "B:hello"
+	}, 3*time.Second, 50*time.Millisecond)
+
+	superSpec, err = super.NewSpec(fmt.Sprintf(`
+kind: TCPServer
+name: tcp-plain
+port: %d
+ipFilter:
+  blockByDefault: true
+routes:
+- name: default
+  pool:
+    servers:
+    - url: tcp://%s
+`, port, addrB))
+	assert.NoError(err)
+	ts3 := &TCPServer{}
+	ts3.Inherit(superSpec, ts2, nil)
+
+	assert.Eventually(func() bool {
+		resp, _ := request(dial, "hello")
+		return resp == ""
+	}, 3*time.Second, 50*time.Millisecond)
+
+	status := ts3.Status().ObjectStatus.(*Status)
+	assert.True(status.Health)
+	assert.GreaterOrEqual(status.TotalConnections, uint64(2))
+	assert.GreaterOrEqual(status.RejectedConnections, uint64(1))
+	assert.Contains(status.Routes, "default")
+}
+
+func TestTCPServerTLS(t *testing.T) {
+	assert := assert.New(t)
+
+	certPem, keyPem := genCertPem(t)
+	cert, err := tls.X509KeyPair([]byte(certPem), []byte(keyPem))
+	assert.NoError(err)
+	backendTLS := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	dialTLS := func(port int, serverName string) func() (net.Conn, error) {
+		return func() (net.Conn, error) {
+			return tls.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port), &tls.Config{
+				ServerName:         serverName,
+				InsecureSkipVerify: true,
+			})
+		}
+	}
+
+	// passthrough
+	addrA := startBackend(t, "A", backendTLS)
+	addrB := startBackend(t, "B", backendTLS)
+	port := freePort(t)
+	newTCPServer(t, fmt.Sprintf(`
+kind: TCPServer
+name: tcp-passthrough
+port: %d
+tlsMode: passthrough
+routes:
+- name: a
+  sni: [a.example.com]
+  pool:
+    servers:
+    - url: tcp://%s
+- name: b
+  pool:
+    servers:
+    - url: tcp://%s
+`, port, addrA, addrB))
+
+	resp, err := request(dialTLS(port, "a.example.com"), "hello")
+	assert.NoError(err)
+	assert.Equal("A:hello", resp)
+	resp, err = request(dialTLS(port, "b.example.com"), "hello")
+	assert.NoError(err)
+	assert.Equal("B:hello", resp)
+
+	// terminate
+	addrA = startBackend(t, "A", nil)
+	addrB = startBackend(t, "B", nil)
+	port = freePort(t)
+	ts := newTCPServer(t, fmt.Sprintf(`
+kind: TCPServer
+name: tcp-terminate
+port: %d
+tlsMode: terminate
+certs:
+  example.com: %s
+keys:
+  example.com: %s
+routes:
+- name: a
+  sni: ["*.example.com"]
+  pool:
+    servers:
+    - url: tcp://%s
+- name: b
+  sni: [example.com]
+  pool:
+    servers:
+    - url: tcp://%s
+`, port, base64.StdEncoding.EncodeToString([]byte(certPem)),
+		base64.StdEncoding.EncodeToString([]byte(keyPem)), addrA, addrB))
+
+	resp, err = request(dialTLS(port, "a.example.com"), "hello")
+	assert.NoError(err)
+	assert.Equal("A:hello", resp)
+	resp, err = request(dialTLS(port, "example.com"), "hello")
+	assert.NoError(err)
+	assert.Equal("B:hello", resp)
+
+	// no route matches
+	resp, _ = request(dialTLS(port, "example.org"), "hello")
+	assert.Empty(resp)
+	assert.Eventually(func() bool {
+		return ts.Status().ObjectStatus.(*Status).RejectedConnections == 1
+	}, 3*time.Second, 50*time.Millisecond)
+}
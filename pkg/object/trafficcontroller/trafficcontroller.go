@@ -119,6 +119,11 @@ func newNamespace(namespace string) *Namespace {
 	}
 }
 
+// Name returns the name of the namespace.
+func (ns *Namespace) Name() string {
+	return ns.namespace
+}
+
 // GetHandler gets handler within the namespace
 func (ns *Namespace) GetHandler(name string) (context.Handler, bool) {
 	entity, exists := ns.pipelines.Load(name)
@@ -0,0 +1,508 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package udpserver
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/filters/proxies/l4proxy"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/supervisor"
+	"github.com/megaease/easegress/v2/pkg/util/ipfilter"
+	"github.com/megaease/easegress/v2/pkg/util/prometheushelper"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	stateNil     stateType = "nil"
+	stateFailed  stateType = "failed"
+	stateRunning stateType = "running"
+	stateClosed  stateType = "closed"
+
+	checkFailedTimeout = 10 * time.Second
+
+	// maxPacketSize is the max size of a UDP packet.
+	maxPacketSize = 64 * 1024
+
+	dropReasonIPFilter = "ipFilter"
+	dropReasonUpstream = "upstream"
+)
+
+var errNil = fmt.Errorf("")
+
+type (
+	stateType string
+
+	eventCheckFailed struct{}
+	eventServeFailed struct {
+		roundNum uint64
+		err      error
+	}
+	eventReload struct {
+		nextSuperSpec *supervisor.Spec
+	}
+	eventClose struct{ done chan struct{} }
+
+	runtime struct {
+		superSpec  *supervisor.Spec
+		spec       *Spec
+		router     atomic.Pointer[router]
+		packetConn net.PacketConn
+		roundNum   uint64
+		eventChan  chan interface{}
+		metrics    *metrics
+
+		// sessions is keyed by the address of clients.
+		sessions sync.Map
+
+		// status
+		state atomic.Value // stateType
+		err   atomic.Value // error
+
+		totalSessions  atomic.Uint64
+		activeSessions atomic.Int64
+		droppedPackets atomic.Uint64
+	}
+
+	// router holds the settings could be updated without restarting the
+	// server, it is replaced as a whole when the spec is updated.
+	router struct {
+		ipFilter       *ipfilter.IPFilter
+		sessionTimeout time.Duration
+		pool           *l4proxy.ServerPool
+	}
+
+	// session is the session of a client, it forwards packets between the
+	// client and the server chosen for it.
+	session struct {
+		clientAddr net.Addr
+		upstream   *l4proxy.Conn
+		timeout    time.Duration
+		lastActive atomic.Int64
+
+		// lock protects closed, so that a packet is never written to a
+		// session which has been removed by the idle check.
+		lock   sync.Mutex
+		closed bool
+	}
+
+	// Status contains all status generated by runtime, for displaying to users.
+	Status struct {
+		Health bool      `json:"health"`
+		State  stateType `json:"state"`
+		Error  string    `json:"error,omitempty"`
+
+		TotalSessions  uint64 `json:"totalSessions"`
+		ActiveSessions int64  `json:"activeSessions"`
+		DroppedPackets uint64 `json:"droppedPackets"`
+
+		Pool *l4proxy.ServerPoolStatus `json:"pool,omitempty"`
+	}
+
+	metrics struct {
+		TotalSessions   *prometheus.CounterVec
+		ActiveSessions  *prometheus.GaugeVec
+		ReceivedPackets *prometheus.CounterVec
+		ReceivedBytes   *prometheus.CounterVec
+		SentPackets     *prometheus.CounterVec
+		SentBytes       *prometheus.CounterVec
+		DroppedPackets  *prometheus.CounterVec
+	}
+)
+
+func newRuntime(superSpec *supervisor.Spec) *runtime {
+	r := &runtime{
+		superSpec: superSpec,
+		eventChan: make(chan interface{}, 10),
+	}
+	r.metrics = r.newMetrics(superSpec.Name())
+	r.setError(errNil)
+	r.setState(stateNil)
+	go r.fsm()
+	go r.checkFailed(checkFailedTimeout)
+	return r
+}
+
+// Close closes runtime.
+func (r *runtime) Close() {
+	done := make(chan struct{})
+	r.eventChan <- &eventClose{done: done}
+	<-done
+}
+
+// Status is the wrapper of runtime's Status.
+func (r *runtime) Status() *Status {
+	err := r.getError()
+	status := &Status{
+		Health:         err.Error() == errNil.Error(),
+		Error:          err.Error(),
+		State:          r.getState(),
+		TotalSessions:  r.totalSessions.Load(),
+		ActiveSessions: r.activeSessions.Load(),
+		DroppedPackets: r.droppedPackets.Load(),
+	}
+
+	if rt := r.router.Load(); rt != nil {
+		status.Pool = rt.pool.Status()
+	}
+
+	return status
+}
+
+// FSM is the finite-state-machine for the runtime.
+func (r *runtime) fsm() {
+	for e := range r.eventChan {
+		switch e := e.(type) {
+		case *eventCheckFailed:
+			r.handleEventCheckFailed(e)
+		case *eventServeFailed:
+			r.handleEventServeFailed(e)
+		case *eventReload:
+			r.handleEventReload(e)
+		case *eventClose:
+			r.handleEventClose(e)
+			// NOTE: We don't close r.eventChan,
+			// in case of panic of any other goroutines
+			// to send event to it later.
+			return
+		default:
+			logger.Errorf("BUG: unknown event: %T\n", e)
+		}
+	}
+}
+
+func (r *runtime) reload(nextSuperSpec *supervisor.Spec) {
+	r.superSpec = nextSuperSpec
+	nextSpec := nextSuperSpec.ObjectSpec().(*Spec)
+
+	rt := &router{
+		ipFilter:       ipfilter.New(nextSpec.IPFilter),
+		sessionTimeout: nextSpec.sessionTimeout(),
+		pool:           l4proxy.NewServerPool(nextSuperSpec.Super(), nextSpec.Pool, nextSuperSpec.Name()),
+	}
+	if prev := r.router.Swap(rt); prev != nil {
+		prev.pool.Close()
+	}
+
+	if r.spec == nil {
+		r.spec = nextSpec
+		r.startServer()
+		return
+	}
+
+	restart := r.spec.Address != nextSpec.Address || r.spec.Port != nextSpec.Port
+	r.spec = nextSpec
+	if restart || r.getState() != stateRunning {
+		r.closeServer()
+		r.startServer()
+	}
+}
+
+func (r *runtime) setState(state stateType) {
+	r.state.Store(state)
+}
+
+func (r *runtime) getState() stateType {
+	return r.state.Load().(stateType)
+}
+
+func (r *runtime) setError(err error) {
+	if err == nil {
+		r.err.Store(errNil)
+	} else {
+		// NOTE: For type safe.
+		r.err.Store(fmt.Errorf("%v", err))
+	}
+}
+
+func (r *runtime) getError() error {
+	err := r.err.Load()
+	if err == nil {
+		return errNil
+	}
+	return err.(error)
+}
+
+func (r *runtime) startServer() {
+	curRound := atomic.AddUint64(&r.roundNum, 1)
+
+	addr := fmt.Sprintf("%s:%d", r.spec.Address, r.spec.Port)
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		r.setState(stateFailed)
+		r.setError(err)
+		return
+	}
+
+	r.setError(errNil)
+	r.setState(stateRunning)
+	r.packetConn = pc
+
+	go func() {
+		buf := make([]byte, maxPacketSize)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if n > 0 {
+				r.handlePacket(pc, addr, buf[:n])
+			}
+			if err == nil {
+				continue
+			}
+
+			if !errors.Is(err, net.ErrClosed) {
+				r.eventChan <- &eventServeFailed{
+					err:      err,
+					roundNum: curRound,
+				}
+			}
+			return
+		}
+	}()
+}
+
+func (r *runtime) closeServer() {
+	if r.packetConn == nil {
+		return
+	}
+	if err := r.packetConn.Close(); err != nil {
+		logger.Warnf("close packet conn of %s failed: %v", r.superSpec.Name(), err)
+	}
+	r.packetConn = nil
+}
+
+func (r *runtime) handlePacket(pc net.PacketConn, addr net.Addr, data []byte) {
+	rt := r.router.Load()
+	if rt == nil {
+		return
+	}
+
+	key := addr.String()
+	for {
+		var s *session
+		if v, ok := r.sessions.Load(key); ok {
+			s = v.(*session)
+		} else if s = r.newSession(pc, rt, key, addr); s == nil {
+			return
+		}
+
+		s.lock.Lock()
+		// the session has been closed for idle, create a new one.
+		if s.closed {
+			s.lock.Unlock()
+			continue
+		}
+		s.lastActive.Store(time.Now().UnixNano())
+		_, err := s.upstream.Write(data)
+		s.lock.Unlock()
+
+		r.metrics.ReceivedPackets.With(nil).Inc()
+		r.metrics.ReceivedBytes.With(nil).Add(float64(len(data)))
+		if err != nil {
+			r.drop(dropReasonUpstream, err)
+		}
+		return
+	}
+}
+
+// newSession creates a session for the client, it returns nil if the
+// packet is dropped.
+func (r *runtime) newSession(pc net.PacketConn, rt *router, key string, addr net.Addr) *session {
+	clientIP, _, _ := net.SplitHostPort(key)
+	if !rt.ipFilter.Allow(clientIP) {
+		r.drop(dropReasonIPFilter, nil)
+		return nil
+	}
+
+	upstream, err := rt.pool.Dial("udp", clientIP)
+	if err != nil {
+		r.drop(dropReasonUpstream, err)
+		return nil
+	}
+
+	s := &session{clientAddr: addr, upstream: upstream, timeout: rt.sessionTimeout}
+	s.lastActive.Store(time.Now().UnixNano())
+	r.sessions.Store(key, s)
+
+	r.totalSessions.Add(1)
+	r.activeSessions.Add(1)
+	r.metrics.TotalSessions.With(nil).Inc()
+	r.metrics.ActiveSessions.With(nil).Inc()
+	go r.serveSession(pc, key, s)
+	return s
+}
+
+// closeSession marks the session as closed and removes it, it returns false if
+// the session is active again, which means it should not be closed for
+// idle.
+func (r *runtime) closeSession(key string, s *session, idle bool) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if idle && time.Since(time.Unix(0, s.lastActive.Load())) < s.timeout {
+		return false
+	}
+	s.closed = true
+	r.sessions.Delete(key)
+	return true
+}
+
+// serveSession forwards packets from the server to the client until the
+// session timed out or the server is unreachable.
+func (r *runtime) serveSession(pc net.PacketConn, key string, s *session) {
+	defer func() {
+		s.upstream.Close()
+		r.activeSessions.Add(-1)
+		r.metrics.ActiveSessions.With(nil).Dec()
+	}()
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		deadline := time.Unix(0, s.lastActive.Load()).Add(s.timeout)
+		s.upstream.SetReadDeadline(deadline)
+
+		n, err := s.upstream.Read(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			// the client may have sent packets after the deadline was set.
+			if !r.closeSession(key, s, true) {
+				continue
+			}
+			return
+		}
+		if err != nil {
+			r.closeSession(key, s, false)
+			return
+		}
+
+		s.lastActive.Store(time.Now().UnixNano())
+		if _, err := pc.WriteTo(buf[:n], s.clientAddr); err != nil {
+			r.closeSession(key, s, false)
+			return
+		}
+		r.metrics.SentPackets.With(nil).Inc()
+		r.metrics.SentBytes.With(nil).Add(float64(n))
+	}
+}
+
+func (r *runtime) drop(reason string, err error) {
+	r.droppedPackets.Add(1)
+	r.metrics.DroppedPackets.With(prometheus.Labels{"reason": reason}).Inc()
+	if err != nil {
+		logger.Debugf("%s: drop packet: %v", r.superSpec.Name(), err)
+	}
+}
+
+func (r *runtime) closeSessions() {
+	r.sessions.Range(func(key, value interface{}) bool {
+		value.(*session).upstream.Close()
+		return true
+	})
+}
+
+func (r *runtime) checkFailed(timeout time.Duration) {
+	ticker := time.NewTicker(timeout)
+	for range ticker.C {
+		state := r.getState()
+		if state == stateFailed {
+			r.eventChan <- &eventCheckFailed{}
+		} else if state == stateClosed {
+			ticker.Stop()
+			return
+		}
+	}
+}
+
+func (r *runtime) handleEventCheckFailed(e *eventCheckFailed) {
+	if r.getState() == stateFailed {
+		r.startServer()
+	}
+}
+
+func (r *runtime) handleEventServeFailed(e *eventServeFailed) {
+	if atomic.LoadUint64(&r.roundNum) > e.roundNum {
+		return
+	}
+	r.setState(stateFailed)
+	r.setError(e.err)
+}
+
+func (r *runtime) handleEventReload(e *eventReload) {
+	r.reload(e.nextSuperSpec)
+}
+
+func (r *runtime) handleEventClose(e *eventClose) {
+	r.setState(stateClosed)
+	r.setError(errNil)
+	r.closeServer()
+	r.closeSessions()
+	if rt := r.router.Swap(nil); rt != nil {
+		rt.pool.Close()
+	}
+	close(e.done)
+}
+
+// newMetrics creates the metrics of UDPServer.
+func (r *runtime) newMetrics(name string) *metrics {
+	commonLabels := prometheus.Labels{
+		"udpServerName": name,
+		"kind":          Kind,
+		"clusterName":   r.superSpec.Super().Options().ClusterName,
+		"clusterRole":   r.superSpec.Super().Options().ClusterRole,
+		"instanceName":  r.superSpec.Super().Options().Name,
+	}
+	udpserverLabels := []string{
+		"clusterName", "clusterRole", "instanceName",
+		"udpServerName", "kind",
+	}
+
+	return &metrics{
+		TotalSessions: prometheushelper.NewCounter(
+			"udpserver_total_sessions",
+			"the total count of client sessions",
+			udpserverLabels).MustCurryWith(commonLabels),
+		ActiveSessions: prometheushelper.NewGauge(
+			"udpserver_active_sessions",
+			"the count of active client sessions",
+			udpserverLabels).MustCurryWith(commonLabels),
+		ReceivedPackets: prometheushelper.NewCounter(
+			"udpserver_received_packets",
+			"the total count of packets received from clients",
+			udpserverLabels).MustCurryWith(commonLabels),
+		ReceivedBytes: prometheushelper.NewCounter(
+			"udpserver_received_bytes",
+			"the total bytes received from clients",
+			udpserverLabels).MustCurryWith(commonLabels),
+		SentPackets: prometheushelper.NewCounter(
+			"udpserver_sent_packets",
+			"the total count of packets sent to clients",
+			udpserverLabels).MustCurryWith(commonLabels),
+		SentBytes: prometheushelper.NewCounter(
+			"udpserver_sent_bytes",
+			"the total bytes sent to clients",
+			udpserverLabels).MustCurryWith(commonLabels),
+		DroppedPackets: prometheushelper.NewCounter(
+			"udpserver_dropped_packets",
+			"the total count of dropped packets",
+			append(udpserverLabels, "reason")).MustCurryWith(commonLabels),
+	}
+}
@@ -0,0 +1,78 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package udpserver
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/filters/proxies/l4proxy"
+	"github.com/megaease/easegress/v2/pkg/util/ipfilter"
+)
+
+const defaultSessionTimeout = 60 * time.Second
+
+// Spec describes the UDPServer.
+type Spec struct {
+	Address string `json:"address,omitempty"`
+	Port    uint16 `json:"port" jsonschema:"required,minimum=1"`
+
+	// SessionTimeout is the idle timeout of the session of a client,
+	// packets from the same client address are sent to the same server
+	// until the session timed out.
+	SessionTimeout string `json:"sessionTimeout,omitempty" jsonschema:"format=duration"`
+
+	IPFilter *ipfilter.Spec          `json:"ipFilter,omitempty"`
+	Pool     *l4proxy.ServerPoolSpec `json:"pool" jsonschema:"required"`
+}
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	if spec.SessionTimeout != "" {
+		d, err := time.ParseDuration(spec.SessionTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid sessionTimeout: %v", err)
+		}
+		if d <= 0 {
+			return fmt.Errorf("sessionTimeout must be positive")
+		}
+	}
+
+	if spec.Pool == nil {
+		return fmt.Errorf("pool is required")
+	}
+	if err := spec.Pool.Validate(); err != nil {
+		return err
+	}
+
+	// the default TCP connect check of layer 4 pools doesn't make sense
+	// for UDP servers.
+	if hc := spec.Pool.HealthCheck; hc != nil && hc.IsEmpty() {
+		return fmt.Errorf("health check of UDP servers requires tcp or grpc probe")
+	}
+
+	return nil
+}
+
+func (spec *Spec) sessionTimeout() time.Duration {
+	if spec.SessionTimeout == "" {
+		return defaultSessionTimeout
+	}
+	d, _ := time.ParseDuration(spec.SessionTimeout)
+	return d
+}
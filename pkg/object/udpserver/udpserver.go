@@ -0,0 +1,98 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package udpserver implements the UDPServer.
+package udpserver
+
+import (
+	"strings"
+
+	"github.com/megaease/easegress/v2/pkg/api"
+	"github.com/megaease/easegress/v2/pkg/context"
+	"github.com/megaease/easegress/v2/pkg/supervisor"
+)
+
+const (
+	// Category is the category of UDPServer.
+	Category = supervisor.CategoryTrafficGate
+
+	// Kind is the kind of UDPServer.
+	Kind = "UDPServer"
+)
+
+func init() {
+	supervisor.Register(&UDPServer{})
+	api.RegisterObject(&api.APIResource{
+		Category: Category,
+		Kind:     Kind,
+		Name:     strings.ToLower(Kind),
+		Aliases:  []string{"udp"},
+	})
+}
+
+// UDPServer is a layer 4 TrafficGate which proxies UDP datagrams to
+// a server pool.
+type UDPServer struct {
+	runtime *runtime
+}
+
+// Category returns the category of UDPServer.
+func (us *UDPServer) Category() supervisor.ObjectCategory {
+	return Category
+}
+
+// Kind returns the kind of UDPServer.
+func (us *UDPServer) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of UDPServer.
+func (us *UDPServer) DefaultSpec() interface{} {
+	return &Spec{
+		SessionTimeout: "60s",
+	}
+}
+
+// Init initializes UDPServer.
+func (us *UDPServer) Init(superSpec *supervisor.Spec, muxMapper context.MuxMapper) {
+	us.runtime = newRuntime(superSpec)
+
+	us.runtime.eventChan <- &eventReload{
+		nextSuperSpec: superSpec,
+	}
+}
+
+// Inherit inherits previous generation of UDPServer.
+func (us *UDPServer) Inherit(superSpec *supervisor.Spec, previousGeneration supervisor.Object, muxMapper context.MuxMapper) {
+	us.runtime = previousGeneration.(*UDPServer).runtime
+
+	us.runtime.eventChan <- &eventReload{
+		nextSuperSpec: superSpec,
+	}
+}
+
+// Status returns the status of UDPServer.
+func (us *UDPServer) Status() *supervisor.Status {
+	return &supervisor.Status{
+		ObjectStatus: us.runtime.Status(),
+	}
+}
+
+// Close closes UDPServer.
+func (us *UDPServer) Close() {
+	us.runtime.Close()
+}
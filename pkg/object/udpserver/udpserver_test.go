@@ -0,0 +1,164 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package udpserver
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/v2/pkg/filters/proxies/l4proxy"
+	"github.com/megaease/easegress/v2/pkg/logger"
+	"github.com/megaease/easegress/v2/pkg/option"
+	"github.com/megaease/easegress/v2/pkg/supervisor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func TestSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &Spec{Port: 10053}
+	assert.Error(spec.Validate())
+
+	spec.Pool = &l4proxy.ServerPoolSpec{}
+	assert.Error(spec.Validate())
+
+	spec.Pool.Servers = []*l4proxy.Server{{URL: "udp://127.0.0.1:53"}}
+	assert.NoError(spec.Validate())
+
+	spec.SessionTimeout = "abc"
+	assert.Error(spec.Validate())
+	spec.SessionTimeout = "0s"
+	assert.Error(spec.Validate())
+	spec.SessionTimeout = "10s"
+	assert.NoError(spec.Validate())
+	assert.Equal(10*time.Second, spec.sessionTimeout())
+
+	spec.Pool.HealthCheck = &l4proxy.ProxyHealthCheckSpec{}
+	assert.Error(spec.Validate())
+}
+
+func TestUDPServer(t *testing.T) {
+	assert := assert.New(t)
+
+	// echo backend
+	backend, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(err)
+	defer backend.Close()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := backend.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			backend.WriteTo([]byte("echo:"+string(buf[:n])), addr)
+		}
+	}()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(err)
+	port := pc.LocalAddr().(*net.UDPAddr).Port
+	pc.Close()
+
+	super := supervisor.NewMock(option.New(), nil, nil, nil, false, nil, nil)
+	superSpec, err := super.NewSpec(fmt.Sprintf(`
+kind: UDPServer
+name: udp-test
+address: 127.0.0.1
+port: %d
+sessionTimeout: 200ms
+pool:
+  servers:
+  - url: udp://%s
+`, port, backend.LocalAddr().String()))
+	assert.NoError(err)
+
+	us := &UDPServer{}
+	us.Init(superSpec, nil)
+	defer us.Close()
+
+	assert.Eventually(func() bool {
+		return us.Status().ObjectStatus.(*Status).State == stateRunning
+	}, 3*time.Second, 50*time.Millisecond)
+
+	client, err := net.Dial("udp", fmt.Sprintf("127.0.0.1:%d", port))
+	assert.NoError(err)
+	defer client.Close()
+
+	buf := make([]byte, 1024)
+	for _, msg := range []string{"hello", "world"} {
+		_, err = client.Write([]byte(msg))
+		assert.NoError(err)
+		client.SetReadDeadline(time.Now().Add(3 * time.Second))
+		n, err := client.Read(buf)
+		assert.NoError(err)
+		assert.Equal("echo:"+msg, string(buf[:n]))
+	}
+
+	status := us.Status().ObjectStatus.(*Status)
+	assert.Equal(uint64(1), status.TotalSessions)
+	assert.Equal(int64(1), status.ActiveSessions)
+	assert.NotNil(status.Pool)
+
+	// the session expires after the timeout.
+	assert.Eventually(func() bool {
+		return us.Status().ObjectStatus.(*Status).ActiveSessions == 0
+	}, 3*time.Second, 50*time.Millisecond)
+
+	// a new session is created for packets after the session expires.
+	_, err = client.Write([]byte("again"))
+	assert.NoError(err)
+	client.SetReadDeadline(time.Now().Add(3 * time.Second))
+	n, err := client.Read(buf)
+	assert.NoError(err)
+	assert.Equal("echo:again", string(buf[:n]))
+	assert.Equal(uint64(2), us.Status().ObjectStatus.(*Status).TotalSessions)
+	assert.Eventually(func() bool {
+		return us.Status().ObjectStatus.(*Status).ActiveSessions == 0
+	}, 3*time.Second, 50*time.Millisecond)
+
+	// packets are dropped by the ip filter.
+	superSpec, err = super.NewSpec(fmt.Sprintf(`
+kind: UDPServer
+name: udp-test
+address: 127.0.0.1
+port: %d
+ipFilter:
+  blockByDefault: true
+pool:
+  servers:
+  - url: udp://%s
+`, port, backend.LocalAddr().String()))
+	assert.NoError(err)
+	us2 := &UDPServer{}
+	us2.Inherit(superSpec, us, nil)
+
+	assert.Eventually(func() bool {
+		client.Write([]byte("hello"))
+		return us2.Status().ObjectStatus.(*Status).DroppedPackets > 0
+	}, 3*time.Second, 50*time.Millisecond)
+}
@@ -0,0 +1,84 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpcprot
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// TimeoutHeader is the name of the header which carries the timeout of a
+// gRPC call.
+const TimeoutHeader = "grpc-timeout"
+
+// maxTimeoutValue is the max value of grpc-timeout, which is at most 8
+// digits.
+const maxTimeoutValue = 100000000 - 1
+
+var timeoutUnits = []struct {
+	unit byte
+	d    time.Duration
+}{
+	{'n', time.Nanosecond},
+	{'u', time.Microsecond},
+	{'m', time.Millisecond},
+	{'S', time.Second},
+	{'M', time.Minute},
+	{'H', time.Hour},
+}
+
+// ParseTimeout parses the value of the grpc-timeout header.
+func ParseTimeout(s string) (time.Duration, error) {
+	if len(s) < 2 || len(s) > 9 {
+		return 0, fmt.Errorf("invalid grpc-timeout: %q", s)
+	}
+
+	v, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid grpc-timeout: %q", s)
+	}
+
+	unit := s[len(s)-1]
+	for _, u := range timeoutUnits {
+		if u.unit != unit {
+			continue
+		}
+		if v > math.MaxInt64/int64(u.d) {
+			return math.MaxInt64, nil
+		}
+		return time.Duration(v) * u.d, nil
+	}
+	return 0, fmt.Errorf("invalid grpc-timeout unit: %q", s)
+}
+
+// FormatTimeout formats d as the value of the grpc-timeout header, it
+// uses the smallest unit which can hold d, and rounds up d if required.
+func FormatTimeout(d time.Duration) string {
+	if d <= 0 {
+		return "0n"
+	}
+	for _, u := range timeoutUnits {
+		v := (d + u.d - 1) / u.d
+		if v <= maxTimeoutValue {
+			return strconv.FormatInt(int64(v), 10) + string(u.unit)
+		}
+	}
+	return strconv.Itoa(maxTimeoutValue) + "H"
+}
@@ -0,0 +1,68 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpcprot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	cases := map[string]time.Duration{
+		"1n":   time.Nanosecond,
+		"10u":  10 * time.Microsecond,
+		"100m": 100 * time.Millisecond,
+		"3S":   3 * time.Second,
+		"2M":   2 * time.Minute,
+		"1H":   time.Hour,
+	}
+	for s, d := range cases {
+		v, err := ParseTimeout(s)
+		assert.NoError(err)
+		assert.Equal(d, v)
+	}
+
+	for _, s := range []string{"", "1", "1x", "-1S", "123456789S", "aS"} {
+		_, err := ParseTimeout(s)
+		assert.Error(err, s)
+	}
+
+	v, err := ParseTimeout("99999999H")
+	assert.NoError(err)
+	assert.Greater(v, time.Duration(0))
+}
+
+func TestFormatTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("0n", FormatTimeout(0))
+	assert.Equal("100n", FormatTimeout(100*time.Nanosecond))
+	assert.Equal("3000000n", FormatTimeout(3*time.Millisecond))
+	assert.Equal("3000000u", FormatTimeout(3*time.Second))
+	assert.Equal("100001u", FormatTimeout(100*time.Millisecond+500))
+
+	for _, d := range []time.Duration{time.Nanosecond, time.Second, 5 * time.Minute, 100 * time.Hour} {
+		v, err := ParseTimeout(FormatTimeout(d))
+		assert.NoError(err)
+		assert.Equal(d, v)
+	}
+}
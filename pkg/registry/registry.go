@@ -36,6 +36,7 @@ import (
 	_ "github.com/megaease/easegress/v2/pkg/filters/mqttclientauth"
 	_ "github.com/megaease/easegress/v2/pkg/filters/oidcadaptor"
 	_ "github.com/megaease/easegress/v2/pkg/filters/opafilter"
+	_ "github.com/megaease/easegress/v2/pkg/filters/pipelinecall"
 	_ "github.com/megaease/easegress/v2/pkg/filters/proxies/grpcproxy"
 	_ "github.com/megaease/easegress/v2/pkg/filters/proxies/httpproxy"
 	_ "github.com/megaease/easegress/v2/pkg/filters/ratelimiter"
@@ -65,7 +66,9 @@ import (
 	_ "github.com/megaease/easegress/v2/pkg/object/nacosserviceregistry"
 	_ "github.com/megaease/easegress/v2/pkg/object/pipeline"
 	_ "github.com/megaease/easegress/v2/pkg/object/rawconfigtrafficcontroller"
+	_ "github.com/megaease/easegress/v2/pkg/object/tcpserver"
 	_ "github.com/megaease/easegress/v2/pkg/object/trafficcontroller"
+	_ "github.com/megaease/easegress/v2/pkg/object/udpserver"
 	_ "github.com/megaease/easegress/v2/pkg/object/zookeeperserviceregistry"
 
 	// Routers
@@ -0,0 +1,299 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resilience
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// AdaptiveConcurrencyAlgorithmGradient is the gradient algorithm.
+	AdaptiveConcurrencyAlgorithmGradient = "gradient"
+	// AdaptiveConcurrencyAlgorithmVegas is the vegas algorithm.
+	AdaptiveConcurrencyAlgorithmVegas = "vegas"
+)
+
+// AdaptiveConcurrencyKind is the kind of AdaptiveConcurrency.
+var AdaptiveConcurrencyKind = &Kind{
+	Name: "AdaptiveConcurrency",
+
+	DefaultPolicy: func() Policy {
+		return &AdaptiveConcurrencyPolicy{
+			AdaptiveConcurrencyRule: AdaptiveConcurrencyRule{
+				Algorithm:        AdaptiveConcurrencyAlgorithmGradient,
+				InitialLimit:     20,
+				MinLimit:         1,
+				MaxLimit:         1000,
+				Smoothing:        0.2,
+				Tolerance:        1.5,
+				RejectStatusCode: http.StatusServiceUnavailable,
+			},
+		}
+	},
+}
+
+var _ Policy = (*AdaptiveConcurrencyPolicy)(nil)
+
+type (
+	// AdaptiveConcurrencyPolicy defines the adaptive concurrency policy,
+	// which limits the number of in-flight requests, the limit is adjusted
+	// automatically according to the round trip time (RTT) of requests.
+	AdaptiveConcurrencyPolicy struct {
+		BaseSpec                `json:",inline"`
+		AdaptiveConcurrencyRule `json:",inline"`
+	}
+
+	// AdaptiveConcurrencyRule is the detailed config of adaptive concurrency.
+	AdaptiveConcurrencyRule struct {
+		Algorithm        string  `json:"algorithm,omitempty" jsonschema:"enum=gradient,enum=vegas"`
+		InitialLimit     int     `json:"initialLimit,omitempty" jsonschema:"minimum=1"`
+		MinLimit         int     `json:"minLimit,omitempty" jsonschema:"minimum=1"`
+		MaxLimit         int     `json:"maxLimit,omitempty" jsonschema:"minimum=1"`
+		Smoothing        float64 `json:"smoothing,omitempty" jsonschema:"exclusiveMinimum=0,maximum=1"`
+		Tolerance        float64 `json:"tolerance,omitempty" jsonschema:"minimum=1"`
+		RejectStatusCode int     `json:"rejectStatusCode,omitempty" jsonschema:"minimum=100,maximum=599"`
+	}
+
+	// AdaptiveConcurrencyStatus is the status of an adaptive concurrency
+	// limiter.
+	AdaptiveConcurrencyStatus struct {
+		Limit    int    `json:"limit"`
+		Inflight int    `json:"inflight"`
+		Rejected uint64 `json:"rejected"`
+		MinRTT   string `json:"minRTT,omitempty"`
+	}
+)
+
+// Validate validates the AdaptiveConcurrencyPolicy.
+func (p *AdaptiveConcurrencyPolicy) Validate() error {
+	if p.MinLimit > p.MaxLimit {
+		return fmt.Errorf("minLimit must not be greater than maxLimit")
+	}
+	if p.InitialLimit < p.MinLimit || p.InitialLimit > p.MaxLimit {
+		return fmt.Errorf("initialLimit must be in [minLimit, maxLimit]")
+	}
+	return nil
+}
+
+// CreateWrapper creates a Wrapper.
+func (p *AdaptiveConcurrencyPolicy) CreateWrapper() Wrapper {
+	w := &AdaptiveConcurrencyWrapper{
+		policy: p.Name(),
+		rule:   p.AdaptiveConcurrencyRule,
+		limit:  float64(p.InitialLimit),
+	}
+
+	r := &w.rule
+	if r.MinLimit <= 0 {
+		r.MinLimit = 1
+	}
+	if r.MaxLimit < r.MinLimit {
+		r.MaxLimit = r.MinLimit
+	}
+	if w.limit < float64(r.MinLimit) || w.limit > float64(r.MaxLimit) {
+		w.limit = float64(r.MinLimit)
+	}
+	if r.Smoothing <= 0 || r.Smoothing > 1 {
+		r.Smoothing = 0.2
+	}
+	if r.Tolerance < 1 {
+		r.Tolerance = 1.5
+	}
+	if r.RejectStatusCode == 0 {
+		r.RejectStatusCode = http.StatusServiceUnavailable
+	}
+	return w
+}
+
+// AdaptiveConcurrencyWrapper is the wrapper of adaptive concurrency.
+type AdaptiveConcurrencyWrapper struct {
+	policy string
+	rule   AdaptiveConcurrencyRule
+
+	lock     sync.Mutex
+	limit    float64
+	inflight int
+	rejected uint64
+
+	// longRTT is the exponential moving average of RTT, used by the
+	// gradient algorithm.
+	longRTT float64
+	// minRTT is the minimum RTT, which is considered as the RTT without
+	// load, used by the vegas algorithm.
+	minRTT float64
+	// probeCountdown is the number of samples before resetting minRTT.
+	probeCountdown int
+}
+
+// vegasProbeMultiplier controls how often the vegas algorithm resets the
+// minimum RTT, it is reset every (limit * vegasProbeMultiplier) samples.
+const vegasProbeMultiplier = 30
+
+// Status returns the status of the limiter.
+func (w *AdaptiveConcurrencyWrapper) Status() *AdaptiveConcurrencyStatus {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	s := &AdaptiveConcurrencyStatus{
+		Limit:    int(w.limit),
+		Inflight: w.inflight,
+		Rejected: w.rejected,
+	}
+	if w.minRTT > 0 {
+		s.MinRTT = time.Duration(w.minRTT).String()
+	}
+	return s
+}
+
+func (w *AdaptiveConcurrencyWrapper) acquire() (int, bool) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.inflight >= int(w.limit) {
+		w.rejected++
+		return 0, false
+	}
+	w.inflight++
+	return w.inflight, true
+}
+
+// release releases a permission and updates the limit with the RTT of the
+// request, inflight is the number of in-flight requests when the request
+// started.
+func (w *AdaptiveConcurrencyWrapper) release(rtt time.Duration, inflight int) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.inflight--
+	if rtt <= 0 {
+		return
+	}
+
+	var limit float64
+	if w.rule.Algorithm == AdaptiveConcurrencyAlgorithmVegas {
+		limit = w.vegas(float64(rtt), inflight)
+	} else {
+		limit = w.gradient(float64(rtt), inflight)
+	}
+
+	limit = w.limit*(1-w.rule.Smoothing) + limit*w.rule.Smoothing
+	limit = math.Max(float64(w.rule.MinLimit), math.Min(float64(w.rule.MaxLimit), limit))
+	w.limit = limit
+}
+
+// gradient calculates the new limit by the gradient of the long term RTT
+// and the RTT of the request, the caller must hold the lock.
+func (w *AdaptiveConcurrencyWrapper) gradient(rtt float64, inflight int) float64 {
+	if w.longRTT == 0 {
+		w.longRTT = rtt
+	} else {
+		w.longRTT = w.longRTT*0.99 + rtt*0.01
+	}
+
+	// the long term RTT drifts away, speed up the recovery.
+	if w.longRTT/rtt > 2 {
+		w.longRTT *= 0.95
+	}
+
+	// the limit is not fully used, don't grow it.
+	if float64(inflight) < w.limit/2 {
+		return w.limit
+	}
+
+	gradient := math.Max(0.5, math.Min(1, w.rule.Tolerance*w.longRTT/rtt))
+	return w.limit*gradient + math.Sqrt(w.limit)
+}
+
+// vegas calculates the new limit by the estimated queue size, the caller
+// must hold the lock.
+func (w *AdaptiveConcurrencyWrapper) vegas(rtt float64, inflight int) float64 {
+	w.probeCountdown--
+	if w.minRTT == 0 || rtt < w.minRTT || w.probeCountdown <= 0 {
+		w.minRTT = rtt
+		w.probeCountdown = int(w.limit) * vegasProbeMultiplier
+		return w.limit
+	}
+
+	if float64(inflight)*2 < w.limit {
+		return w.limit
+	}
+
+	log := math.Max(1, math.Log10(w.limit))
+	alpha, beta := 3*log, 6*log
+	queue := math.Ceil(w.limit * (1 - w.minRTT/rtt))
+
+	switch {
+	case queue <= log:
+		return w.limit + beta
+	case queue < alpha:
+		return w.limit + log
+	case queue > beta:
+		return w.limit - log
+	}
+	return w.limit
+}
+
+type rttSampleKey struct{}
+
+// rttSample is the start time of the last upstream call of a request, it
+// is reset by the retry policy before every attempt, so that the time
+// spent in failed attempts and backoff is excluded from the RTT.
+type rttSample struct {
+	start time.Time
+}
+
+// startRTTSample resets the RTT sample of the request, if any.
+func startRTTSample(ctx context.Context) {
+	if s, ok := ctx.Value(rttSampleKey{}).(*rttSample); ok {
+		s.start = time.Now()
+	}
+}
+
+// Wrap wraps the handler function, the RTT is only sampled from the
+// upstream calls which completed successfully.
+func (w *AdaptiveConcurrencyWrapper) Wrap(handler HandlerFunc) HandlerFunc {
+	return func(ctx context.Context) error {
+		inflight, ok := w.acquire()
+		if !ok {
+			return &RejectedError{Policy: w.policy, StatusCode: w.rule.RejectStatusCode}
+		}
+
+		sample := &rttSample{start: time.Now()}
+		panicked := true
+		defer func() {
+			if panicked {
+				w.release(0, inflight)
+			}
+		}()
+
+		err := handler(context.WithValue(ctx, rttSampleKey{}, sample))
+		if err == nil {
+			w.release(time.Since(sample.start), inflight)
+		} else {
+			w.release(0, inflight)
+		}
+
+		panicked = false
+		return err
+	}
+}
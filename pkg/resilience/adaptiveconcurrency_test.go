@@ -0,0 +1,160 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resilience
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestAdaptiveConcurrency(rule AdaptiveConcurrencyRule) *AdaptiveConcurrencyWrapper {
+	p := AdaptiveConcurrencyKind.DefaultPolicy().(*AdaptiveConcurrencyPolicy)
+	if rule.Algorithm != "" {
+		p.Algorithm = rule.Algorithm
+	}
+	if rule.InitialLimit != 0 {
+		p.InitialLimit = rule.InitialLimit
+	}
+	if rule.RejectStatusCode != 0 {
+		p.RejectStatusCode = rule.RejectStatusCode
+	}
+	return p.CreateWrapper().(*AdaptiveConcurrencyWrapper)
+}
+
+func TestAdaptiveConcurrencyValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	p := AdaptiveConcurrencyKind.DefaultPolicy().(*AdaptiveConcurrencyPolicy)
+	assert.NoError(p.Validate())
+	p.MinLimit = 2000
+	assert.Error(p.Validate())
+	p.MinLimit = 1
+	p.InitialLimit = 2000
+	assert.Error(p.Validate())
+}
+
+func TestAdaptiveConcurrencyReject(t *testing.T) {
+	assert := assert.New(t)
+
+	w := newTestAdaptiveConcurrency(AdaptiveConcurrencyRule{
+		InitialLimit:     2,
+		RejectStatusCode: http.StatusTooManyRequests,
+	})
+
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	handler := w.Wrap(func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(handler(context.Background()))
+		}()
+	}
+
+	assert.Eventually(func() bool {
+		return w.Status().Inflight == 2
+	}, time.Second, time.Millisecond)
+
+	err := handler(context.Background())
+	re, ok := err.(*RejectedError)
+	assert.True(ok)
+	assert.Equal(http.StatusTooManyRequests, re.StatusCode)
+	assert.Equal(uint64(1), w.Status().Rejected)
+
+	close(release)
+	wg.Wait()
+	assert.Equal(0, w.Status().Inflight)
+}
+
+func TestAdaptiveConcurrencySample(t *testing.T) {
+	assert := assert.New(t)
+
+	w := newTestAdaptiveConcurrency(AdaptiveConcurrencyRule{
+		Algorithm: AdaptiveConcurrencyAlgorithmVegas,
+	})
+
+	// failed calls are not sampled.
+	err := w.Wrap(func(ctx context.Context) error {
+		return testError{code: http.StatusBadGateway}
+	})(context.Background())
+	assert.Error(err)
+	assert.Empty(w.Status().MinRTT)
+	assert.Equal(0, w.Status().Inflight)
+
+	// failed attempts and backoff of retries are not sampled.
+	retry := newTestRetryPolicy(RetryRule{MaxAttempts: 2})
+	retry.waitDuration = 100 * time.Millisecond
+	attempts := 0
+	err = w.Wrap(retry.Wrap(func(ctx context.Context) error {
+		attempts++
+		if attempts == 1 {
+			time.Sleep(100 * time.Millisecond)
+			return testError{code: http.StatusBadGateway}
+		}
+		return nil
+	}))(context.Background())
+	assert.NoError(err)
+	assert.NotEmpty(w.Status().MinRTT)
+	assert.Less(w.minRTT, float64(100*time.Millisecond))
+}
+
+func testAdaptiveConcurrencyLimit(t *testing.T, algorithm string) {
+	assert := assert.New(t)
+
+	w := newTestAdaptiveConcurrency(AdaptiveConcurrencyRule{
+		Algorithm:    algorithm,
+		InitialLimit: 100,
+	})
+
+	// the limit grows when the RTT is stable and the limit is used.
+	for i := 0; i < 200; i++ {
+		w.release(10*time.Millisecond, int(w.limit))
+		w.inflight++
+	}
+	grown := w.Status().Limit
+	assert.Greater(grown, 100, algorithm)
+
+	// the limit shrinks when the RTT climbs.
+	for i := 0; i < 200; i++ {
+		w.release(100*time.Millisecond, int(w.limit))
+		w.inflight++
+	}
+	assert.Less(w.Status().Limit, grown, algorithm)
+
+	// the limit does not change when it is not fully used.
+	limit := w.limit
+	w.release(10*time.Millisecond, 1)
+	assert.Equal(limit, w.limit, algorithm)
+}
+
+func TestAdaptiveConcurrencyGradient(t *testing.T) {
+	testAdaptiveConcurrencyLimit(t, AdaptiveConcurrencyAlgorithmGradient)
+}
+
+func TestAdaptiveConcurrencyVegas(t *testing.T) {
+	testAdaptiveConcurrencyLimit(t, AdaptiveConcurrencyAlgorithmVegas)
+}
@@ -0,0 +1,179 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resilience
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// BulkheadKind is the kind of Bulkhead.
+var BulkheadKind = &Kind{
+	Name: "Bulkhead",
+
+	DefaultPolicy: func() Policy {
+		return &BulkheadPolicy{
+			BulkheadRule: BulkheadRule{
+				MaxConcurrentRequests: 100,
+				QueueTimeout:          "1s",
+				RejectStatusCode:      http.StatusServiceUnavailable,
+			},
+		}
+	},
+}
+
+var _ Policy = (*BulkheadPolicy)(nil)
+
+type (
+	// BulkheadPolicy defines the bulkhead policy, which limits the number
+	// of concurrent requests, requests exceeding the limit wait in a
+	// bounded queue, and are rejected if the queue is full or they wait
+	// too long.
+	BulkheadPolicy struct {
+		BaseSpec     `json:",inline"`
+		BulkheadRule `json:",inline"`
+	}
+
+	// BulkheadRule is the detailed config of bulkhead.
+	BulkheadRule struct {
+		MaxConcurrentRequests int    `json:"maxConcurrentRequests,omitempty" jsonschema:"minimum=1"`
+		MaxQueueSize          int    `json:"maxQueueSize,omitempty" jsonschema:"minimum=0"`
+		QueueTimeout          string `json:"queueTimeout,omitempty" jsonschema:"format=duration"`
+		RejectStatusCode      int    `json:"rejectStatusCode,omitempty" jsonschema:"minimum=100,maximum=599"`
+	}
+
+	// BulkheadStatus is the status of a bulkhead.
+	BulkheadStatus struct {
+		Inflight     int64  `json:"inflight"`
+		Queued       int64  `json:"queued"`
+		TotalQueued  uint64 `json:"totalQueued"`
+		Rejected     uint64 `json:"rejected"`
+		QueueTimeout uint64 `json:"queueTimeout"`
+	}
+)
+
+// Validate validates the BulkheadPolicy.
+func (p *BulkheadPolicy) Validate() error {
+	if p.QueueTimeout != "" {
+		d, err := time.ParseDuration(p.QueueTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid queueTimeout: %v", err)
+		}
+		if d <= 0 {
+			return fmt.Errorf("queueTimeout must be positive")
+		}
+	}
+	return nil
+}
+
+// CreateWrapper creates a Wrapper.
+func (p *BulkheadPolicy) CreateWrapper() Wrapper {
+	w := &BulkheadWrapper{
+		policy:     p.Name(),
+		statusCode: p.RejectStatusCode,
+		maxQueue:   int64(p.MaxQueueSize),
+	}
+
+	maxConcurrent := p.MaxConcurrentRequests
+	if maxConcurrent <= 0 {
+		maxConcurrent = 100
+	}
+	w.sem = make(chan struct{}, maxConcurrent)
+
+	if d := p.QueueTimeout; d != "" {
+		w.queueTimeout, _ = time.ParseDuration(d)
+	}
+	if w.queueTimeout <= 0 {
+		w.queueTimeout = time.Second
+	}
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusServiceUnavailable
+	}
+	return w
+}
+
+// BulkheadWrapper is the wrapper of bulkhead.
+type BulkheadWrapper struct {
+	policy       string
+	statusCode   int
+	maxQueue     int64
+	queueTimeout time.Duration
+	sem          chan struct{}
+
+	queued       atomic.Int64
+	totalQueued  atomic.Uint64
+	rejected     atomic.Uint64
+	timeoutCount atomic.Uint64
+}
+
+// Status returns the status of the bulkhead.
+func (w *BulkheadWrapper) Status() *BulkheadStatus {
+	return &BulkheadStatus{
+		Inflight:     int64(len(w.sem)),
+		Queued:       w.queued.Load(),
+		TotalQueued:  w.totalQueued.Load(),
+		Rejected:     w.rejected.Load(),
+		QueueTimeout: w.timeoutCount.Load(),
+	}
+}
+
+// acquire acquires a permission, it waits in the queue if there's no
+// permission available.
+func (w *BulkheadWrapper) acquire(ctx context.Context) bool {
+	select {
+	case w.sem <- struct{}{}:
+		return true
+	default:
+	}
+
+	if w.queued.Add(1) > w.maxQueue {
+		w.queued.Add(-1)
+		w.rejected.Add(1)
+		return false
+	}
+	defer w.queued.Add(-1)
+	w.totalQueued.Add(1)
+
+	timer := time.NewTimer(w.queueTimeout)
+	defer timer.Stop()
+
+	select {
+	case w.sem <- struct{}{}:
+		return true
+	case <-timer.C:
+		w.timeoutCount.Add(1)
+	case <-ctx.Done():
+	}
+	w.rejected.Add(1)
+	return false
+}
+
+// Wrap wraps the handler function.
+func (w *BulkheadWrapper) Wrap(handler HandlerFunc) HandlerFunc {
+	return func(ctx context.Context) error {
+		if !w.acquire(ctx) {
+			return &RejectedError{Policy: w.policy, StatusCode: w.statusCode}
+		}
+		defer func() { <-w.sem }()
+
+		return handler(ctx)
+	}
+}
@@ -0,0 +1,102 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resilience
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBulkheadValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	p := BulkheadKind.DefaultPolicy().(*BulkheadPolicy)
+	assert.NoError(p.Validate())
+	p.QueueTimeout = "abc"
+	assert.Error(p.Validate())
+	p.QueueTimeout = "-1s"
+	assert.Error(p.Validate())
+}
+
+func TestBulkhead(t *testing.T) {
+	assert := assert.New(t)
+
+	p := &BulkheadPolicy{
+		BulkheadRule: BulkheadRule{
+			MaxConcurrentRequests: 2,
+			MaxQueueSize:          1,
+			QueueTimeout:          "50ms",
+			RejectStatusCode:      http.StatusTooManyRequests,
+		},
+	}
+	w := p.CreateWrapper().(*BulkheadWrapper)
+
+	release := make(chan struct{})
+	handler := w.Wrap(func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(handler(context.Background()))
+		}()
+	}
+	assert.Eventually(func() bool {
+		return w.Status().Inflight == 2
+	}, time.Second, time.Millisecond)
+
+	// the third request waits in the queue and times out.
+	err := handler(context.Background())
+	re, ok := err.(*RejectedError)
+	assert.True(ok)
+	assert.Equal(http.StatusTooManyRequests, re.StatusCode)
+	s := w.Status()
+	assert.Equal(uint64(1), s.TotalQueued)
+	assert.Equal(uint64(1), s.QueueTimeout)
+	assert.Equal(uint64(1), s.Rejected)
+
+	// the third request waits in the queue, and the fourth one is
+	// rejected immediately as the queue is full.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		assert.NoError(handler(context.Background()))
+	}()
+	assert.Eventually(func() bool {
+		return w.Status().Queued == 1
+	}, time.Second, time.Millisecond)
+	assert.Error(handler(context.Background()))
+	assert.Equal(uint64(2), w.Status().Rejected)
+
+	// the queued request gets the permission after others finished.
+	close(release)
+	wg.Wait()
+	s = w.Status()
+	assert.Equal(int64(0), s.Inflight)
+	assert.Equal(int64(0), s.Queued)
+	assert.Equal(uint64(2), s.TotalQueued)
+}
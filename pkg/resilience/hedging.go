@@ -0,0 +1,225 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resilience
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// hedgingSampleSize is the number of latency samples to calculate
+	// the hedging delay.
+	hedgingSampleSize = 1000
+	// hedgingMinSamples is the minimum number of samples before the
+	// latency percentile can be used as the hedging delay.
+	hedgingMinSamples = 100
+	// hedgingUpdateInterval is the number of samples between two updates
+	// of the latency percentile.
+	hedgingUpdateInterval = 100
+)
+
+// HedgingSpec is the spec of request hedging. A hedged request is a
+// duplicate of the original request which is sent if the original one has
+// not answered after a delay, the first successful response is used and
+// the others are cancelled.
+//
+// Only requests with idempotent methods are hedged.
+type HedgingSpec struct {
+	// Delay is the delay to send a hedged request, it is also used before
+	// enough latency samples are collected when Percentile is set.
+	Delay string `json:"delay,omitempty" jsonschema:"format=duration"`
+	// Percentile is the latency percentile of recent successful requests
+	// to be used as the delay, for example, 95.
+	Percentile float64 `json:"percentile,omitempty" jsonschema:"exclusiveMinimum=0,exclusiveMaximum=100"`
+	// MaxHedgedRequests is the maximum number of hedged requests for one
+	// request, default is 1.
+	MaxHedgedRequests int `json:"maxHedgedRequests,omitempty" jsonschema:"minimum=1"`
+}
+
+// Validate validates the HedgingSpec.
+func (spec *HedgingSpec) Validate() error {
+	if spec.Delay == "" && spec.Percentile == 0 {
+		return fmt.Errorf("at least one of delay and percentile must be set")
+	}
+	if spec.Delay != "" {
+		d, err := time.ParseDuration(spec.Delay)
+		if err != nil {
+			return fmt.Errorf("invalid delay: %v", err)
+		}
+		if d <= 0 {
+			return fmt.Errorf("delay must be positive")
+		}
+	}
+	if spec.Percentile < 0 || spec.Percentile >= 100 {
+		return fmt.Errorf("percentile must be in (0, 100)")
+	}
+	if spec.MaxHedgedRequests < 0 {
+		return fmt.Errorf("maxHedgedRequests must not be negative")
+	}
+	return nil
+}
+
+type hedger struct {
+	delay      time.Duration
+	percentile float64
+	maxHedged  int
+
+	lock    sync.Mutex
+	samples []time.Duration
+	next    int
+	count   int
+
+	// latency is the latency percentile in nanoseconds, 0 means not
+	// available.
+	latency atomic.Int64
+}
+
+func newHedger(spec *HedgingSpec) *hedger {
+	h := &hedger{
+		percentile: spec.Percentile,
+		maxHedged:  spec.MaxHedgedRequests,
+		samples:    make([]time.Duration, hedgingSampleSize),
+	}
+	h.delay, _ = time.ParseDuration(spec.Delay)
+	if h.maxHedged <= 0 {
+		h.maxHedged = 1
+	}
+	return h
+}
+
+// observe records the latency of a successful request.
+func (h *hedger) observe(d time.Duration) {
+	if h.percentile <= 0 {
+		return
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.samples[h.next] = d
+	h.next = (h.next + 1) % len(h.samples)
+	h.count++
+	if h.count < hedgingMinSamples || h.count%hedgingUpdateInterval != 0 {
+		return
+	}
+
+	n := h.count
+	if n > len(h.samples) {
+		n = len(h.samples)
+	}
+	sorted := make([]time.Duration, n)
+	copy(sorted, h.samples[:n])
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	idx := int(float64(n) * h.percentile / 100)
+	if idx >= n {
+		idx = n - 1
+	}
+	h.latency.Store(int64(sorted[idx]))
+}
+
+// hedgingDelay returns the delay to send a hedged request, 0 means the
+// request should not be hedged.
+func (h *hedger) hedgingDelay() time.Duration {
+	if l := h.latency.Load(); l > 0 {
+		return time.Duration(l)
+	}
+	return h.delay
+}
+
+type hedgeResult struct {
+	index int
+	err   error
+}
+
+// wrap wraps the handler function to send hedged requests, budget is
+// optional, a hedged request is sent only if the budget allows.
+func (h *hedger) wrap(handler HandlerFunc, budget *retryBudget) HandlerFunc {
+	return func(ctx context.Context) error {
+		info := requestInfoFromContext(ctx)
+		delay := h.hedgingDelay()
+		if !info.Hedgeable || !isIdempotent(info.Method) || delay <= 0 {
+			start := time.Now()
+			err := handler(ctx)
+			if err == nil {
+				h.observe(time.Since(start))
+			}
+			return err
+		}
+
+		results := make(chan hedgeResult, h.maxHedged+1)
+		cancels := make([]context.CancelFunc, 0, h.maxHedged+1)
+		launch := func() {
+			attemptCtx, cancel := context.WithCancel(ctx)
+			cancels = append(cancels, cancel)
+			index := len(cancels) - 1
+			go func() {
+				start := time.Now()
+				err := handler(attemptCtx)
+				if err == nil {
+					h.observe(time.Since(start))
+				}
+				results <- hedgeResult{index: index, err: err}
+			}()
+		}
+
+		launch()
+		inflight := 1
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		for {
+			select {
+			case r := <-results:
+				inflight--
+				if r.err == nil {
+					// cancel the others, but not the winner, as its
+					// response may still be being read, it is canceled
+					// when the response is released.
+					for i, cancel := range cancels {
+						if i != r.index {
+							cancel()
+						}
+					}
+					info.release(cancels[r.index])
+					return nil
+				}
+				if inflight == 0 {
+					for _, cancel := range cancels {
+						cancel()
+					}
+					return r.err
+				}
+			case <-timer.C:
+				if len(cancels) > h.maxHedged {
+					continue
+				}
+				if budget != nil && !budget.withdraw() {
+					continue
+				}
+				launch()
+				inflight++
+				timer.Reset(delay)
+			}
+		}
+	}
+}
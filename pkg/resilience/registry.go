@@ -19,8 +19,10 @@ package resilience
 
 // kinds is the resilience kind registry.
 var kinds = map[string]*Kind{
-	CircuitBreakerKind.Name: CircuitBreakerKind,
-	RetryKind.Name:          RetryKind,
+	CircuitBreakerKind.Name:      CircuitBreakerKind,
+	RetryKind.Name:               RetryKind,
+	AdaptiveConcurrencyKind.Name: AdaptiveConcurrencyKind,
+	BulkheadKind.Name:            BulkheadKind,
 }
 
 // WalkKind walks the registry, calling fn for each filter kind, and stops
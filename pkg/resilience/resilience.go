@@ -60,12 +60,32 @@ type (
 		CreateWrapper() Wrapper
 	}
 
+	// initializer is implemented by policies which have states shared by
+	// all of their wrappers, the states are built by NewPolicy.
+	initializer interface {
+		init()
+	}
+
 	// BaseSpec is the universal spec for all resilience policies.
 	BaseSpec struct {
 		supervisor.MetaSpec `json:",inline"`
 	}
 )
 
+// RejectedError is the error returned by resilience policies which reject
+// requests on overload, for example, AdaptiveConcurrency and Bulkhead.
+type RejectedError struct {
+	// Policy is the name of the policy.
+	Policy string
+	// StatusCode is the status code to respond to the rejected request.
+	StatusCode int
+}
+
+// Error implements error.
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("the call was rejected by policy %s", e.Policy)
+}
+
 // NewPolicy creates a resilience policy and validates it.
 func NewPolicy(rawSpec interface{}) (policy Policy, err error) {
 	defer func() {
@@ -101,6 +121,9 @@ func NewPolicy(rawSpec interface{}) (policy Policy, err error) {
 	if vr := v.Validate(policy); !vr.Valid() {
 		return nil, fmt.Errorf("%v", vr)
 	}
+	if i, ok := policy.(initializer); ok {
+		i.init()
+	}
 
 	return
 }
@@ -19,7 +19,10 @@ package resilience
 
 import (
 	"context"
+	"fmt"
 	"math/rand"
+	"net/http"
+	"sync"
 	"time"
 )
 
@@ -45,6 +48,12 @@ type (
 	RetryPolicy struct {
 		BaseSpec  `json:",inline"`
 		RetryRule `json:",inline"`
+
+		// the budget and the hedger are shared by all users of the
+		// policy, they are built only once.
+		initOnce sync.Once
+		budget   *retryBudget
+		hedger   *hedger
 	}
 
 	// RetryRule is the detailed config of retry
@@ -52,40 +61,190 @@ type (
 		MaxAttempts         int    `json:"maxAttempts,omitempty" jsonschema:"minimum=1"`
 		WaitDuration        string `json:"waitDuration,omitempty" jsonschema:"format=duration"`
 		waitDuration        time.Duration
-		BackOffPolicy       string  `json:"backOffPolicy,omitempty" jsonschema:"enum=random,enum=exponential"`
-		RandomizationFactor float64 `json:"randomizationFactor,omitempty" jsonschema:"minimum=0,maximum=1"`
+		BackOffPolicy       string           `json:"backOffPolicy,omitempty" jsonschema:"enum=random,enum=exponential"`
+		RandomizationFactor float64          `json:"randomizationFactor,omitempty" jsonschema:"minimum=0,maximum=1"`
+		RetryOn             *RetryOnSpec     `json:"retryOn,omitempty"`
+		Budget              *RetryBudgetSpec `json:"budget,omitempty"`
+		Hedging             *HedgingSpec     `json:"hedging,omitempty"`
+	}
+
+	// RetryOnSpec defines the conditions to retry a failed request. If both
+	// StatusCodes and ErrorClasses are empty, all errors are retryable.
+	RetryOnSpec struct {
+		// StatusCodes is the status codes to retry.
+		StatusCodes []int `json:"statusCodes,omitempty" jsonschema:"uniqueItems=true"`
+		// ErrorClasses is the error classes to retry, the error classes
+		// are the results of the filter, for example, serverError and
+		// timeout of the Proxy filter.
+		ErrorClasses []string `json:"errorClasses,omitempty" jsonschema:"uniqueItems=true"`
+		// IdempotentOnly means only requests with idempotent methods are
+		// retried.
+		IdempotentOnly bool `json:"idempotentOnly,omitempty"`
+	}
+
+	// ClassifiedError is the interface of errors returned by handler
+	// functions which carry a status code and an error class, the retry
+	// policy uses them to decide whether to retry.
+	ClassifiedError interface {
+		error
+		// Code returns the status code.
+		Code() int
+		// Result returns the error class.
+		Result() string
+	}
+
+	// RequestInfo is the information of the request being handled, it
+	// is used by the retry policy to decide whether to retry or hedge the
+	// request.
+	RequestInfo struct {
+		// Method is the method of the request.
+		Method string
+		// Hedgeable means the handler function is safe to be called
+		// concurrently, requests are hedged only when it is true.
+		Hedgeable bool
+		// OnRelease registers a function to be called when the response
+		// is released, it is used to cancel the context of the hedged
+		// request which wins. The context is canceled when the handler
+		// returns if it is nil.
+		OnRelease func(fn func())
 	}
+
+	requestInfoKey struct{}
 )
 
+// WithRequestInfo returns a copy of ctx which carries the request info.
+func WithRequestInfo(ctx context.Context, info *RequestInfo) context.Context {
+	return context.WithValue(ctx, requestInfoKey{}, info)
+}
+
+// release calls fn when the response is released.
+func (info *RequestInfo) release(fn func()) {
+	if info.OnRelease != nil {
+		info.OnRelease(fn)
+	} else {
+		fn()
+	}
+}
+
+func requestInfoFromContext(ctx context.Context) *RequestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*RequestInfo)
+	if info == nil {
+		return &RequestInfo{}
+	}
+	return info
+}
+
+// isIdempotent returns whether the method is idempotent.
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions,
+		http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
 // Validate validates the retry policy.
 func (p *RetryPolicy) Validate() error {
-	// TODO
+	if p.Budget != nil {
+		if err := p.Budget.Validate(); err != nil {
+			return fmt.Errorf("invalid budget: %v", err)
+		}
+	}
+	if p.Hedging != nil {
+		if err := p.Hedging.Validate(); err != nil {
+			return fmt.Errorf("invalid hedging: %v", err)
+		}
+	}
 	return nil
 }
 
+// init initializes the policy, it is called by NewPolicy, and is safe to
+// be called more than once.
+func (p *RetryPolicy) init() {
+	p.initOnce.Do(func() {
+		if d := p.WaitDuration; d != "" {
+			p.waitDuration, _ = time.ParseDuration(d)
+		}
+		if p.waitDuration <= 0 {
+			p.waitDuration = time.Millisecond * 500
+		}
+
+		if p.Budget != nil {
+			p.budget = newRetryBudget(p.Budget)
+		}
+		if p.Hedging != nil {
+			p.hedger = newHedger(p.Hedging)
+		}
+	})
+}
+
 // CreateWrapper creates a Wrapper. For the RetryPolicy, just reuse itself.
 func (p *RetryPolicy) CreateWrapper() Wrapper {
-	if d := p.WaitDuration; d != "" {
-		p.waitDuration, _ = time.ParseDuration(d)
+	// policies created without NewPolicy are initialized here.
+	p.init()
+	return p
+}
+
+// retryable returns whether the error is retryable.
+func (p *RetryPolicy) retryable(ctx context.Context, err error) bool {
+	if p.RetryOn == nil {
+		return true
 	}
-	if p.waitDuration <= 0 {
-		p.waitDuration = time.Millisecond * 500
+
+	if p.RetryOn.IdempotentOnly && !isIdempotent(requestInfoFromContext(ctx).Method) {
+		return false
 	}
-	return p
+
+	if len(p.RetryOn.StatusCodes) == 0 && len(p.RetryOn.ErrorClasses) == 0 {
+		return true
+	}
+
+	ce, ok := err.(ClassifiedError)
+	if !ok {
+		return false
+	}
+	for _, code := range p.RetryOn.StatusCodes {
+		if code == ce.Code() {
+			return true
+		}
+	}
+	for _, class := range p.RetryOn.ErrorClasses {
+		if class == ce.Result() {
+			return true
+		}
+	}
+	return false
 }
 
 // Wrap wraps the handler function.
 func (p *RetryPolicy) Wrap(handler HandlerFunc) HandlerFunc {
+	if p.hedger != nil {
+		handler = p.hedger.wrap(handler, p.budget)
+	}
+
 	return func(ctx context.Context) error {
 		var err error
 		base := float64(p.waitDuration)
 
+		if p.budget != nil {
+			p.budget.deposit()
+		}
+
 		for attempt := 0; attempt < p.MaxAttempts; attempt++ {
+			startRTTSample(ctx)
 			err = handler(ctx)
 			if err == nil {
 				return nil
 			}
 
+			if attempt == p.MaxAttempts-1 || !p.retryable(ctx, err) {
+				return err
+			}
+			if p.budget != nil && !p.budget.withdraw() {
+				return err
+			}
+
 			delta := base * p.RandomizationFactor
 			d := base - delta + float64(rand.Intn(int(delta*2+1)))
 
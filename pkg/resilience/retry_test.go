@@ -0,0 +1,287 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resilience
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testError struct {
+	code   int
+	result string
+}
+
+func (e testError) Error() string {
+	return fmt.Sprintf("code=%d, result=%s", e.code, e.result)
+}
+
+func (e testError) Code() int {
+	return e.code
+}
+
+func (e testError) Result() string {
+	return e.result
+}
+
+func newTestRetryPolicy(rule RetryRule) *RetryPolicy {
+	if rule.MaxAttempts == 0 {
+		rule.MaxAttempts = 3
+	}
+	rule.WaitDuration = "1ms"
+	p := &RetryPolicy{RetryRule: rule}
+	p.CreateWrapper()
+	return p
+}
+
+func TestRetryOn(t *testing.T) {
+	assert := assert.New(t)
+
+	p := newTestRetryPolicy(RetryRule{
+		RetryOn: &RetryOnSpec{
+			StatusCodes:    []int{503},
+			ErrorClasses:   []string{"timeout"},
+			IdempotentOnly: true,
+		},
+	})
+	assert.NoError(p.Validate())
+
+	get := WithRequestInfo(context.Background(), &RequestInfo{Method: http.MethodGet})
+	post := WithRequestInfo(context.Background(), &RequestInfo{Method: http.MethodPost})
+
+	cases := []struct {
+		ctx      context.Context
+		err      error
+		attempts int32
+	}{
+		{get, testError{503, "serverError"}, 3},
+		{get, testError{408, "timeout"}, 3},
+		{get, testError{500, "failureCode"}, 1},
+		{get, fmt.Errorf("unknown error"), 1},
+		{post, testError{503, "serverError"}, 1},
+		{context.Background(), testError{503, "serverError"}, 1},
+	}
+	for i, c := range cases {
+		var attempts int32
+		handler := p.Wrap(func(ctx context.Context) error {
+			atomic.AddInt32(&attempts, 1)
+			return c.err
+		})
+		assert.Equal(c.err, handler(c.ctx))
+		assert.Equal(c.attempts, attempts, "case %d", i)
+	}
+
+	// all errors are retryable without retryOn.
+	p = newTestRetryPolicy(RetryRule{})
+	var attempts int32
+	handler := p.Wrap(func(ctx context.Context) error {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			return fmt.Errorf("error")
+		}
+		return nil
+	})
+	assert.NoError(handler(post))
+	assert.Equal(int32(3), attempts)
+}
+
+func TestRetryBudget(t *testing.T) {
+	assert := assert.New(t)
+
+	one, zero, negative := 1, 0, -1
+	spec := &RetryBudgetSpec{Percent: 20, MinRetriesPerSecond: &one}
+	assert.NoError(spec.Validate())
+	assert.Error((&RetryBudgetSpec{Percent: 101}).Validate())
+	assert.Error((&RetryPolicy{RetryRule: RetryRule{Budget: &RetryBudgetSpec{MinRetriesPerSecond: &negative}}}).Validate())
+
+	now := time.Now()
+	b := newRetryBudget(spec)
+	b.nowFunc = func() time.Time { return now }
+	b.lastRefill = now
+
+	// the initial tokens are the minimum retries of one second.
+	assert.True(b.withdraw())
+	assert.False(b.withdraw())
+
+	// 100 requests allow 20 retries.
+	for i := 0; i < 100; i++ {
+		b.deposit()
+	}
+	retries := 0
+	for b.withdraw() {
+		retries++
+	}
+	assert.Equal(20, retries)
+
+	// the minimum retries are refilled over time.
+	now = now.Add(2 * time.Second)
+	assert.True(b.withdraw())
+	assert.True(b.withdraw())
+	assert.False(b.withdraw())
+
+	// the policy stops retrying when the budget is exhausted.
+	p := newTestRetryPolicy(RetryRule{MaxAttempts: 10, Budget: &RetryBudgetSpec{MinRetriesPerSecond: &one}})
+	p.budget.nowFunc = func() time.Time { return now }
+	p.budget.lastRefill = now
+	var attempts int32
+	handler := p.Wrap(func(ctx context.Context) error {
+		atomic.AddInt32(&attempts, 1)
+		return fmt.Errorf("error")
+	})
+	assert.Error(handler(context.Background()))
+	assert.Equal(int32(2), attempts)
+
+	// zero disables the minimum retries, retries only come from requests.
+	b = newRetryBudget(&RetryBudgetSpec{MinRetriesPerSecond: &zero})
+	b.nowFunc = func() time.Time { return now }
+	b.lastRefill = now
+	assert.False(b.withdraw())
+	now = now.Add(time.Minute)
+	assert.False(b.withdraw())
+	for i := 0; i < 5; i++ {
+		b.deposit()
+	}
+	assert.True(b.withdraw())
+	assert.False(b.withdraw())
+
+	// the default minimum retries are 10 per second.
+	b = newRetryBudget(&RetryBudgetSpec{})
+	for i := 0; i < 10; i++ {
+		assert.True(b.withdraw())
+	}
+	assert.False(b.withdraw())
+}
+
+func TestHedging(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Error((&HedgingSpec{}).Validate())
+	assert.Error((&HedgingSpec{Delay: "-1s"}).Validate())
+	assert.Error((&HedgingSpec{Percentile: 100}).Validate())
+	assert.NoError((&HedgingSpec{Percentile: 95}).Validate())
+
+	p := newTestRetryPolicy(RetryRule{
+		MaxAttempts: 1,
+		Hedging:     &HedgingSpec{Delay: "10ms", MaxHedgedRequests: 2},
+	})
+
+	// the first call is slow, the hedged one wins, and the first one is
+	// cancelled.
+	var calls int32
+	var winner context.Context
+	cancelled := make(chan struct{})
+	handler := p.Wrap(func(ctx context.Context) error {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			<-ctx.Done()
+			close(cancelled)
+			return ctx.Err()
+		}
+		winner = ctx
+		return nil
+	})
+
+	// the winner is canceled when the response is released.
+	var releases []func()
+	info := &RequestInfo{
+		Method:    http.MethodGet,
+		Hedgeable: true,
+		OnRelease: func(fn func()) { releases = append(releases, fn) },
+	}
+	get := WithRequestInfo(context.Background(), info)
+	assert.NoError(handler(get))
+	assert.Equal(int32(2), atomic.LoadInt32(&calls))
+	<-cancelled
+	assert.NoError(winner.Err())
+	assert.Len(releases, 1)
+	releases[0]()
+	assert.Error(winner.Err())
+
+	// the winner is canceled when the handler returns if there's no
+	// release hook.
+	calls = 0
+	cancelled = make(chan struct{})
+	get = WithRequestInfo(context.Background(), &RequestInfo{Method: http.MethodGet, Hedgeable: true})
+	assert.NoError(handler(get))
+	<-cancelled
+	assert.Error(winner.Err())
+
+	// all attempts fail.
+	calls = 0
+	handler = p.Wrap(func(ctx context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(50 * time.Millisecond)
+		return fmt.Errorf("error")
+	})
+	assert.Error(handler(get))
+	assert.Equal(int32(3), atomic.LoadInt32(&calls))
+
+	// not hedged if the method is not idempotent or the handler is not
+	// hedgeable.
+	for _, info := range []*RequestInfo{
+		{Method: http.MethodPost, Hedgeable: true},
+		{Method: http.MethodGet},
+	} {
+		calls = 0
+		ctx := WithRequestInfo(context.Background(), info)
+		assert.Error(handler(ctx))
+		assert.Equal(int32(1), atomic.LoadInt32(&calls))
+	}
+}
+
+func TestRetryPolicyInit(t *testing.T) {
+	assert := assert.New(t)
+
+	policy, err := NewPolicy(map[string]interface{}{
+		"name": "retry",
+		"kind": RetryKind.Name,
+		"budget": map[string]interface{}{
+			"percent": 10,
+		},
+		"hedging": map[string]interface{}{
+			"delay": "10ms",
+		},
+	})
+	assert.NoError(err)
+
+	// the shared states are built by NewPolicy, wrappers reuse them.
+	p := policy.(*RetryPolicy)
+	budget, hedger := p.budget, p.hedger
+	assert.NotNil(budget)
+	assert.NotNil(hedger)
+	assert.Equal(500*time.Millisecond, p.waitDuration)
+	p.CreateWrapper()
+	assert.Same(budget, p.budget)
+	assert.Same(hedger, p.hedger)
+}
+
+func TestHedgingPercentile(t *testing.T) {
+	assert := assert.New(t)
+
+	h := newHedger(&HedgingSpec{Percentile: 90})
+	assert.Equal(time.Duration(0), h.hedgingDelay())
+
+	for i := 1; i <= hedgingSampleSize; i++ {
+		h.observe(time.Duration(i) * time.Millisecond)
+	}
+	assert.Equal(901*time.Millisecond, h.hedgingDelay())
+}
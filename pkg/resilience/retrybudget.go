@@ -0,0 +1,121 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resilience
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// RetryBudgetSpec is the spec of the retry budget, which limits the number
+// of retries to a percentage of the requests, so that retries cannot turn
+// a brownout of the backend into a retry storm.
+//
+// The budget is a token bucket shared by all users of the retry policy,
+// every request deposits Percent/100 tokens, and every retry or hedged
+// request withdraws one token. MinRetriesPerSecond tokens are added every
+// second to allow retries when the traffic is low.
+type RetryBudgetSpec struct {
+	// Percent is the maximum percentage of retries to requests, default
+	// is 20.
+	Percent int `json:"percent,omitempty" jsonschema:"minimum=1,maximum=100"`
+	// MinRetriesPerSecond is the number of retries allowed per second
+	// regardless of the percentage, default is 10, and 0 disables it.
+	MinRetriesPerSecond *int `json:"minRetriesPerSecond,omitempty" jsonschema:"minimum=0"`
+}
+
+// Validate validates the RetryBudgetSpec.
+func (spec *RetryBudgetSpec) Validate() error {
+	if spec.Percent < 0 || spec.Percent > 100 {
+		return fmt.Errorf("percent must be in [0, 100]")
+	}
+	if n := spec.MinRetriesPerSecond; n != nil && *n < 0 {
+		return fmt.Errorf("minRetriesPerSecond must not be negative")
+	}
+	return nil
+}
+
+// retryBudget is the token bucket of retry budget, to avoid the error of
+// floating point numbers, tokens are in the unit of 1/100 retry.
+type retryBudget struct {
+	lock       sync.Mutex
+	ratio      float64
+	minPerSec  float64
+	capacity   float64
+	tokens     float64
+	lastRefill time.Time
+	nowFunc    func() time.Time
+}
+
+func newRetryBudget(spec *RetryBudgetSpec) *retryBudget {
+	b := &retryBudget{
+		ratio:     float64(spec.Percent),
+		minPerSec: 1000,
+		nowFunc:   time.Now,
+	}
+	if b.ratio <= 0 {
+		b.ratio = 20
+	}
+	if n := spec.MinRetriesPerSecond; n != nil {
+		b.minPerSec = float64(*n) * 100
+	}
+
+	// the bucket can hold the budget of 100 requests plus the minimum
+	// retries of one second.
+	b.capacity = b.ratio*100 + b.minPerSec
+	b.tokens = b.minPerSec
+	b.lastRefill = b.nowFunc()
+	return b
+}
+
+// refill adds the minimum retries to the bucket, the caller must hold
+// the lock.
+func (b *retryBudget) refill() {
+	now := b.nowFunc()
+	elapsed := now.Sub(b.lastRefill).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	b.lastRefill = now
+	b.tokens = math.Min(b.capacity, b.tokens+elapsed*b.minPerSec)
+}
+
+// deposit deposits the tokens of a request.
+func (b *retryBudget) deposit() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.refill()
+	b.tokens = math.Min(b.capacity, b.tokens+b.ratio)
+}
+
+// withdraw withdraws a token for a retry, it returns false if the budget
+// is exhausted.
+func (b *retryBudget) withdraw() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.refill()
+	if b.tokens < 100 {
+		return false
+	}
+	b.tokens -= 100
+	return true
+}
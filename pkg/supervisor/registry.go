@@ -95,6 +95,14 @@ type (
 		Inherit(superSpec *Spec, previousGeneration Object)
 	}
 
+	// ObjectNameSetter is an optional interface of object specs which
+	// need the name of the object in validation.
+	ObjectNameSetter interface {
+		// SetObjectName sets the name of the object, it is called
+		// before the object spec is validated.
+		SetObjectName(name string)
+	}
+
 	// ObjectCategory is the type to classify all objects.
 	ObjectCategory string
 )
@@ -141,6 +141,9 @@ func (s *Supervisor) newSpec(config string, created bool) (spec *Spec, err error
 	}
 	objectSpec := rootObject.DefaultSpec()
 	codectool.MustUnmarshal(buff, objectSpec)
+	if setter, ok := objectSpec.(ObjectNameSetter); ok {
+		setter.SetObjectName(meta.Name)
+	}
 	verr = v.Validate(objectSpec)
 	if !verr.Valid() {
 		panic(verr)
@@ -100,3 +100,12 @@ func (l *limitListenerConn) Close() error {
 	l.releaseOnce.Do(l.release)
 	return err
 }
+
+// CloseWrite shuts down the writing side of the connection, the
+// connection is closed if it does not support half close.
+func (l *limitListenerConn) CloseWrite() error {
+	if cw, ok := l.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return l.Close()
+}
@@ -0,0 +1,499 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package proxyprotocol implements the PROXY protocol v1 and v2, which is
+// used by layer 4 proxies to pass the address of clients to servers.
+// See https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt.
+package proxyprotocol
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Version1 is the human-readable format of PROXY protocol.
+	Version1 = "v1"
+	// Version2 is the binary format of PROXY protocol.
+	Version2 = "v2"
+
+	defaultHeaderTimeout = 5 * time.Second
+
+	// maxV1HeaderLength is the max length of a v1 header, including the
+	// trailing CRLF.
+	maxV1HeaderLength = 107
+
+	v2HeaderLength = 16
+	v2CmdLocal     = 0x0
+	v2CmdProxy     = 0x1
+	v2FamilyInet   = 0x1
+	v2FamilyInet6  = 0x2
+	v2TransStream  = 0x1
+	v2TransDgram   = 0x2
+)
+
+var (
+	v1Prefix    = []byte("PROXY ")
+	v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+type (
+	// Spec is the spec of PROXY protocol of a listener. Headers are only
+	// parsed for connections from trusted sources, and connections
+	// without a header are accepted as is.
+	Spec struct {
+		// TrustedCIDRs is the IPs or CIDRs of trusted sources. It is
+		// required, because headers are optional and trusting every source
+		// would allow any client to spoof its address.
+		TrustedCIDRs  []string `json:"trustedCIDRs" jsonschema:"required,minItems=1"`
+		HeaderTimeout string   `json:"headerTimeout,omitempty" jsonschema:"format=duration"`
+	}
+
+	// Header is a PROXY protocol header.
+	Header struct {
+		Version     string
+		Source      net.Addr
+		Destination net.Addr
+	}
+
+	// Listener is a listener which parses PROXY protocol headers of the
+	// accepted connections.
+	Listener struct {
+		net.Listener
+		trusted []*net.IPNet
+		timeout time.Duration
+	}
+
+	// Conn is a connection which may begin with a PROXY protocol header.
+	// The header is read on the first call of Read, RemoteAddr or
+	// LocalAddr, so that a slow client doesn't block the accept loop.
+	Conn struct {
+		net.Conn
+		br      *bufio.Reader
+		timeout time.Duration
+
+		once sync.Once
+		src  net.Addr
+		dst  net.Addr
+		err  error
+
+		mu           sync.Mutex
+		readDeadline time.Time
+	}
+
+	addrsKey struct{}
+	addrs    struct {
+		src net.Addr
+		dst net.Addr
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	if len(spec.TrustedCIDRs) == 0 {
+		return fmt.Errorf("trustedCIDRs is required")
+	}
+	if _, err := parseCIDRs(spec.TrustedCIDRs); err != nil {
+		return err
+	}
+	if spec.HeaderTimeout != "" {
+		if _, err := time.ParseDuration(spec.HeaderTimeout); err != nil {
+			return fmt.Errorf("invalid headerTimeout: %v", err)
+		}
+	}
+	return nil
+}
+
+// ValidateVersion validates the version of PROXY protocol, an empty
+// version means PROXY protocol is disabled.
+func ValidateVersion(version string) error {
+	switch version {
+	case "", Version1, Version2:
+		return nil
+	default:
+		return fmt.Errorf("unknown PROXY protocol version: %s", version)
+	}
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var result []*net.IPNet
+	for _, cidr := range cidrs {
+		if ip := net.ParseIP(cidr); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			result = append(result, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted cidr %s: %v", cidr, err)
+		}
+		result = append(result, ipNet)
+	}
+	return result, nil
+}
+
+// NewListener wraps l to parse PROXY protocol headers according to spec,
+// l is returned directly if spec is nil.
+func NewListener(l net.Listener, spec *Spec) net.Listener {
+	if spec == nil {
+		return l
+	}
+
+	// NOTE: the spec has been validated, so the errors are ignored.
+	trusted, _ := parseCIDRs(spec.TrustedCIDRs)
+	timeout := defaultHeaderTimeout
+	if spec.HeaderTimeout != "" {
+		timeout, _ = time.ParseDuration(spec.HeaderTimeout)
+	}
+
+	return &Listener{Listener: l, trusted: trusted, timeout: timeout}
+}
+
+// Accept accepts a connection, the connection is wrapped as a Conn if it
+// comes from a trusted source.
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.isTrusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	return NewConn(conn, l.timeout), nil
+}
+
+func (l *Listener) isTrusted(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, ipNet := range l.trusted {
+		if ipNet.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewConn creates a Conn, the header must be received within timeout.
+func NewConn(conn net.Conn, timeout time.Duration) *Conn {
+	return &Conn{
+		Conn:    conn,
+		br:      bufio.NewReader(conn),
+		timeout: timeout,
+	}
+}
+
+func (c *Conn) readHeader() {
+	c.once.Do(func() {
+		if c.timeout > 0 {
+			c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		}
+
+		c.src, c.dst, c.err = readHeader(c.br)
+
+		c.mu.Lock()
+		c.Conn.SetReadDeadline(c.readDeadline)
+		c.mu.Unlock()
+	})
+}
+
+// Read reads data from the connection, the header is not included.
+func (c *Conn) Read(p []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.br.Read(p)
+}
+
+// RemoteAddr returns the source address in the header, or the remote
+// address of the connection if there's no header.
+func (c *Conn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if c.src != nil {
+		return c.src
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the destination address in the header, or the local
+// address of the connection if there's no header.
+func (c *Conn) LocalAddr() net.Addr {
+	c.readHeader()
+	if c.dst != nil {
+		return c.dst
+	}
+	return c.Conn.LocalAddr()
+}
+
+// SetDeadline sets the read and write deadlines of the connection.
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return c.Conn.SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline of the connection.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return c.Conn.SetReadDeadline(t)
+}
+
+// CloseWrite shuts down the writing side of the connection, the
+// connection is closed if it does not support half close.
+func (c *Conn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Close()
+}
+
+// readHeader reads the header from r, nil addresses are returned if
+// there's no header, or the header doesn't carry addresses.
+func readHeader(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch b[0] {
+	case v1Prefix[0]:
+		b, err = r.Peek(len(v1Prefix))
+		if err != nil || !bytes.Equal(b, v1Prefix) {
+			return nil, nil, nil
+		}
+		return readV1Header(r)
+	case v2Signature[0]:
+		b, err = r.Peek(len(v2Signature))
+		if err != nil || !bytes.Equal(b, v2Signature) {
+			return nil, nil, nil
+		}
+		return readV2Header(r)
+	}
+
+	return nil, nil, nil
+}
+
+func readV1Header(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	var line []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, fmt.Errorf("read PROXY protocol v1 header failed: %v", err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= maxV1HeaderLength {
+			return nil, nil, fmt.Errorf("PROXY protocol v1 header is too long")
+		}
+	}
+
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, fmt.Errorf("invalid PROXY protocol v1 header: %q", line)
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, fmt.Errorf("invalid PROXY protocol v1 header: %q", line)
+	}
+
+	parse := func(host, port string) (*net.TCPAddr, error) {
+		ip := net.ParseIP(host)
+		if ip == nil || (ip.To4() != nil) != (fields[1] == "TCP4") {
+			return nil, fmt.Errorf("invalid address %s in PROXY protocol v1 header", host)
+		}
+		p, err := strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %s in PROXY protocol v1 header", port)
+		}
+		return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+	}
+
+	src, err := parse(fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+	dst, err := parse(fields[3], fields[5])
+	if err != nil {
+		return nil, nil, err
+	}
+	return src, dst, nil
+}
+
+func readV2Header(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	header := make([]byte, v2HeaderLength)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, nil, fmt.Errorf("read PROXY protocol v2 header failed: %v", err)
+	}
+
+	if header[12]>>4 != 2 {
+		return nil, nil, fmt.Errorf("invalid PROXY protocol v2 version: %d", header[12]>>4)
+	}
+	cmd := header[12] & 0x0F
+	if cmd != v2CmdLocal && cmd != v2CmdProxy {
+		return nil, nil, fmt.Errorf("invalid PROXY protocol v2 command: %d", cmd)
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, nil, fmt.Errorf("read PROXY protocol v2 addresses failed: %v", err)
+	}
+
+	if cmd == v2CmdLocal {
+		return nil, nil, nil
+	}
+
+	var ipLen int
+	switch header[13] >> 4 {
+	case v2FamilyInet:
+		ipLen = net.IPv4len
+	case v2FamilyInet6:
+		ipLen = net.IPv6len
+	default:
+		// unspecified or unix addresses, which are ignored.
+		return nil, nil, nil
+	}
+	if len(payload) < 2*ipLen+4 {
+		return nil, nil, fmt.Errorf("PROXY protocol v2 addresses are too short")
+	}
+
+	srcIP := net.IP(payload[:ipLen])
+	dstIP := net.IP(payload[ipLen : 2*ipLen])
+	srcPort := int(binary.BigEndian.Uint16(payload[2*ipLen:]))
+	dstPort := int(binary.BigEndian.Uint16(payload[2*ipLen+2:]))
+
+	if header[13]&0x0F == v2TransDgram {
+		return &net.UDPAddr{IP: srcIP, Port: srcPort}, &net.UDPAddr{IP: dstIP, Port: dstPort}, nil
+	}
+	return &net.TCPAddr{IP: srcIP, Port: srcPort}, &net.TCPAddr{IP: dstIP, Port: dstPort}, nil
+}
+
+func tcpAddr(addr net.Addr) *net.TCPAddr {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a
+	case *net.UDPAddr:
+		return &net.TCPAddr{IP: a.IP, Port: a.Port}
+	}
+	return nil
+}
+
+// Format formats the header, an UNKNOWN (v1) or LOCAL (v2) header is
+// returned if the addresses are not TCP or UDP addresses.
+func (h *Header) Format() ([]byte, error) {
+	src, dst := tcpAddr(h.Source), tcpAddr(h.Destination)
+
+	isV4 := false
+	if src != nil && dst != nil {
+		isV4 = src.IP.To4() != nil && dst.IP.To4() != nil
+	}
+
+	switch h.Version {
+	case Version1:
+		if src == nil || dst == nil {
+			return []byte("PROXY UNKNOWN\r\n"), nil
+		}
+		proto, srcIP, dstIP := "TCP6", src.IP.To16(), dst.IP.To16()
+		if isV4 {
+			proto, srcIP, dstIP = "TCP4", src.IP.To4(), dst.IP.To4()
+		}
+		return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", proto, srcIP, dstIP, src.Port, dst.Port)), nil
+
+	case Version2:
+		buf := bytes.NewBuffer(make([]byte, 0, v2HeaderLength+2*net.IPv6len+4))
+		buf.Write(v2Signature)
+		if src == nil || dst == nil {
+			buf.Write([]byte{0x20 | v2CmdLocal, 0, 0, 0})
+			return buf.Bytes(), nil
+		}
+
+		family, srcIP, dstIP := byte(v2FamilyInet6), src.IP.To16(), dst.IP.To16()
+		if isV4 {
+			family, srcIP, dstIP = v2FamilyInet, src.IP.To4(), dst.IP.To4()
+		}
+		buf.WriteByte(0x20 | v2CmdProxy)
+		buf.WriteByte(family<<4 | v2TransStream)
+		binary.Write(buf, binary.BigEndian, uint16(2*len(srcIP)+4))
+		buf.Write(srcIP)
+		buf.Write(dstIP)
+		binary.Write(buf, binary.BigEndian, uint16(src.Port))
+		binary.Write(buf, binary.BigEndian, uint16(dst.Port))
+		return buf.Bytes(), nil
+	}
+
+	return nil, fmt.Errorf("unknown PROXY protocol version: %s", h.Version)
+}
+
+// NewContext returns a new context which carries the source and
+// destination addresses for DialContext.
+func NewContext(ctx context.Context, src, dst net.Addr) context.Context {
+	return context.WithValue(ctx, addrsKey{}, &addrs{src: src, dst: dst})
+}
+
+// DialContext wraps dial to send a header of version at the beginning of
+// every connection, the addresses of the header come from the context,
+// see NewContext.
+func DialContext(version string, dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		h := &Header{Version: version}
+		if a, ok := ctx.Value(addrsKey{}).(*addrs); ok {
+			h.Source, h.Destination = a.src, a.dst
+			// fallback to the address of the server if the destination
+			// is unknown.
+			if h.Source != nil && h.Destination == nil {
+				h.Destination = conn.RemoteAddr()
+			}
+		}
+
+		data, err := h.Format()
+		if err == nil {
+			_, err = conn.Write(data)
+		}
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+}
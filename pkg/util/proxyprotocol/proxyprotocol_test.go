@@ -0,0 +1,224 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxyprotocol
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &Spec{}
+	assert.Error(spec.Validate())
+
+	spec.TrustedCIDRs = []string{"10.0.0.0/33"}
+	assert.Error(spec.Validate())
+	spec.TrustedCIDRs = []string{"10.0.0.0/8", "192.168.1.1", "::1"}
+	assert.NoError(spec.Validate())
+
+	spec.HeaderTimeout = "abc"
+	assert.Error(spec.Validate())
+	spec.HeaderTimeout = "1s"
+	assert.NoError(spec.Validate())
+
+	assert.NoError(ValidateVersion(""))
+	assert.NoError(ValidateVersion(Version1))
+	assert.NoError(ValidateVersion(Version2))
+	assert.Error(ValidateVersion("v3"))
+}
+
+func TestFormatAndRead(t *testing.T) {
+	assert := assert.New(t)
+
+	cases := []struct {
+		src, dst *net.TCPAddr
+	}{
+		{
+			src: &net.TCPAddr{IP: net.ParseIP("192.168.1.1"), Port: 56324},
+			dst: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 443},
+		},
+		{
+			src: &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 56324},
+			dst: &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 443},
+		},
+	}
+
+	for _, version := range []string{Version1, Version2} {
+		for _, c := range cases {
+			h := &Header{Version: version, Source: c.src, Destination: c.dst}
+			data, err := h.Format()
+			assert.NoError(err)
+
+			r := bufio.NewReader(strings.NewReader(string(data) + "GET / HTTP/1.1\r\n"))
+			src, dst, err := readHeader(r)
+			assert.NoError(err)
+			assert.Equal(c.src.String(), src.String())
+			assert.Equal(c.dst.String(), dst.String())
+
+			rest, _ := io.ReadAll(r)
+			assert.Equal("GET / HTTP/1.1\r\n", string(rest))
+		}
+
+		// no addresses
+		data, err := (&Header{Version: version}).Format()
+		assert.NoError(err)
+		src, dst, err := readHeader(bufio.NewReader(strings.NewReader(string(data))))
+		assert.NoError(err)
+		assert.Nil(src)
+		assert.Nil(dst)
+	}
+
+	_, err := (&Header{Version: "v3"}).Format()
+	assert.Error(err)
+
+	assert.Equal("PROXY TCP4 192.168.1.1 10.0.0.1 56324 443\r\n", func() string {
+		data, _ := (&Header{Version: Version1, Source: cases[0].src, Destination: cases[0].dst}).Format()
+		return string(data)
+	}())
+}
+
+func TestReadHeader(t *testing.T) {
+	assert := assert.New(t)
+
+	read := func(s string) (net.Addr, net.Addr, error) {
+		return readHeader(bufio.NewReader(strings.NewReader(s)))
+	}
+
+	// no header
+	for _, s := range []string{"GET / HTTP/1.1\r\n", "POST / HTTP/1.1\r\n", "\r\nabc"} {
+		src, dst, err := read(s)
+		assert.NoError(err)
+		assert.Nil(src)
+		assert.Nil(dst)
+	}
+
+	// invalid headers
+	for _, s := range []string{
+		"PROXY TCP4 1.1.1.1 2.2.2.2 1 2\n",
+		"PROXY TCP4 1.1.1.1 2.2.2.2 1\r\n",
+		"PROXY UDP4 1.1.1.1 2.2.2.2 1 2\r\n",
+		"PROXY TCP4 ::1 2.2.2.2 1 2\r\n",
+		"PROXY TCP4 1.1.1.1 2.2.2.2 1 65536\r\n",
+		"PROXY TCP4 1.1.1.1 2.2.2.2 1 2",
+		"PROXY " + strings.Repeat("a", 200) + "\r\n",
+		"\r\n\r\n\x00\r\nQUIT\n\x11\x11\x00\x0c",
+		"\r\n\r\n\x00\r\nQUIT\n\x23\x11\x00\x00",
+		"\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x02ab",
+	} {
+		_, _, err := read(s)
+		assert.Error(err, s)
+	}
+
+	src, dst, err := read("PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n")
+	assert.NoError(err)
+	assert.Nil(src)
+	assert.Nil(dst)
+
+	// v2 UDP over IPv4 with a TLV
+	src, dst, err = read("\r\n\r\n\x00\r\nQUIT\n\x21\x12\x00\x0f" +
+		"\x01\x02\x03\x04\x05\x06\x07\x08\x00\x50\x01\xbb\x04\x00\x00")
+	assert.NoError(err)
+	assert.Equal("1.2.3.4:80", src.String())
+	assert.Equal("5.6.7.8:443", dst.String())
+	assert.IsType(&net.UDPAddr{}, src)
+}
+
+func TestListener(t *testing.T) {
+	assert := assert.New(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(err)
+	assert.Equal(ln, NewListener(ln, nil))
+
+	check := func(spec *Spec, header string, expectedRemote string) {
+		pl := NewListener(ln, spec)
+
+		go func() {
+			conn, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			conn.Write([]byte(header + "hello"))
+		}()
+
+		conn, err := pl.Accept()
+		assert.NoError(err)
+		defer conn.Close()
+
+		conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		data, err := io.ReadAll(conn)
+		assert.NoError(err)
+		if strings.HasPrefix(expectedRemote, "127.0.0.1") {
+			assert.Equal(header+"hello", string(data))
+		} else {
+			assert.Equal("hello", string(data))
+		}
+		assert.True(strings.HasPrefix(conn.RemoteAddr().String(), expectedRemote))
+	}
+
+	header := "PROXY TCP4 192.168.1.1 10.0.0.1 56324 443\r\n"
+	check(&Spec{}, header, "127.0.0.1")
+	check(&Spec{TrustedCIDRs: []string{"127.0.0.0/8"}}, header, "192.168.1.1:56324")
+	check(&Spec{TrustedCIDRs: []string{"127.0.0.0/8"}}, "", "127.0.0.1")
+	check(&Spec{TrustedCIDRs: []string{"10.0.0.0/8"}}, header, "127.0.0.1")
+
+	ln.Close()
+}
+
+func TestDialContext(t *testing.T) {
+	assert := assert.New(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(err)
+	defer ln.Close()
+
+	dial := DialContext(Version2, (&net.Dialer{}).DialContext)
+	src := &net.TCPAddr{IP: net.ParseIP("192.168.1.1"), Port: 1234}
+	dst := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 80}
+
+	go func() {
+		conn, err := dial(NewContext(context.Background(), src, dst), "tcp", ln.Addr().String())
+		if err != nil {
+			return
+		}
+		conn.Write([]byte("hello"))
+		conn.Close()
+	}()
+
+	conn, err := NewListener(ln, &Spec{TrustedCIDRs: []string{"127.0.0.1"}}).Accept()
+	assert.NoError(err)
+	defer conn.Close()
+	data, err := io.ReadAll(conn)
+	assert.NoError(err)
+	assert.Equal("hello", string(data))
+	assert.Equal(src.String(), conn.RemoteAddr().String())
+	assert.Equal(dst.String(), conn.LocalAddr().String())
+
+	_, err = dial(context.Background(), "tcp", "127.0.0.1:1")
+	assert.Error(err)
+}
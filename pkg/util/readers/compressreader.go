@@ -0,0 +1,209 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package readers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// EncodingGzip is the content coding of gzip.
+	EncodingGzip = "gzip"
+	// EncodingBrotli is the content coding of brotli.
+	EncodingBrotli = "br"
+	// EncodingZstd is the content coding of zstandard.
+	EncodingZstd = "zstd"
+)
+
+// IsSupportedEncoding returns whether the content coding is supported by
+// NewCompressReader and NewDecompressReader.
+func IsSupportedEncoding(encoding string) bool {
+	switch encoding {
+	case EncodingGzip, EncodingBrotli, EncodingZstd:
+		return true
+	}
+	return false
+}
+
+// ValidateLevel checks whether level is a valid compression level of the
+// encoding, zero always means the default level of the encoding.
+func ValidateLevel(encoding string, level int) error {
+	var max int
+	switch encoding {
+	case EncodingGzip:
+		max = gzip.BestCompression
+	case EncodingBrotli:
+		max = brotli.BestCompression
+	case EncodingZstd:
+		max = 22
+	default:
+		return fmt.Errorf("unsupported encoding %q", encoding)
+	}
+
+	if level < 0 || level > max {
+		return fmt.Errorf("invalid %s compression level %d, must be 0 (default) or in [1, %d]", encoding, level, max)
+	}
+	return nil
+}
+
+// CompressReader wraps an io.Reader to a new io.Reader, whose data is the
+// compression result of the original io.Reader in the given encoding.
+type CompressReader struct {
+	r    io.Reader
+	buff *bytes.Buffer
+	w    io.WriteCloser
+	err  error
+}
+
+// NewCompressReader creates a new CompressReader from r, level zero means
+// the default compression level of the encoding.
+func NewCompressReader(r io.Reader, encoding string, level int) (*CompressReader, error) {
+	if err := ValidateLevel(encoding, level); err != nil {
+		return nil, err
+	}
+
+	buff := bytes.NewBuffer(nil)
+	cr := &CompressReader{r: r, buff: buff}
+
+	switch encoding {
+	case EncodingGzip:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		cr.w, _ = gzip.NewWriterLevel(buff, level)
+	case EncodingBrotli:
+		if level == 0 {
+			level = brotli.DefaultCompression
+		}
+		cr.w = brotli.NewWriterLevel(buff, level)
+	case EncodingZstd:
+		opts := []zstd.EOption{zstd.WithEncoderConcurrency(1)}
+		if level != 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		w, err := zstd.NewWriter(buff, opts...)
+		if err != nil {
+			return nil, err
+		}
+		cr.w = w
+	}
+
+	return cr, nil
+}
+
+// Read implements io.Reader.
+func (r *CompressReader) Read(p []byte) (n int, err error) {
+	for {
+		// The error could only be io.EOF, which need to be ignored.
+		m, _ := r.buff.Read(p)
+		n += m
+		if m == len(p) {
+			break
+		}
+
+		if r.err != nil {
+			err = r.err
+			break
+		}
+
+		r.pull()
+		p = p[m:]
+	}
+	return
+}
+
+func (r *CompressReader) pull() {
+	// reset the buffer to avoid it becomes too large.
+	r.buff.Reset()
+
+	_, r.err = io.CopyN(r.w, r.r, bodyFlushSize)
+	if r.err == io.EOF {
+		if err := r.w.Close(); err != nil {
+			r.err = err
+		}
+	}
+}
+
+// Close implements io.Closer and closes the underlying io.Reader if
+// it is an io.Closer.
+func (r *CompressReader) Close() error {
+	if c, ok := r.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// DecompressReader wraps an io.Reader to a new io.Reader, whose data is
+// the decompression result of the original io.Reader.
+type DecompressReader struct {
+	io.Reader
+	r       io.Reader
+	closeFn func() error
+}
+
+// NewDecompressReader creates a new DecompressReader from r, which is
+// compressed in the given encoding.
+func NewDecompressReader(r io.Reader, encoding string) (*DecompressReader, error) {
+	dr := &DecompressReader{r: r}
+
+	switch encoding {
+	case EncodingGzip:
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		dr.Reader, dr.closeFn = zr, zr.Close
+	case EncodingBrotli:
+		dr.Reader = brotli.NewReader(r)
+	case EncodingZstd:
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		dr.Reader = zr
+		dr.closeFn = func() error {
+			zr.Close()
+			return nil
+		}
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q", encoding)
+	}
+
+	return dr, nil
+}
+
+// Close implements io.Closer, it closes both the decompressor and the
+// underlying io.Reader, if it is an io.Closer.
+func (r *DecompressReader) Close() error {
+	var err error
+	if r.closeFn != nil {
+		err = r.closeFn()
+	}
+	if c, ok := r.r.(io.Closer); ok {
+		if err2 := c.Close(); err2 != nil {
+			err = err2
+		}
+	}
+	return err
+}
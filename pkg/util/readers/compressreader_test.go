@@ -0,0 +1,69 @@
+/*
+ * Copyright (c) 2017, The Easegress Authors
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package readers
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressDecompressReader(t *testing.T) {
+	assert := assert.New(t)
+
+	str := strings.Repeat("123123123124234asdjflasjflasfjlaksnvalknfaslkfnalkfnaslfjasfasfasfas", 2000)
+
+	for _, encoding := range []string{EncodingGzip, EncodingBrotli, EncodingZstd} {
+		for _, level := range []int{0, 1, 5} {
+			compressReader, err := NewCompressReader(strings.NewReader(str), encoding, level)
+			assert.Nil(err)
+			data, err := io.ReadAll(compressReader)
+			assert.Nil(err)
+			assert.Nil(compressReader.Close())
+			assert.Less(10*len(data), len(str), encoding)
+
+			decompressReader, err := NewDecompressReader(bytes.NewReader(data), encoding)
+			assert.Nil(err)
+			data, err = io.ReadAll(decompressReader)
+			assert.Nil(err)
+			assert.Equal(str, string(data), encoding)
+			assert.Nil(decompressReader.Close())
+		}
+	}
+
+	_, err := NewCompressReader(strings.NewReader(str), "deflate", 0)
+	assert.NotNil(err)
+	_, err = NewCompressReader(strings.NewReader(str), EncodingGzip, 10)
+	assert.NotNil(err)
+	_, err = NewDecompressReader(strings.NewReader(str), "deflate")
+	assert.NotNil(err)
+	_, err = NewDecompressReader(strings.NewReader(str), EncodingGzip)
+	assert.NotNil(err)
+
+	assert.True(IsSupportedEncoding(EncodingZstd))
+	assert.False(IsSupportedEncoding("compress"))
+	assert.Nil(ValidateLevel(EncodingBrotli, 11))
+	assert.NotNil(ValidateLevel(EncodingZstd, 23))
+	assert.NotNil(ValidateLevel(EncodingZstd, -1))
+	assert.Nil(ValidateLevel(EncodingGzip, 0))
+	err = ValidateLevel(EncodingGzip, 10)
+	assert.Contains(err.Error(), "0 (default) or in [1, 9]")
+}